@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/brehan/bank/cmd/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// createAPIKey handles POST /api/admin/api-keys. The key itself is only
+// returned in this response.
+func (app *Application) createAPIKey(c *gin.Context) {
+	var req createAPIKeyRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	createdBy, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	key, secret, err := app.apiKeyService.Create(auditContext(c), req.Name, req.Scopes, req.ExpiresAt, createdBy)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"key":     secret,
+		"api_key": key,
+	})
+}
+
+// getAPIKeys handles GET /api/admin/api-keys
+func (app *Application) getAPIKeys(c *gin.Context) {
+	keys, err := app.apiKeyService.List(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// revokeAPIKey handles DELETE /api/admin/api-keys/:id
+func (app *Application) revokeAPIKey(c *gin.Context) {
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Error(errInvalidID)
+		return
+	}
+
+	// Revoke the key and audit it in the same transaction
+	if err := app.apiKeyService.Revoke(auditContext(c), keyID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
+	"github.com/brehan/bank/cmd/apperr"
 	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/metrics"
 	"github.com/brehan/bank/cmd/service"
 )
 
+// errWrongLinkType is returned when an application is sent through a link
+// of the other type
+var errWrongLinkType = apperr.Validation("wrong_link_type", "", "invalid application type")
+
 // Add ApplicationLinkService to the Application struct
 type ApplicationLinkHandler struct {
 	linkService *service.ApplicationLinkService
@@ -17,18 +24,18 @@ type ApplicationLinkHandler struct {
 func (app *Application) generateApplicationLinks(c *gin.Context) {
 	jobID := c.Param("id")
 	
-	// Create application links
-	internalLink, externalLink, err := app.applicationLinkService.GenerateApplicationLinks(jobID)
+	// Create and audit the application links
+	internalLink, externalLink, err := app.applicationLinkService.GenerateApplicationLinks(auditContext(c), jobID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
+	app.metrics.LinksGenerated.Add(2)
 
 	// Format links for response
-	baseURL := "http://localhost:3000" // Change this to your actual frontend URL
-	links, err := app.applicationLinkService.FormatApplicationLinksForResponse(internalLink, externalLink, baseURL)
+	links, err := app.applicationLinkService.FormatApplicationLinksForResponse(c.Request.Context(), internalLink, externalLink, app.config.Frontend.BaseURL)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -43,9 +50,9 @@ func (app *Application) getApplicationLinks(c *gin.Context) {
 	jobID := c.Param("id")
 	
 	// Get links from database
-	links, err := app.applicationLinkService.GetApplicationLinksByJob(jobID)
+	links, err := app.applicationLinkService.GetApplicationLinksByJob(c.Request.Context(), jobID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -57,16 +64,16 @@ func (app *Application) getSecureApplicationForm(c *gin.Context) {
 	token := c.Param("token")
 	
 	// Validate the token
-	link, err := app.applicationLinkService.ValidateApplicationLink(token)
+	link, err := app.applicationLinkService.ValidateApplicationLink(c.Request.Context(), token)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	// Get the job details
-	job, err := app.repo.GetJobById(link.JobID)
+	job, err := app.jobService.GetJobById(c.Request.Context(), link.JobID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		c.Error(err)
 		return
 	}
 
@@ -81,56 +88,61 @@ func (app *Application) handleSecureInternalApplication(c *gin.Context) {
 	token := c.Param("token")
 	
 	// Validate the token
-	link, err := app.applicationLinkService.ValidateApplicationLink(token)
+	link, err := app.applicationLinkService.ValidateApplicationLink(c.Request.Context(), token)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	// Check if this is an internal application link
 	if link.Type != "internal" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid application type"})
+		c.Error(errWrongLinkType)
 		return
 	}
 
 	var internalApp data.InternalEmployee
-	if err := c.ShouldBindJSON(&internalApp); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &internalApp); err != nil {
+		c.Error(err)
 		return
 	}
 
 	// Set the job ID from the link
 	internalApp.Jobid = link.JobID
 
-	// Process file upload if included
+	// Process file upload if included. Only a resume uploaded with this
+	// request is stored: a path in the body is ignored.
+	internalApp.Resumepath = ""
 	file, err := c.FormFile("resume")
 	if err == nil {
 		// A file was uploaded
-		dst := "static/resumes/" + token + "_" + file.Filename
+		dst := app.resumeUploadPath(file.Filename)
+		// The application keeps a copy of its own, so the upload goes
+		// when the request ends, whether or not it succeeds
+		defer os.Remove(dst)
 		if err := c.SaveUploadedFile(file, dst); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save resume"})
+			c.Error(err)
 			return
 		}
 		internalApp.Resumepath = dst
 	}
 
-	// Save the application
-	if err := app.internalEmployeeService.Save_Internal_Employee(internalApp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Save and audit the application, match it with an employee record for
+	// automatic promotion and use up the link, all or nothing
+	emp, revision, err := app.internalEmployeeService.SubmitViaLink(auditContext(c), internalApp, token)
+	if err != nil {
+		c.Error(err)
 		return
 	}
-
-	// Match with employee record for automatic promotion
-	emp, err := app.internalEmployeeService.MatchWithExistingEmployee(internalApp)
-	if err == nil {
-		// Successfully matched, can trigger promotion process
-		app.log.Printf("Matched internal application from %s %s with employee ID %d", 
-			internalApp.FirstName, internalApp.LastName, emp.ID)
+	if revision {
+		app.applicationRevised(c)
+		return
 	}
+	app.metrics.ApplicationsSubmitted.WithLabelValues(metrics.KindInternal).Inc()
 
-	// Mark the link as used
-	if err := app.applicationLinkService.MarkLinkAsUsed(token); err != nil {
-		app.log.Printf("Failed to mark link as used: %v", err)
+	if emp.ID != 0 {
+		// Successfully matched, can trigger promotion process
+		app.log.InfoContext(c.Request.Context(), "Matched internal application with employee",
+			"job_id", internalApp.Jobid, "employee_id", emp.ID)
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Internal job application submitted successfully"})
@@ -141,49 +153,56 @@ func (app *Application) handleSecureExternalApplication(c *gin.Context) {
 	token := c.Param("token")
 	
 	// Validate the token
-	link, err := app.applicationLinkService.ValidateApplicationLink(token)
+	link, err := app.applicationLinkService.ValidateApplicationLink(c.Request.Context(), token)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	// Check if this is an external application link
 	if link.Type != "external" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid application type"})
+		c.Error(errWrongLinkType)
 		return
 	}
 
 	var externalApp data.ExternalEmployee
-	if err := c.ShouldBindJSON(&externalApp); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &externalApp); err != nil {
+		c.Error(err)
 		return
 	}
 
 	// Set the job ID from the link
 	externalApp.Jobid = link.JobID
 
-	// Process file upload if included
+	// Process file upload if included. Only a resume uploaded with this
+	// request is stored: a path in the body is ignored.
+	externalApp.Resumepath = ""
 	file, err := c.FormFile("resume")
 	if err == nil {
 		// A file was uploaded
-		dst := "static/resumes/" + token + "_" + file.Filename
+		dst := app.resumeUploadPath(file.Filename)
+		// The application keeps a copy of its own, so the upload goes
+		// when the request ends, whether or not it succeeds
+		defer os.Remove(dst)
 		if err := c.SaveUploadedFile(file, dst); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save resume"})
+			c.Error(err)
 			return
 		}
 		externalApp.Resumepath = dst
 	}
 
-	// Save the application
-	if err := app.externalEmployeeService.SaveExternalEmployee(externalApp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Save and audit the application, or a revision of the applicant's
+	// earlier one, and use up the link, all or nothing
+	revision, err := app.externalEmployeeService.SubmitViaLink(auditContext(c), externalApp, token)
+	if err != nil {
+		c.Error(err)
 		return
 	}
-
-	// Mark the link as used
-	if err := app.applicationLinkService.MarkLinkAsUsed(token); err != nil {
-		app.log.Printf("Failed to mark link as used: %v", err)
+	if revision {
+		app.applicationRevised(c)
+		return
 	}
+	app.metrics.ApplicationsSubmitted.WithLabelValues(metrics.KindExternal).Inc()
 
 	c.JSON(http.StatusCreated, gin.H{"message": "External job application submitted successfully"})
 } 
\ No newline at end of file
@@ -1,8 +1,11 @@
 package main
 
 import (
+    "context"
+    "log/slog"
     "net/http"
 
+    "github.com/brehan/bank/cmd/data"
     "github.com/brehan/bank/cmd/middleware"
     "github.com/brehan/bank/cmd/service"
     "github.com/gin-gonic/gin"
@@ -11,10 +14,14 @@ import (
 
 type AuthHandler struct {
     authService *service.AuthService
+    mfaService  *service.MFAService
+    log         *slog.Logger
+    // oidc is nil unless OIDC single sign-on is configured
+    oidc *oidcLogin
 }
 
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
-    return &AuthHandler{authService: authService}
+func NewAuthHandler(authService *service.AuthService, mfaService *service.MFAService, logger *slog.Logger) *AuthHandler {
+    return &AuthHandler{authService: authService, mfaService: mfaService, log: logger}
 }
 
 type loginRequest struct {
@@ -29,100 +36,136 @@ type registerRequest struct {
     District string `json:"district" binding:"required_if=Role district_manager"`
 }
 
+type authUser struct {
+    ID          string   `json:"id"`
+    Name        string   `json:"name"`
+    Role        string   `json:"role"`
+    Roles       []string `json:"roles"`
+    Permissions []string `json:"permissions"`
+    District    string   `json:"district,omitempty"`
+}
+
 type authResponse struct {
-    Token string `json:"token"`
-    User  struct {
-        ID       string `json:"id"`
-        Name     string `json:"name"`
-        Role     string `json:"role"`
-        District string `json:"district,omitempty"`
-    } `json:"user"`
+    Token string   `json:"token"`
+    User  authUser `json:"user"`
+}
+
+// mfaChallengeResponse is returned instead of authResponse when the password
+// was correct but a second factor is still needed. MFAToken is only accepted
+// by the /api/auth/mfa endpoints.
+type mfaChallengeResponse struct {
+    MFARequired        bool   `json:"mfa_required"`
+    EnrollmentRequired bool   `json:"enrollment_required"`
+    MFAToken           string `json:"mfa_token"`
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
 
     var req loginRequest
-    if err := c.ShouldBindJSON(&req); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+    if err := bindJSON(c, &req); err != nil {
+        c.Error(err)
         return
     }
 
-    user, role, district, err := h.authService.Login(req.Name, req.Password)
+    result, err := h.authService.Login(auditContext(c), req.Name, req.Password)
     if err != nil {
-        if err == service.ErrInvalidCredentials {
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-            return
-        }
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+        c.Error(err)
         return
     }
 
-    token, err := middleware.GenerateToken(user.Id, role, district)
+    h.startSession(c, http.StatusOK, result.User, result.Access)
+}
+
+func (h *AuthHandler) Register(c *gin.Context) {
+    var req registerRequest
+    if err := bindJSON(c, &req); err != nil {
+        c.Error(err)
+        return
+    }
+
+    user, access, err := h.authService.Register(auditContext(c), req.Name, req.Password, req.Role, req.District)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+        c.Error(err)
         return
     }
 
-    response := authResponse{
-        Token: token,
-        User: struct {
-            ID       string `json:"id"`
-            Name     string `json:"name"`
-            Role     string `json:"role"`
-            District string `json:"district,omitempty"`
-        }{
-            ID:       user.Id.String(),
-            Name:     user.Name,
-            Role:     role,
-            District: district,
-        },
+    h.startSession(c, http.StatusCreated, user, access)
+}
+
+// newSession issues a session token and records the login
+func (h *AuthHandler) newSession(ctx context.Context, user *data.User, access data.UserAccess) (string, error) {
+    token, err := middleware.GenerateToken(user, access)
+    if err != nil {
+        return "", err
     }
 
-    c.JSON(http.StatusOK, response)
+    if err := h.authService.RecordLogin(ctx, user.Id); err != nil {
+        h.log.ErrorContext(ctx, "Failed to record last login", "user_id", user.Id, "error", err)
+    }
+    return token, nil
 }
 
-func (h *AuthHandler) Register(c *gin.Context) {
-    var req registerRequest
-    if err := c.ShouldBindJSON(&req); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+// startSession responds with a full session token, or with an MFA challenge
+// when the user has two-factor enabled or their role requires it
+func (h *AuthHandler) startSession(c *gin.Context, status int, user *data.User, access data.UserAccess) {
+    challenge, err := h.mfaChallenge(c.Request.Context(), user, access)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+    if challenge != nil {
+        c.JSON(status, challenge)
         return
     }
 
-    user, role, district, err := h.authService.Register(req.Name, req.Password, req.Role, req.District)
+    h.issueToken(c, status, user, access)
+}
+
+// mfaChallenge returns the MFA challenge for a user who has two-factor
+// enabled or whose role requires it, and nil for anyone else
+func (h *AuthHandler) mfaChallenge(ctx context.Context, user *data.User, access data.UserAccess) (*mfaChallengeResponse, error) {
+    mfaEnabled, err := h.mfaService.IsEnabled(ctx, user.Id)
+    if err != nil {
+        return nil, err
+    }
+    if !mfaEnabled && !h.mfaService.IsRequired(access.RoleNames()...) {
+        return nil, nil
+    }
+
+    token, err := middleware.GenerateMFAPendingToken(user.Id)
     if err != nil {
-        switch err {
-        case service.ErrUserExists:
-            c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
-        case service.ErrInvalidRole:
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
-        case service.ErrInvalidDistrict:
-            c.JSON(http.StatusBadRequest, gin.H{"error": "District required for district_manager"})
-        default:
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-        }
+        return nil, err
+    }
+    return &mfaChallengeResponse{
+        MFARequired:        true,
+        EnrollmentRequired: !mfaEnabled,
+        MFAToken:           token,
+    }, nil
+}
+
+// issueToken responds with a full session token for the user
+func (h *AuthHandler) issueToken(c *gin.Context, status int, user *data.User, access data.UserAccess) {
+    // The account may have been disabled between the password and MFA steps
+    if user.Status == data.UserStatusDisabled {
+        c.Error(service.ErrAccountDisabled)
         return
     }
 
-    token, err := middleware.GenerateToken(user.Id, role, district)
+    token, err := h.newSession(c.Request.Context(), user, access)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+        c.Error(err)
         return
     }
 
-    response := authResponse{
+    c.JSON(status, authResponse{
         Token: token,
-        User: struct {
-            ID       string `json:"id"`
-            Name     string `json:"name"`
-            Role     string `json:"role"`
-            District string `json:"district,omitempty"`
-        }{
-            ID:       user.Id.String(),
-            Name:     user.Name,
-            Role:     role,
-            District: district,
+        User: authUser{
+            ID:          user.Id.String(),
+            Name:        user.Name,
+            Role:        access.PrimaryRole().Role,
+            Roles:       access.RoleNames(),
+            Permissions: access.Permissions,
+            District:    access.District(),
         },
-    }
-
-    c.JSON(http.StatusCreated, response)
+    })
 }
\ No newline at end of file
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brehan/bank/cmd/config"
+	"github.com/brehan/bank/cmd/migrate"
+)
+
+const commandUsage = `usage: api [flags] <command>
+
+commands:
+  audit verify                   check the audit log hash chain
+  config print [--redacted]      print the effective configuration as YAML
+  migrate up                     apply pending schema migrations
+  migrate down [n]               roll back the last n migrations (default 1)
+  migrate status                 list migrations and whether they are applied`
+
+// runCommand runs a maintenance command instead of the server and returns
+// the process exit code. Flags such as -datasource go before the command:
+//
+//	go run ./cmd/api -datasource=... audit verify
+func (app *Application) runCommand(args []string) int {
+	switch {
+	case len(args) == 2 && args[0] == "audit" && args[1] == "verify":
+		return app.verifyAuditLog()
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+}
+
+// verifyAuditLog walks the audit hash chain. It exits with 1 if any entry
+// was modified, removed or reordered.
+func (app *Application) verifyAuditLog() int {
+	result, err := app.auditService.Verify(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit verify: %v\n", err)
+		return 1
+	}
+
+	if !result.Valid {
+		fmt.Printf("audit log BROKEN at entry %d: %s\n", result.BrokenAt, result.Reason)
+		fmt.Printf("%d entries checked\n", result.Entries)
+		return 1
+	}
+
+	fmt.Printf("audit log OK: %d entries\n", result.Entries)
+	if result.LastHash != "" {
+		fmt.Printf("last hash: %s\n", result.LastHash)
+	}
+	return 0
+}
+
+// runConfigCommand handles "config print". It runs before the database is
+// opened, so it also works when the database is unreachable.
+func runConfigCommand(cfg *config.Config, args []string) int {
+	switch {
+	case len(args) == 1 && args[0] == "print":
+	case len(args) == 2 && args[0] == "print" && (args[1] == "--redacted" || args[1] == "-redacted"):
+		cfg = cfg.Redacted()
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+
+	if err := cfg.Write(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "config print: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runMigrateCommand handles "migrate up|down|status". It runs before the
+// schema check, which would otherwise stop a database that needs migrating
+// from being migrated.
+func runMigrateCommand(migrator *migrate.Migrator, args []string) int {
+	ctx := context.Background()
+	switch {
+	case len(args) == 1 && args[0] == "up":
+		ran, err := migrator.Up(ctx)
+		for _, m := range ran {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate up: %v\n", err)
+			return 1
+		}
+		if len(ran) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return 0
+	case (len(args) == 1 || len(args) == 2) && args[0] == "down":
+		n := 1
+		if len(args) == 2 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "migrate down: %q is not a positive number\n", args[1])
+				return 2
+			}
+		}
+		ran, err := migrator.Down(ctx, n)
+		for _, m := range ran {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate down: %v\n", err)
+			return 1
+		}
+		return 0
+	case len(args) == 1 && args[0] == "status":
+		return migrateStatus(ctx, migrator)
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+}
+
+// migrateStatus prints every migration and exits with 1 if the database
+// does not match this build
+func migrateStatus(ctx context.Context, migrator *migrate.Migrator) int {
+	states, err := migrator.Status(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate status: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range states {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Local().Format(time.RFC3339)
+		}
+		switch {
+		case s.Unknown:
+			applied += " (unknown to this build)"
+		case s.Modified:
+			applied += " (changed since applied)"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	w.Flush()
+
+	if err := migrator.Check(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
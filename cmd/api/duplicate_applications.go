@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/gin-gonic/gin"
+)
+
+// applicationRevised answers an application that was kept as a revision of
+// the applicant's earlier one for the job. It is not a new applicant, so it
+// is not counted as submitted.
+func (app *Application) applicationRevised(c *gin.Context) {
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "You had already applied for this job; this application was added to your earlier one as a revision",
+		"revision": true,
+	})
+}
+
+// Report applications that look like they come from the same person
+func (app *Application) getDuplicateApplications(c *gin.Context) {
+	groups, err := app.jobService.DuplicateApplications(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if groups == nil {
+		groups = []data.DuplicateGroup{}
+	}
+	c.JSON(http.StatusOK, gin.H{"duplicates": groups})
+}
@@ -1,27 +1,96 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/brehan/bank/cmd/apperr"
 	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/metrics"
+	"github.com/brehan/bank/cmd/middleware"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/service"
 )
 
+// errNoDistrict is returned on district routes to callers without a
+// district-scoped role, such as API keys
+var errNoDistrict = apperr.Forbidden("no_district", "district routes need a district-scoped role")
+
+// errScoreRange is returned for an evaluation score outside 0 to 100
+func errScoreRange(field string) error {
+	return apperr.Validation("score_out_of_range", field, "score must be between 0 and 100")
+}
+
+// managerDistrict returns the district of the caller's district-scoped role
+func managerDistrict(c *gin.Context) (string, error) {
+	district := c.GetString(middleware.DistrictKey)
+	if district == "" {
+		return "", errNoDistrict
+	}
+	return district, nil
+}
+
+// districtEmployee reads an employee for a district route, refusing one of
+// another district than the caller's
+func (app *Application) districtEmployee(c *gin.Context, id int) (data.Employee, error) {
+	district, err := managerDistrict(c)
+	if err != nil {
+		return data.Employee{}, err
+	}
+	emp, err := app.employeeByID(c.Request.Context(), id)
+	if err != nil {
+		return emp, err
+	}
+	if emp.District != district {
+		return data.Employee{}, service.ErrEmployeeOtherDistrict
+	}
+	return emp, nil
+}
+
+// requireDistrictEmployee lets a request for an employee through only if
+// the employee is in the caller's district
+func (app *Application) requireDistrictEmployee(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(errInvalidID)
+		c.Abort()
+		return
+	}
+	if _, err := app.districtEmployee(c, id); err != nil {
+		c.Error(err)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// employeeByID reads an employee from the store, reporting a missing one as
+// service.ErrEmployeeNotFound
+func (app *Application) employeeByID(ctx context.Context, id int) (data.Employee, error) {
+	emp, err := app.employees.GetEmployeeByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return emp, service.ErrEmployeeNotFound
+	}
+	return emp, err
+}
+
 // ===== Core Employee Management Handlers =====
 
 // Get employee by ID
 func (app *Application) getEmployeeById(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid employee ID"})
+		c.Error(errInvalidID)
 		return
 	}
 
-	employee, err := app.employeeService.GetEmployeeById(id)
+	employee, err := app.employeeService.GetEmployeeById(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		c.Error(err)
 		return
 	}
 
@@ -30,10 +99,9 @@ func (app *Application) getEmployeeById(c *gin.Context) {
 
 // Get all employees
 func (app *Application) getAllEmployees(c *gin.Context) {
-	employees, err := app.employeeService.GetAllEmployees()
+	employees, err := app.employeeService.GetAllEmployees(c.Request.Context())
 	if err != nil {
-		fmt.Printf("Error getting employees: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve employees"})
+		c.Error(err)
 		return
 	}
 
@@ -88,8 +156,8 @@ func (app *Application) getAllEmployees(c *gin.Context) {
 			employeeData["tmdrec20"] = 0.0
 		}
 		
-		if emp.Disrect15.Valid {
-			employeeData["disrec20"] = emp.Disrect15.Float64
+		if emp.Disrec15.Valid {
+			employeeData["disrec20"] = emp.Disrec15.Float64
 		} else {
 			employeeData["disrec20"] = 0.0
 		}
@@ -109,13 +177,28 @@ func (app *Application) getAllEmployees(c *gin.Context) {
 // Create new employee
 func (app *Application) createEmployee(c *gin.Context) {
 	var emp data.Employee
-	if err := c.ShouldBindJSON(&emp); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &emp); err != nil {
+		c.Error(err)
 		return
 	}
 
-	if err := app.employeeService.CreateEmployee(emp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Create the employee with the service bound to the transaction, so the
+	// employee and its audit entry are committed together
+	err := app.uow.WithTx(c.Request.Context(), func(tx repository.Stores) error {
+		employees := service.NewEmployeeService(tx.Employees)
+		if err := employees.CreateEmployee(c.Request.Context(), emp); err != nil {
+			return err
+		}
+
+		// Read the row back for its ID and computed scores
+		created, err := employees.GetEmployeeByFileNumber(c.Request.Context(), emp.FileNumber)
+		if err != nil {
+			return err
+		}
+		return auditTx(c, tx, data.AuditActionCreate, data.AuditEntityEmployee, strconv.Itoa(created.ID), nil, created)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -126,13 +209,13 @@ func (app *Application) createEmployee(c *gin.Context) {
 func (app *Application) updateEmployee(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid employee ID"})
+		c.Error(errInvalidID)
 		return
 	}
 
 	var emp data.Employee
-	if err := c.ShouldBindJSON(&emp); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &emp); err != nil {
+		c.Error(err)
 		return
 	}
 	
@@ -140,11 +223,12 @@ func (app *Application) updateEmployee(c *gin.Context) {
 	emp.ID = id
 
 	// First get existing employee to preserve fields not included in the request
-	existingEmp, err := app.employeeService.GetEmployeeById(id)
+	existingEmp, err := app.employeeService.GetEmployeeById(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		c.Error(err)
 		return
 	}
+	before := existingEmp
 	
 	// Update fields that were provided (this is simplified, you might need more logic)
 	if emp.FullName != "" {
@@ -159,13 +243,19 @@ func (app *Application) updateEmployee(c *gin.Context) {
 	}
 	
 	districtRec := 0.0
-	if existingEmp.Disrect15.Valid {
-		districtRec = existingEmp.Disrect15.Float64
+	if existingEmp.Disrec15.Valid {
+		districtRec = existingEmp.Disrec15.Float64
 	}
 	
-	// Save the updated employee
-	if err := app.employeeService.UpdateEmployeeManagerInputs(id, individualPMS, districtRec); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Save and audit the updated employee
+	err = app.uow.WithTx(c.Request.Context(), func(tx repository.Stores) error {
+		if err := service.NewEmployeeService(tx.Employees).UpdateEmployeeManagerInputs(c.Request.Context(), id, individualPMS, districtRec); err != nil {
+			return err
+		}
+		return auditEmployeeUpdate(c, tx, id, before)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -179,14 +269,14 @@ func (app *Application) updateEmployeePMS(c *gin.Context) {
 	employeeID := c.Param("id")
 	id, err := strconv.Atoi(employeeID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid employee ID"})
+		c.Error(errInvalidID)
 		return
 	}
 	
 	// Check if the employee exists
-	_, err = app.repo.GetEmployeeByID(id)
+	before, err := app.employeeByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		c.Error(err)
 		return
 	}
 	
@@ -195,23 +285,29 @@ func (app *Application) updateEmployeePMS(c *gin.Context) {
 		IndividualPMS float64 `json:"individual_pms" binding:"required"`
 	}
 	
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
 	
 	// Validate score (between 0 and 100)
 	if req.IndividualPMS < 0 || req.IndividualPMS > 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "IndividualPMS score must be between 0 and 100"})
+		c.Error(errScoreRange("individual_pms"))
 		return
 	}
 	
-	// Update the PMS score
-	err = app.repo.UpdateEmployeeIndividualPMS(id, req.IndividualPMS)
+	// Update and audit the PMS score
+	err = app.uow.WithTx(c.Request.Context(), func(tx repository.Stores) error {
+		if err := tx.Employees.UpdateEmployeeIndividualPMS(c.Request.Context(), id, req.IndividualPMS); err != nil {
+			return err
+		}
+		return auditEmployeeUpdate(c, tx, id, before)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update PMS score"})
+		c.Error(err)
 		return
 	}
+	app.countScoreUpdate(c, metrics.ScoreIndividualPMS)
 	
 	c.JSON(http.StatusOK, gin.H{"message": "Individual PMS score updated successfully"})
 }
@@ -221,14 +317,14 @@ func (app *Application) updateEmployeeManagerRecommendation(c *gin.Context) {
 	employeeID := c.Param("id")
 	id, err := strconv.Atoi(employeeID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid employee ID"})
+		c.Error(errInvalidID)
 		return
 	}
 	
 	// Check if the employee exists
-	_, err = app.repo.GetEmployeeByID(id)
+	before, err := app.employeeByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		c.Error(err)
 		return
 	}
 	
@@ -237,23 +333,29 @@ func (app *Application) updateEmployeeManagerRecommendation(c *gin.Context) {
 		ManagerRecommendation float64 `json:"manager_recommendation" binding:"required"`
 	}
 	
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
 	
 	// Validate score (between 0 and 100)
 	if req.ManagerRecommendation < 0 || req.ManagerRecommendation > 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Manager recommendation score must be between 0 and 100"})
+		c.Error(errScoreRange("manager_recommendation"))
 		return
 	}
 	
-	// Update the recommendation score
-	err = app.repo.UpdateEmployeeManagerRecommendation(id, req.ManagerRecommendation)
+	// Update and audit the recommendation score
+	err = app.uow.WithTx(c.Request.Context(), func(tx repository.Stores) error {
+		if err := tx.Employees.UpdateEmployeeManagerRecommendation(c.Request.Context(), id, req.ManagerRecommendation); err != nil {
+			return err
+		}
+		return auditEmployeeUpdate(c, tx, id, before)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update manager recommendation"})
+		c.Error(err)
 		return
 	}
+	app.countScoreUpdate(c, metrics.ScoreManagerRecommendation)
 	
 	c.JSON(http.StatusOK, gin.H{"message": "Manager recommendation updated successfully"})
 }
@@ -263,14 +365,14 @@ func (app *Application) updateEmployeeDistrictRec(c *gin.Context) {
 	employeeID := c.Param("id")
 	id, err := strconv.Atoi(employeeID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid employee ID"})
+		c.Error(errInvalidID)
 		return
 	}
 	
-	// Check if the employee exists
-	_, err = app.repo.GetEmployeeByID(id)
+	// Check that the employee exists and is in the manager's district
+	before, err := app.districtEmployee(c, id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		c.Error(err)
 		return
 	}
 	
@@ -279,40 +381,66 @@ func (app *Application) updateEmployeeDistrictRec(c *gin.Context) {
 		DistrictRecommendation float64 `json:"district_recommendation" binding:"required"`
 	}
 	
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
 		return
 	}
 	
 	// Validate score (between 0 and 100)
 	if req.DistrictRecommendation < 0 || req.DistrictRecommendation > 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "District recommendation score must be between 0 and 100"})
+		c.Error(errScoreRange("district_recommendation"))
 		return
 	}
 	
-	// Update the district recommendation score
-	err = app.repo.UpdateEmployeeDistrictRecommendation(id, req.DistrictRecommendation)
+	// Update and audit the district recommendation score
+	err = app.uow.WithTx(c.Request.Context(), func(tx repository.Stores) error {
+		if err := tx.Employees.UpdateEmployeeDistrictRecommendation(c.Request.Context(), id, req.DistrictRecommendation); err != nil {
+			return err
+		}
+		return auditEmployeeUpdate(c, tx, id, before)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update district recommendation"})
+		c.Error(err)
 		return
 	}
+	app.countScoreUpdate(c, metrics.ScoreDistrictRecommendation)
 	
 	c.JSON(http.StatusOK, gin.H{"message": "District recommendation updated successfully"})
 }
 
+// countScoreUpdate counts a score update by the role of the caller. API
+// keys have no role and are counted as middleware.APIKeyRole.
+func (app *Application) countScoreUpdate(c *gin.Context, score string) {
+	role := c.GetString(middleware.RoleKey)
+	if role == "" {
+		role = middleware.APIKeyRole
+	}
+	app.metrics.ScoreUpdates.WithLabelValues(role, score).Inc()
+}
+
+// auditEmployeeUpdate records an employee update made in tx, reading the row
+// back so the entry shows recomputed scores as well as the changed field
+func auditEmployeeUpdate(c *gin.Context, tx repository.Stores, id int, before data.Employee) error {
+	after, err := tx.Employees.GetEmployeeByID(c.Request.Context(), id)
+	if err != nil {
+		return err
+	}
+	return auditTx(c, tx, data.AuditActionUpdate, data.AuditEntityEmployee, strconv.Itoa(id), before, after)
+}
+
 // Get employee promotion evaluation details
 func (app *Application) getEmployeeEvaluation(c *gin.Context) {
 	employeeID := c.Param("id")
 	id, err := strconv.Atoi(employeeID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid employee ID"})
+		c.Error(errInvalidID)
 		return
 	}
 	
 	// Get the employee with all evaluation scores
-	employee, err := app.repo.GetEmployeeByID(id)
+	employee, err := app.employeeByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		c.Error(err)
 		return
 	}
 	
@@ -353,8 +481,8 @@ func (app *Application) getEmployeeEvaluation(c *gin.Context) {
 		evaluationDetails["manager_rec"] = 0.0
 	}
 	
-	if employee.Disrect15.Valid {
-		evaluationDetails["district_rec"] = employee.Disrect15.Float64
+	if employee.Disrec15.Valid {
+		evaluationDetails["district_rec"] = employee.Disrec15.Float64
 	} else {
 		evaluationDetails["district_rec"] = 0.0
 	}
@@ -374,46 +502,38 @@ func (app *Application) getEmployeeEvaluation(c *gin.Context) {
 func (app *Application) getEmployeeForDistrictManager(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid employee ID"})
+		c.Error(errInvalidID)
 		return
 	}
 	
-	// Get the district manager's branch from the authenticated user
-	managerBranch := c.GetString("user_branch")
-	if managerBranch == "" {
-		// For testing purposes - you should remove this in production
-		managerBranch = c.Query("branch")
-		if managerBranch == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing branch information"})
-			return
-		}
+	// Get the district manager's district from the authenticated user
+	district, err := managerDistrict(c)
+	if err != nil {
+		c.Error(err)
+		return
 	}
 
-	employee, err := app.employeeService.GetEmployeeForDistrictManager(id, managerBranch)
+	employee, err := app.employeeService.GetEmployeeForDistrictManager(c.Request.Context(), id, district)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, employee)
 }
 
-// Get all employees for district manager (limited info, filtered by branch)
+// Get all employees for district manager (limited info, filtered by district)
 func (app *Application) getEmployeesForDistrictManager(c *gin.Context) {
-	// Get the district manager's branch from the authenticated user
-	managerBranch := c.GetString("user_branch")
-	if managerBranch == "" {
-		// For testing purposes - you should remove this in production
-		managerBranch = c.Query("branch")
-		if managerBranch == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing branch information"})
-			return
-		}
+	// Get the district manager's district from the authenticated user
+	district, err := managerDistrict(c)
+	if err != nil {
+		c.Error(err)
+		return
 	}
 
-	employees, err := app.employeeService.GetEmployeesByBranch(managerBranch)
+	employees, err := app.employeeService.GetEmployeesByDistrict(c.Request.Context(), district)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -422,13 +542,11 @@ func (app *Application) getEmployeesForDistrictManager(c *gin.Context) {
 
 // Get all employees with minimal fields
 func (app *Application) getAllEmployeesSimple(c *gin.Context) {
-	fmt.Println("DEBUG: getAllEmployeesSimple handler called")
-	
 	// Use the full GetAllEmployees method to ensure complete data
-	employees, err := app.repo.GetAllEmployees()
+	employees, err := app.employees.GetAllEmployees(c.Request.Context())
 	if err != nil {
-		fmt.Printf("DEBUG: Error getting employees: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to retrieve employees: %v", err)})
+		app.log.ErrorContext(c.Request.Context(), "Error getting employees", "error", err)
+		c.Error(err)
 		return
 	}
 
@@ -462,6 +580,6 @@ func (app *Application) getAllEmployeesSimple(c *gin.Context) {
 		response = append(response, employeeData)
 	}
 
-	fmt.Printf("DEBUG: Retrieved %d employees for simplified endpoint\n", len(employees))
+	app.log.DebugContext(c.Request.Context(), "Retrieved employees for simplified endpoint", "count", len(employees))
 	c.JSON(http.StatusOK, response)
 }
\ No newline at end of file
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+var (
+	errRouteNotFound = apperr.NotFound("route_not_found", "no such endpoint")
+	errInvalidBody   = apperr.Validation("invalid_body", "", "request body is not valid")
+	errInvalidID     = apperr.Validation("invalid_id", "id", "invalid ID")
+	errInvalidQuery  = apperr.Validation("invalid_query", "", "invalid query parameter")
+)
+
+func init() {
+	// Name fields in validation errors as the client sent them
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" || name == "" {
+				return f.Name
+			}
+			return name
+		})
+	}
+}
+
+// bindJSON decodes and validates the JSON request body into obj. The error
+// names each field that failed validation.
+func bindJSON(c *gin.Context, obj interface{}) error {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return nil
+	}
+
+	var tooLarge *http.MaxBytesError
+	var invalid validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &tooLarge):
+		return middleware.ErrBodyTooLarge
+	case errors.As(err, &invalid):
+		fields := make([]apperr.FieldError, 0, len(invalid))
+		for _, fe := range invalid {
+			fields = append(fields, apperr.FieldError{Field: fe.Field(), Message: fieldMessage(fe)})
+		}
+		return errInvalidBody.WithFields(fields...)
+	case errors.As(err, &typeErr):
+		return errInvalidBody.WithFields(apperr.FieldError{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()})
+	case errors.As(err, &syntaxErr):
+		return apperr.Validation("invalid_json", "", "request body is not valid JSON")
+	}
+	return errInvalidBody.Wrap(err)
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param()
+	}
+	return "failed the " + fe.Tag() + " check"
+}
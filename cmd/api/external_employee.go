@@ -2,45 +2,59 @@ package main
 
 import (
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/metrics"
 )
 
 // Handle external employee job application
 func (app *Application) handleExternalJobApplication(c *gin.Context) {
 	var externalApp data.ExternalEmployee
-	if err := c.ShouldBindJSON(&externalApp); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &externalApp); err != nil {
+		c.Error(err)
 		return
 	}
 
-	// Process file upload if included
+	// Process file upload if included. Only a resume uploaded with this
+	// request is stored: a path in the body is ignored.
+	externalApp.Resumepath = ""
 	file, err := c.FormFile("resume")
 	if err == nil {
 		// A file was uploaded
-		dst := "static/" + file.Filename
+		dst := app.resumeUploadPath(file.Filename)
+		// The application keeps a copy of its own, so the upload goes
+		// when the request ends, whether or not it succeeds
+		defer os.Remove(dst)
 		if err := c.SaveUploadedFile(file, dst); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save resume"})
+			c.Error(err)
 			return
 		}
 		externalApp.Resumepath = dst
 	}
 
-	// Save the application
-	if err := app.externalEmployeeService.SaveExternalEmployee(externalApp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Save the application, or a revision of the applicant's earlier one,
+	// and audit it in the same transaction
+	revision, err := app.externalEmployeeService.SaveExternalEmployee(auditContext(c), externalApp)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if revision {
+		app.applicationRevised(c)
 		return
 	}
+	app.metrics.ApplicationsSubmitted.WithLabelValues(metrics.KindExternal).Inc()
 
 	c.JSON(http.StatusCreated, gin.H{"message": "External job application submitted successfully"})
 }
 
 // Get all external job applications
 func (app *Application) getAllExternalApplications(c *gin.Context) {
-	applications, err := app.externalEmployeeService.GetAllExternalApplications()
+	applications, err := app.externalEmployeeService.GetAllExternalApplications(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -51,9 +65,9 @@ func (app *Application) getAllExternalApplications(c *gin.Context) {
 func (app *Application) getExternalApplicationsByJob(c *gin.Context) {
 	jobID := c.Param("id")
 	
-	applications, err := app.externalEmployeeService.GetApplicationsByJobID(jobID)
+	applications, err := app.externalEmployeeService.GetApplicationsByJobID(c.Request.Context(), jobID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds all readiness checks together, so that a load
+// balancer probing /readyz gets an answer before its own timeout
+const readinessTimeout = 2 * time.Second
+
+// readinessCheck is a dependency that must work for the API to serve
+// requests
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// healthz reports that the process is up. It checks nothing else, so that
+// an outage of the database does not get the API restarted.
+func (app *Application) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz runs the readiness checks and answers 503 if any of them fails.
+// Failures are logged; the response only names the failing checks.
+func (app *Application) readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	checks := gin.H{}
+	for _, rc := range app.readiness {
+		if err := rc.check(ctx); err != nil {
+			app.log.ErrorContext(ctx, "Readiness check failed", "check", rc.name, "error", err)
+			checks[rc.name] = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		checks[rc.name] = "ok"
+	}
+	result := "ready"
+	if status != http.StatusOK {
+		result = "unavailable"
+	}
+	c.JSON(status, gin.H{"status": result, "checks": checks})
+}
+
+// checkDirWritable checks that files can be created in dir
+func checkDirWritable(dir string) func(context.Context) error {
+	return func(context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
@@ -5,6 +5,9 @@ import(
 	"errors"
 	"regexp"
 	"fmt"
+	"path/filepath"
+
+	"github.com/google/uuid"
 
 	
 
@@ -28,9 +31,9 @@ func (app *Application)verfiypassword(password string,hash string)(bool,error){
 	return true ,nil
 }
 func(app *Application) saveresume(name string ,pdf []byte)error{
-	filepath:="/home/oem/Desktop/final_brehan_project/cmd/static/resumes/"+name+".pdf"
+	path:=filepath.Join(app.config.Storage.ResumeDir,name+".pdf")
 
-	file,err:=os.Create(filepath)
+	file,err:=os.Create(path)
 	if err!=nil{
 		return err
 	}
@@ -48,6 +51,14 @@ func(app *Application) saveresume(name string ,pdf []byte)error{
 return nil
 
 }
+// resumeUploadPath is where an uploaded resume is saved until the
+// application is stored. Only the base of the client's file name is kept,
+// so it cannot point outside the resume directory, and a random prefix
+// keeps uploads of the same name apart.
+func (app *Application) resumeUploadPath(filename string) string {
+	return filepath.Join(app.config.Storage.ResumeDir, uuid.NewString()+"_"+filepath.Base(filename))
+}
+
 func sanitizeFileName(name string) (string, error) {
     reg, err := regexp.Compile(`[^a-zA-Z0-9_-]+`)
     if err != nil {
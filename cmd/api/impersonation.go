@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/brehan/bank/cmd/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type impersonateRequest struct {
+	// Write allows changes; impersonation is read-only unless it is set
+	Write bool `json:"write"`
+	// TTLMinutes defaults to 15 and may be at most 60
+	TTLMinutes int `json:"ttl_minutes"`
+}
+
+// impersonateUser handles POST /api/admin/users/:id/impersonate. It returns
+// a short-lived token that carries the user's own roles and names the admin
+// in its act claim. Responses to requests made with it carry the
+// X-Impersonated-By and X-Impersonation-Mode headers.
+func (app *Application) impersonateUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Error(errInvalidID)
+		return
+	}
+
+	var req impersonateRequest
+	if c.Request.ContentLength != 0 {
+		if err := bindJSON(c, &req); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+
+	adminID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	imp, err := app.authService.Impersonate(auditContext(c), adminID, userID, !req.Write, time.Duration(req.TTLMinutes)*time.Minute)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	token, err := middleware.GenerateImpersonationToken(imp.Impersonator, imp.User, imp.Access, imp.ReadOnly, imp.ExpiresAt)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      token,
+		"mode":       imp.Mode(),
+		"expires_at": imp.ExpiresAt.UTC(),
+		"user":       userJSON(imp.User, imp.Access),
+	})
+}
@@ -0,0 +1,1109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/config"
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/middleware"
+	"github.com/brehan/bank/cmd/migrate"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/service"
+	"github.com/brehan/bank/cmd/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// ctx is the context of the store and service calls in these tests
+var ctx = context.Background()
+
+// The tests in this file run the real router against a migrated SQLite
+// database and compare responses with the golden files in testdata/golden.
+// After an intended change to a response, rewrite them with
+//
+//	go test ./api -update
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	middleware.SetJWTKey([]byte("integration-test-signing-key-0123456789"))
+	os.Exit(m.Run())
+}
+
+// fixturePassword is the password of every fixture user
+const fixturePassword = "correct horse battery"
+
+// testServer is the API on a fresh database, with one user per role
+type testServer struct {
+	t       *testing.T
+	app     *Application
+	handler http.Handler
+}
+
+// newTestServer starts the API with flags appended to the test settings
+func newTestServer(t *testing.T, flags ...string) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	args := []string{
+		"-driver", config.DriverSQLite,
+		"-datasource", filepath.Join(dir, "bank.db"),
+		"-resume-dir", filepath.Join(dir, "resumes"),
+		"-frontend-base-url", "https://jobs.example",
+	}
+	args = append(args, flags...)
+	noEnv := func(string) (string, bool) { return "", false }
+	cfg, _, err := config.Load("api", args, noEnv, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(cfg.Storage.ResumeDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := repository.Open(repository.SQLite, cfg.Database.Datasource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrate.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := newApplication(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), repository.NewStores(db), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{t: t, app: app, handler: app.routes()}
+
+	fixtures := []struct{ name, role, district string }{
+		{"admin", data.RoleAdmin, ""},
+		{"manager", data.RoleManager, ""},
+		{"district", data.RoleDistrictManager, "North"},
+	}
+	for _, f := range fixtures {
+		if _, _, err := app.authService.Register(ctx, f.name, fixturePassword, f.role, f.district); err != nil {
+			t.Fatalf("registering %s: %v", f.name, err)
+		}
+	}
+	return s
+}
+
+// do sends body as JSON, with token as the bearer token unless it is empty
+func (s *testServer) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect checks the status of a response and decodes its body into out,
+// unless out is nil
+func (s *testServer) expect(rec *httptest.ResponseRecorder, status int, out interface{}) {
+	s.t.Helper()
+	if rec.Code != status {
+		s.t.Fatalf("status %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+	}
+}
+
+// login signs a fixture user in and returns their session token. For roles
+// that require two-factor this also enrols a TOTP secret.
+func (s *testServer) login(name string) string {
+	s.t.Helper()
+	var session struct {
+		Token    string `json:"token"`
+		MFAToken string `json:"mfa_token"`
+	}
+	s.expect(s.do("POST", "/api/auth/login", "", loginRequest{Name: name, Password: fixturePassword}), http.StatusOK, &session)
+	if session.MFAToken == "" {
+		return session.Token
+	}
+
+	var enrollment service.MFAEnrollment
+	s.expect(s.do("POST", "/api/auth/mfa/enroll", session.MFAToken, nil), http.StatusOK, &enrollment)
+	code, err := service.TOTPCode(enrollment.Secret, time.Now().Unix()/30)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.expect(s.do("POST", "/api/auth/mfa/enroll/confirm", session.MFAToken, mfaCodeRequest{Code: code}), http.StatusOK, &session)
+	return session.Token
+}
+
+var (
+	uuidPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	timePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+	jwtPattern  = regexp.MustCompile(`eyJ[\w-]*\.[\w-]*\.[\w-]*`)
+	linkPattern = regexp.MustCompile(`/apply/(internal|external)/[\w-]+`)
+)
+
+// volatileKeys hold values that differ on every run and are replaced
+// wholesale in golden files
+var volatileKeys = map[string]bool{
+	"token":            true,
+	"mfa_token":        true,
+	"secret":           true,
+	"provisioning_uri": true,
+	"recovery_codes":   true,
+}
+
+// scrub replaces generated IDs, tokens and timestamps with placeholders so
+// that golden files only change when the shape of a response does
+func scrub(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if volatileKeys[key] {
+				v[key] = "<" + key + ">"
+			} else {
+				v[key] = scrub(value)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = scrub(v[i])
+		}
+	case string:
+		v = jwtPattern.ReplaceAllString(v, "<jwt>")
+		v = uuidPattern.ReplaceAllString(v, "<uuid>")
+		v = timePattern.ReplaceAllString(v, "<time>")
+		return linkPattern.ReplaceAllString(v, "/apply/$1/<token>")
+	}
+	return v
+}
+
+// golden compares the status and scrubbed body of a response with
+// testdata/golden/<name>.json
+func (s *testServer) golden(name string, rec *httptest.ResponseRecorder) {
+	s.t.Helper()
+	var body interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		s.t.Fatalf("%s: decoding %s: %v", name, rec.Body, err)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]interface{}{"status": rec.Code, "body": scrub(body)}); err != nil {
+		s.t.Fatal(err)
+	}
+	got := buf.Bytes()
+
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			s.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			s.t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		s.t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		s.t.Errorf("%s does not match %s:\n%s", name, path, got)
+	}
+}
+
+func TestLoginFlow(t *testing.T) {
+	s := newTestServer(t)
+
+	s.golden("login_wrong_password", s.do("POST", "/api/auth/login", "", loginRequest{Name: "manager", Password: "wrong"}))
+	s.golden("login_manager", s.do("POST", "/api/auth/login", "", loginRequest{Name: "manager", Password: fixturePassword}))
+	s.golden("login_district_manager", s.do("POST", "/api/auth/login", "", loginRequest{Name: "district", Password: fixturePassword}))
+
+	rec := s.do("POST", "/api/auth/login", "", loginRequest{Name: "admin", Password: fixturePassword})
+	s.golden("login_admin_mfa_challenge", rec)
+	var challenge mfaChallengeResponse
+	s.expect(rec, http.StatusOK, &challenge)
+
+	// The pending token only opens the MFA endpoints
+	s.expect(s.do("GET", "/api/employees/", challenge.MFAToken, nil), http.StatusUnauthorized, nil)
+
+	rec = s.do("POST", "/api/auth/mfa/enroll", challenge.MFAToken, nil)
+	s.golden("mfa_enroll", rec)
+	var enrollment service.MFAEnrollment
+	s.expect(rec, http.StatusOK, &enrollment)
+	code, err := service.TOTPCode(enrollment.Secret, time.Now().Unix()/30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.golden("mfa_enroll_confirm", s.do("POST", "/api/auth/mfa/enroll/confirm", challenge.MFAToken, mfaCodeRequest{Code: code}))
+}
+
+func TestSessionRevocation(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.login("admin")
+	manager := s.login("manager")
+	district := s.login("district")
+
+	var users []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	s.expect(s.do("GET", "/api/v1/admin/users", admin, nil), http.StatusOK, &users)
+	ids := make(map[string]string)
+	for _, u := range users {
+		ids[u.Name] = u.ID
+	}
+
+	// Changing a user's roles ends the sessions issued before
+	s.expect(s.do("GET", "/api/v1/employees/", manager, nil), http.StatusOK, nil)
+	s.expect(s.do("POST", "/api/v1/admin/users/"+ids["manager"]+"/roles", admin, userRoleRequest{Role: data.RoleDistrictManager, District: "South"}), http.StatusOK, nil)
+	s.expect(s.do("GET", "/api/v1/employees/", manager, nil), http.StatusUnauthorized, nil)
+	s.expect(s.do("GET", "/api/v1/employees/", s.login("manager"), nil), http.StatusOK, nil)
+
+	// So does disabling them, and enabling them again does not bring the
+	// sessions back
+	s.expect(s.do("POST", "/api/v1/admin/users/"+ids["district"]+"/disable", admin, nil), http.StatusOK, nil)
+	s.expect(s.do("GET", "/api/v1/employees/", district, nil), http.StatusUnauthorized, nil)
+	s.expect(s.do("POST", "/api/v1/admin/users/"+ids["district"]+"/enable", admin, nil), http.StatusOK, nil)
+	s.expect(s.do("GET", "/api/v1/employees/", district, nil), http.StatusUnauthorized, nil)
+}
+
+func TestEmployeeScoring(t *testing.T) {
+	s := newTestServer(t)
+	admin, manager, district := s.login("admin"), s.login("manager"), s.login("district")
+
+	hired := time.Now().AddDate(-10, 0, 0)
+	employee := gin.H{
+		"id":              1,
+		"file_number":     "BB-0001",
+		"full_name":       "Eve Tadesse",
+		"sex":             "Female",
+		"employment_date": hired,
+		"branch":          "Bole",
+		"district":        "North",
+		"job_grade":       "JG-7",
+	}
+	s.golden("employee_create_forbidden", s.do("POST", "/api/admin/employees", manager, employee))
+	s.golden("employee_create", s.do("POST", "/api/admin/employees", admin, employee))
+	s.golden("employee_list", s.do("GET", "/api/employees/", manager, nil))
+
+	s.golden("employee_pms_invalid", s.do("PATCH", "/api/manager/employees/1/pms", manager, gin.H{"individual_pms": 120}))
+	s.golden("employee_pms", s.do("PATCH", "/api/manager/employees/1/pms", manager, gin.H{"individual_pms": 80}))
+	s.golden("employee_manager_recommendation", s.do("PATCH", "/api/manager/employees/1/recommendation", manager, gin.H{"manager_recommendation": 90}))
+	s.golden("employee_district_recommendation_forbidden", s.do("PATCH", "/api/district/employees/1/recommendation", manager, gin.H{"district_recommendation": 70}))
+	s.golden("employee_district_recommendation", s.do("PATCH", "/api/district/employees/1/recommendation", district, gin.H{"district_recommendation": 60}))
+	s.golden("employee_evaluation", s.do("GET", "/api/manager/employees/1/evaluation", manager, nil))
+
+	s.expectMetrics(
+		`bank_score_updates_total{role="manager",score="individual_pms"} 1`,
+		`bank_score_updates_total{role="manager",score="manager_recommendation"} 1`,
+		`bank_score_updates_total{role="district_manager",score="district_recommendation"} 1`,
+	)
+}
+
+func TestDistrictScope(t *testing.T) {
+	s := newTestServer(t)
+	admin, district := s.login("admin"), s.login("district")
+
+	hired := time.Now().AddDate(-10, 0, 0)
+	for _, emp := range []gin.H{
+		{"id": 1, "file_number": "BB-0001", "full_name": "Eve Tadesse", "sex": "Female", "employment_date": hired, "branch": "Bole", "district": "North"},
+		{"id": 2, "file_number": "BB-0003", "full_name": "Hana Bekele", "sex": "Female", "employment_date": hired, "branch": "Bole", "district": "South"},
+	} {
+		s.expect(s.do("POST", "/api/v1/admin/employees", admin, emp), http.StatusCreated, nil)
+	}
+
+	// The district comes from the manager's role, not from the request
+	var employees []data.Employee
+	s.expect(s.do("GET", "/api/v1/district/employees?branch=Bole", district, nil), http.StatusOK, &employees)
+	if len(employees) != 1 || employees[0].FullName != "Eve Tadesse" {
+		t.Errorf("employees = %+v, want only the North one", employees)
+	}
+	s.expect(s.do("GET", "/api/v1/district/employees/1", district, nil), http.StatusOK, nil)
+
+	s.expect(s.do("GET", "/api/v1/district/employees/2", district, nil), http.StatusForbidden, nil)
+	s.expect(s.do("GET", "/api/v1/district/employees/2/evaluation", district, nil), http.StatusForbidden, nil)
+	s.expect(s.do("PATCH", "/api/v1/district/employees/2/recommendation", district, gin.H{"district_recommendation": 60}), http.StatusForbidden, nil)
+
+	var evaluation struct {
+		DistrictRec float64 `json:"district_rec"`
+	}
+	s.expect(s.do("GET", "/api/v1/manager/employees/2/evaluation", s.login("manager"), nil), http.StatusOK, &evaluation)
+	if evaluation.DistrictRec != 0 {
+		t.Errorf("district recommendation of another district's employee = %v", evaluation.DistrictRec)
+	}
+}
+
+func TestJobApplicationFlow(t *testing.T) {
+	s := newTestServer(t)
+	admin, manager := s.login("admin"), s.login("manager")
+
+	hired := time.Now().AddDate(-6, 0, 0)
+	s.expect(s.do("POST", "/api/admin/employees", admin, gin.H{
+		"id": 1, "file_number": "BB-0002", "full_name": "Abel Girma", "sex": "Male", "employment_date": hired,
+	}), http.StatusCreated, nil)
+
+	job := data.Job{Title: "Branch Manager", Description: "Runs a branch", Department: "Retail", Location: "Addis Ababa", JobType: "both"}
+	rec := s.do("POST", "/api/admin/jobs/", admin, job)
+	s.golden("job_create", rec)
+	var created struct {
+		JobID string `json:"job_id"`
+	}
+	s.expect(rec, http.StatusCreated, &created)
+	s.golden("job_get", s.do("GET", "/api/admin/jobs/"+created.JobID, admin, nil))
+	s.golden("public_jobs", s.do("GET", "/api/public/jobs", "", nil))
+
+	rec = s.do("POST", "/api/admin/jobs/"+created.JobID+"/application-links", admin, nil)
+	s.golden("application_links_generate", rec)
+	var generated struct {
+		Links []data.ApplicationLinkResponse `json:"links"`
+	}
+	s.expect(rec, http.StatusOK, &generated)
+	s.golden("application_links_list", s.do("GET", "/api/admin/jobs/"+created.JobID+"/application-links", admin, nil))
+
+	internalToken := filepath.Base(generated.Links[0].URL)
+	externalToken := filepath.Base(generated.Links[1].URL)
+	s.golden("secure_form", s.do("GET", "/api/secure/apply/"+internalToken, "", nil))
+	s.golden("secure_apply_wrong_type", s.do("POST", "/api/secure/apply/external/"+internalToken, "", data.ExternalEmployee{FirstName: "Hana"}))
+
+	internal := data.InternalEmployee{FirstName: "Abel", LastName: "Girma"}
+	s.golden("secure_apply_internal", s.do("POST", "/api/secure/apply/internal/"+internalToken, "", internal))
+	s.golden("secure_apply_internal_reused", s.do("POST", "/api/secure/apply/internal/"+internalToken, "", internal))
+	s.golden("secure_apply_external", s.do("POST", "/api/secure/apply/external/"+externalToken, "", data.ExternalEmployee{FirstName: "Hana", LastName: "Bekele"}))
+
+	s.golden("job_applications", s.do("GET", "/api/admin/jobs/"+created.JobID+"/applications", admin, nil))
+	s.golden("matched_employee_evaluation", s.do("GET", "/api/manager/employees/1/evaluation", manager, nil))
+
+	// Listing the applications shows the match without restarting the
+	// employee's evaluation
+	s.expect(s.do("PATCH", "/api/manager/employees/1/pms", manager, gin.H{"individual_pms": 80}), http.StatusOK, nil)
+	var listed struct {
+		Applications []data.InternalEmployee `json:"applications"`
+	}
+	s.expect(s.do("GET", "/api/admin/applications/internal/"+created.JobID, admin, nil), http.StatusOK, &listed)
+	if len(listed.Applications) != 1 || listed.Applications[0].MatchedEmployee != "Abel Girma" {
+		t.Errorf("applications = %+v", listed.Applications)
+	}
+	var evaluation struct {
+		Indpms25 float64 `json:"indpms25"`
+	}
+	s.expect(s.do("GET", "/api/manager/employees/1/evaluation", manager, nil), http.StatusOK, &evaluation)
+	if evaluation.Indpms25 == 0 {
+		t.Error("listing the applications reset the evaluation")
+	}
+}
+
+func TestAPIVersions(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("manager")
+
+	rec := s.do("GET", "/api/v1/employees/", token, nil)
+	s.expect(rec, http.StatusOK, nil)
+	for _, header := range []string{"Deprecation", "Sunset", "Link"} {
+		if got := rec.Header().Get(header); got != "" {
+			t.Errorf("/api/v1 sends %s: %s", header, got)
+		}
+	}
+	current := rec.Body.String()
+
+	rec = s.do("GET", "/api/employees/", token, nil)
+	s.expect(rec, http.StatusOK, nil)
+	if rec.Body.String() != current {
+		t.Errorf("/api alias answers %s, want the /api/v1 response %s", rec.Body, current)
+	}
+	want := map[string]string{
+		"Deprecation": fmt.Sprintf("@%d", legacyAPIDeprecated.Unix()),
+		"Sunset":      "Wed, 30 Jun 2027 00:00:00 GMT",
+		"Link":        `</api/v1/employees/>; rel="successor-version"`,
+	}
+	for header, value := range want {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("/api alias %s = %q, want %q", header, got, value)
+		}
+	}
+
+	// Sessions from either version work on the other
+	var session struct {
+		Token string `json:"token"`
+	}
+	s.expect(s.do("POST", "/api/v1/auth/login", "", loginRequest{Name: "manager", Password: fixturePassword}), http.StatusOK, &session)
+	s.expect(s.do("GET", "/api/manager/dashboard", session.Token, nil), http.StatusOK, nil)
+}
+
+func TestBodyLimit(t *testing.T) {
+	s := newTestServer(t)
+	huge := strings.Repeat("x", s.app.config.Server.MaxBodyBytes)
+	rec := s.do("POST", "/api/auth/login", "", map[string]string{"username": "admin", "password": huge})
+	s.expect(rec, http.StatusRequestEntityTooLarge, nil)
+}
+
+func TestProblemDetails(t *testing.T) {
+	s := newTestServer(t)
+	engine := s.handler.(*gin.Engine)
+	engine.GET("/test/fail", func(c *gin.Context) {
+		c.Error(errors.New("dial tcp db:5432: password=hunter2 rejected"))
+	})
+	engine.GET("/test/panic", func(c *gin.Context) {
+		panic("nil map")
+	})
+
+	problem := func(rec *httptest.ResponseRecorder, status int, code string) middleware.Problem {
+		t.Helper()
+		if got := rec.Header().Get("Content-Type"); got != middleware.ProblemContentType {
+			t.Errorf("Content-Type = %q, want %q", got, middleware.ProblemContentType)
+		}
+		var p middleware.Problem
+		s.expect(rec, status, &p)
+		if p.Code != code || p.Status != status {
+			t.Errorf("problem = %+v, want code %s and status %d", p, code, status)
+		}
+		if p.RequestID == "" || p.RequestID != rec.Header().Get(middleware.RequestIDHeader) {
+			t.Errorf("request_id = %q, want the %s header %q", p.RequestID, middleware.RequestIDHeader, rec.Header().Get(middleware.RequestIDHeader))
+		}
+		return p
+	}
+
+	p := problem(s.do("POST", "/api/auth/login", "", map[string]string{}), http.StatusBadRequest, "invalid_body")
+	fields := map[string]string{}
+	for _, e := range p.Errors {
+		fields[e.Field] = e.Message
+	}
+	if fields["name"] != "is required" || fields["password"] != "is required" {
+		t.Errorf("field errors = %+v, want name and password required", p.Errors)
+	}
+
+	problem(s.do("GET", "/api/no-such-endpoint", "", nil), http.StatusNotFound, "route_not_found")
+	problem(s.do("GET", "/api/employees/abc", s.login("manager"), nil), http.StatusBadRequest, "invalid_id")
+
+	for _, path := range []string{"/test/fail", "/test/panic"} {
+		rec := s.do("GET", path, "", nil)
+		p := problem(rec, http.StatusInternalServerError, apperr.CodeInternal)
+		if strings.Contains(rec.Body.String(), "hunter2") || strings.Contains(rec.Body.String(), "nil map") {
+			t.Errorf("%s leaks the cause: %s", path, rec.Body)
+		}
+		if p.Detail == "" {
+			t.Errorf("%s has no detail", path)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	s := newTestServer(t, "-cors-allowed-origins", "https://hr.example.com,https://*.branches.example.com,http://localhost:3000")
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://hr.example.com", true},
+		{"https://HR.example.com", true},
+		{"https://bole.branches.example.com", true},
+		{"https://a.b.branches.example.com", true},
+		{"http://localhost:3000", true},
+		{"https://evil.example", false},
+		{"http://hr.example.com", false},
+		{"https://hr.example.com:8443", false},
+		{"https://hr.example.com.evil.example", false},
+		{"https://branches.example.com", false},
+		{"https://evilbranches.example.com", false},
+		{"https://bole.branches.example.com:8443", false},
+		{"http://bole.branches.example.com", false},
+		{"http://localhost:3001", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/v1/public/jobs", nil)
+		req.Header.Set("Origin", tt.origin)
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, req)
+		allowOrigin := rec.Header().Get("Access-Control-Allow-Origin")
+		if tt.allowed && (allowOrigin != tt.origin || rec.Header().Get("Access-Control-Allow-Credentials") != "true") {
+			t.Errorf("GET from %s: Allow-Origin %q, want the origin with credentials", tt.origin, allowOrigin)
+		}
+		if !tt.allowed && allowOrigin != "" {
+			t.Errorf("GET from %s: Allow-Origin %q, want none", tt.origin, allowOrigin)
+		}
+
+		req = httptest.NewRequest("OPTIONS", "/api/v1/admin/users", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "DELETE")
+		req.Header.Set("Access-Control-Request-Headers", "authorization")
+		rec = httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, req)
+		if tt.allowed {
+			if rec.Code != http.StatusNoContent || !strings.Contains(rec.Header().Get("Access-Control-Allow-Methods"), "DELETE") ||
+				!strings.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "Authorization") {
+				t.Errorf("preflight from %s: status %d, headers %v", tt.origin, rec.Code, rec.Header())
+			}
+		} else if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("preflight from %s: status %d, Allow-Origin %q, want 403 without CORS headers",
+				tt.origin, rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+
+	// Without configured origins only the frontend may call the API
+	s = newTestServer(t)
+	for origin, allowed := range map[string]bool{"https://jobs.example": true, "https://hr.example.com": false} {
+		req := httptest.NewRequest("GET", "/ping", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin") != ""; got != allowed {
+			t.Errorf("default origins: %s allowed = %v, want %v", origin, got, allowed)
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	s := newTestServer(t)
+	want := map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"Content-Security-Policy":   "frame-ancestors 'none'",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "no-referrer",
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+	}
+	// Error responses get the headers too
+	for _, path := range []string{"/ping", "/api/v1/employees/", "/api/no-such-endpoint"} {
+		rec := s.do("GET", path, "", nil)
+		for header, value := range want {
+			if got := rec.Header().Get(header); got != value {
+				t.Errorf("%s: %s = %q, want %q", path, header, got, value)
+			}
+		}
+	}
+
+	s = newTestServer(t, "-hsts-max-age", "0")
+	if got := s.do("GET", "/ping", "", nil).Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("HSTS sent when disabled: %q", got)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	for _, store := range []string{config.RateLimitStoreMemory, config.RateLimitStoreDatabase} {
+		t.Run(store, func(t *testing.T) {
+			s := newTestServer(t, "-rate-limit-store", store, "-rate-limit-login", "3/1m", "-rate-limit-apply", "1/1h",
+				"-trusted-proxies", "10.0.0.0/8")
+			request := func(method, path, remoteAddr, forwardedFor string, body interface{}) *httptest.ResponseRecorder {
+				encoded, _ := json.Marshal(body)
+				req := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+				req.Header.Set("Content-Type", "application/json")
+				req.RemoteAddr = remoteAddr
+				if forwardedFor != "" {
+					req.Header.Set("X-Forwarded-For", forwardedFor)
+				}
+				rec := httptest.NewRecorder()
+				s.handler.ServeHTTP(rec, req)
+				return rec
+			}
+			wrong := loginRequest{Name: "manager", Password: "wrong"}
+			token := s.login("manager")
+
+			// The /api and /api/v1 paths of a route share a bucket
+			s.expect(request("POST", "/api/auth/login", "192.0.2.1:1234", "", wrong), http.StatusUnauthorized, nil)
+			s.expect(request("POST", "/api/v1/auth/login", "192.0.2.1:1234", "", wrong), http.StatusUnauthorized, nil)
+			rec := request("POST", "/api/v1/auth/login", "192.0.2.1:1234", "", loginRequest{Name: "manager", Password: fixturePassword})
+			var problem middleware.Problem
+			s.expect(rec, http.StatusTooManyRequests, &problem)
+			if problem.Code != "rate_limited" || rec.Header().Get("Retry-After") != "20" {
+				t.Errorf("limited login: code %q, Retry-After %q, want rate_limited after 20s", problem.Code, rec.Header().Get("Retry-After"))
+			}
+
+			// Clients cannot pick their IP, but a trusted proxy names them
+			s.expect(request("POST", "/api/v1/auth/login", "192.0.2.1:1234", "198.51.100.7", wrong), http.StatusTooManyRequests, nil)
+			s.expect(request("POST", "/api/v1/auth/login", "192.0.2.2:1234", "", wrong), http.StatusUnauthorized, nil)
+			s.expect(request("POST", "/api/v1/auth/login", "10.0.0.5:1234", "192.0.2.1", wrong), http.StatusTooManyRequests, nil)
+			s.expect(request("POST", "/api/v1/auth/login", "10.0.0.5:1234", "198.51.100.7", wrong), http.StatusUnauthorized, nil)
+
+			// Each application route has its own bucket
+			s.expect(request("GET", "/api/v1/secure/apply/no-such-token", "192.0.2.1:1234", "", nil), http.StatusUnauthorized, nil)
+			rec = request("GET", "/api/v1/secure/apply/no-such-token", "192.0.2.1:1234", "", nil)
+			s.expect(rec, http.StatusTooManyRequests, nil)
+			if rec.Header().Get("Retry-After") != "3600" {
+				t.Errorf("limited form: Retry-After %q, want 3600", rec.Header().Get("Retry-After"))
+			}
+			s.expect(request("POST", "/api/v1/secure/apply/internal/no-such-token", "192.0.2.1:1234", "", nil), http.StatusUnauthorized, nil)
+
+			// Signed-in routes are not throttled
+			for i := 0; i < 3; i++ {
+				s.expect(s.do("GET", "/api/v1/manager/dashboard", token, nil), http.StatusOK, nil)
+			}
+		})
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.login("admin")
+	request := func(method, path, token, key string, body interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		encoded, ok := body.([]byte)
+		if !ok {
+			encoded, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, req)
+		return rec
+	}
+	expectReplay := func(first, retry *httptest.ResponseRecorder) {
+		t.Helper()
+		if first.Header().Get(middleware.IdempotentReplayedHeader) != "" || retry.Header().Get(middleware.IdempotentReplayedHeader) != "true" {
+			t.Errorf("Idempotent-Replayed: %q then %q, want only the retry marked",
+				first.Header().Get(middleware.IdempotentReplayedHeader), retry.Header().Get(middleware.IdempotentReplayedHeader))
+		}
+		if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+			t.Errorf("retry got %d %s, want %d %s", retry.Code, retry.Body, first.Code, first.Body)
+		}
+	}
+	count := func(path string) int {
+		t.Helper()
+		var items []json.RawMessage
+		s.expect(s.do("GET", path, admin, nil), http.StatusOK, &items)
+		return len(items)
+	}
+
+	// A retry, also through the /api alias, gets the first response
+	job := data.Job{Title: "Teller", Description: "Front desk", Department: "Retail", JobType: "both"}
+	first := request("POST", "/api/v1/admin/jobs/", admin, "job-1", job)
+	var created struct {
+		JobID string `json:"job_id"`
+	}
+	s.expect(first, http.StatusCreated, &created)
+	expectReplay(first, request("POST", "/api/admin/jobs/", admin, "job-1", job))
+	if n := count("/api/v1/admin/jobs/"); n != 1 {
+		t.Errorf("%d jobs after a retried create, want 1", n)
+	}
+
+	// The key cannot be reused for another request, but another caller has
+	// keys of its own
+	var problem middleware.Problem
+	s.expect(request("POST", "/api/v1/admin/jobs/", admin, "job-1", data.Job{Title: "Clerk", Description: "Back office"}),
+		http.StatusUnprocessableEntity, &problem)
+	if problem.Code != "idempotency_key_reused" {
+		t.Errorf("reused key: code %q", problem.Code)
+	}
+	s.expect(request("POST", "/api/v1/admin/jobs/", s.login("manager"), "job-1", job), http.StatusForbidden, nil)
+
+	// Application links are not replayed, which would store their tokens
+	request("POST", "/api/v1/admin/jobs/"+created.JobID+"/application-links", admin, "links-1", nil)
+	rec := request("POST", "/api/v1/admin/jobs/"+created.JobID+"/application-links", admin, "links-1", nil)
+	var generated struct {
+		Links []data.ApplicationLinkResponse `json:"links"`
+	}
+	s.expect(rec, http.StatusOK, &generated)
+	if rec.Header().Get(middleware.IdempotentReplayedHeader) != "" {
+		t.Error("generated application links were replayed")
+	}
+
+	// A failed request leaves the key free for the retry
+	externalToken := filepath.Base(generated.Links[1].URL)
+	path := "/api/v1/secure/apply/external/" + externalToken
+	s.expect(request("POST", path, "", "apply-1", []byte(`{"first_name":`)), http.StatusBadRequest, nil)
+	applicant := data.ExternalEmployee{FirstName: "Hana", LastName: "Bekele"}
+	first = request("POST", path, "", "apply-1", applicant)
+	s.expect(first, http.StatusCreated, nil)
+	expectReplay(first, request("POST", path, "", "apply-1", applicant))
+	s.expect(request("POST", path, "", "apply-2", applicant), http.StatusUnauthorized, nil)
+
+	applicant.Jobid = created.JobID
+	first = request("POST", "/api/v1/public/apply/external", "", "apply-1", applicant)
+	s.expect(first, http.StatusCreated, nil)
+	expectReplay(first, request("POST", "/api/v1/public/apply/external", "", "apply-1", applicant))
+	if n := count("/api/v1/admin/applications/external"); n != 2 {
+		t.Errorf("%d external applications after retries, want 2", n)
+	}
+
+	// Anonymous callers at another IP have keys of their own
+	other := applicant
+	other.FirstName, other.Email = "Sara", "sara@example.com"
+	encoded, _ := json.Marshal(other)
+	req := httptest.NewRequest("POST", "/api/v1/public/apply/external", bytes.NewReader(encoded))
+	req.RemoteAddr = "198.51.100.7:4321"
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(middleware.IdempotencyKeyHeader, "apply-1")
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	s.expect(rec, http.StatusCreated, nil)
+	if rec.Header().Get(middleware.IdempotentReplayedHeader) != "" {
+		t.Error("another client got a replay of the first applicant's response")
+	}
+	if n := count("/api/v1/admin/applications/external"); n != 3 {
+		t.Errorf("%d external applications, want 3", n)
+	}
+
+	// Without a key nothing is deduplicated
+	s.expect(s.do("POST", "/api/v1/public/apply/external", "", applicant), http.StatusCreated, nil)
+	if n := count("/api/v1/admin/applications/external"); n != 4 {
+		t.Errorf("%d external applications, want 4", n)
+	}
+
+	s.expect(request("POST", "/api/v1/public/apply/external", "", strings.Repeat("k", 256), applicant), http.StatusBadRequest, &problem)
+	if problem.Code != "invalid_idempotency_key" {
+		t.Errorf("long key: code %q", problem.Code)
+	}
+}
+
+func TestDuplicateApplications(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.login("admin")
+	createJob := func(job data.Job) string {
+		t.Helper()
+		var created struct {
+			JobID string `json:"job_id"`
+		}
+		s.expect(s.do("POST", "/api/v1/admin/jobs/", admin, job), http.StatusCreated, &created)
+		return created.JobID
+	}
+	rejecting := createJob(data.Job{Title: "Teller", Description: "Front desk", Department: "Retail", JobType: "both"})
+	merging := createJob(data.Job{Title: "Auditor", Description: "Internal audit", Department: "Audit", JobType: "both", DuplicatePolicy: "merge"})
+
+	var problem middleware.Problem
+	s.expect(s.do("POST", "/api/v1/admin/jobs/", admin, data.Job{Title: "Clerk", DuplicatePolicy: "ignore"}), http.StatusBadRequest, &problem)
+	if problem.Code != "invalid_duplicate_policy" {
+		t.Errorf("unknown policy: code %q", problem.Code)
+	}
+	var job struct {
+		DuplicatePolicy string `json:"duplicate_policy"`
+	}
+	s.expect(s.do("GET", "/api/v1/admin/jobs/"+rejecting, admin, nil), http.StatusOK, &job)
+	if job.DuplicatePolicy != "reject" {
+		t.Errorf("default policy = %q, want reject", job.DuplicatePolicy)
+	}
+	// An update without a policy keeps the job's
+	s.expect(s.do("PUT", "/api/v1/admin/jobs/"+merging, admin, data.Job{Title: "Senior Auditor", Description: "Internal audit", Department: "Audit"}), http.StatusOK, nil)
+	s.expect(s.do("GET", "/api/v1/admin/jobs/"+merging, admin, nil), http.StatusOK, &job)
+	if job.DuplicatePolicy != "merge" {
+		t.Errorf("policy after an update = %q, want merge", job.DuplicatePolicy)
+	}
+
+	// The same email or phone, however written, is a duplicate for the job
+	hana := data.ExternalEmployee{FirstName: "Hana", LastName: "Bekele", Email: "hana@example.com", Phone: "+251 911 000 111", Jobid: rejecting}
+	s.expect(s.do("POST", "/api/v1/public/apply/external", "", hana), http.StatusCreated, nil)
+	for _, again := range []data.ExternalEmployee{
+		{FirstName: "Hana", Email: " Hana@Example.com", Jobid: rejecting},
+		{FirstName: "H.", Phone: "251-911-000-111", Jobid: rejecting},
+	} {
+		s.expect(s.do("POST", "/api/v1/public/apply/external", "", again), http.StatusConflict, &problem)
+		if problem.Code != "duplicate_application" {
+			t.Errorf("duplicate %+v: code %q", again, problem.Code)
+		}
+	}
+	internal := data.InternalEmployee{FirstName: "Abel", LastName: "Girma", FileNumber: "BB-0002", Jobid: rejecting}
+	s.expect(s.do("POST", "/api/v1/public/apply/internal", "", internal), http.StatusCreated, nil)
+	internal.FileNumber = "bb 0002"
+	s.expect(s.do("POST", "/api/v1/public/apply/internal", "", internal), http.StatusConflict, nil)
+	s.expect(s.do("POST", "/api/v1/public/apply/external", "", data.ExternalEmployee{FirstName: "Hana", Jobid: "no-such-job"}), http.StatusNotFound, nil)
+
+	// Applications sent at the same time are checked one after the other
+	sara := data.ExternalEmployee{FirstName: "Sara", Email: "sara@example.com", Jobid: rejecting}
+	statuses := make(chan int, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- s.do("POST", "/api/v1/public/apply/external", "", sara).Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("concurrent application: status %d", status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d of the concurrent applications were saved, want 1", created)
+	}
+
+	// The merging job keeps a revision and leaves the earlier application
+	// as it was, since anyone can send one with the applicant's email
+	hana.Jobid = merging
+	s.expect(s.do("POST", "/api/v1/public/apply/external", "", hana), http.StatusCreated, nil)
+	update := data.ExternalEmployee{FirstName: "Mallory", Email: "HANA@example.com", OtherJobExp: "Audit clerk", OtherJobYear: 2, Jobid: merging}
+	var revised struct {
+		Revision bool `json:"revision"`
+	}
+	s.expect(s.do("POST", "/api/v1/public/apply/external", "", update), http.StatusCreated, &revised)
+	if !revised.Revision {
+		t.Error("revision not marked as one")
+	}
+	var applications struct {
+		External []data.ExternalEmployee `json:"external_applications"`
+	}
+	s.expect(s.do("GET", "/api/v1/admin/jobs/"+merging+"/applications", admin, nil), http.StatusOK, &applications)
+	if len(applications.External) != 2 || applications.External[0] != hana && applications.External[1] != hana {
+		t.Errorf("applications after a revision = %+v, want %+v unchanged among them", applications.External, hana)
+	}
+
+	// Admins get the applicants who applied for several jobs
+	s.expect(s.do("GET", "/api/v1/admin/applications/duplicates", s.login("manager"), nil), http.StatusForbidden, nil)
+	var report struct {
+		Duplicates []data.DuplicateGroup `json:"duplicates"`
+	}
+	s.expect(s.do("GET", "/api/v1/admin/applications/duplicates", admin, nil), http.StatusOK, &report)
+	if len(report.Duplicates) != 1 {
+		t.Fatalf("report = %+v, want one group", report.Duplicates)
+	}
+	group := report.Duplicates[0]
+	jobs := map[string]int{}
+	revisions := 0
+	for _, app := range group.Applications {
+		jobs[app.JobTitle]++
+		if app.RevisionOf != "" {
+			revisions++
+		}
+	}
+	if !reflect.DeepEqual(group.MatchedOn, []string{"email", "phone"}) || !reflect.DeepEqual(jobs, map[string]int{"Teller": 1, "Senior Auditor": 2}) || revisions != 1 {
+		t.Errorf("duplicate group = %+v", group)
+	}
+}
+
+func TestResumePath(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.login("admin")
+	var created struct {
+		JobID string `json:"job_id"`
+	}
+	s.expect(s.do("POST", "/api/v1/admin/jobs/", admin, data.Job{Title: "Teller", Description: "Front desk", Department: "Retail", JobType: "both"}), http.StatusCreated, &created)
+
+	// A resume path in the body is ignored, whether or not it points into
+	// the resume directory: only uploads are stored
+	pdf := []byte("%PDF-1.4\n")
+	dir := s.app.config.Storage.ResumeDir
+	outside := filepath.Join(t.TempDir(), "secret.pdf")
+	inside := filepath.Join(dir, "upload_cv.pdf")
+	for _, path := range []string{outside, inside} {
+		if err := os.WriteFile(path, pdf, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hana := data.ExternalEmployee{FirstName: "Hana", LastName: "Bekele", Email: "hana@example.com", Jobid: created.JobID, Resumepath: outside}
+	s.expect(s.do("POST", "/api/v1/public/apply/external", "", hana), http.StatusCreated, nil)
+	abebe := data.ExternalEmployee{FirstName: "Abebe", LastName: "Kebede", Email: "abebe@example.com", Jobid: created.JobID, Resumepath: inside}
+	s.expect(s.do("POST", "/api/v1/public/apply/external", "", abebe), http.StatusCreated, nil)
+
+	var stored []data.ExternalEmployee
+	s.expect(s.do("GET", "/api/v1/admin/applications/external", admin, nil), http.StatusOK, &stored)
+	if len(stored) != 2 {
+		t.Fatalf("applications = %+v, want 2", stored)
+	}
+	for _, app := range stored {
+		if app.Resumepath != "" {
+			t.Errorf("application of %s has resume %q from the request body", app.FirstName, app.Resumepath)
+		}
+	}
+}
+
+func TestApplicationAudit(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.login("admin")
+	var job struct {
+		JobID string `json:"job_id"`
+	}
+	s.expect(s.do("POST", "/api/v1/admin/jobs/", admin, data.Job{Title: "Teller", Description: "Front desk", Department: "Retail", JobType: "both"}), http.StatusCreated, &job)
+	hana := data.ExternalEmployee{FirstName: "Hana", LastName: "Bekele", Email: "hana@example.com", Phone: "0911 000 111", Jobid: job.JobID}
+	s.expect(s.do("POST", "/api/v1/public/apply/external", "", hana), http.StatusCreated, nil)
+
+	// The entry names the application and keeps no personal details
+	var entries []data.AuditEntry
+	s.expect(s.do("GET", "/api/v1/admin/audit?entity_type=external_application", admin, nil), http.StatusOK, &entries)
+	if len(entries) != 1 || entries[0].EntityID == "" || entries[0].Action != data.AuditActionCreate {
+		t.Fatalf("entries = %+v, want one create of the application", entries)
+	}
+	if after := string(entries[0].After); strings.Contains(after, "hana") || strings.Contains(after, "0911") || !strings.Contains(after, job.JobID) {
+		t.Errorf("after = %s, want the job and no personal details", after)
+	}
+	original := entries[0].EntityID
+	s.expect(s.do("GET", "/api/v1/admin/audit?entity_id="+original, admin, nil), http.StatusOK, &entries)
+	if len(entries) != 1 {
+		t.Errorf("filtering on the application's ID found %d entries", len(entries))
+	}
+
+	// A revision is a new application that names the one it revises
+	s.expect(s.do("PUT", "/api/v1/admin/jobs/"+job.JobID, admin, data.Job{Title: "Teller", Description: "Front desk", Department: "Retail", DuplicatePolicy: data.DuplicatePolicyMerge}), http.StatusOK, nil)
+	s.expect(s.do("POST", "/api/v1/public/apply/external", "", hana), http.StatusCreated, nil)
+	s.expect(s.do("GET", "/api/v1/admin/audit?entity_type=external_application", admin, nil), http.StatusOK, &entries)
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v, want two", entries)
+	}
+	revision := entries[0]
+	if revision.EntityID == original {
+		revision = entries[1]
+	}
+	if revision.EntityID == original || revision.Action != data.AuditActionCreate || !strings.Contains(string(revision.After), original) {
+		t.Errorf("revision entry = %+v, after %s", revision, revision.After)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	s := newTestServer(t)
+
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set(middleware.RequestIDHeader, "lb-7f3a")
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get(middleware.RequestIDHeader); got != "lb-7f3a" {
+		t.Errorf("request ID from the caller = %q, want it echoed", got)
+	}
+
+	req = httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set(middleware.RequestIDHeader, "not a\nvalid id")
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get(middleware.RequestIDHeader); !uuidPattern.MatchString(got) {
+		t.Errorf("request ID = %q, want a generated UUID", got)
+	}
+}
+
+// expectMetrics checks that /metrics reports every one of the samples
+func (s *testServer) expectMetrics(samples ...string) {
+	s.t.Helper()
+	rec := s.do("GET", "/metrics", "", nil)
+	s.expect(rec, http.StatusOK, nil)
+	for _, sample := range samples {
+		if !strings.Contains(rec.Body.String(), sample+"\n") {
+			s.t.Errorf("metrics do not report %s", sample)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := newTestServer(t)
+
+	s.expect(s.do("GET", "/healthz", "", nil), http.StatusOK, nil)
+	var ready struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	s.expect(s.do("GET", "/readyz", "", nil), http.StatusOK, &ready)
+	if ready.Status != "ready" || ready.Checks["database"] != "ok" || ready.Checks["storage"] != "ok" {
+		t.Errorf("readyz = %+v", ready)
+	}
+
+	// Losing the resume directory makes the API unready but still alive
+	if err := os.RemoveAll(s.app.config.Storage.ResumeDir); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(s.do("GET", "/readyz", "", nil), http.StatusServiceUnavailable, &ready)
+	if ready.Checks["storage"] != "unavailable" || ready.Checks["database"] != "ok" {
+		t.Errorf("readyz without storage = %+v", ready)
+	}
+	s.expect(s.do("GET", "/healthz", "", nil), http.StatusOK, nil)
+
+	s.expectMetrics(
+		`bank_http_request_duration_seconds_count{method="GET",route="/readyz",status="503"} 1`,
+		`bank_applications_submitted_total{kind="external"} 0`,
+		`go_sql_max_open_connections{db_name="sqlite"} 1`,
+	)
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracing.Install(tracing.NewProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { tracing.Install(noop.NewTracerProvider()) })
+
+	s := newTestServer(t)
+	manager := s.login("manager")
+	exporter.Reset()
+
+	req := httptest.NewRequest("GET", "/api/employees/", nil)
+	req.Header.Set("Authorization", "Bearer "+manager)
+	req.Header.Set(middleware.RequestIDHeader, "trace-me")
+	s.handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	server, ok := spans["GET /api/employees/"]
+	if !ok {
+		t.Fatalf("no server span in %v", spanNames(exporter.GetSpans()))
+	}
+	service := spans["EmployeeService.GetAllEmployees"]
+	query := spans["sql SELECT"]
+	if service.Parent.SpanID() != server.SpanContext.SpanID() || query.Parent.SpanID() != service.SpanContext.SpanID() {
+		t.Errorf("spans are not nested request > service > query: %v", spanNames(exporter.GetSpans()))
+	}
+
+	for _, span := range []tracetest.SpanStub{server, service, query} {
+		if v := spanAttr(span, tracing.RequestIDKey); v != "trace-me" {
+			t.Errorf("%s: request.id = %q", span.Name, v)
+		}
+		if v := spanAttr(span, tracing.UserRoleKey); v != data.RoleManager {
+			t.Errorf("%s: user.role = %q", span.Name, v)
+		}
+	}
+	if v := spanAttr(query, "db.system"); v != "sqlite" {
+		t.Errorf("db.system = %q", v)
+	}
+}
+
+func spanAttr(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
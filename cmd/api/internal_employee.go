@@ -2,43 +2,58 @@ package main
 
 import (
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/metrics"
 )
 
 // Handle internal employee job application
 func (app *Application) handleInternalJobApplication(c *gin.Context) {
 	var internalApp data.InternalEmployee
-	if err := c.ShouldBindJSON(&internalApp); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &internalApp); err != nil {
+		c.Error(err)
 		return
 	}
 
-	// Process file upload if included
+	// Process file upload if included. Only a resume uploaded with this
+	// request is stored: a path in the body is ignored.
+	internalApp.Resumepath = ""
 	file, err := c.FormFile("resume")
 	if err == nil {
 		// A file was uploaded
-		dst := "static/" + file.Filename
+		dst := app.resumeUploadPath(file.Filename)
+		// The application keeps a copy of its own, so the upload goes
+		// when the request ends, whether or not it succeeds
+		defer os.Remove(dst)
 		if err := c.SaveUploadedFile(file, dst); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save resume"})
+			c.Error(err)
 			return
 		}
 		internalApp.Resumepath = dst
 	}
 
-	// Save the application
-	if err := app.internalEmployeeService.Save_Internal_Employee(internalApp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Save the application, or a revision of the applicant's earlier one,
+	// and audit it in the same transaction. A revision's applicant was
+	// matched when they first applied.
+	revision, err := app.internalEmployeeService.Save_Internal_Employee(auditContext(c), internalApp)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if revision {
+		app.applicationRevised(c)
 		return
 	}
+	app.metrics.ApplicationsSubmitted.WithLabelValues(metrics.KindInternal).Inc()
 
 	// Match with employee record for automatic promotion process
-	matchedEmployee, err := app.internalEmployeeService.MatchWithExistingEmployee(internalApp)
+	matchedEmployee, err := app.internalEmployeeService.MatchWithExistingEmployee(c.Request.Context(), internalApp)
 	if err == nil {
 		// Successfully matched, the evaluation process is initialized automatically
-		app.log.Printf("Matched internal application from %s %s with employee ID %d",
-			internalApp.FirstName, internalApp.LastName, matchedEmployee.ID)
+		app.log.InfoContext(c.Request.Context(), "Matched internal application with employee",
+			"job_id", internalApp.Jobid, "employee_id", matchedEmployee.ID)
 
 		// Return success with the matched employee information
 		c.JSON(http.StatusCreated, gin.H{
@@ -58,9 +73,9 @@ func (app *Application) handleInternalJobApplication(c *gin.Context) {
 
 // Get all internal job applications
 func (app *Application) getAllInternalApplications(c *gin.Context) {
-	applications, err := app.internalEmployeeService.GetAllInternalApplications()
+	applications, err := app.internalEmployeeService.GetAllInternalApplications(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -71,19 +86,20 @@ func (app *Application) getAllInternalApplications(c *gin.Context) {
 func (app *Application) getInternalApplicationsByJob(c *gin.Context) {
 	jobID := c.Param("id")
 	if jobID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Job ID is required"})
+		c.Error(errInvalidID)
 		return
 	}
 
-	applications, err := app.internalEmployeeService.GetApplicationsByJobID(jobID)
+	applications, err := app.internalEmployeeService.GetApplicationsByJobID(c.Request.Context(), jobID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	// Match with existing employees for promotion tracking
+	// Show the employee each application matches. Applications are matched,
+	// and evaluations started, when they are sent, never on a read.
 	for i, application := range applications {
-		emp, err := app.internalEmployeeService.MatchWithExistingEmployee(application)
+		emp, err := app.internalEmployeeService.FindMatchingEmployee(c.Request.Context(), application)
 		if err == nil {
 			applications[i].MatchedEmployee = emp.FullName
 		}
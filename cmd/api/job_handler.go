@@ -1,23 +1,36 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/service"
 )
 
 // Create a new job posting
 func (app *Application) createJob(c *gin.Context) {
 	var job data.Job
-	if err := c.ShouldBindJSON(&job); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &job); err != nil {
+		c.Error(err)
+		return
+	}
+	if err := service.CheckDuplicatePolicy(&job); err != nil {
+		c.Error(err)
 		return
 	}
 
-	// Save the job
-	if err := app.repo.CreateJob(job); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Save and audit the job
+	err := app.uow.WithTx(c.Request.Context(), func(tx repository.Stores) error {
+		if err := tx.Jobs.CreateJob(c.Request.Context(), &job); err != nil {
+			return err
+		}
+		return auditTx(c, tx, data.AuditActionCreate, data.AuditEntityJob, job.ID, nil, job)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -27,9 +40,9 @@ func (app *Application) createJob(c *gin.Context) {
 // Get all job postings
 func (app *Application) getAllJobs(c *gin.Context) {
 	// Use the repository's GetAllJobs function instead of direct SQL
-	jobs, err := app.repo.GetAllJobs()
+	jobs, err := app.jobs.GetAllJobs(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	
@@ -48,6 +61,7 @@ func (app *Application) getAllJobs(c *gin.Context) {
 			"created_at":    job.CreatedAt,
 			"deadline":      job.Deadline,
 			"status":        job.Status.String, // Convert NullString to string
+		"duplicate_policy": job.DuplicatePolicy,
 		}
 		response = append(response, jobData)
 	}
@@ -61,9 +75,9 @@ func (app *Application) getJobById(c *gin.Context) {
 	
 	// No need to convert string ID to integer
 	// Get job details
-	job, err := app.repo.GetJobById(jobID)
+	job, err := app.jobService.GetJobById(c.Request.Context(), jobID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		c.Error(err)
 		return
 	}
 
@@ -80,6 +94,7 @@ func (app *Application) getJobById(c *gin.Context) {
 		"created_at":    job.CreatedAt,
 		"deadline":      job.Deadline,
 		"status":        job.Status.String, // Convert NullString to string
+		"duplicate_policy": job.DuplicatePolicy,
 	}
 
 	c.JSON(http.StatusOK, jobResponse)
@@ -90,9 +105,9 @@ func (app *Application) getJobsByType(c *gin.Context) {
 	jobType := c.Param("type")
 	
 	// Get jobs by type
-	jobs, err := app.repo.GetJobByType(jobType)
+	jobs, err := app.jobs.GetJobByType(c.Request.Context(), jobType)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No jobs found for this type"})
+		c.Error(err)
 		return
 	}
 
@@ -111,6 +126,7 @@ func (app *Application) getJobsByType(c *gin.Context) {
 			"created_at":    job.CreatedAt,
 			"deadline":      job.Deadline,
 			"status":        job.Status.String, // Convert NullString to string
+		"duplicate_policy": job.DuplicatePolicy,
 		}
 		response = append(response, jobData)
 	}
@@ -123,17 +139,38 @@ func (app *Application) updateJob(c *gin.Context) {
 	jobID := c.Param("id")
 	
 	var job data.Job
-	if err := c.ShouldBindJSON(&job); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindJSON(c, &job); err != nil {
+		c.Error(err)
 		return
 	}
 
 	// Ensure ID matches
 	job.ID = jobID
 
-	// Update the job
-	if err := app.repo.UpdateJob(job); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	before, err := app.jobService.GetJobById(c.Request.Context(), jobID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// A job sent without a duplicate policy keeps its own
+	if job.DuplicatePolicy == "" {
+		job.DuplicatePolicy = before.DuplicatePolicy
+	}
+	if err := service.CheckDuplicatePolicy(&job); err != nil {
+		c.Error(err)
+		return
+	}
+
+	// Update and audit the job
+	err = app.uow.WithTx(c.Request.Context(), func(tx repository.Stores) error {
+		if err := tx.Jobs.UpdateJob(c.Request.Context(), job); err != nil {
+			return err
+		}
+		return auditTx(c, tx, data.AuditActionUpdate, data.AuditEntityJob, jobID, before, job)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -143,10 +180,22 @@ func (app *Application) updateJob(c *gin.Context) {
 // Delete job
 func (app *Application) deleteJob(c *gin.Context) {
 	jobID := c.Param("id")
+
+	before, err := app.jobService.GetJobById(c.Request.Context(), jobID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
 	
-	// Delete the job
-	if err := app.repo.DeleteJob(jobID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Delete the job and audit it
+	err = app.uow.WithTx(c.Request.Context(), func(tx repository.Stores) error {
+		if err := tx.Jobs.DeleteJob(c.Request.Context(), jobID); err != nil {
+			return err
+		}
+		return auditTx(c, tx, data.AuditActionDelete, data.AuditEntityJob, jobID, before, nil)
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -158,13 +207,13 @@ func (app *Application) getApplicationsForJob(c *gin.Context) {
 	jobID := c.Param("id")
 	
 	// Get internal applications
-	internalApps, err1 := app.repo.GetInternalApplicationsByJobID(jobID)
+	internalApps, err1 := app.applications.GetInternalApplicationsByJobID(c.Request.Context(), jobID)
 	
 	// Get external applications
-	externalApps, err2 := app.repo.GetExternalApplicationsByJobID(jobID)
+	externalApps, err2 := app.applications.GetExternalApplicationsByJobID(c.Request.Context(), jobID)
 	
 	if err1 != nil && err2 != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve applications"})
+		c.Error(errors.Join(err1, err2))
 		return
 	}
 
@@ -174,7 +223,7 @@ func (app *Application) getApplicationsForJob(c *gin.Context) {
 		fullName := application.FirstName + " " + application.LastName
 		
 		// Try to find a matching employee
-		employees, err := app.repo.GetEmployeesByName(fullName)
+		employees, err := app.employees.GetEmployeesByName(c.Request.Context(), fullName)
 		if err == nil && len(employees) > 0 {
 			internalApps[i].MatchedEmployee = employees[0].FullName
 		}
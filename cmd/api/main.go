@@ -1,32 +1,45 @@
 package main
 
 import (
+    "context"
+    "crypto/rand"
     "flag"
     "fmt"
     "log"
+    "log/slog"
     "os"
+    "strings"
+    "time"
 
-    "database/sql"
-
-    _ "github.com/lib/pq"
-
+    "github.com/brehan/bank/cmd/config"
+    "github.com/brehan/bank/cmd/data"
+    "github.com/brehan/bank/cmd/logging"
+    "github.com/brehan/bank/cmd/metrics"
+    "github.com/brehan/bank/cmd/middleware"
+    "github.com/brehan/bank/cmd/migrate"
     "github.com/brehan/bank/cmd/repository"
+    "github.com/brehan/bank/cmd/repository/memory"
     "github.com/brehan/bank/cmd/service"
+    "github.com/brehan/bank/cmd/tracing"
+    "github.com/gin-gonic/gin"
 
 )
 
-type config struct {
-    port       int
-    env        string
-    datasource string
-}
-
 type Application struct {
-    config                 config
-    log                    *log.Logger
-    repo                   *repository.Repository
-    authRepo               *repository.AuthRepository
+    config                 *config.Config
+    log                    *slog.Logger
+    metrics                *metrics.Metrics
+    readiness              []readinessCheck
+    uow                    repository.UnitOfWork
+    employees              repository.EmployeeStore
+    jobs                   repository.JobStore
+    applications           repository.ApplicationStore
     authService            *service.AuthService
+    mfaService             *service.MFAService
+    auditService           *service.AuditService
+    apiKeyService          *service.APIKeyService
+    rateLimiter            *service.RateLimiter
+    idempotencyService     *service.IdempotencyService
     authHandler            *AuthHandler
     employeeService        service.EmployeeService
     internalEmployeeService *service.InternalEmployeeService
@@ -36,71 +49,216 @@ type Application struct {
 }
 
 func main() {
-    var cfg config
-    flag.IntVar(&cfg.port, "port", 8080, "Server port")
-    flag.StringVar(&cfg.env, "env", "dev", "Environment (dev|prod)")
-    flag.StringVar(&cfg.datasource, "datasource", "postgresql://postgres:123@%23@localhost:5432/final_brehan_bank", "PostgreSQL connection string")
-    flag.Parse()
-
-    // Use environment variable for datasource if not provided via flag
-    if cfg.datasource == "" {
-        cfg.datasource = os.Getenv("DATABASE_URL")
-        if cfg.datasource == "" {
-            log.Fatal("Database connection string not provided (use -datasource or DATABASE_URL)")
-        }
+    cfg, args, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv, os.Stderr)
+    if err == flag.ErrHelp {
+        os.Exit(0)
+    }
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // Configuration commands need no database
+    if len(args) > 0 && args[0] == "config" {
+        os.Exit(runConfigCommand(cfg, args[1:]))
     }
 
     // Initialize logger
-    logger := log.New(os.Stdout, "", log.LstdFlags)
+    logger, err := logging.New(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+    if err != nil {
+        log.Fatal(err)
+    }
+    slog.SetDefault(logger)
+    fatal := func(msg string, err error) {
+        logger.Error(msg, "error", err)
+        os.Exit(1)
+    }
+    // Tracing; spans are flushed when the server stops
+    shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, os.Stdout)
+    if err != nil {
+        fatal("Setting up tracing", err)
+    }
+    defer func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := shutdownTracing(ctx); err != nil {
+            logger.Error("Flushing trace spans", "error", err)
+        }
+    }()
+
+    // gin's debug mode prints every route at startup, outside the logger
+    if !strings.EqualFold(cfg.Log.Level, "debug") {
+        gin.SetMode(gin.ReleaseMode)
+    }
+
+    jwtKey := []byte(cfg.Auth.JWTSecret)
+    if len(jwtKey) == 0 {
+        logger.Warn("auth.jwt_secret is not set; using a random key, sessions end on restart")
+        jwtKey = make([]byte, 32)
+        if _, err := rand.Read(jwtKey); err != nil {
+            fatal("Generating a JWT key", err)
+        }
+    }
+    middleware.SetJWTKey(jwtKey)
+
+    if err := os.MkdirAll(cfg.Storage.ResumeDir, 0o750); err != nil {
+        fatal("Creating the resume directory", err)
+    }
+
+    // Open the stores: in memory for demos, otherwise the database
+    var stores repository.Stores
+    var uow repository.UnitOfWork
+    if cfg.Database.Driver == config.DriverMemory {
+        if len(args) > 0 && args[0] == "migrate" {
+            fatal("Cannot migrate", fmt.Errorf("the %s driver has no schema", config.DriverMemory))
+        }
+        logger.Warn("All data is lost on exit", "driver", config.DriverMemory)
+        store := memory.New()
+        stores, uow = store.Stores(), store
+    } else {
+        db, err := repository.Open(repository.Dialect(cfg.Database.Driver), cfg.Database.Datasource)
+        if err != nil {
+            fatal("Opening the database", err)
+        }
+        defer db.Close()
 
-    // Connect to database
-    db, err := sql.Open("postgres", cfg.datasource)
+        // Bring the schema up to date, or refuse to start if it is not
+        migrator, err := migrate.New(db)
+        if err != nil {
+            fatal("Reading migrations", err)
+        }
+        if len(args) > 0 && args[0] == "migrate" {
+            os.Exit(runMigrateCommand(migrator, args[1:]))
+        }
+        if cfg.Env == config.EnvDev || cfg.Database.AutoMigrate {
+            ran, err := migrator.Up(context.Background())
+            for _, m := range ran {
+                logger.Info("Applied migration", "version", m.Version, "name", m.Name)
+            }
+            if err != nil {
+                fatal("Migrating the database", err)
+            }
+        }
+        if err := migrator.Check(context.Background()); err != nil {
+            fatal("Database schema is out of date; run \"api migrate status\" for details and \"api migrate up\" to apply pending migrations", err)
+        }
+        stores, uow = repository.NewStores(db), db
+    }
+
+    app, err := newApplication(cfg, logger, stores, uow)
     if err != nil {
-        logger.Fatal(err)
+        fatal("Starting the application", err)
     }
-    defer db.Close()
 
-    // Initialize repositories
-    repo := repository.NewRepository(db)
-    authRepo := repository.NewAuthRepository(db)
+    // Run a maintenance command instead of the server, e.g. "audit verify"
+    if len(args) > 0 {
+        os.Exit(app.runCommand(args))
+    }
+
+    // Start server
+    if cfg.Database.Driver != config.DriverMemory {
+        logger.Info("Connected to database", "driver", cfg.Database.Driver, "datasource", cfg.Redacted().Database.Datasource)
+    }
+    if err := app.serve(); err != nil {
+        fatal("Server failed", err)
+    }
+}
 
+// newApplication builds the services and handlers on top of the stores
+func newApplication(cfg *config.Config, logger *slog.Logger, stores repository.Stores, uow repository.UnitOfWork) (*Application, error) {
     // Initialize services
-    authService := service.NewAuthService(authRepo)
-    employeeService := service.NewEmployeeService(repo)
-    internalEmployeeService := service.NewInternalEmployeeService(*repo)
-    externalEmployeeService := service.NewExternalEmployeeService(*repo)
-    jobService := service.NewJobService(repo)
-    applicationLinkService := service.NewApplicationLinkService(repo)
+    authService := service.NewAuthService(stores.Users, uow)
+    if cfg.LDAP.URL != "" {
+        ldapAuth, err := service.NewLDAPAuthenticator(service.LDAPConfig{
+            URL:          cfg.LDAP.URL,
+            StartTLS:     cfg.LDAP.StartTLS,
+            BindDN:       cfg.LDAP.BindDN,
+            BindPassword: cfg.LDAP.BindPassword,
+            BaseDN:       cfg.LDAP.BaseDN,
+            UserFilter:   cfg.LDAP.UserFilter,
+            SubjectAttr:  cfg.LDAP.SubjectAttr,
+            NameAttr:     cfg.LDAP.NameAttr,
+            EmailAttr:    cfg.LDAP.EmailAttr,
+            GroupAttr:    cfg.LDAP.GroupAttr,
+            GroupBaseDN:  cfg.LDAP.GroupBaseDN,
+        })
+        if err != nil {
+            return nil, err
+        }
+        groups, err := service.ParseGroupMapper(cfg.LDAP.GroupMap)
+        if err != nil {
+            return nil, err
+        }
+        authService.RegisterAuthenticator(data.AuthProviderLDAP, ldapAuth, groups)
+    }
+    if err := authService.SetDefaultAuthProvider(cfg.Auth.Default); err != nil {
+        return nil, fmt.Errorf("auth.default %q: %w", cfg.Auth.Default, err)
+    }
+    mfaService := service.NewMFAService(stores.MFA, uow, cfg.MFA.Issuer, cfg.MFA.RequiredRoles)
+    auditService := service.NewAuditService(stores.Audit)
+    apiKeyService := service.NewAPIKeyService(stores.APIKeys, uow)
+    employeeService := service.NewEmployeeService(stores.Employees)
+    internalEmployeeService := service.NewInternalEmployeeService(stores.Applications, stores.Employees, uow, cfg.Storage.ResumeDir)
+    externalEmployeeService := service.NewExternalEmployeeService(stores.Applications, uow, cfg.Storage.ResumeDir)
+    jobService := service.NewJobService(stores.Jobs, stores.Applications)
+    applicationLinkService := service.NewApplicationLinkService(stores.Links, stores.Jobs, uow)
+
+    // Token buckets in this process, unless nodes share them in the database
+    rateLimits := stores.RateLimits
+    if cfg.RateLimit.Store == config.RateLimitStoreMemory {
+        rateLimits = memory.NewRateLimits()
+    }
+    rateLimiter := service.NewRateLimiter(rateLimits)
+    idempotencyService := service.NewIdempotencyService(stores.Idempotency, cfg.Idempotency.TTL)
 
     // Initialize handlers
-    authHandler := NewAuthHandler(authService)
+    authHandler := NewAuthHandler(authService, mfaService, logger)
+    if cfg.OIDC.Issuer != "" {
+        provider, err := service.NewOIDCProvider(context.Background(), service.OIDCConfig{
+            IssuerURL:    cfg.OIDC.Issuer,
+            ClientID:     cfg.OIDC.ClientID,
+            ClientSecret: cfg.OIDC.ClientSecret,
+            RedirectURL:  cfg.OIDC.RedirectURL,
+            GroupsClaim:  cfg.OIDC.GroupsClaim,
+        })
+        if err != nil {
+            return nil, err
+        }
+        groups, err := service.ParseGroupMapper(cfg.OIDC.GroupMap)
+        if err != nil {
+            return nil, err
+        }
+        authHandler.oidc = &oidcLogin{provider: provider, groups: groups, successURL: cfg.OIDC.SuccessURL}
+    }
+
+    // Metrics, and the dependencies /readyz checks
+    m := metrics.New()
+    readiness := []readinessCheck{{"storage", checkDirWritable(cfg.Storage.ResumeDir)}}
+    if db, ok := uow.(*repository.DB); ok {
+        m.RegisterDB(db.DB, string(db.Dialect))
+        readiness = append(readiness, readinessCheck{"database", db.PingContext})
+    }
 
     // Initialize application
-    app := &Application{
+    return &Application{
         config:                 cfg,
         log:                    logger,
-        repo:                   repo,
-        authRepo:               authRepo,
+        metrics:                m,
+        readiness:              readiness,
+        uow:                    uow,
+        employees:              stores.Employees,
+        jobs:                   stores.Jobs,
+        applications:           stores.Applications,
         authService:            authService,
+        mfaService:             mfaService,
+        auditService:           auditService,
+        apiKeyService:          apiKeyService,
+        rateLimiter:            rateLimiter,
+        idempotencyService:     idempotencyService,
         authHandler:            authHandler,
         employeeService:        employeeService,
         internalEmployeeService: internalEmployeeService,
         externalEmployeeService: externalEmployeeService,
         jobService:             jobService,
         applicationLinkService: applicationLinkService,
-    }
-
-    // Start server
-    app.log.Printf("Connected to database with %s", cfg.datasource)
-    app.serve()
-}
-
-func (app *Application) serve() {
-    router := app.routes()
-    addr := fmt.Sprintf(":%d", app.config.port)
-    app.log.Printf("Starting %s server on %s", app.config.env, addr)
-
-    if err := router.Run(addr); err != nil {
-        app.log.Fatal(err)
-    }
+    }, nil
 }
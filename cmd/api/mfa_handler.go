@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/brehan/bank/cmd/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFAStatus reports whether the current user has two-factor enabled and
+// whether their role requires it
+func (h *AuthHandler) MFAStatus(c *gin.Context) {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	roles, _ := middleware.GetRolesFromContext(c)
+
+	enabled, err := h.mfaService.IsEnabled(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  enabled,
+		"required": h.mfaService.IsRequired(roles...),
+	})
+}
+
+// EnrollMFA starts TOTP enrolment and returns the secret, the provisioning
+// URI to render as a QR code and the recovery codes. It is reachable both
+// with a session token and with an mfa_pending token, so that users whose
+// role requires two-factor can enrol during their first login.
+func (h *AuthHandler) EnrollMFA(c *gin.Context) {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	user, _, err := h.authService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	enrollment, err := h.mfaService.Enroll(c.Request.Context(), user)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMFAEnrollment enables two-factor once the user submits a valid code.
+// During login (mfa_pending token) it completes the login and returns a
+// session token.
+func (h *AuthHandler) ConfirmMFAEnrollment(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := h.mfaService.ConfirmEnrollment(auditContext(c), userID, req.Code); err != nil {
+		c.Error(err)
+		return
+	}
+
+	if c.GetString(middleware.ScopeKey) != middleware.ScopeMFAPending {
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled"})
+		return
+	}
+
+	user, access, err := h.authService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	h.issueToken(c, http.StatusOK, user, access)
+}
+
+// VerifyMFA exchanges an mfa_pending token and a TOTP or recovery code for
+// a session token
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := h.mfaService.Verify(c.Request.Context(), userID, req.Code); err != nil {
+		c.Error(err)
+		return
+	}
+
+	user, access, err := h.authService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	h.issueToken(c, http.StatusOK, user, access)
+}
+
+// DisableMFA removes the current user's enrolment. A current code is required.
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	roles, _ := middleware.GetRolesFromContext(c)
+
+	if err := h.mfaService.Disable(auditContext(c), userID, roles, req.Code); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/service"
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookie holds the state, nonce and PKCE verifier of a login in
+// progress and lives for ten minutes. It is scoped to the API rather than
+// the OIDC endpoints, because the login and the callback may be under
+// different versions of the API.
+const (
+	oidcStateCookie    = "oidc_login"
+	oidcStateCookieAge = 600
+	oidcCookiePath     = "/api"
+)
+
+var (
+	errSignInFailed       = apperr.Unauthorized("sign_in_failed", "sign-in failed")
+	errInvalidSignInState = apperr.Validation("invalid_sign_in_state", "", "invalid or expired sign-in attempt")
+)
+
+type oidcLogin struct {
+	provider *service.OIDCProvider
+	groups   *service.GroupMapper
+	// successURL is where the browser is sent after login, with the session
+	// token in the URL fragment. If empty the callback responds with JSON.
+	successURL string
+}
+
+// OIDCLogin handles GET /api/auth/oidc/login by redirecting to the
+// identity provider
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	login, err := service.NewOIDCLoginState()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	value, err := json.Marshal(login)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// Lax so the cookie comes back on the provider's top-level redirect
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, base64.RawURLEncoding.EncodeToString(value), oidcStateCookieAge,
+		oidcCookiePath, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, h.oidc.provider.AuthCodeURL(login))
+}
+
+// OIDCCallback handles GET /api/auth/oidc/callback. It verifies the login,
+// provisions or updates the user and starts a session as a password login
+// does, so users with two-factor get an MFA challenge instead of a token.
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if reason := c.Query("error"); reason != "" {
+		message := "sign-in failed: " + reason
+		if description := c.Query("error_description"); description != "" {
+			message += ": " + description
+		}
+		c.Error(errSignInFailed.WithMessage(message))
+		return
+	}
+
+	login, ok := readOIDCState(c)
+	c.SetCookie(oidcStateCookie, "", -1, oidcCookiePath, "", c.Request.TLS != nil, true)
+	if !ok || c.Query("state") == "" || c.Query("state") != login.State {
+		c.Error(errInvalidSignInState)
+		return
+	}
+
+	identity, err := h.oidc.provider.Exchange(c.Request.Context(), c.Query("code"), login)
+	if err != nil {
+		c.Error(errSignInFailed.Wrap(err))
+		return
+	}
+
+	result, err := h.authService.LoginExternal(auditContext(c), *identity, data.AuthProviderOIDC, h.oidc.groups.Roles(identity.Groups))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if h.oidc.successURL == "" {
+		h.startSession(c, http.StatusOK, result.User, result.Access)
+		return
+	}
+
+	if result.User.Status == data.UserStatusDisabled {
+		c.Error(service.ErrAccountDisabled)
+		return
+	}
+	challenge, err := h.mfaChallenge(c.Request.Context(), result.User, result.Access)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var fragment url.Values
+	if challenge != nil {
+		fragment = url.Values{
+			"mfa_token":           {challenge.MFAToken},
+			"enrollment_required": {strconv.FormatBool(challenge.EnrollmentRequired)},
+		}
+	} else {
+		token, err := h.newSession(c.Request.Context(), result.User, result.Access)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		fragment = url.Values{"token": {token}}
+	}
+	// The fragment is never sent to a server, so the token stays out of logs
+	c.Redirect(http.StatusFound, h.oidc.successURL+"#"+fragment.Encode())
+}
+
+func readOIDCState(c *gin.Context) (*service.OIDCLoginState, bool) {
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, false
+	}
+	value, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil {
+		return nil, false
+	}
+	var login service.OIDCLoginState
+	if err := json.Unmarshal(value, &login); err != nil {
+		return nil, false
+	}
+	return &login, true
+}
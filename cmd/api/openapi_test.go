@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ginParam matches a gin path parameter such as :id
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.do("GET", "/api/openapi.json", "", nil)
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	s.expect(rec, http.StatusOK, &spec)
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Fatalf("openapi = %q, want 3.x", spec.OpenAPI)
+	}
+
+	// The unversioned /api routes are deprecated aliases of /api/v1
+	for _, route := range s.handler.(*gin.Engine).Routes() {
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		if rest, ok := strings.CutPrefix(path, "/api/"); ok && !strings.HasPrefix(rest, "v1/") {
+			path = "/api/v1/" + rest
+		}
+		if _, ok := spec.Paths[path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s %s is not in openapi.json", route.Method, path)
+		}
+	}
+}
+
+func TestOpenAPIRefs(t *testing.T) {
+	var spec interface{}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok && resolve(spec, ref) == nil {
+				t.Errorf("unresolved $ref %s", ref)
+			}
+			for _, value := range v {
+				walk(value)
+			}
+		case []interface{}:
+			for _, value := range v {
+				walk(value)
+			}
+		}
+	}
+	walk(spec)
+}
+
+// resolve looks up a local reference such as #/components/schemas/Job
+func resolve(spec interface{}, ref string) interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	node := spec
+	for _, name := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[name]
+	}
+	return node
+}
+
+func TestAPIDocs(t *testing.T) {
+	s := newTestServer(t)
+	rec := s.do("GET", "/api/docs", "", nil)
+	s.expect(rec, http.StatusOK, nil)
+	if !strings.Contains(rec.Body.String(), "openapi.json") {
+		t.Errorf("docs page does not load the spec: %s", rec.Body)
+	}
+}
@@ -2,121 +2,216 @@ package main
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/brehan/bank/cmd/config"
+	"github.com/brehan/bank/cmd/data"
 	"github.com/brehan/bank/cmd/middleware"
 	"time"
 )
+
+// legacyAPIDeprecated is when the unversioned /api paths were deprecated in
+// favour of /api/v1
+var legacyAPIDeprecated = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
 func (app *Application) routes() *gin.Engine {
-    r := gin.Default()
+    r := gin.New()
+    // Validate has checked the addresses
+    _ = r.SetTrustedProxies(app.config.Server.TrustedProxies)
+    r.Use(middleware.RequestID(), middleware.Tracing(), middleware.AccessLog(app.log), middleware.RequestMetrics(app.metrics), middleware.Problems(), middleware.Recovery(app.log))
+    r.NoRoute(func(c *gin.Context) {
+        middleware.Abort(c, errRouteNotFound)
+    })
 
-    // Add CORS middleware to all routes
-    r.Use(middleware.CorsMiddleware())
+    // CORS for the configured origins, and security headers, on all routes
+    r.Use(middleware.CORS(middleware.CORSOptions{
+        Origins: app.config.Origins(),
+        Methods: app.config.CORS.AllowedMethods,
+        Headers: app.config.CORS.AllowedHeaders,
+        MaxAge:  app.config.CORS.MaxAge,
+    }), middleware.SecurityHeaders(app.config.Server.HSTSMaxAge))
 
     // Public routes
     r.GET("/ping", func(c *gin.Context) {
         c.JSON(200, gin.H{
             "message": "pong",
             "version": "1.0.0",
-            "status": "running",
             "time": time.Now().Format(time.RFC3339),
-            "cors": "enabled",
-            "headers": gin.H{
-                "origin": c.Request.Header.Get("Origin"),
-                "referer": c.Request.Header.Get("Referer"),
-                "user-agent": c.Request.Header.Get("User-Agent"),
-            },
         })
     })
 
-    r.POST("/api/auth/login", app.authHandler.Login)
-    r.POST("/api/auth/register", app.authHandler.Register)
+    // Liveness and readiness probes, and metrics for Prometheus
+    r.GET("/healthz", app.healthz)
+    r.GET("/readyz", app.readyz)
+    r.GET("/metrics", gin.WrapH(app.metrics.Handler()))
+
+    // The API is served under /api/v1. The unversioned /api paths are
+    // aliases kept for existing clients and announce their removal.
+    app.apiRoutes(r.Group("/api/v1"))
+    app.apiRoutes(r.Group("/api", middleware.Deprecated("/api", "/api/v1", legacyAPIDeprecated, app.config.Server.LegacyAPISunsetDate())))
+
+    return r
+}
+
+// apiRoutes registers the API on api, which is either the /api/v1 group or
+// the deprecated /api group
+func (app *Application) apiRoutes(api *gin.RouterGroup) {
+    // API description, and a docs UI for it in development
+    api.GET("/openapi.json", app.openAPI)
+    if app.config.Env == config.EnvDev {
+        api.GET("/docs", app.apiDocs)
+    }
+
+    // Request body limits: the application routes take a resume upload,
+    // everything else is JSON
+    jsonLimit := middleware.MaxBodySize(int64(app.config.Server.MaxBodyBytes))
+    uploadLimit := middleware.MaxBodySize(int64(app.config.Server.MaxUploadBytes))
+
+    // Throttling per client IP of the routes that need no sign-in
+    loginLimit := rateLimit(app.config.RateLimit.Login)
+    applyLimit := rateLimit(app.config.RateLimit.Apply)
+    throttle := func(route string, limit data.RateLimit) gin.HandlerFunc {
+        return middleware.RateLimit(app.rateLimiter, route, limit)
+    }
+    // Creating routes that are safe to retry with an Idempotency-Key
+    idempotent := func(route string) gin.HandlerFunc {
+        return middleware.Idempotent(app.idempotencyService, route)
+    }
+
+    api.POST("/auth/login", throttle("login", loginLimit), jsonLimit, app.authHandler.Login)
+    api.POST("/auth/register", jsonLimit, app.authHandler.Register)
 
-    // Protected routes
-    api := r.Group("/api")
-    api.Use(middleware.AuthMiddleware)
+    // OpenID Connect single sign-on, only when configured
+    if app.authHandler.oidc != nil {
+        api.GET("/auth/oidc/login", app.authHandler.OIDCLogin)
+        api.GET("/auth/oidc/callback", app.authHandler.OIDCCallback)
+    }
+
+    // Second login step, authenticated with the mfa_pending token from Login
+    mfaLogin := api.Group("/auth/mfa")
+    mfaLogin.Use(throttle("mfa", loginLimit), jsonLimit, middleware.MFAPendingMiddleware)
+    mfaLogin.POST("/verify", app.authHandler.VerifyMFA)
+    mfaLogin.POST("/enroll", app.authHandler.EnrollMFA)
+    mfaLogin.POST("/enroll/confirm", app.authHandler.ConfirmMFAEnrollment)
+
+    // Protected routes, reachable with a session token or an API key
+    protected := api.Group("")
+    protected.Use(jsonLimit, middleware.AuthMiddleware(app.apiKeyService, app.authService))
+
+    // Two-factor management for the signed-in user
+    accountMFA := protected.Group("/account/mfa")
+    accountMFA.Use(middleware.DenyImpersonation)
+    accountMFA.GET("", app.authHandler.MFAStatus)
+    accountMFA.POST("/enroll", app.authHandler.EnrollMFA)
+    accountMFA.POST("/confirm", app.authHandler.ConfirmMFAEnrollment)
+    accountMFA.DELETE("", app.authHandler.DisableMFA)
+
+    perm := middleware.RequirePermission
 
     // Employee routes - read-only
-    employees := api.Group("/employees")
-    employees.GET("/", app.getAllEmployees)
-    employees.GET("/:id", app.getEmployeeById)
+    employees := protected.Group("/employees")
+    employees.GET("/", perm(data.PermEmployeeRead), app.getAllEmployees)
+    employees.GET("/:id", perm(data.PermEmployeeRead), app.getEmployeeById)
 
     // Admin routes
-    admin := api.Group("/admin")
-    admin.Use(middleware.RequireRole("admin"))
-    admin.GET("/dashboard", func(c *gin.Context) {
+    admin := protected.Group("/admin")
+    admin.GET("/dashboard", perm(data.PermDashboardAdmin), func(c *gin.Context) {
         c.JSON(200, gin.H{
             "message": "Admin dashboard",
         })
     })
     // Admin can create and fully update employees
-    admin.POST("/employees", app.createEmployee)
-    admin.PUT("/employees/:id", app.updateEmployee)
-    admin.GET("/employees",app.getAllEmployees)
-    
+    admin.POST("/employees", perm(data.PermEmployeeWrite), idempotent("create_employee"), app.createEmployee)
+    admin.PUT("/employees/:id", perm(data.PermEmployeeWrite), app.updateEmployee)
+    admin.GET("/employees", perm(data.PermEmployeeRead), app.getAllEmployees)
+
     // Admin user management
-    admin.DELETE("/users/:id", app.deleteUser)
-    admin.GET("/users", app.Getallusers)
+    admin.DELETE("/users/:id", perm(data.PermUserWrite), app.deleteUser)
+    admin.GET("/users", perm(data.PermUserRead), app.Getallusers)
+    admin.GET("/users/:id", perm(data.PermUserRead), app.getUser)
+    admin.PUT("/users/:id", perm(data.PermUserWrite), app.updateUser)
+    admin.PUT("/users/:id/role", perm(data.PermUserWrite), app.changeUserRole)
+    admin.POST("/users/:id/disable", perm(data.PermUserWrite), app.disableUser)
+    admin.POST("/users/:id/enable", perm(data.PermUserWrite), app.enableUser)
+    admin.POST("/users/:id/impersonate", middleware.DenyImpersonation, perm(data.PermUserImpersonate), app.impersonateUser)
+    admin.POST("/users/:id/roles", perm(data.PermUserWrite), app.grantUserRole)
+    admin.DELETE("/users/:id/roles/:role", perm(data.PermUserWrite), app.revokeUserRole)
+    admin.GET("/roles", perm(data.PermUserRead), app.getRoles)
+
+    // Audit log
+    admin.GET("/audit", perm(data.PermAuditRead), app.getAuditLog)
+
+    // API keys for scripts and integrations. Creating one is not
+    // idempotent, as a replay would need the secret to be stored.
+    admin.POST("/api-keys", middleware.DenyImpersonation, perm(data.PermAPIKeyManage), app.createAPIKey)
+    admin.GET("/api-keys", middleware.DenyImpersonation, perm(data.PermAPIKeyManage), app.getAPIKeys)
+    admin.DELETE("/api-keys/:id", middleware.DenyImpersonation, perm(data.PermAPIKeyManage), app.revokeAPIKey)
 
     // Job routes - admin only
     jobs := admin.Group("/jobs")
-    jobs.POST("/", app.createJob)
-    jobs.GET("/", app.getAllJobs)
-    jobs.GET("/:id", app.getJobById)
-    jobs.GET("/type/:type", app.getJobsByType)
-    jobs.PUT("/:id", app.updateJob)
-    jobs.DELETE("/:id", app.deleteJob)
-    jobs.GET("/:id/applications", app.getApplicationsForJob)
-    
-    // Application links - admin only
-    jobs.POST("/:id/application-links", app.generateApplicationLinks)
-    jobs.GET("/:id/application-links", app.getApplicationLinks)
-    
+    jobs.POST("/", perm(data.PermJobWrite), idempotent("create_job"), app.createJob)
+    jobs.GET("/", perm(data.PermJobRead), app.getAllJobs)
+    jobs.GET("/:id", perm(data.PermJobRead), app.getJobById)
+    jobs.GET("/type/:type", perm(data.PermJobRead), app.getJobsByType)
+    jobs.PUT("/:id", perm(data.PermJobWrite), app.updateJob)
+    jobs.DELETE("/:id", perm(data.PermJobWrite), app.deleteJob)
+    jobs.GET("/:id/applications", perm(data.PermApplicationRead), app.getApplicationsForJob)
+
+    // Application links - admin only. Generating them is not idempotent, as
+    // a replay would need the link tokens to be stored.
+    jobs.POST("/:id/application-links", perm(data.PermApplicationLinkWrite), app.generateApplicationLinks)
+    jobs.GET("/:id/application-links", perm(data.PermApplicationLinkRead), app.getApplicationLinks)
+
     // Application management - admin only
-    admin.GET("/applications/internal", app.getAllInternalApplications)
-    admin.GET("/applications/external", app.getAllExternalApplications)
-    admin.GET("/applications/internal/:id", app.getInternalApplicationsByJob)
-    admin.GET("/applications/external/:id", app.getExternalApplicationsByJob)
-	
+    admin.GET("/applications/internal", perm(data.PermApplicationRead), app.getAllInternalApplications)
+    admin.GET("/applications/external", perm(data.PermApplicationRead), app.getAllExternalApplications)
+    admin.GET("/applications/internal/:id", perm(data.PermApplicationRead), app.getInternalApplicationsByJob)
+    admin.GET("/applications/external/:id", perm(data.PermApplicationRead), app.getExternalApplicationsByJob)
+    admin.GET("/applications/duplicates", perm(data.PermApplicationRead), app.getDuplicateApplications)
 
     // Manager routes
-    manager := api.Group("/manager")
-    manager.Use(middleware.RequireRole("manager"))
-    manager.GET("/dashboard", func(c *gin.Context) {
+    manager := protected.Group("/manager")
+    manager.GET("/dashboard", perm(data.PermDashboardManager), func(c *gin.Context) {
         c.JSON(200, gin.H{
             "message": "Manager dashboard",
         })
     })
     // Allow managers to update evaluations for employees
-    manager.PATCH("/employees/:id/pms", app.updateEmployeePMS)
-    manager.PATCH("/employees/:id/recommendation", app.updateEmployeeManagerRecommendation)
-    manager.GET("/employees/:id/evaluation", app.getEmployeeEvaluation)
+    manager.PATCH("/employees/:id/pms", perm(data.PermEmployeePMSWrite), app.updateEmployeePMS)
+    manager.PATCH("/employees/:id/recommendation", perm(data.PermEmployeeManagerRecWrite), app.updateEmployeeManagerRecommendation)
+    manager.GET("/employees/:id/evaluation", perm(data.PermEmployeeEvaluationRead), app.getEmployeeEvaluation)
 
     // District manager routes
-    district := api.Group("/district")
-    district.Use(middleware.RequireRole("district_manager"))
-    district.GET("/dashboard", func(c *gin.Context) {
+    district := protected.Group("/district")
+    district.GET("/dashboard", perm(data.PermDashboardDistrict), func(c *gin.Context) {
         c.JSON(200, gin.H{
             "message": "District manager dashboard",
         })
     })
-    // Get all employees for the district manager's branch (limited info)
-    district.GET("/employees", app.getEmployeesForDistrictManager)
+    // Get all employees of the district manager's district (limited info)
+    district.GET("/employees", perm(data.PermEmployeeDistrictRead), app.getEmployeesForDistrictManager)
     // Get specific employee for district manager (limited info)
-    district.GET("/employees/:id", app.getEmployeeForDistrictManager)
+    district.GET("/employees/:id", perm(data.PermEmployeeDistrictRead), app.getEmployeeForDistrictManager)
     // Allow district managers to update district recommendations
-    district.PATCH("/employees/:id/recommendation", app.updateEmployeeDistrictRec)
-    district.GET("/employees/:id/evaluation", app.getEmployeeEvaluation)
+    district.PATCH("/employees/:id/recommendation", perm(data.PermEmployeeDistrictRecWrite), app.updateEmployeeDistrictRec)
+    district.GET("/employees/:id/evaluation", perm(data.PermEmployeeEvaluationRead), app.requireDistrictEmployee, app.getEmployeeEvaluation)
 
     // Public job application routes (no auth required)
-    publicRoutes := r.Group("/api/public")
+    publicRoutes := api.Group("/public")
+    publicRoutes.Use(uploadLimit)
     publicRoutes.GET("/jobs", app.getAllJobs) // Anyone can view jobs
-    publicRoutes.POST("/apply/internal", app.handleInternalJobApplication)
-    publicRoutes.POST("/apply/external", app.handleExternalJobApplication)
+    publicRoutes.POST("/apply/internal", throttle("apply_internal", applyLimit), idempotent("apply_internal"), app.handleInternalJobApplication)
+    publicRoutes.POST("/apply/external", throttle("apply_external", applyLimit), idempotent("apply_external"), app.handleExternalJobApplication)
 
     // Secure application routes with tokens (no auth required)
-    secureApplyRoutes := r.Group("/api/secure")
-    secureApplyRoutes.GET("/apply/:token", app.getSecureApplicationForm)
-    secureApplyRoutes.POST("/apply/internal/:token", app.handleSecureInternalApplication)
-    secureApplyRoutes.POST("/apply/external/:token", app.handleSecureExternalApplication)
+    secureApplyRoutes := api.Group("/secure")
+    secureApplyRoutes.Use(uploadLimit)
+    secureApplyRoutes.GET("/apply/:token", throttle("secure_form", applyLimit), app.getSecureApplicationForm)
+    secureApplyRoutes.POST("/apply/internal/:token", throttle("secure_apply_internal", applyLimit), idempotent("secure_apply_internal"), app.handleSecureInternalApplication)
+    secureApplyRoutes.POST("/apply/external/:token", throttle("secure_apply_external", applyLimit), idempotent("secure_apply_external"), app.handleSecureExternalApplication)
+}
 
-    return r
+// rateLimit returns a limit from the configuration, which Validate has
+// checked. An empty setting is the zero limit, which allows everything.
+func rateLimit(value string) data.RateLimit {
+    requests, per, _ := config.ParseRateLimit(value)
+    return data.RateLimit{Requests: requests, Per: per}
 }
\ No newline at end of file
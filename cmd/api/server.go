@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// server returns the HTTP server for the API with the configured timeouts
+// and header limit
+func (app *Application) server() *http.Server {
+	cfg := app.config.Server
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           app.routes(),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(app.log.Handler(), slog.LevelWarn),
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+}
+
+// serve runs the API until SIGINT or SIGTERM, then stops accepting
+// connections and waits for in-flight requests to finish
+func (app *Application) serve() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := app.server()
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	scheme := "http"
+	if app.config.Server.TLS() {
+		scheme = "https"
+	}
+	app.log.Info("Starting server", "env", app.config.Env, "addr", srv.Addr, "scheme", scheme)
+	return app.runServer(ctx, srv, ln)
+}
+
+// runServer serves on ln until ctx is done and then shuts srv down, giving
+// open requests up to the shutdown timeout to complete
+func (app *Application) runServer(ctx context.Context, srv *http.Server, ln net.Listener) error {
+	errc := make(chan error, 1)
+	go func() {
+		if app.config.Server.TLS() {
+			errc <- srv.ServeTLS(ln, app.config.Server.TLSCertFile, app.config.Server.TLSKeyFile)
+		} else {
+			errc <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	app.log.Info("Shutting down, waiting for open requests", "timeout", app.config.Server.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	app.log.Info("Server stopped")
+	return nil
+}
@@ -2,14 +2,16 @@ package main
 
 import (
 	"net/http"
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/middleware"
+	"github.com/brehan/bank/cmd/service"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 func (app *Application) Getallusers(c *gin.Context){
-	users, err := app.authService.Getallusers()
+	users, err := app.authService.Getallusers(c.Request.Context())
 	if err != nil {
-		app.log.Printf("Error fetching users: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	
@@ -28,24 +30,187 @@ func (app *Application) deleteUser(c *gin.Context) {
 	userIDStr := c.Param("id")
 	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		c.Error(errInvalidID)
 		return
 	}
 
-	// Check if the user exists first
-	_, _, _, err = app.authService.GetUserByID(userID)
+	// Delete the user
+	err = app.authService.DeleteUser(auditContext(c), userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(err)
 		return
 	}
 
-	// Delete the user
-	err = app.authRepo.DeleteUser(userID)
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+} 
+type userRoleRequest struct {
+	Role     string `json:"role" binding:"required"`
+	District string `json:"district"`
+}
+
+// getRoles handles GET /api/admin/roles
+func (app *Application) getRoles(c *gin.Context) {
+	roles, err := app.authService.GetRoles(c.Request.Context())
 	if err != nil {
-		app.log.Printf("Error deleting user %s: %v", userID.String(), err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		c.Error(err)
 		return
 	}
+	c.JSON(http.StatusOK, roles)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
-} 
\ No newline at end of file
+// grantUserRole handles POST /api/admin/users/:id/roles
+func (app *Application) grantUserRole(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Error(errInvalidID)
+		return
+	}
+
+	var req userRoleRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := app.authService.GrantRole(auditContext(c), userID, req.Role, req.District); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role granted successfully"})
+}
+
+// revokeUserRole handles DELETE /api/admin/users/:id/roles/:role
+func (app *Application) revokeUserRole(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Error(errInvalidID)
+		return
+	}
+
+	if err := app.authService.RevokeRole(auditContext(c), userID, c.Param("role")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role revoked successfully"})
+}
+
+type updateUserRequest struct {
+	Name         *string `json:"name"`
+	Email        *string `json:"email"`
+	Branch       *string `json:"branch"`
+	District     *string `json:"district"`
+	AuthProvider *string `json:"auth_provider"`
+}
+
+// userJSON renders a user in the same shape as the GET /api/admin/users list
+func userJSON(user *data.User, access data.UserAccess) gin.H {
+	return service.UserRecord(user, access)
+}
+
+// getUser handles GET /api/admin/users/:id
+func (app *Application) getUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Error(errInvalidID)
+		return
+	}
+
+	user, access, err := app.authService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, userJSON(user, access))
+}
+
+// updateUser handles PUT /api/admin/users/:id. Only the fields present in
+// the body are changed.
+func (app *Application) updateUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Error(errInvalidID)
+		return
+	}
+
+	var req updateUserRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	user, access, err := app.authService.UpdateUser(auditContext(c), userID, service.UpdateUserInput{
+		Name:         req.Name,
+		Email:        req.Email,
+		Branch:       req.Branch,
+		District:     req.District,
+		AuthProvider: req.AuthProvider,
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, userJSON(user, access))
+}
+
+// changeUserRole handles PUT /api/admin/users/:id/role. The user ends up
+// with exactly the given role.
+func (app *Application) changeUserRole(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Error(errInvalidID)
+		return
+	}
+
+	var req userRoleRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	if _, err := app.authService.ChangeRole(auditContext(c), userID, req.Role, req.District); err != nil {
+		c.Error(err)
+		return
+	}
+
+	user, access, err := app.authService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, userJSON(user, access))
+}
+
+// disableUser handles POST /api/admin/users/:id/disable
+func (app *Application) disableUser(c *gin.Context) {
+	app.setUserStatus(c, data.UserStatusDisabled, "User disabled successfully")
+}
+
+// enableUser handles POST /api/admin/users/:id/enable
+func (app *Application) enableUser(c *gin.Context) {
+	app.setUserStatus(c, data.UserStatusActive, "User enabled successfully")
+}
+
+func (app *Application) setUserStatus(c *gin.Context, status, message string) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Error(errInvalidID)
+		return
+	}
+
+	actorID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := app.authService.SetUserStatus(auditContext(c), userID, actorID, status); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
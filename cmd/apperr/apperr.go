@@ -0,0 +1,134 @@
+// Package apperr defines the errors that services return to the API. Each
+// error has a kind, which decides the HTTP status, and a machine-readable
+// code that clients can switch on. Any other error is an internal error
+// whose message is never shown to clients.
+package apperr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind is the class of an error
+type Kind string
+
+const (
+	KindValidation    Kind = "validation"
+	KindUnauthorized  Kind = "unauthorized"
+	KindForbidden     Kind = "forbidden"
+	KindNotFound      Kind = "not_found"
+	KindConflict      Kind = "conflict"
+	KindUnprocessable Kind = "unprocessable"
+	KindTooLarge      Kind = "too_large"
+	KindRateLimited   Kind = "rate_limited"
+	KindInternal      Kind = "internal"
+)
+
+// CodeInternal is the code of every error that is not an *Error
+const CodeInternal = "internal"
+
+// FieldError describes what is wrong with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error that is safe to show to clients. Err, if set, is the
+// underlying cause and is only logged.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an *Error with the same kind and code, so
+// that errors.Is matches a sentinel error after Wrap or WithFields
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Code == e.Code
+}
+
+// Wrap returns a copy of e caused by err
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.Err = err
+	return &c
+}
+
+// WithMessage returns a copy of e with a more specific message
+func (e *Error) WithMessage(message string) *Error {
+	c := *e
+	c.Message = message
+	return &c
+}
+
+// WithFields returns a copy of e with the given field details
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	c := *e
+	c.Fields = fields
+	return &c
+}
+
+// New returns an error of the given kind
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// Validation returns an error for a request that is malformed or fails
+// validation. field names the offending field in the request, if any.
+func Validation(code, field, message string) *Error {
+	e := New(KindValidation, code, message)
+	if field != "" {
+		e.Fields = []FieldError{{Field: field, Message: message}}
+	}
+	return e
+}
+
+func Unauthorized(code, message string) *Error {
+	return New(KindUnauthorized, code, message)
+}
+
+func Forbidden(code, message string) *Error {
+	return New(KindForbidden, code, message)
+}
+
+func NotFound(code, message string) *Error {
+	return New(KindNotFound, code, message)
+}
+
+func Conflict(code, message string) *Error {
+	return New(KindConflict, code, message)
+}
+
+// Internal wraps an unexpected error
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Code: CodeInternal, Message: "internal server error", Err: err}
+}
+
+// From returns err as an *Error, treating any other error as internal
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Internal(err)
+}
+
+// KindOf returns the kind of err, which is KindInternal for errors that are
+// not an *Error
+func KindOf(err error) Kind {
+	return From(err).Kind
+}
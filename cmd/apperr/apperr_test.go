@@ -0,0 +1,51 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIs(t *testing.T) {
+	errNotFound := NotFound("job_not_found", "job not found")
+
+	wrapped := fmt.Errorf("loading job: %w", errNotFound.Wrap(errors.New("no rows")))
+	if !errors.Is(wrapped, errNotFound) {
+		t.Errorf("errors.Is(%v, errNotFound) = false after Wrap", wrapped)
+	}
+	if !errors.Is(errNotFound.WithFields(FieldError{Field: "id"}), errNotFound) {
+		t.Error("errors.Is = false after WithFields")
+	}
+	if errors.Is(NotFound("employee_not_found", "employee not found"), errNotFound) {
+		t.Error("errors.Is matched an error with another code")
+	}
+	if errNotFound.Err != nil || errNotFound.Fields != nil {
+		t.Errorf("Wrap or WithFields changed the sentinel: %+v", errNotFound)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	cause := errors.New("connection refused")
+	e := From(fmt.Errorf("query: %w", cause))
+	if e.Kind != KindInternal || e.Code != CodeInternal || !errors.Is(e, cause) {
+		t.Errorf("From(plain error) = %+v, want an internal error wrapping it", e)
+	}
+
+	conflict := Conflict("user_exists", "user already exists")
+	if got := From(fmt.Errorf("register: %w", conflict)); got != conflict {
+		t.Errorf("From(wrapped *Error) = %+v, want %+v", got, conflict)
+	}
+	if got := KindOf(conflict); got != KindConflict {
+		t.Errorf("KindOf = %s, want %s", got, KindConflict)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	e := Validation("invalid_email", "email", "invalid email address")
+	if len(e.Fields) != 1 || e.Fields[0].Field != "email" {
+		t.Errorf("Fields = %+v, want one entry for email", e.Fields)
+	}
+	if e := Validation("invalid_body", "", "request body is not valid"); e.Fields != nil {
+		t.Errorf("Fields = %+v, want none without a field", e.Fields)
+	}
+}
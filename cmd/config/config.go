@@ -0,0 +1,403 @@
+// Package config holds the API server's settings. Every setting has a
+// default and can be overridden, in increasing order of precedence, by a
+// YAML file, an environment variable and a command line flag. The sources
+// for a field are declared with struct tags:
+//
+//	yaml     key in the config file
+//	env      environment variable; NAME_FILE reads the value from a file
+//	flag     command line flag
+//	default  value used when no source sets the field
+//	secret   "true" hides the value in redacted output; "url" hides only
+//	         the password of a URL
+//	usage    flag help text
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EnvDev  = "dev"
+	EnvProd = "prod"
+)
+
+// minJWTSecretLength is the shortest signing key accepted in production:
+// HS256 keys should be at least as long as the hash
+const minJWTSecretLength = 32
+
+type Config struct {
+	Env         string            `yaml:"env" env:"APP_ENV" flag:"env" default:"dev" usage:"Environment (dev|prod)"`
+	Log         LogConfig         `yaml:"log"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Server      ServerConfig      `yaml:"server"`
+	CORS        CORSConfig        `yaml:"cors"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Frontend    FrontendConfig    `yaml:"frontend"`
+	Storage     StorageConfig     `yaml:"storage"`
+	MFA         MFAConfig         `yaml:"mfa"`
+	OIDC        OIDCConfig        `yaml:"oidc"`
+	LDAP        LDAPConfig        `yaml:"ldap"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" default:"info" usage:"Lowest level logged (debug|info|warn|error)"`
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"log-format" default:"json" usage:"Log record format (json|text)"`
+}
+
+const (
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingOTLP   = "otlp"
+)
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" default:"none" usage:"Where trace spans are sent (none|stdout|otlp)"`
+	// Endpoint is the collector's OTLP/HTTP URL. When it is empty the
+	// exporter falls back to the standard OTEL_EXPORTER_OTLP_* variables.
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" flag:"tracing-endpoint" usage:"OTLP/HTTP collector URL, e.g. http://localhost:4318"`
+	ServiceName string  `yaml:"service_name" env:"TRACING_SERVICE_NAME" flag:"tracing-service-name" default:"bank-api" usage:"Service name reported with every span"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" flag:"tracing-sample-ratio" default:"1" usage:"Fraction of new traces that are recorded, from 0 to 1"`
+}
+
+type ServerConfig struct {
+	Port int `yaml:"port" env:"PORT" flag:"port" default:"8080" usage:"Server port"`
+	// ReadTimeout covers reading the whole request, so it bounds how long a
+	// resume upload may take
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT" flag:"read-timeout" default:"60s" usage:"Longest time to read a request, body included"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT" flag:"read-header-timeout" default:"10s" usage:"Longest time to read request headers"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT" flag:"write-timeout" default:"60s" usage:"Longest time from the end of the request headers to the end of the response"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" flag:"idle-timeout" default:"120s" usage:"How long an idle keep-alive connection is kept open"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"30s" usage:"How long to wait for requests in progress on SIGINT or SIGTERM"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" env:"SERVER_MAX_HEADER_BYTES" flag:"max-header-bytes" default:"65536" usage:"Largest accepted request header, in bytes"`
+	// MaxBodyBytes applies to the JSON API, MaxUploadBytes to the public
+	// application routes that accept a resume
+	MaxBodyBytes   int    `yaml:"max_body_bytes" env:"SERVER_MAX_BODY_BYTES" flag:"max-body-bytes" default:"1048576" usage:"Largest accepted JSON request body, in bytes"`
+	MaxUploadBytes int    `yaml:"max_upload_bytes" env:"SERVER_MAX_UPLOAD_BYTES" flag:"max-upload-bytes" default:"10485760" usage:"Largest accepted job application body including the resume, in bytes"`
+	TLSCertFile    string `yaml:"tls_cert_file" env:"SERVER_TLS_CERT_FILE" flag:"tls-cert-file" usage:"PEM certificate chain; serves HTTPS when set together with the key"`
+	TLSKeyFile     string `yaml:"tls_key_file" env:"SERVER_TLS_KEY_FILE" flag:"tls-key-file" usage:"PEM private key for tls_cert_file"`
+	// HSTSMaxAge is sent in Strict-Transport-Security. Browsers ignore the
+	// header on plain HTTP, so it is safe to send in dev.
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age" env:"SERVER_HSTS_MAX_AGE" flag:"hsts-max-age" default:"8760h" usage:"How long browsers should only use HTTPS for this host; 0 disables HSTS"`
+	// LegacyAPISunset is announced in the Sunset header of the unversioned
+	// /api aliases of /api/v1
+	LegacyAPISunset string `yaml:"legacy_api_sunset" env:"SERVER_LEGACY_API_SUNSET" flag:"legacy-api-sunset" default:"2027-06-30" usage:"Date (YYYY-MM-DD) after which the unversioned /api paths may be removed"`
+	// TrustedProxies may set X-Forwarded-For. The client IP of requests from
+	// anywhere else is the connection's address, so that clients cannot
+	// choose the IP that rate limits and the audit log see.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"SERVER_TRUSTED_PROXIES" flag:"trusted-proxies" usage:"Comma-separated IPs or CIDRs of reverse proxies whose X-Forwarded-For header gives the client IP"`
+}
+
+// LegacyAPISunsetDate returns LegacyAPISunset as midnight UTC. It is the
+// zero time if the setting is invalid, which Validate reports.
+func (s ServerConfig) LegacyAPISunsetDate() time.Time {
+	date, _ := time.Parse(time.DateOnly, s.LegacyAPISunset)
+	return date
+}
+
+// TLS reports whether the server should listen for HTTPS
+func (s ServerConfig) TLS() bool {
+	return s.TLSCertFile != ""
+}
+
+// CORSConfig decides which web origins may call the API from a browser.
+// Origins may start with a wildcard subdomain, as in https://*.example.com,
+// which matches any subdomain but not example.com itself.
+type CORSConfig struct {
+	AllowedOrigins []string      `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" flag:"cors-allowed-origins" usage:"Comma-separated origins allowed to call the API, e.g. https://hr.example.com,https://*.example.com; the frontend base URL if empty"`
+	AllowedMethods []string      `yaml:"allowed_methods" env:"CORS_ALLOWED_METHODS" flag:"cors-allowed-methods" default:"GET,POST,PUT,PATCH,DELETE" usage:"Comma-separated methods allowed in cross-origin requests"`
+	AllowedHeaders []string      `yaml:"allowed_headers" env:"CORS_ALLOWED_HEADERS" flag:"cors-allowed-headers" default:"Authorization,Content-Type,Idempotency-Key,X-API-Key,X-Request-ID" usage:"Comma-separated request headers allowed in cross-origin requests"`
+	MaxAge         time.Duration `yaml:"max_age" env:"CORS_MAX_AGE" flag:"cors-max-age" default:"10m" usage:"How long browsers may cache a preflight response"`
+}
+
+// Origins returns the allowed origins, which default to the origin of the
+// frontend
+func (c *Config) Origins() []string {
+	if len(c.CORS.AllowedOrigins) > 0 {
+		return c.CORS.AllowedOrigins
+	}
+	u, err := url.Parse(c.Frontend.BaseURL)
+	if err != nil {
+		return nil
+	}
+	return []string{u.Scheme + "://" + u.Host}
+}
+
+// Where the rate limiter keeps its token buckets
+const (
+	RateLimitStoreMemory   = "memory"
+	RateLimitStoreDatabase = "database"
+)
+
+// RateLimitConfig throttles the routes that need no sign-in, per client IP
+// and route. A limit such as 10/1m allows bursts of 10 requests, refilled
+// evenly over a minute; an empty limit switches throttling off.
+type RateLimitConfig struct {
+	Store string `yaml:"store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" default:"memory" usage:"Where token buckets are kept: memory for a single node, database to share them between nodes (memory|database)"`
+	Login string `yaml:"login" env:"RATE_LIMIT_LOGIN" flag:"rate-limit-login" default:"10/1m" usage:"Password logins and two-factor codes per client IP, as requests/period"`
+	Apply string `yaml:"apply" env:"RATE_LIMIT_APPLY" flag:"rate-limit-apply" default:"20/1h" usage:"Requests per client IP to each public and secure-link application route, as requests/period"`
+}
+
+// ParseRateLimit parses a limit such as 10/1m into the burst size and the
+// time in which it refills. An empty limit is zero.
+func ParseRateLimit(value string) (requests int, per time.Duration, err error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	count, period, ok := strings.Cut(value, "/")
+	if ok {
+		requests, err = strconv.Atoi(count)
+	}
+	if ok && err == nil {
+		per, err = time.ParseDuration(period)
+	}
+	if !ok || err != nil || requests <= 0 || per <= 0 {
+		return 0, 0, fmt.Errorf("rate limit must be like 10/1m, got %q", value)
+	}
+	return requests, per, nil
+}
+
+// IdempotencyConfig decides how long the response to a request made with an
+// Idempotency-Key is replayed to retries
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" flag:"idempotency-ttl" default:"24h" usage:"How long responses to requests with an Idempotency-Key are kept for retries"`
+}
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	// DriverMemory keeps everything in memory, for demos. Data is lost on
+	// exit and there are no migrations.
+	DriverMemory = "memory"
+)
+
+type DatabaseConfig struct {
+	Driver     string `yaml:"driver" env:"DATABASE_DRIVER" flag:"driver" default:"postgres" usage:"Database engine (postgres|sqlite|memory)"`
+	Datasource string `yaml:"datasource" env:"DATABASE_URL" flag:"datasource" default:"postgres://localhost:5432/final_brehan_bank?sslmode=disable" secret:"url" usage:"PostgreSQL connection string, or the database file for sqlite"`
+	// AutoMigrate applies pending migrations at startup. Dev always does;
+	// in prod they are normally applied with "api migrate up".
+	AutoMigrate bool `yaml:"auto_migrate" env:"DATABASE_AUTO_MIGRATE" flag:"auto-migrate" usage:"Apply pending schema migrations at startup (always on in dev)"`
+}
+
+type AuthConfig struct {
+	// JWTSecret signs session tokens. In dev a random key is generated when
+	// it is empty, which signs everybody out on restart.
+	JWTSecret string `yaml:"jwt_secret" env:"JWT_SECRET" flag:"jwt-secret" secret:"true" usage:"Key used to sign session tokens; required in prod"`
+	Default   string `yaml:"default" env:"AUTH_DEFAULT" flag:"auth-default" default:"local" usage:"Password backend for users without an account (local|ldap); ldap provisions them on first sign-in"`
+}
+
+type FrontendConfig struct {
+	BaseURL string `yaml:"base_url" env:"FRONTEND_BASE_URL" flag:"frontend-base-url" default:"http://localhost:3000" usage:"Frontend URL used to build application links"`
+}
+
+type StorageConfig struct {
+	ResumeDir string `yaml:"resume_dir" env:"RESUME_DIR" flag:"resume-dir" default:"cmd/static/resumes" usage:"Directory uploaded resumes are saved to"`
+}
+
+type MFAConfig struct {
+	Issuer        string   `yaml:"issuer" env:"MFA_ISSUER" flag:"mfa-issuer" default:"Brehan Bank" usage:"Issuer name shown in authenticator apps"`
+	RequiredRoles []string `yaml:"required_roles" env:"MFA_REQUIRED_ROLES" flag:"mfa-required-roles" default:"admin,district_manager" usage:"Comma-separated roles that must use two-factor authentication"`
+}
+
+type OIDCConfig struct {
+	Issuer       string `yaml:"issuer" env:"OIDC_ISSUER" flag:"oidc-issuer" usage:"OpenID Connect issuer URL; enables single sign-on when set"`
+	ClientID     string `yaml:"client_id" env:"OIDC_CLIENT_ID" flag:"oidc-client-id" usage:"OpenID Connect client ID"`
+	ClientSecret string `yaml:"client_secret" env:"OIDC_CLIENT_SECRET" flag:"oidc-client-secret" secret:"true" usage:"OpenID Connect client secret"`
+	RedirectURL  string `yaml:"redirect_url" env:"OIDC_REDIRECT_URL" flag:"oidc-redirect-url" default:"http://localhost:8080/api/auth/oidc/callback" usage:"Callback URL registered with the identity provider"`
+	GroupsClaim  string `yaml:"groups_claim" env:"OIDC_GROUPS_CLAIM" flag:"oidc-groups-claim" default:"groups" usage:"ID token claim that lists the user's groups"`
+	GroupMap     string `yaml:"group_map" env:"OIDC_GROUP_MAP" flag:"oidc-group-map" usage:"Comma-separated group=role[:district] mappings, e.g. bank-admins=admin,north=district_manager:North"`
+	SuccessURL   string `yaml:"success_url" env:"OIDC_SUCCESS_URL" flag:"oidc-success-url" usage:"Frontend URL to redirect to after sign-in, with the token or an MFA challenge in the fragment; JSON response if empty"`
+}
+
+type LDAPConfig struct {
+	URL          string `yaml:"url" env:"LDAP_URL" flag:"ldap-url" usage:"LDAP or Active Directory URL, e.g. ldaps://ad.example.com; enables LDAP sign-in when set"`
+	StartTLS     bool   `yaml:"starttls" env:"LDAP_STARTTLS" flag:"ldap-starttls" usage:"Use StartTLS on an ldap:// connection"`
+	BindDN       string `yaml:"bind_dn" env:"LDAP_BIND_DN" flag:"ldap-bind-dn" usage:"DN of the service account used to look users up; anonymous if empty"`
+	BindPassword string `yaml:"bind_password" env:"LDAP_BIND_PASSWORD" flag:"ldap-bind-password" secret:"true" usage:"Service account password"`
+	BaseDN       string `yaml:"base_dn" env:"LDAP_BASE_DN" flag:"ldap-base-dn" usage:"Base DN to search for users"`
+	UserFilter   string `yaml:"user_filter" env:"LDAP_USER_FILTER" flag:"ldap-user-filter" default:"(uid=%s)" usage:"Filter that finds a user by name; use (sAMAccountName=%s) for Active Directory"`
+	SubjectAttr  string `yaml:"subject_attr" env:"LDAP_SUBJECT_ATTR" flag:"ldap-subject-attr" usage:"Stable user ID attribute, e.g. entryUUID or objectGUID; the entry DN if empty"`
+	NameAttr     string `yaml:"name_attr" env:"LDAP_NAME_ATTR" flag:"ldap-name-attr" default:"displayName" usage:"Attribute holding the user's display name"`
+	EmailAttr    string `yaml:"email_attr" env:"LDAP_EMAIL_ATTR" flag:"ldap-email-attr" default:"mail" usage:"Attribute holding the user's email address"`
+	GroupAttr    string `yaml:"group_attr" env:"LDAP_GROUP_ATTR" flag:"ldap-group-attr" default:"memberOf" usage:"Attribute listing the DNs of the user's groups"`
+	GroupBaseDN  string `yaml:"group_base_dn" env:"LDAP_GROUP_BASE_DN" flag:"ldap-group-base-dn" usage:"DN of the container of the groups in group_map; groups elsewhere grant no roles"`
+	GroupMap     string `yaml:"group_map" env:"LDAP_GROUP_MAP" flag:"ldap-group-map" usage:"Comma-separated group=role[:district] mappings by CN of groups directly under group_base_dn; roles are managed in the app if empty"`
+}
+
+// ValidationError lists every problem found by Validate
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate checks the settings that can be checked without connecting to
+// anything. Settings of features that are switched off are not checked.
+func (c *Config) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Env != EnvDev && c.Env != EnvProd {
+		problem("env must be %q or %q, got %q", EnvDev, EnvProd, c.Env)
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		problem("log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		problem("log.format must be %q or %q, got %q", "json", "text", c.Log.Format)
+	}
+	switch c.Tracing.Exporter {
+	case TracingNone, TracingStdout:
+	case TracingOTLP:
+		if c.Tracing.Endpoint != "" && !isAbsoluteURL(c.Tracing.Endpoint) {
+			problem("tracing.endpoint must be an absolute http(s) URL, got %q", c.Tracing.Endpoint)
+		}
+	default:
+		problem("tracing.exporter must be %q, %q or %q, got %q", TracingNone, TracingStdout, TracingOTLP, c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problem("tracing.sample_ratio must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		problem("server.port must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read_timeout", c.Server.ReadTimeout},
+		{"read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"write_timeout", c.Server.WriteTimeout},
+		{"idle_timeout", c.Server.IdleTimeout},
+		{"shutdown_timeout", c.Server.ShutdownTimeout},
+	}
+	for _, t := range timeouts {
+		if t.value <= 0 {
+			problem("server.%s must be positive, got %s", t.name, t.value)
+		}
+	}
+	if c.Server.MaxHeaderBytes <= 0 || c.Server.MaxBodyBytes <= 0 || c.Server.MaxUploadBytes <= 0 {
+		problem("server.max_header_bytes, max_body_bytes and max_upload_bytes must be positive")
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		problem("server.tls_cert_file and server.tls_key_file must be set together")
+	}
+	if c.Server.HSTSMaxAge < 0 {
+		problem("server.hsts_max_age must not be negative, got %s", c.Server.HSTSMaxAge)
+	}
+	if _, err := time.Parse(time.DateOnly, c.Server.LegacyAPISunset); err != nil {
+		problem("server.legacy_api_sunset must be a date like 2027-06-30, got %q", c.Server.LegacyAPISunset)
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problem("server.trusted_proxies must be IPs or CIDRs, got %q", proxy)
+		}
+	}
+	switch c.Database.Driver {
+	case DriverPostgres, DriverSQLite:
+	case DriverMemory:
+		if c.Env == EnvProd {
+			problem("database.driver %q loses all data on exit and cannot be used in prod", DriverMemory)
+		}
+	default:
+		problem("database.driver must be %q, %q or %q, got %q", DriverPostgres, DriverSQLite, DriverMemory, c.Database.Driver)
+	}
+	if c.Database.Datasource == "" {
+		problem("database.datasource is required")
+	} else if c.Database.Driver == DriverSQLite && strings.HasPrefix(c.Database.Datasource, "postgres") {
+		problem("database.datasource must be a file path for sqlite, got a PostgreSQL URL")
+	}
+	if c.Env == EnvProd && len(c.Auth.JWTSecret) < minJWTSecretLength {
+		problem("auth.jwt_secret must be at least %d characters in prod", minJWTSecretLength)
+	}
+	if !isAbsoluteURL(c.Frontend.BaseURL) {
+		problem("frontend.base_url must be an absolute http(s) URL, got %q", c.Frontend.BaseURL)
+	}
+	if c.Storage.ResumeDir == "" {
+		problem("storage.resume_dir is required")
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if !isOrigin(origin) {
+			problem("cors.allowed_origins must be origins like https://hr.example.com or https://*.example.com, got %q", origin)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		problem("cors.max_age must not be negative, got %s", c.CORS.MaxAge)
+	}
+	if c.RateLimit.Store != RateLimitStoreMemory && c.RateLimit.Store != RateLimitStoreDatabase {
+		problem("rate_limit.store must be %q or %q, got %q", RateLimitStoreMemory, RateLimitStoreDatabase, c.RateLimit.Store)
+	}
+	if _, _, err := ParseRateLimit(c.RateLimit.Login); err != nil {
+		problem("rate_limit.login %v", err)
+	}
+	if _, _, err := ParseRateLimit(c.RateLimit.Apply); err != nil {
+		problem("rate_limit.apply %v", err)
+	}
+	if c.Idempotency.TTL <= 0 {
+		problem("idempotency.ttl must be positive, got %s", c.Idempotency.TTL)
+	}
+
+	if c.OIDC.Issuer != "" {
+		if !isAbsoluteURL(c.OIDC.Issuer) {
+			problem("oidc.issuer must be an absolute http(s) URL, got %q", c.OIDC.Issuer)
+		}
+		if c.OIDC.ClientID == "" {
+			problem("oidc.client_id is required when oidc.issuer is set")
+		}
+		if !isAbsoluteURL(c.OIDC.RedirectURL) {
+			problem("oidc.redirect_url must be an absolute http(s) URL, got %q", c.OIDC.RedirectURL)
+		}
+	}
+
+	if c.LDAP.URL != "" {
+		if u, err := url.Parse(c.LDAP.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+			problem("ldap.url must be an ldap:// or ldaps:// URL, got %q", c.LDAP.URL)
+		}
+		if c.LDAP.BaseDN == "" {
+			problem("ldap.base_dn is required when ldap.url is set")
+		}
+		if c.LDAP.GroupMap != "" && c.LDAP.GroupBaseDN == "" {
+			problem("ldap.group_base_dn is required when ldap.group_map is set")
+		}
+	}
+	if c.Auth.Default == "ldap" && c.LDAP.URL == "" {
+		problem("auth.default is ldap but ldap.url is not set")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func isAbsoluteURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isOrigin reports whether value is a scheme and host with an optional
+// port and nothing else. The host may start with a "*." wildcard.
+func isOrigin(value string) bool {
+	host := value
+	if rest, ok := strings.CutPrefix(value, "https://*."); ok {
+		host = "https://" + rest
+	} else if rest, ok := strings.CutPrefix(value, "http://*."); ok {
+		host = "http://" + rest
+	}
+	u, err := url.Parse(host)
+	return err == nil && isAbsoluteURL(host) && u.User == nil && u.Path == "" && u.RawQuery == "" && u.Fragment == "" &&
+		!strings.Contains(u.Host, "*")
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, args, err := Load("api", []string{"audit", "verify"}, env(nil), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 8080 || cfg.Env != EnvDev || cfg.Frontend.BaseURL != "http://localhost:3000" {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.MFA.RequiredRoles, []string{"admin", "district_manager"}) {
+		t.Errorf("required roles = %v", cfg.MFA.RequiredRoles)
+	}
+	if !reflect.DeepEqual(args, []string{"audit", "verify"}) {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "api.yaml", `
+server:
+  port: 9000
+frontend:
+  base_url: https://jobs.example.com
+storage:
+  resume_dir: /var/lib/bank/resumes
+mfa:
+  required_roles: [admin]
+ldap:
+  starttls: true
+`)
+	vars := map[string]string{
+		ConfigFileEnv:       file,
+		"PORT":              "9100",
+		"FRONTEND_BASE_URL": "https://careers.example.com",
+	}
+
+	cfg, _, err := Load("api", []string{"-port", "9200"}, env(vars), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9200 {
+		t.Errorf("flag should win over env and file, port = %d", cfg.Server.Port)
+	}
+	if cfg.Frontend.BaseURL != "https://careers.example.com" {
+		t.Errorf("env should win over file, base_url = %q", cfg.Frontend.BaseURL)
+	}
+	if cfg.Storage.ResumeDir != "/var/lib/bank/resumes" || !cfg.LDAP.StartTLS {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.MFA.RequiredRoles, []string{"admin"}) {
+		t.Errorf("required roles = %v", cfg.MFA.RequiredRoles)
+	}
+	if cfg.Database.Datasource == "" {
+		t.Error("defaults of keys missing from the file should be kept")
+	}
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	secret := writeFile(t, "jwt", "0123456789abcdef0123456789abcdef\n")
+
+	cfg, _, err := Load("api", nil, env(map[string]string{"JWT_SECRET_FILE": secret}), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Auth.JWTSecret != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("jwt secret = %q", cfg.Auth.JWTSecret)
+	}
+
+	_, _, err = Load("api", nil, env(map[string]string{"JWT_SECRET_FILE": secret, "JWT_SECRET": "x"}), io.Discard)
+	if err == nil {
+		t.Error("setting both JWT_SECRET and JWT_SECRET_FILE should fail")
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	file := writeFile(t, "api.yaml", "server:\n  prot: 9000\n")
+	if _, _, err := Load("api", []string{"-config", file}, env(nil), io.Discard); err == nil {
+		t.Error("a misspelt key should fail")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, _, err := Load("api", []string{"-env", "prod", "-log-level", "trace", "-tracing-sample-ratio", "2", "-port", "0", "-tls-cert-file", "cert.pem", "-hsts-max-age", "-1h", "-legacy-api-sunset", "next summer", "-trusted-proxies", "10.0.0.0/8,proxy.internal", "-driver", "memory", "-frontend-base-url", "localhost:3000", "-cors-allowed-origins", "https://hr.example.com/,*.example.com", "-rate-limit-store", "redis", "-rate-limit-login", "10 per minute", "-idempotency-ttl", "0s", "-auth-default", "ldap"}, env(nil), io.Discard)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("err = %v, want a ValidationError", err)
+	}
+
+	want := []string{"log.level", "tracing.sample_ratio", "server.port", "server.tls_cert_file", "server.hsts_max_age", "server.legacy_api_sunset", "server.trusted_proxies", "database.driver", "auth.jwt_secret", "frontend.base_url", "cors.allowed_origins", "cors.allowed_origins", "rate_limit.store", "rate_limit.login", "idempotency.ttl", "auth.default"}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("problems = %q", verr.Problems)
+	}
+	for i, key := range want {
+		if !strings.HasPrefix(verr.Problems[i], key) {
+			t.Errorf("problem %d = %q, want it to be about %s", i, verr.Problems[i], key)
+		}
+	}
+
+	// A group map by CN needs the container the CNs are looked up in
+	_, _, err = Load("api", []string{"-ldap-url", "ldap://ad.example.com", "-ldap-base-dn", "dc=example,dc=org", "-ldap-group-map", "bank-admins=admin"}, env(nil), io.Discard)
+	if verr, ok := err.(*ValidationError); !ok || len(verr.Problems) != 1 || !strings.HasPrefix(verr.Problems[0], "ldap.group_base_dn") {
+		t.Errorf("group map without a group base DN = %v", err)
+	}
+}
+
+func TestOrigins(t *testing.T) {
+	cfg, _, err := Load("api", []string{"-frontend-base-url", "https://jobs.example.com/careers"}, env(nil), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Origins(); !reflect.DeepEqual(got, []string{"https://jobs.example.com"}) {
+		t.Errorf("origins = %v, want the frontend origin", got)
+	}
+
+	vars := map[string]string{"CORS_ALLOWED_ORIGINS": "https://hr.example.com,https://*.branches.example.com"}
+	cfg, _, err = Load("api", nil, env(vars), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Origins(); !reflect.DeepEqual(got, []string{"https://hr.example.com", "https://*.branches.example.com"}) {
+		t.Errorf("origins = %v", got)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value    string
+		requests int
+		per      time.Duration
+		ok       bool
+	}{
+		{"10/1m", 10, time.Minute, true},
+		{"20/1h30m", 20, 90 * time.Minute, true},
+		{"", 0, 0, true},
+		{"10", 0, 0, false},
+		{"10/m", 0, 0, false},
+		{"0/1m", 0, 0, false},
+		{"10/0s", 0, 0, false},
+		{"ten/1m", 0, 0, false},
+	}
+	for _, tt := range tests {
+		requests, per, err := ParseRateLimit(tt.value)
+		if requests != tt.requests || per != tt.per || (err == nil) != tt.ok {
+			t.Errorf("ParseRateLimit(%q) = %d, %s, %v", tt.value, requests, per, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	vars := map[string]string{
+		"DATABASE_URL":       "postgres://bank:s3cret@db:5432/bank",
+		"OIDC_CLIENT_SECRET": "client-secret",
+	}
+	cfg, _, err := Load("api", nil, env(vars), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := cfg.Redacted().Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	printed := out.String()
+	for _, secret := range []string{"s3cret", "client-secret"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("redacted output contains %q:\n%s", secret, printed)
+		}
+	}
+	if !strings.Contains(printed, "postgres://bank:REDACTED@db:5432/bank") {
+		t.Errorf("datasource should keep everything but the password:\n%s", printed)
+	}
+	if cfg.OIDC.ClientSecret != "client-secret" {
+		t.Error("Redacted must not modify the original")
+	}
+}
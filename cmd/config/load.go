@@ -0,0 +1,209 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the config file when the -config flag is not given
+const ConfigFileEnv = "CONFIG_FILE"
+
+// field is a settable leaf of Config together with its tags
+type field struct {
+	value reflect.Value
+	tag   reflect.StructTag
+	// path is the dotted YAML path, e.g. database.datasource
+	path string
+}
+
+// fields lists the leaves of the struct v points to, depth first
+func fields(v reflect.Value, prefix string) []field {
+	var out []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		path := prefix + sf.Tag.Get("yaml")
+		if sf.Type.Kind() == reflect.Struct {
+			out = append(out, fields(v.Field(i), path+".")...)
+			continue
+		}
+		out = append(out, field{value: v.Field(i), tag: sf.Tag, path: path})
+	}
+	return out
+}
+
+// set parses raw into the field according to its type
+func (f field) set(raw string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(raw)
+	case int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", f.path, raw)
+		}
+		f.value.SetInt(int64(n))
+	case float64:
+		x, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", f.path, raw)
+		}
+		f.value.SetFloat(x)
+	case bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s: %q is not true or false", f.path, raw)
+		}
+		f.value.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration", f.path, raw)
+		}
+		f.value.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.path, f.value.Type())
+	}
+	return nil
+}
+
+// flagValue records a flag's raw value so that flags can be applied after
+// the file and environment have been read
+type flagValue struct {
+	raw    string
+	isBool bool
+}
+
+func (v *flagValue) String() string     { return v.raw }
+func (v *flagValue) Set(s string) error { v.raw = s; return nil }
+func (v *flagValue) IsBoolFlag() bool   { return v.isBool }
+
+// Load builds the configuration from defaults, the YAML file named by
+// -config or $CONFIG_FILE, environment variables and command line flags,
+// in that order, and validates it. It returns the arguments left after the
+// flags. lookupEnv is usually os.LookupEnv.
+func Load(name string, args []string, lookupEnv func(string) (string, bool), output io.Writer) (*Config, []string, error) {
+	cfg := &Config{}
+	all := fields(reflect.ValueOf(cfg).Elem(), "")
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	configFile := fs.String("config", "", "YAML config file (default $"+ConfigFileEnv+")")
+	flags := make(map[string]*flagValue)
+	for _, f := range all {
+		if name := f.tag.Get("flag"); name != "" {
+			v := &flagValue{raw: f.tag.Get("default"), isBool: f.value.Kind() == reflect.Bool}
+			usage := f.tag.Get("usage")
+			if env := f.tag.Get("env"); env != "" {
+				usage += " ($" + env + ")"
+			}
+			fs.Var(v, name, usage)
+			flags[name] = v
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	for _, f := range all {
+		if def, ok := f.tag.Lookup("default"); ok {
+			if err := f.set(def); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(ConfigFileEnv)
+	}
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, f := range all {
+		if err := loadEnv(f, lookupEnv); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		if v, ok := flags[fl.Name]; ok && err == nil {
+			for _, f := range all {
+				if f.tag.Get("flag") == fl.Name {
+					err = f.set(v.raw)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile reads a YAML file over the defaults. Unknown keys are rejected so
+// that typos do not go unnoticed.
+func loadFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv sets a field from its environment variable, or from the file named
+// by the variable with a _FILE suffix. Setting both is an error.
+func loadEnv(f field, lookupEnv func(string) (string, bool)) error {
+	name := f.tag.Get("env")
+	if name == "" {
+		return nil
+	}
+
+	value, ok := lookupEnv(name)
+	path, fromFile := lookupEnv(name + "_FILE")
+	if ok && fromFile {
+		return fmt.Errorf("both $%s and $%s_FILE are set", name, name)
+	}
+	if fromFile {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("$%s_FILE: %w", name, err)
+		}
+		// Files written by editors and secret stores usually end in a newline
+		value, ok = strings.TrimRight(string(content), "\r\n"), true
+	}
+	if !ok {
+		return nil
+	}
+	return f.set(value)
+}
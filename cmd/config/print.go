@@ -0,0 +1,53 @@
+package config
+
+import (
+	"io"
+	"net/url"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration with secrets replaced.
+// Empty secrets are left empty so it is still visible that they are unset.
+func (c *Config) Redacted() *Config {
+	out := *c
+	for _, f := range fields(reflect.ValueOf(&out).Elem(), "") {
+		value := f.value.String()
+		if value == "" {
+			continue
+		}
+		switch f.tag.Get("secret") {
+		case "true":
+			f.value.SetString(redacted)
+		case "url":
+			f.value.SetString(redactURL(value))
+		}
+	}
+	return &out
+}
+
+// redactURL hides the password in a URL, or the whole value if it cannot
+// be parsed
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
+
+// Write prints the configuration as YAML in the format Load reads
+func (c *Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
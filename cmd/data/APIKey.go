@@ -0,0 +1,54 @@
+package data
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// API key scopes. Each scope maps to the permissions a key holding it is
+// granted, so keys pass the same RequirePermission checks as users.
+const (
+	ScopeEmployeesRead    = "employees:read"
+	ScopeJobsRead         = "jobs:read"
+	ScopeApplicationsRead = "applications:read"
+)
+
+var APIKeyScopes = map[string][]string{
+	ScopeEmployeesRead:    {PermEmployeeRead},
+	ScopeJobsRead:         {PermJobRead},
+	ScopeApplicationsRead: {PermApplicationRead},
+}
+
+// APIKey is a credential for scripts and other services. Only the SHA-256 of
+// the key is stored; Prefix is kept so admins can tell keys apart.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  uuid.UUID  `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// Permissions returns the permissions granted by the key's scopes
+func (k *APIKey) Permissions() []string {
+	var permissions []string
+	for _, scope := range k.Scopes {
+		permissions = append(permissions, APIKeyScopes[scope]...)
+	}
+	return permissions
+}
+
+// Active reports whether the key can still be used at t
+func (k *APIKey) Active(t time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || t.Before(*k.ExpiresAt)
+}
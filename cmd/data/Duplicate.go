@@ -0,0 +1,80 @@
+package data
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Duplicate policies of a job, for an application from someone who already
+// applied for it
+const (
+	// DuplicatePolicyReject refuses the application. It is the default.
+	DuplicatePolicyReject = "reject"
+	// DuplicatePolicyMerge keeps it as a revision of the earlier
+	// application, which is left as it is
+	DuplicatePolicyMerge = "merge"
+)
+
+// Kinds of application
+const (
+	ApplicationKindInternal = "internal"
+	ApplicationKindExternal = "external"
+)
+
+// What two applications were found to share
+const (
+	DuplicateMatchEmail      = "email"
+	DuplicateMatchPhone      = "phone"
+	DuplicateMatchFileNumber = "file_number"
+)
+
+// ApplicationContact is an application with the details of the applicant
+// that duplicates are detected by
+type ApplicationContact struct {
+	ID         string `json:"id"`
+	Kind       string `json:"kind"`
+	JobID      string `json:"job_id"`
+	JobTitle   string `json:"job_title"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	Email      string `json:"email,omitempty"`
+	Phone      string `json:"phone,omitempty"`
+	FileNumber string `json:"file_number,omitempty"`
+	// RevisionOf is the ID of the application this one revises
+	RevisionOf string `json:"revision_of,omitempty"`
+}
+
+// DuplicateGroup is a set of applications that look like they come from the
+// same person, and what they were matched on
+type DuplicateGroup struct {
+	MatchedOn    []string             `json:"matched_on"`
+	Applications []ApplicationContact `json:"applications"`
+}
+
+// NormalizeEmail returns the form of an email address that duplicates are
+// detected by: trimmed and in lower case
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizePhone keeps only the digits of a phone number, so that spacing
+// and punctuation do not matter
+func NormalizePhone(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// NormalizeFileNumber keeps only the letters and digits of an employee file
+// number, in upper case, so that "bb 0002" and "BB-0002" are the same
+func NormalizeFileNumber(fileNumber string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, fileNumber)
+}
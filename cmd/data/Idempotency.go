@@ -0,0 +1,21 @@
+package data
+
+import "time"
+
+// IdempotentRequest is a request made with an Idempotency-Key and, once it
+// has succeeded, its response. Fingerprint identifies the request, so that
+// the key cannot be reused for a different one. Status is zero while the
+// request is in progress.
+type IdempotentRequest struct {
+	Key         string
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// Done reports whether the response has been stored
+func (r *IdempotentRequest) Done() bool {
+	return r.Status != 0
+}
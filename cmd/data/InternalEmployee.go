@@ -2,6 +2,7 @@ package data
 type InternalEmployee struct {
     FirstName       string `json:"first_name"`
     LastName        string `json:"last_name"`
+    FileNumber      string `json:"file_number,omitempty"` // Employee file number, if the applicant gave it
     OtherBankExp    string `json:"other_bank_exp"` // Clarify: duration, description?
     Jobid           string `json:"jobid"`
     Resumepath      string `json:"resumepath"`
@@ -17,6 +17,7 @@ type Job struct {
 	CreatedAt   time.Time  `json:"created_at"`
 	Deadline    *time.Time `json:"deadline"`
 	Status      sql.NullString `json:"status"` // open, closed, filled
+	DuplicatePolicy string     `json:"duplicate_policy"` // reject or merge, see DuplicatePolicyReject
 }
 
 // Extended InternalEmployee for response matching
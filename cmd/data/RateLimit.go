@@ -0,0 +1,10 @@
+package data
+
+import "time"
+
+// RateLimit allows bursts of Requests requests and refills evenly, one
+// request every Per/Requests. The zero RateLimit allows everything.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
@@ -0,0 +1,100 @@
+package data
+
+// Built-in role names. Roles live in the roles table; these are the ones
+// seeded by the rbac migration and referenced by code.
+const (
+	RoleAdmin           = "admin"
+	RoleManager         = "manager"
+	RoleDistrictManager = "district_manager"
+)
+
+// Permission names checked by middleware.RequirePermission
+const (
+	PermEmployeeRead             = "employee.read"
+	PermEmployeeWrite            = "employee.write"
+	PermEmployeePMSWrite         = "employee.pms.write"
+	PermEmployeeManagerRecWrite  = "employee.manager_rec.write"
+	PermEmployeeDistrictRead     = "employee.district.read"
+	PermEmployeeDistrictRecWrite = "employee.district_rec.write"
+	PermEmployeeEvaluationRead   = "employee.evaluation.read"
+	PermJobRead                  = "job.read"
+	PermJobWrite                 = "job.write"
+	PermApplicationRead          = "application.read"
+	PermApplicationLinkRead      = "application_link.read"
+	PermApplicationLinkWrite     = "application_link.write"
+	PermUserRead                 = "user.read"
+	PermUserWrite                = "user.write"
+	PermUserImpersonate          = "user.impersonate"
+	PermAuditRead                = "audit.read"
+	PermAPIKeyManage             = "api_key.manage"
+	PermDashboardAdmin           = "dashboard.admin"
+	PermDashboardManager         = "dashboard.manager"
+	PermDashboardDistrict        = "dashboard.district"
+)
+
+// rolePriority decides which role is reported as "the" role of a user who
+// holds several, e.g. in the login response and the JWT role claim.
+var rolePriority = []string{RoleAdmin, RoleManager, RoleDistrictManager}
+
+type Role struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserRole is a role granted to a user. District is only set for roles that
+// are scoped to a district, such as district_manager.
+type UserRole struct {
+	Role     string `json:"role"`
+	District string `json:"district,omitempty"`
+}
+
+// UserAccess is everything a user is allowed to do, resolved from user_roles
+type UserAccess struct {
+	Roles       []UserRole
+	Permissions []string
+}
+
+// PrimaryRole returns the highest priority role the user holds
+func (a UserAccess) PrimaryRole() UserRole {
+	for _, name := range rolePriority {
+		for _, role := range a.Roles {
+			if role.Role == name {
+				return role
+			}
+		}
+	}
+	if len(a.Roles) > 0 {
+		return a.Roles[0]
+	}
+	return UserRole{}
+}
+
+// RoleNames returns the names of all roles the user holds
+func (a UserAccess) RoleNames() []string {
+	names := make([]string, 0, len(a.Roles))
+	for _, role := range a.Roles {
+		names = append(names, role.Role)
+	}
+	return names
+}
+
+// District returns the district of the first district-scoped role
+func (a UserAccess) District() string {
+	for _, role := range a.Roles {
+		if role.District != "" {
+			return role.District
+		}
+	}
+	return ""
+}
+
+func (a UserAccess) HasPermission(permission string) bool {
+	for _, p := range a.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -6,12 +6,46 @@ import (
 	"github.com/google/uuid"
 )
 
+// Account status values for User.Status
+const (
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+)
+
+// How a user signs in, stored in User.AuthProvider. Only local users have a
+// password.
+const (
+	AuthProviderLocal = "local"
+	AuthProviderOIDC  = "oidc"
+	AuthProviderLDAP  = "ldap"
+)
+
 type User struct {
-	Id        uuid.UUID
-	Name      string
-	Password  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	Id           uuid.UUID
+	Name         string
+	Password     string
+	Email        string
+	Branch       string
+	Status       string
+	AuthProvider string
+	LastLogin    *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	// TokenVersion is carried by the user's session tokens, which are only
+	// accepted while it is unchanged
+	TokenVersion int
+}
+
+// ExternalIdentity is an account at an identity provider, as reported by
+// that provider after a successful sign-in
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Username      string
+	Name          string
+	Email         string
+	EmailVerified bool
+	Groups        []string
 }
 
 type Admin struct {
@@ -26,3 +60,18 @@ type DistrictManager struct {
 	User     User
 	District string
 }
+
+// UserMFA holds a user's TOTP enrolment. The secret is only usable for
+// login once Enabled is set by a successful confirmation code.
+// FailedAttempts counts invalid codes since the last valid one, and no code
+// is accepted before LockedUntil.
+type UserMFA struct {
+	UserID         uuid.UUID
+	Secret         string
+	Enabled        bool
+	LastUsedStep   int64
+	CreatedAt      time.Time
+	EnabledAt      *time.Time
+	FailedAttempts int
+	LockedUntil    *time.Time
+}
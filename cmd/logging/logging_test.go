@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("Signed in abebe@example.com",
+		"password", "hunter2",
+		"session_token", "eyJhbGciOi",
+		"contact", "call +251 91 123 4567 or 0911-123-456",
+		"error", errors.New("header Bearer abc.def.ghi rejected"),
+		"date", "2024-01-15",
+		"employee_id", 42,
+	)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"msg":           "Signed in [email]",
+		"password":      Redacted,
+		"session_token": Redacted,
+		"contact":       "call [phone] or [phone]",
+		"error":         "header Bearer " + Redacted + " rejected",
+		"date":          "2024-01-15",
+		"employee_id":   float64(42),
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("%s = %v, want %v", key, record[key], value)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "warn", FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithRequestID(context.Background(), "req-1")
+	logger.InfoContext(ctx, "below the level")
+	logger.With("handler", "jobs").WarnContext(ctx, "slow query")
+
+	out := buf.String()
+	if strings.Contains(out, "below the level") {
+		t.Errorf("info record written at warn level: %s", out)
+	}
+	if !strings.Contains(out, "request_id=req-1") || !strings.Contains(out, "handler=jobs") {
+		t.Errorf("record = %s", out)
+	}
+}
+
+func TestNewRejectsUnknownSettings(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "verbose", FormatJSON); err == nil {
+		t.Error("unknown level accepted")
+	}
+	if _, err := New(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces values that must not be logged
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are parts of attribute names whose values are never logged
+var sensitiveKeys = []string{
+	"password", "passwd", "secret", "token", "authorization", "cookie",
+	"api_key", "apikey", "recovery", "email", "phone",
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// Phone numbers start with a country code or a trunk 0, e.g.
+	// +251 91 123 4567 or 0911-123-456
+	phonePattern = regexp.MustCompile(`(?:\+\d{1,3}|\b0)[\s-]?\d{2,3}[\s-]?\d{3}[\s-]?\d{3,4}\b`)
+	// bearerPattern finds credentials quoted in messages and errors
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+)
+
+// Redact masks the email addresses, phone numbers and bearer credentials
+// found in s
+func Redact(s string) string {
+	s = emailPattern.ReplaceAllString(s, "[email]")
+	s = phonePattern.ReplaceAllString(s, "[phone]")
+	return bearerPattern.ReplaceAllString(s, "$1 "+Redacted)
+}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAttr is the ReplaceAttr hook of every handler built by New. It
+// drops the values of sensitive attributes and masks personal data in the
+// message and in string and error values.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	if sensitiveKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	switch v := a.Value.Resolve(); v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(v.String()))
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
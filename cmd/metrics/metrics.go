@@ -0,0 +1,101 @@
+// Package metrics holds the Prometheus metrics of the API: request
+// latencies, database pool statistics and business counters. Each Metrics
+// has its own registry, so tests can build as many as they like.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bank"
+
+// Application kinds, the label values of ApplicationsSubmitted
+const (
+	KindInternal = "internal"
+	KindExternal = "external"
+)
+
+// Scores, the score label values of ScoreUpdates
+const (
+	ScoreIndividualPMS          = "individual_pms"
+	ScoreManagerRecommendation  = "manager_recommendation"
+	ScoreDistrictRecommendation = "district_recommendation"
+)
+
+type Metrics struct {
+	registry        *prometheus.Registry
+	requestDuration *prometheus.HistogramVec
+
+	// ApplicationsSubmitted counts job applications by kind
+	ApplicationsSubmitted *prometheus.CounterVec
+	// LinksGenerated counts application links handed out
+	LinksGenerated prometheus.Counter
+	// ScoreUpdates counts promotion score changes by the role of the user
+	// making them and the score changed
+	ScoreUpdates *prometheus.CounterVec
+}
+
+// New returns metrics registered with a new registry, together with the Go
+// runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests, by route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		ApplicationsSubmitted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "applications_submitted_total",
+			Help:      "Job applications submitted, by kind.",
+		}, []string{"kind"}),
+		LinksGenerated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "application_links_generated_total",
+			Help:      "Application links generated.",
+		}),
+		ScoreUpdates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "score_updates_total",
+			Help:      "Promotion score updates, by role of the user and score.",
+		}, []string{"role", "score"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requestDuration,
+		m.ApplicationsSubmitted,
+		m.LinksGenerated,
+		m.ScoreUpdates,
+	)
+	// Start the kinds at zero so that rates work from the first submission
+	m.ApplicationsSubmitted.WithLabelValues(KindInternal)
+	m.ApplicationsSubmitted.WithLabelValues(KindExternal)
+	return m
+}
+
+// RegisterDB adds the connection pool statistics of db
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveRequest records a served request. route is the route pattern,
+// never the requested path, to keep the number of series bounded.
+func (m *Metrics) ObserveRequest(method, route string, status int, d time.Duration) {
+	m.requestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
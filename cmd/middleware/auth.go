@@ -1,11 +1,14 @@
 package middleware
 
 import (
-    "errors"
+    "context"
     "net/http"
     "strings"
     "time"
 
+    "github.com/brehan/bank/cmd/apperr"
+    "github.com/brehan/bank/cmd/data"
+    "github.com/brehan/bank/cmd/tracing"
     "github.com/dgrijalva/jwt-go"
     "github.com/gin-gonic/gin"
     "github.com/google/uuid"
@@ -13,31 +16,110 @@ import (
 )
 
 var (
-    ErrInvalidToken = errors.New("invalid token")
-    ErrNoToken      = errors.New("no token provided")
-    ErrInvalidRole  = errors.New("invalid role")
+    ErrInvalidToken        = apperr.Unauthorized("invalid_token", "invalid token")
+    ErrNoToken             = apperr.Unauthorized("no_token", "no token provided")
+    ErrMissingPermission   = apperr.Forbidden("missing_permission", "missing permission")
+    ErrInvalidAPIKey       = apperr.Unauthorized("invalid_api_key", "invalid API key")
+    ErrReadOnlyToken       = apperr.Forbidden("read_only_session", "this impersonation session is read-only")
+    ErrImpersonationDenied = apperr.Forbidden("impersonation_denied", "not allowed while impersonating")
 )
 
+// Claims carry the user's primary role for the frontend, plus every role
+// and the resolved permissions so requests need no role lookup
 type Claims struct {
-    UserID   uuid.UUID `json:"user_id"`
-    Role     string    `json:"role"`
-    District string    `json:"district,omitempty"`
+    UserID      uuid.UUID `json:"user_id"`
+    Role        string    `json:"role,omitempty"`
+    Roles       []string  `json:"roles,omitempty"`
+    Permissions []string  `json:"permissions,omitempty"`
+    District    string    `json:"district,omitempty"`
+    Scope       string    `json:"scope,omitempty"`
+    // TokenVersion is the user's token version when the token was issued.
+    // The token stops working once it changes.
+    TokenVersion int `json:"tv"`
+    // Act is set on impersonation tokens and names the admin acting as the
+    // user (RFC 8693). ReadOnly tokens only allow safe methods.
+    Act      *Actor `json:"act,omitempty"`
+    ReadOnly bool   `json:"read_only,omitempty"`
     jwt.StandardClaims
 }
 
+// Actor is the real user behind an impersonation token
+type Actor struct {
+    UserID       uuid.UUID `json:"sub"`
+    TokenVersion int       `json:"tv"`
+}
+
 const (
-    UserIDKey = "user_id"
-    RoleKey   = "role"
+    UserIDKey      = "user_id"
+    RoleKey        = "role"
+    RolesKey       = "roles"
+    PermissionsKey = "permissions"
+    // DistrictKey holds the district of the user's district-scoped role, if
+    // any. District routes only show employees of this district.
+    DistrictKey = "district"
+    ScopeKey       = "scope"
+    APIKeyIDKey    = "api_key_id"
+    // ImpersonatorIDKey holds the admin's user ID on impersonated requests
+    ImpersonatorIDKey = "impersonator_id"
 )
 
-var jwtKey = []byte("your-secret-key") // Replace with env variable in production
+// APIKeyRole stands in for the role of requests made with an API key in
+// traces and metrics
+const APIKeyRole = "api_key"
+
+// Every response to an impersonated request carries these headers so the
+// frontend can show a banner
+const (
+    ImpersonatedByHeader    = "X-Impersonated-By"
+    ImpersonationModeHeader = "X-Impersonation-Mode"
+)
+
+const (
+    ImpersonationReadOnly  = "read-only"
+    ImpersonationReadWrite = "read-write"
+)
+
+// APIKeyHeader carries an API key as an alternative to a bearer token
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuthenticator resolves a presented API key, returning nil if the key
+// is not valid. It is implemented by service.APIKeyService.
+type APIKeyAuthenticator interface {
+    Authenticate(ctx context.Context, secret, ip string) (*data.APIKey, error)
+}
+
+// SessionValidator checks on every request that the user behind a session
+// token may still use it, so that disabling a user or changing their roles
+// takes effect before the token expires. It is implemented by
+// service.AuthService.
+type SessionValidator interface {
+    ValidateSession(ctx context.Context, userID uuid.UUID, tokenVersion int) error
+}
 
-func GenerateToken(userID uuid.UUID, role string, district string) (string, error) {
+// ScopeMFAPending marks a token issued after a correct password but before
+// the second factor. It is only accepted by the MFA endpoints.
+const ScopeMFAPending = "mfa_pending"
+
+const mfaPendingTTL = 5 * time.Minute
+
+// jwtKey signs and verifies session tokens. It is set from the
+// configuration at startup with SetJWTKey.
+var jwtKey []byte
+
+// SetJWTKey sets the key used to sign and verify tokens
+func SetJWTKey(key []byte) {
+    jwtKey = key
+}
+
+func GenerateToken(user *data.User, access data.UserAccess) (string, error) {
     expirationTime := time.Now().Add(24 * time.Hour)
     claims := &Claims{
-        UserID:   userID,
-        Role:     role,
-        District: district,
+        UserID:       user.Id,
+        TokenVersion: user.TokenVersion,
+        Role:        access.PrimaryRole().Role,
+        Roles:       access.RoleNames(),
+        Permissions: access.Permissions,
+        District:    access.District(),
         StandardClaims: jwt.StandardClaims{
             ExpiresAt: expirationTime.Unix(),
         },
@@ -47,11 +129,47 @@ func GenerateToken(userID uuid.UUID, role string, district string) (string, erro
     return token.SignedString(jwtKey)
 }
 
-func AuthMiddleware(c *gin.Context) {
+// GenerateMFAPendingToken issues a short-lived token that only allows the
+// holder to complete (or enrol in) two-factor authentication
+func GenerateMFAPendingToken(userID uuid.UUID) (string, error) {
+    claims := &Claims{
+        UserID: userID,
+        Scope:  ScopeMFAPending,
+        StandardClaims: jwt.StandardClaims{
+            ExpiresAt: time.Now().Add(mfaPendingTTL).Unix(),
+        },
+    }
+
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+    return token.SignedString(jwtKey)
+}
+
+// GenerateImpersonationToken issues a token that lets impersonator act as
+// user with the user's own access. It is read-only unless readOnly is
+// false.
+func GenerateImpersonationToken(impersonator, user *data.User, access data.UserAccess, readOnly bool, expiresAt time.Time) (string, error) {
+    claims := &Claims{
+        UserID:       user.Id,
+        TokenVersion: user.TokenVersion,
+        Role:        access.PrimaryRole().Role,
+        Roles:       access.RoleNames(),
+        Permissions: access.Permissions,
+        District:    access.District(),
+        Act:         &Actor{UserID: impersonator.Id, TokenVersion: impersonator.TokenVersion},
+        ReadOnly:    readOnly,
+        StandardClaims: jwt.StandardClaims{
+            ExpiresAt: expiresAt.Unix(),
+        },
+    }
+
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+    return token.SignedString(jwtKey)
+}
+
+func parseToken(c *gin.Context) (*Claims, error) {
     authHeader := c.GetHeader("Authorization")
     if authHeader == "" {
-        c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrNoToken.Error()})
-        return
+        return nil, ErrNoToken
     }
 
     tokenString := strings.TrimPrefix(authHeader, "Bearer ")
@@ -62,37 +180,135 @@ func AuthMiddleware(c *gin.Context) {
     })
 
     if err != nil || !token.Valid {
-        c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidToken.Error()})
-        return
+        return nil, ErrInvalidToken
     }
-
-    c.Set(UserIDKey, claims.UserID)
-    c.Set(RoleKey, claims.Role)
-    c.Next()
+    return claims, nil
 }
 
-func RequireRole(roles ...string) gin.HandlerFunc {
+// AuthMiddleware accepts either a session JWT in the Authorization header
+// or an API key in the X-API-Key header. API key requests carry the
+// permissions of the key's scopes and no user ID. Session tokens are checked
+// with sessions, and so are those of the admin behind an impersonation.
+func AuthMiddleware(keys APIKeyAuthenticator, sessions SessionValidator) gin.HandlerFunc {
     return func(c *gin.Context) {
-        roleValue, exists := c.Get(RoleKey)
-        if !exists {
-            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidToken.Error()})
+        if secret := c.GetHeader(APIKeyHeader); secret != "" {
+            key, err := keys.Authenticate(c.Request.Context(), secret, c.ClientIP())
+            if err != nil {
+                Abort(c, err)
+                return
+            }
+            if key == nil {
+                Abort(c, ErrInvalidAPIKey)
+                return
+            }
+
+            c.Set(APIKeyIDKey, key.ID)
+            c.Set(RolesKey, []string{})
+            c.Set(PermissionsKey, key.Permissions())
+            c.Request = c.Request.WithContext(tracing.WithUserRole(c.Request.Context(), APIKeyRole))
+            c.Next()
+            return
+        }
+
+        claims, err := parseToken(c)
+        if err != nil {
+            Abort(c, err)
             return
         }
 
-        role, ok := roleValue.(string)
-        if !ok {
-            c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "invalid role type"})
+        // Limited tokens (e.g. mfa_pending) are not session tokens
+        if claims.Scope != "" {
+            Abort(c, ErrInvalidToken)
             return
         }
 
-        for _, allowed := range roles {
-            if role == allowed {
-                c.Next()
+        if err := sessions.ValidateSession(c.Request.Context(), claims.UserID, claims.TokenVersion); err != nil {
+            Abort(c, err)
+            return
+        }
+        if claims.Act != nil {
+            if err := sessions.ValidateSession(c.Request.Context(), claims.Act.UserID, claims.Act.TokenVersion); err != nil {
+                Abort(c, err)
                 return
             }
         }
 
-        c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrInvalidRole.Error()})
+        if claims.Act != nil {
+            mode := ImpersonationReadWrite
+            if claims.ReadOnly {
+                mode = ImpersonationReadOnly
+            }
+            c.Header(ImpersonatedByHeader, claims.Act.UserID.String())
+            c.Header(ImpersonationModeHeader, mode)
+            if claims.ReadOnly && !isSafeMethod(c.Request.Method) {
+                Abort(c, ErrReadOnlyToken)
+                return
+            }
+            c.Set(ImpersonatorIDKey, claims.Act.UserID)
+        }
+
+        c.Set(UserIDKey, claims.UserID)
+        c.Set(RoleKey, claims.Role)
+        c.Set(RolesKey, claims.Roles)
+        c.Set(PermissionsKey, claims.Permissions)
+        c.Set(DistrictKey, claims.District)
+        c.Request = c.Request.WithContext(tracing.WithUserRole(c.Request.Context(), claims.Role))
+        c.Next()
+    }
+}
+
+func isSafeMethod(method string) bool {
+    return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// DenyImpersonation rejects impersonated requests. It guards credential
+// management, which must stay with the user themselves.
+func DenyImpersonation(c *gin.Context) {
+    if _, ok := GetImpersonatorFromContext(c); ok {
+        Abort(c, ErrImpersonationDenied)
+        return
+    }
+    c.Next()
+}
+
+// MFAPendingMiddleware only accepts tokens issued by GenerateMFAPendingToken
+func MFAPendingMiddleware(c *gin.Context) {
+    claims, err := parseToken(c)
+    if err != nil {
+        Abort(c, err)
+        return
+    }
+
+    if claims.Scope != ScopeMFAPending {
+        Abort(c, ErrInvalidToken)
+        return
+    }
+
+    c.Set(UserIDKey, claims.UserID)
+    c.Set(ScopeKey, claims.Scope)
+    c.Next()
+}
+
+// RequirePermission lets the request through if the token grants any of the
+// given permissions
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        granted, err := GetPermissionsFromContext(c)
+        if err != nil {
+            Abort(c, ErrInvalidToken)
+            return
+        }
+
+        for _, required := range permissions {
+            for _, p := range granted {
+                if p == required {
+                    c.Next()
+                    return
+                }
+            }
+        }
+
+        Abort(c, ErrMissingPermission)
     }
 }
 
@@ -108,6 +324,17 @@ func GetUserIDFromContext(c *gin.Context) (uuid.UUID, error) {
     return userID, nil
 }
 
+// GetImpersonatorFromContext returns the admin behind an impersonated
+// request, or false if the request is not impersonated
+func GetImpersonatorFromContext(c *gin.Context) (uuid.UUID, bool) {
+    id, exists := c.Get(ImpersonatorIDKey)
+    if !exists {
+        return uuid.Nil, false
+    }
+    impersonatorID, ok := id.(uuid.UUID)
+    return impersonatorID, ok
+}
+
 func GetRoleFromContext(c *gin.Context) (string, error) {
     role, exists := c.Get(RoleKey)
     if !exists {
@@ -119,3 +346,27 @@ func GetRoleFromContext(c *gin.Context) (string, error) {
     }
     return roleStr, nil
 }
+
+func GetRolesFromContext(c *gin.Context) ([]string, error) {
+    roles, exists := c.Get(RolesKey)
+    if !exists {
+        return nil, ErrInvalidToken
+    }
+    roleList, ok := roles.([]string)
+    if !ok {
+        return nil, ErrInvalidToken
+    }
+    return roleList, nil
+}
+
+func GetPermissionsFromContext(c *gin.Context) ([]string, error) {
+    permissions, exists := c.Get(PermissionsKey)
+    if !exists {
+        return nil, ErrInvalidToken
+    }
+    permissionList, ok := permissions.([]string)
+    if !ok {
+        return nil, ErrInvalidToken
+    }
+    return permissionList, nil
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrBodyTooLarge is returned for request bodies over the limit
+var ErrBodyTooLarge = apperr.New(apperr.KindTooLarge, "body_too_large", "request body too large")
+
+// MaxBodySize rejects requests whose body is larger than limit bytes. A
+// declared Content-Length over the limit is refused before the handler
+// runs; a body without one is cut off at the limit, which makes reading it
+// fail in the handler.
+func MaxBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.Header("Connection", "close")
+			Abort(c, ErrBodyTooLarge)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
@@ -1,32 +1,116 @@
 package middleware
 
 import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
 	"github.com/gin-gonic/gin"
 )
 
-// CorsMiddleware adds CORS headers to allow all cross-origin requests
-// This is a simplified version for development
-func CorsMiddleware() gin.HandlerFunc {
+// ErrOriginNotAllowed answers a CORS preflight from an origin that is not
+// in the allowlist
+var ErrOriginNotAllowed = apperr.Forbidden("origin_not_allowed", "origin not allowed")
+
+// exposedHeaders are the response headers that browser code may read
+var exposedHeaders = strings.Join([]string{
+	RequestIDHeader,
+	ImpersonatedByHeader,
+	ImpersonationModeHeader,
+	IdempotentReplayedHeader,
+	"Deprecation",
+	"Sunset",
+	"Link",
+	"Retry-After",
+}, ", ")
+
+// CORSOptions configure CORS. Origins are either exact, such as
+// https://hr.example.com, or match any subdomain, such as
+// https://*.example.com.
+type CORSOptions struct {
+	Origins []string
+	Methods []string
+	Headers []string
+	MaxAge  time.Duration
+}
+
+// CORS lets the allowed origins call the API with credentials. Requests
+// from other origins get no CORS headers, so browsers do not let the page
+// read the response, and their preflight requests are refused.
+func CORS(opts CORSOptions) gin.HandlerFunc {
+	allowed := newOriginMatcher(opts.Origins)
+	methods := strings.Join(opts.Methods, ", ")
+	headers := strings.Join(opts.Headers, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge.Seconds()))
+
 	return func(c *gin.Context) {
-		// Get request origin
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
 		origin := c.Request.Header.Get("Origin")
 		if origin == "" {
-			origin = "*"
+			c.Next()
+			return
 		}
-		
-		// Allow the specific origin that made the request
-		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type, Authorization")
-		
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		preflight := c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != ""
+
+		if !allowed(origin) {
+			if preflight {
+				Abort(c, ErrOriginNotAllowed)
+				return
+			}
+			c.Next()
 			return
 		}
-		
-		c.Next()
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", exposedHeaders)
+			c.Next()
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", methods)
+		h.Set("Access-Control-Allow-Headers", headers)
+		h.Set("Access-Control-Max-Age", maxAge)
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// newOriginMatcher returns a function that reports whether an origin is one
+// of origins. Scheme, host and port must all match; a "*." wildcard stands
+// for one or more subdomain labels.
+func newOriginMatcher(origins []string) func(string) bool {
+	exact := make(map[string]bool)
+	type wildcard struct{ scheme, suffix string }
+	var wildcards []wildcard
+	for _, o := range origins {
+		o = strings.ToLower(o)
+		if scheme, rest, ok := strings.Cut(o, "://*."); ok {
+			wildcards = append(wildcards, wildcard{scheme + "://", "." + rest})
+		} else {
+			exact[o] = true
+		}
+	}
+
+	return func(origin string) bool {
+		origin = strings.ToLower(origin)
+		if exact[origin] {
+			return true
+		}
+		for _, w := range wildcards {
+			rest, ok := strings.CutPrefix(origin, w.scheme)
+			if !ok || !strings.HasSuffix(rest, w.suffix) {
+				continue
+			}
+			// The subdomain must be a non-empty host name, so that the
+			// suffix cannot be reached through a port or path
+			sub := strings.TrimSuffix(rest, w.suffix)
+			if sub != "" && !strings.ContainsAny(sub, ":/@") {
+				return true
+			}
+		}
+		return false
 	}
-} 
\ No newline at end of file
+}
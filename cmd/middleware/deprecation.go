@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks the responses of a route group as deprecated since the
+// given time (RFC 9745). The Sunset header (RFC 8594) announces when the
+// routes may be removed, unless sunset is zero, and a successor-version link
+// points at the same path with prefix replaced by successor.
+func Deprecated(prefix, successor string, since, sunset time.Time) gin.HandlerFunc {
+	deprecation := fmt.Sprintf("@%d", since.Unix())
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("Deprecation", deprecation)
+		if !sunset.IsZero() {
+			h.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		if rest, ok := strings.CutPrefix(c.Request.URL.Path, prefix); ok {
+			h.Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successor, rest))
+		}
+		c.Next()
+	}
+}
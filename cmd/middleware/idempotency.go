@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/data"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// IdempotencyKeyHeader carries a key chosen by the client that makes
+	// retries of a request safe
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a response stored for an earlier
+	// request with the same key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+var ErrInvalidIdempotencyKey = apperr.Validation("invalid_idempotency_key", "", "Idempotency-Key must be at most 255 characters")
+
+// IdempotencyKeys runs each request made with a key once. It is implemented
+// by service.IdempotencyService.
+type IdempotencyKeys interface {
+	Begin(ctx context.Context, key, fingerprint string) (*data.IdempotentRequest, error)
+	Complete(ctx context.Context, key string, status int, contentType string, body []byte) error
+	Release(ctx context.Context, key string) error
+}
+
+// Idempotent makes a route safe to retry. A request with an Idempotency-Key
+// header runs once; retries with the same key and the same request get its
+// response again, marked with Idempotent-Replayed. Only successful responses
+// are kept, so a retry of a failed request runs again.
+//
+// route names the route, as for RateLimit. Keys are separate for every
+// signed-in user and API key. Anonymous callers have keys of their own per
+// client IP and path, so the callers of a link only share keys if they
+// share the link token too; a retry from a new IP runs again.
+func Idempotent(keys IdempotencyKeys, route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientKey := c.GetHeader(IdempotencyKeyHeader)
+		if clientKey == "" {
+			c.Next()
+			return
+		}
+		if len(clientKey) > maxIdempotencyKeyLength {
+			Abort(c, ErrInvalidIdempotencyKey)
+			return
+		}
+		fingerprint, err := fingerprintRequest(c)
+		if err != nil {
+			Abort(c, err)
+			return
+		}
+
+		ctx := c.Request.Context()
+		key := route + " " + caller(c) + " " + clientKey
+		held, err := keys.Begin(ctx, key, fingerprint)
+		if err != nil {
+			Abort(c, err)
+			return
+		}
+		if held != nil {
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(held.Status, held.ContentType, held.Body)
+			c.Abort()
+			return
+		}
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		completed := false
+		// Also frees the key when the handler panics
+		defer func() {
+			if !completed {
+				_ = keys.Release(ctx, key)
+			}
+		}()
+		c.Next()
+
+		if status := w.Status(); w.Written() && status >= 200 && status < 300 {
+			completed = keys.Complete(ctx, key, status, w.Header().Get("Content-Type"), w.body.Bytes()) == nil
+		}
+	}
+}
+
+// caller names who made the request: a user, an API key or, for routes
+// without sign-in, a hash of the client IP and the path parameters, which
+// keeps both the IP and link tokens out of the stored keys
+func caller(c *gin.Context) string {
+	if id, ok := c.Get(UserIDKey); ok {
+		return fmt.Sprint("user:", id)
+	}
+	if id, ok := c.Get(APIKeyIDKey); ok {
+		return fmt.Sprint("key:", id)
+	}
+	h := sha256.New()
+	writeField(h, []byte(c.ClientIP()))
+	for _, p := range c.Params {
+		writeField(h, []byte(p.Key))
+		writeField(h, []byte(p.Value))
+	}
+	return "anon:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// fingerprintRequest hashes the path parameters and body of a request. A
+// multipart body is hashed part by part, because browsers choose a new
+// boundary for every submission. The body is put back for the handler.
+func fingerprintRequest(c *gin.Context) (string, error) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return "", ErrBodyTooLarge
+		}
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	h := sha256.New()
+	for _, p := range c.Params {
+		writeField(h, []byte(p.Key))
+		writeField(h, []byte(p.Value))
+	}
+	if !writeParts(h, c.GetHeader("Content-Type"), body) {
+		writeField(h, body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeParts hashes the names and contents of the parts of a multipart
+// body. It reports false if the body is not multipart or cannot be read as
+// such; the handler then rejects it anyway.
+func writeParts(h hash.Hash, contentType string, body []byte) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return false
+	}
+	var parts bytes.Buffer
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return false
+		}
+		writeField(&parts, []byte(part.FormName()))
+		writeField(&parts, []byte(part.FileName()))
+		writeField(&parts, content)
+	}
+	h.Write(parts.Bytes())
+	return true
+}
+
+// writeField writes a length-prefixed field, so that fields cannot run into
+// each other
+func writeField(w io.Writer, field []byte) {
+	fmt.Fprintf(w, "%d:", len(field))
+	w.Write(field)
+}
+
+// recordingWriter keeps a copy of the response body
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/brehan/bank/cmd/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestMetrics records the latency of every request by route pattern.
+// Requests that match no route are counted under "unmatched".
+func RequestMetrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
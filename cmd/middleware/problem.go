@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemContentType is the media type of error responses (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// Problem is the body of every error response. Code is the
+// machine-readable apperr code and RequestID correlates the response with
+// the server logs.
+type Problem struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Detail    string              `json:"detail"`
+	Instance  string              `json:"instance"`
+	Code      string              `json:"code"`
+	RequestID string              `json:"request_id,omitempty"`
+	Errors    []apperr.FieldError `json:"errors,omitempty"`
+}
+
+var statusByKind = map[apperr.Kind]int{
+	apperr.KindValidation:    http.StatusBadRequest,
+	apperr.KindUnauthorized:  http.StatusUnauthorized,
+	apperr.KindForbidden:     http.StatusForbidden,
+	apperr.KindNotFound:      http.StatusNotFound,
+	apperr.KindConflict:      http.StatusConflict,
+	apperr.KindUnprocessable: http.StatusUnprocessableEntity,
+	apperr.KindTooLarge:      http.StatusRequestEntityTooLarge,
+	apperr.KindRateLimited:   http.StatusTooManyRequests,
+	apperr.KindInternal:      http.StatusInternalServerError,
+}
+
+// Status returns the HTTP status for errors of the given kind
+func Status(kind apperr.Kind) int {
+	if status, ok := statusByKind[kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Problems writes the last error that a handler added with c.Error as a
+// problem+json response, unless the handler already wrote a response. The
+// message of an internal error is replaced by a generic one; the access log
+// records the cause.
+func Problems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		e := apperr.From(c.Errors.Last().Err)
+		status := Status(e.Kind)
+		detail := e.Message
+		if e.Kind == apperr.KindInternal {
+			detail = "The server could not complete the request"
+		}
+
+		c.Header("Content-Type", ProblemContentType)
+		c.JSON(status, Problem{
+			Type:      "about:blank",
+			Title:     http.StatusText(status),
+			Status:    status,
+			Detail:    detail,
+			Instance:  c.Request.URL.Path,
+			Code:      e.Code,
+			RequestID: c.GetString(RequestIDKey),
+			Errors:    e.Fields,
+		})
+	}
+}
+
+// Abort stops the request with err, which Problems writes as the response
+func Abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimiter takes a token from a named bucket, returning an error and how
+// long to wait when there is none. It is implemented by
+// service.RateLimiter.
+type RateLimiter interface {
+	Take(ctx context.Context, bucket string, limit data.RateLimit) (time.Duration, error)
+}
+
+// RateLimit allows each client IP limit requests to a route. route names
+// the route's buckets, so that its /api and /api/v1 paths share them. A
+// refused request gets Retry-After in whole seconds. The zero limit lets
+// every request through.
+func RateLimit(limiter RateLimiter, route string, limit data.RateLimit) gin.HandlerFunc {
+	if limit.Requests <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		wait, err := limiter.Take(c.Request.Context(), route+" "+c.ClientIP(), limit)
+		if err != nil {
+			if wait > 0 {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			}
+			Abort(c, err)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// RequestIDHeader carries the request ID in both directions
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey holds the request ID in the gin context
+	RequestIDKey = "request_id"
+)
+
+// validRequestID limits the IDs taken over from clients and proxies to
+// ones that are safe to log and echo
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// RequestID gives every request an ID, taken from the X-Request-ID header
+// when the caller sent a usable one. The ID is returned in the response
+// header and stored in the request context, where logging picks it up.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// AccessLog logs one record per request once it has been served. Routes
+// are logged by their pattern rather than the requested path, which keeps
+// the tokens of application links out of the log.
+func AccessLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if userID, ok := c.Get(UserIDKey); ok {
+			attrs = append(attrs, slog.Any("user_id", userID))
+		}
+		if keyID, ok := c.Get(APIKeyIDKey); ok {
+			attrs = append(attrs, slog.Any("key_id", keyID))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery turns a panic in a handler into an internal error and logs it.
+// It must run inside Problems, which writes the response.
+func Recovery(logger *slog.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err interface{}) {
+		logger.ErrorContext(c.Request.Context(), "panic serving request", "route", c.FullPath(), "panic", err)
+		Abort(c, apperr.Internal(fmt.Errorf("panic: %v", err)))
+	})
+}
@@ -0,0 +1,206 @@
+package migrate
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(files, "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for _, m := range migrations {
+		if len(m.Checksum) != 64 {
+			t.Errorf("migration %d has checksum %q", m.Version, m.Checksum)
+		}
+	}
+	if migrations[0].Name != "baseline" {
+		t.Errorf("first migration is %s, want baseline", migrations[0].Name)
+	}
+}
+
+func TestLoadRejectsBadSets(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+	tests := map[string]fstest.MapFS{
+		"gap": {
+			"m/0001_a.up.sql": file("A"), "m/0001_a.down.sql": file("a"),
+			"m/0003_c.up.sql": file("C"), "m/0003_c.down.sql": file("c"),
+		},
+		"missing down": {
+			"m/0001_a.up.sql": file("A"),
+		},
+		"bad name": {
+			"m/0001_a.up.sql": file("A"), "m/0001_a.down.sql": file("a"),
+			"m/notes.txt": file(""),
+		},
+		"two names": {
+			"m/0001_a.up.sql": file("A"), "m/0001_b.down.sql": file("a"),
+		},
+	}
+	for name, fsys := range tests {
+		if _, err := Load(fsys, "m"); err == nil {
+			t.Errorf("%s: Load should fail", name)
+		}
+	}
+}
+
+func TestStates(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0001_a.up.sql": {Data: []byte("A")}, "m/0001_a.down.sql": {Data: []byte("a")},
+		"m/0002_b.up.sql": {Data: []byte("B")}, "m/0002_b.down.sql": {Data: []byte("b")},
+		"m/0003_c.up.sql": {Data: []byte("C")}, "m/0003_c.down.sql": {Data: []byte("c")},
+	}
+	migrations, err := Load(fsys, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Migrator{migrations: migrations}
+	now := time.Now()
+
+	states := m.states(map[int]applied{
+		1: {Version: 1, Name: "a", Checksum: migrations[0].Checksum, AppliedAt: now},
+		2: {Version: 2, Name: "b", Checksum: "edited", AppliedAt: now},
+		4: {Version: 4, Name: "d", Checksum: "x", AppliedAt: now},
+	})
+	if len(states) != 4 {
+		t.Fatalf("states = %+v", states)
+	}
+	if states[0].AppliedAt == nil || states[0].Modified {
+		t.Errorf("migration 1 should be applied and unchanged: %+v", states[0])
+	}
+	if !states[1].Modified {
+		t.Errorf("migration 2 should be modified: %+v", states[1])
+	}
+	if states[2].AppliedAt != nil {
+		t.Errorf("migration 3 should be pending: %+v", states[2])
+	}
+	if !states[3].Unknown || states[3].Version != 4 {
+		t.Errorf("migration 4 should be unknown: %+v", states[3])
+	}
+
+	err = conflicts(states)
+	drift, ok := err.(*DriftError)
+	if !ok || len(drift.Problems) != 2 {
+		t.Fatalf("conflicts = %v", err)
+	}
+	if !strings.Contains(drift.Problems[0], "changed") || !strings.Contains(drift.Problems[1], "newer build") {
+		t.Errorf("problems = %q", drift.Problems)
+	}
+}
+
+func TestDialectsMatch(t *testing.T) {
+	postgres, err := Load(files, "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := Load(files, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("%d postgres migrations but %d sqlite migrations", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d is %s for postgres but %s for sqlite", postgres[i].Version, postgres[i].Name, sqlite[i].Name)
+		}
+	}
+}
+
+func TestSQLiteUpDown(t *testing.T) {
+	db, err := repository.Open(repository.SQLite, filepath.Join(t.TempDir(), "bank.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, ok := m.Check(ctx).(*DriftError); !ok {
+		t.Fatal("an empty database should have pending migrations")
+	}
+	ran, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != len(m.Migrations()) {
+		t.Errorf("applied %d of %d migrations", len(ran), len(m.Migrations()))
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ran, err := m.Up(ctx); err != nil || len(ran) != 0 {
+		t.Errorf("second Up applied %d migrations, err %v", len(ran), err)
+	}
+
+	if _, err := db.Exec(`ALTER TABLE jobs DROP COLUMN salary`); err != nil {
+		t.Fatal(err)
+	}
+	drift, ok := m.Check(ctx).(*DriftError)
+	if !ok || len(drift.Problems) != 1 || !strings.Contains(drift.Problems[0], "jobs.salary") {
+		t.Fatalf("Check after dropping a column = %v", drift)
+	}
+	if _, err := db.Exec(`ALTER TABLE jobs ADD COLUMN salary TEXT`); err != nil {
+		t.Fatal(err)
+	}
+
+	ran, err = m.Down(ctx, len(m.Migrations()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != len(m.Migrations()) || ran[0].Version != len(m.Migrations()) {
+		t.Errorf("Down rolled back %+v", ran)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up after rolling everything back: %v", err)
+	}
+}
+
+func TestSQLitePhoneKeyBackfill(t *testing.T) {
+	db, err := repository.Open(repository.SQLite, filepath.Join(t.TempDir(), "bank.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A key as the 0011 backfill left it
+	phone := "+251 (911) 000/111 ext. 2"
+	if _, err := db.Exec(`INSERT INTO external_applications (phone, phone_key) VALUES ($1, $2)`, phone, "251911000/111ext2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var key string
+	if err := db.QueryRow(`SELECT phone_key FROM external_applications`).Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+	if key != data.NormalizePhone(phone) {
+		t.Errorf("phone_key = %q, want %q", key, data.NormalizePhone(phone))
+	}
+}
@@ -0,0 +1,312 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brehan/bank/cmd/repository"
+)
+
+// lockID is the PostgreSQL advisory lock held while migrating, so that
+// servers starting at the same time do not apply a migration twice
+const lockID = 7_265_310_246_418_019
+
+// Migrator applies the embedded migrations to a database
+type Migrator struct {
+	db         *repository.DB
+	migrations []Migration
+}
+
+// New returns a Migrator for the migrations built into the binary for the
+// database's dialect
+func New(db *repository.DB) (*Migrator, error) {
+	migrations, err := Load(files, string(db.Dialect))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrations returns the migrations known to this build, oldest first
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// applied is a row of schema_migrations
+type applied struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// State describes one migration in Status
+type State struct {
+	Version int
+	Name    string
+	// AppliedAt is nil for pending migrations
+	AppliedAt *time.Time
+	// Modified is set when the migration was applied from a different script
+	Modified bool
+	// Unknown is set for migrations applied by a newer build
+	Unknown bool
+}
+
+// DriftError lists every way the database differs from this build
+type DriftError struct {
+	Problems []string
+}
+
+func (e *DriftError) Error() string {
+	return "database schema does not match this build:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// querier is implemented by *repository.DB and *sql.Conn
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// tableExists is the query that tells whether schema_migrations exists
+var tableExists = map[repository.Dialect]string{
+	repository.Postgres: `SELECT to_regclass('schema_migrations') IS NOT NULL`,
+	repository.SQLite:   `SELECT count(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`,
+}
+
+// createTable creates schema_migrations. SQLite has no TIMESTAMPTZ, and
+// the driver only parses times back from columns declared TIMESTAMP.
+var createTable = map[repository.Dialect]string{
+	repository.Postgres: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`,
+	repository.SQLite: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`,
+}
+
+// readApplied returns the applied migrations by version. A database that
+// was never migrated has none.
+func (m *Migrator) readApplied(ctx context.Context, q querier) (map[int]applied, error) {
+	var exists bool
+	if err := q.QueryRowContext(ctx, tableExists[m.db.Dialect]).Scan(&exists); err != nil {
+		return nil, err
+	}
+	out := make(map[int]applied)
+	if !exists {
+		return out, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a applied
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		out[a.Version] = a
+	}
+	return out, rows.Err()
+}
+
+// states merges the known and the applied migrations, ordered by version
+func (m *Migrator) states(done map[int]applied) []State {
+	var out []State
+	for _, mig := range m.migrations {
+		s := State{Version: mig.Version, Name: mig.Name}
+		if a, ok := done[mig.Version]; ok {
+			at := a.AppliedAt
+			s.AppliedAt = &at
+			s.Modified = a.Checksum != mig.Checksum
+		}
+		out = append(out, s)
+	}
+	var unknown []applied
+	for _, a := range done {
+		if a.Version > len(m.migrations) {
+			unknown = append(unknown, a)
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	for _, a := range unknown {
+		at := a.AppliedAt
+		out = append(out, State{Version: a.Version, Name: a.Name, AppliedAt: &at, Unknown: true})
+	}
+	return out
+}
+
+// Status lists every migration known to this build or applied to the
+// database
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	done, err := m.readApplied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	return m.states(done), nil
+}
+
+// Check returns a *DriftError if the database is not at the latest version,
+// a migration was edited after it was applied or a table or column the code
+// uses is missing
+func (m *Migrator) Check(ctx context.Context) error {
+	states, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, s := range states {
+		switch {
+		case s.Unknown:
+			problems = append(problems, fmt.Sprintf("migration %d (%s) was applied by a newer build", s.Version, s.Name))
+		case s.AppliedAt == nil:
+			problems = append(problems, fmt.Sprintf("migration %d (%s) is pending", s.Version, s.Name))
+		case s.Modified:
+			problems = append(problems, fmt.Sprintf("migration %d (%s) was changed after it was applied", s.Version, s.Name))
+		}
+	}
+
+	// Columns are only compared once every migration is in, since pending
+	// migrations are expected to add some
+	if len(problems) == 0 {
+		missing, err := missingColumns(ctx, m.db)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, missing...)
+	}
+
+	if len(problems) > 0 {
+		return &DriftError{Problems: problems}
+	}
+	return nil
+}
+
+// Up applies every pending migration and returns the ones it applied. It
+// refuses to run on a database with unknown or modified migrations.
+func (m *Migrator) Up(ctx context.Context) (ran []Migration, err error) {
+	err = m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := m.readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := conflicts(m.states(done)); err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, mig.Up, m.db.Rebind(`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES ($1, $2, $3, $4)`),
+				mig.Version, mig.Name, mig.Checksum, time.Now().UTC()); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, err)
+			}
+			ran = append(ran, mig)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Down rolls back the last n applied migrations and returns them, newest
+// first
+func (m *Migrator) Down(ctx context.Context, n int) (ran []Migration, err error) {
+	err = m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := m.readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := conflicts(m.states(done)); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(ran) < n; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if err := apply(ctx, conn, mig.Down, m.db.Rebind(`DELETE FROM schema_migrations WHERE version = $1`), mig.Version); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, err)
+			}
+			ran = append(ran, mig)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// conflicts returns a *DriftError for applied migrations that this build
+// cannot safely build on or roll back
+func conflicts(states []State) error {
+	var problems []string
+	for _, s := range states {
+		if s.Unknown {
+			problems = append(problems, fmt.Sprintf("migration %d (%s) was applied by a newer build", s.Version, s.Name))
+		}
+		if s.Modified {
+			problems = append(problems, fmt.Sprintf("migration %d (%s) was changed after it was applied", s.Version, s.Name))
+		}
+	}
+	if len(problems) > 0 {
+		return &DriftError{Problems: problems}
+	}
+	return nil
+}
+
+// locked runs fn on a single connection that holds the migration lock.
+// SQLite needs no lock: the pool has one connection.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.db.Dialect == repository.Postgres {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+	}
+
+	if _, err := conn.ExecContext(ctx, createTable[m.db.Dialect]); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// apply runs a migration script and the statement that records it in one
+// transaction
+func apply(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) (err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, args...)
+	return err
+}
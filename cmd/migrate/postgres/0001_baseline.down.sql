@@ -0,0 +1,9 @@
+-- Drops the core tables and everything in them. Tables renamed from an old
+-- schema by the up migration are dropped too, not renamed back.
+
+DROP TABLE IF EXISTS application_links;
+DROP TABLE IF EXISTS internal_applications;
+DROP TABLE IF EXISTS external_applications;
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS employee;
+DROP TABLE IF EXISTS users;
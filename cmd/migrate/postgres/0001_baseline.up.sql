@@ -0,0 +1,135 @@
+-- Core tables: employees, job postings, applications and users.
+--
+-- Databases created by the old db.sql or setup_db.sh have a Job table with
+-- job_name/job_desc/job_progress/closetime columns, InternalEmployee and
+-- ExternalEmployee tables and a disrec20 column. They are renamed to what
+-- the code uses rather than recreated, so existing rows are kept; columns
+-- the code does not use are left in place. Safe to run more than once.
+
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+DO $$
+BEGIN
+    IF to_regclass('job') IS NOT NULL AND to_regclass('jobs') IS NULL THEN
+        ALTER TABLE job RENAME TO jobs;
+        ALTER TABLE jobs RENAME COLUMN job_name TO title;
+        ALTER TABLE jobs RENAME COLUMN job_desc TO description;
+        ALTER TABLE jobs RENAME COLUMN job_progress TO status;
+        ALTER TABLE jobs RENAME COLUMN closetime TO deadline;
+    END IF;
+
+    IF to_regclass('internalemployee') IS NOT NULL AND to_regclass('internal_applications') IS NULL THEN
+        ALTER TABLE internalemployee RENAME TO internal_applications;
+        ALTER TABLE internal_applications RENAME COLUMN employee_id TO matched_employee_id;
+    END IF;
+
+    IF to_regclass('externalemployee') IS NOT NULL AND to_regclass('external_applications') IS NULL THEN
+        ALTER TABLE externalemployee RENAME TO external_applications;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'employee' AND column_name = 'disrec20') THEN
+        ALTER TABLE employee RENAME COLUMN disrec20 TO disrec15;
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS employee (
+    id SERIAL PRIMARY KEY,
+    file_number TEXT,
+    full_name TEXT,
+    sex TEXT,
+    employment_date DATE,
+    doe DATE,
+    individual_pms FLOAT,
+    last_dop DATE,
+    job_grade TEXT,
+    new_salary FLOAT,
+    job_category TEXT,
+    new_position TEXT,
+    branch TEXT,
+    department TEXT,
+    district TEXT,
+    twin_branch TEXT,
+    region TEXT,
+    field_of_study TEXT,
+    educational_level TEXT,
+    cluster TEXT,
+    indpms25 FLOAT,
+    totalexp20 FLOAT,
+    totalexp INT,
+    relatedexp INT,
+    expafterpromo FLOAT,
+    tmdrec20 FLOAT,
+    disrec15 FLOAT,
+    total FLOAT
+);
+
+ALTER TABLE employee ADD COLUMN IF NOT EXISTS doe DATE;
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    title TEXT,
+    description TEXT,
+    qualifications TEXT,
+    department TEXT,
+    location TEXT,
+    job_type TEXT,
+    salary TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deadline TIMESTAMP,
+    status TEXT
+);
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS qualifications TEXT;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS department TEXT;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS location TEXT;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS salary TEXT;
+
+-- matched_employee_id is set when an application is matched to an existing
+-- employee by name
+CREATE TABLE IF NOT EXISTS internal_applications (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    first_name TEXT,
+    last_name TEXT,
+    jobid UUID REFERENCES jobs(id),
+    other_bank_exp TEXT,
+    matched_employee_id INT REFERENCES employee(id) ON DELETE CASCADE,
+    promotion_status TEXT,
+    resume_path TEXT
+);
+
+ALTER TABLE internal_applications ADD COLUMN IF NOT EXISTS promotion_status TEXT;
+ALTER TABLE internal_applications ADD COLUMN IF NOT EXISTS resume_path TEXT;
+
+CREATE TABLE IF NOT EXISTS external_applications (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    first_name TEXT,
+    last_name TEXT,
+    email TEXT,
+    phone TEXT,
+    jobid UUID REFERENCES jobs(id),
+    other_job_exp TEXT,
+    other_job_exp_year INT,
+    resume_path TEXT
+);
+
+ALTER TABLE external_applications ADD COLUMN IF NOT EXISTS resume_path TEXT;
+
+-- Single-use links that let a candidate apply for one job
+CREATE TABLE IF NOT EXISTS application_links (
+    id SERIAL PRIMARY KEY,
+    job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    token TEXT NOT NULL UNIQUE,
+    type TEXT NOT NULL, -- 'internal' or 'external'
+    expires_at TIMESTAMP NOT NULL,
+    is_used BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name TEXT,
+    password TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
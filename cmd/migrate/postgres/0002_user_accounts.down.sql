@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS users_email_key;
+
+ALTER TABLE users DROP COLUMN IF EXISTS last_login;
+ALTER TABLE users DROP COLUMN IF EXISTS status;
+ALTER TABLE users DROP COLUMN IF EXISTS branch;
+ALTER TABLE users DROP COLUMN IF EXISTS email;
@@ -0,0 +1,9 @@
+-- Account details used by the admin user management screens.
+-- Safe to run more than once.
+
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS email TEXT;
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS branch TEXT;
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS last_login TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON Users (lower(email));
@@ -0,0 +1,119 @@
+-- Roles and permissions.
+--
+-- Replaces the per-role Admin, Manager and DistrictManager tables with
+-- roles/permissions/user_roles. Safe to run more than once: existing rows in
+-- the old role tables are copied into user_roles, which is left untouched if
+-- they were already copied. The old tables are not dropped so the move can
+-- be checked first; drop them by hand afterwards.
+
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id SERIAL PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INT REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INT REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+-- A user can hold several roles; district is only used by district-scoped roles
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id UUID REFERENCES Users(id) ON DELETE CASCADE,
+    role_id INT REFERENCES roles(id) ON DELETE CASCADE,
+    district TEXT,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full access to employees, jobs, applications and users'),
+    ('manager', 'Scores individual PMS and manager recommendations'),
+    ('district_manager', 'Gives district recommendations for employees in a district')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('employee.read', 'List and view employees'),
+    ('employee.write', 'Create and update employees'),
+    ('employee.pms.write', 'Set an employee''s individual PMS score'),
+    ('employee.manager_rec.write', 'Set an employee''s manager recommendation'),
+    ('employee.district.read', 'View employees of the own district'),
+    ('employee.district_rec.write', 'Set an employee''s district recommendation'),
+    ('employee.evaluation.read', 'View an employee''s promotion evaluation'),
+    ('job.read', 'List and view job postings in the admin area'),
+    ('job.write', 'Create, update and delete job postings'),
+    ('application.read', 'View internal and external applications'),
+    ('application_link.read', 'View generated application links'),
+    ('application_link.write', 'Generate application links'),
+    ('user.read', 'List users and roles'),
+    ('user.write', 'Manage users and their roles'),
+    ('audit.read', 'View the audit log'),
+    ('dashboard.admin', 'Open the admin dashboard'),
+    ('dashboard.manager', 'Open the manager dashboard'),
+    ('dashboard.district', 'Open the district manager dashboard')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM (VALUES
+    ('admin', 'dashboard.admin'),
+    ('admin', 'employee.read'),
+    ('admin', 'employee.write'),
+    ('admin', 'job.read'),
+    ('admin', 'job.write'),
+    ('admin', 'application.read'),
+    ('admin', 'application_link.read'),
+    ('admin', 'application_link.write'),
+    ('admin', 'user.read'),
+    ('admin', 'user.write'),
+    ('admin', 'audit.read'),
+    ('manager', 'dashboard.manager'),
+    ('manager', 'employee.read'),
+    ('manager', 'employee.pms.write'),
+    ('manager', 'employee.manager_rec.write'),
+    ('manager', 'employee.evaluation.read'),
+    ('district_manager', 'dashboard.district'),
+    ('district_manager', 'employee.read'),
+    ('district_manager', 'employee.district.read'),
+    ('district_manager', 'employee.district_rec.write'),
+    ('district_manager', 'employee.evaluation.read')
+) AS grants (role_name, permission_name)
+JOIN roles r ON r.name = grants.role_name
+JOIN permissions p ON p.name = grants.permission_name
+ON CONFLICT DO NOTHING;
+
+-- Move existing role rows over. The old db.sql created the district table as
+-- DistrictManager (i.e. districtmanager) while the code used
+-- district_manager, so both names are checked.
+DO $$
+BEGIN
+    IF to_regclass('admin') IS NOT NULL THEN
+        INSERT INTO user_roles (user_id, role_id)
+        SELECT a.user_id, r.id FROM admin a JOIN roles r ON r.name = 'admin'
+        ON CONFLICT DO NOTHING;
+    END IF;
+
+    IF to_regclass('manager') IS NOT NULL THEN
+        INSERT INTO user_roles (user_id, role_id)
+        SELECT m.user_id, r.id FROM manager m JOIN roles r ON r.name = 'manager'
+        ON CONFLICT DO NOTHING;
+    END IF;
+
+    IF to_regclass('district_manager') IS NOT NULL THEN
+        INSERT INTO user_roles (user_id, role_id, district)
+        SELECT dm.user_id, r.id, dm.district FROM district_manager dm JOIN roles r ON r.name = 'district_manager'
+        ON CONFLICT DO NOTHING;
+    END IF;
+
+    IF to_regclass('districtmanager') IS NOT NULL THEN
+        INSERT INTO user_roles (user_id, role_id, district)
+        SELECT dm.user_id, r.id, dm.district FROM districtmanager dm JOIN roles r ON r.name = 'district_manager'
+        ON CONFLICT DO NOTHING;
+    END IF;
+END $$;
@@ -0,0 +1,4 @@
+-- Drops the audit log and its whole history
+
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
@@ -0,0 +1,40 @@
+-- Tamper-evident audit log.
+--
+-- Every mutation made through the API is appended here. Each row stores the
+-- hash of the row before it (prev_hash) and a hash over its own content, so
+-- `api audit verify` can detect rows that were edited or removed. actor_id
+-- deliberately has no foreign key: entries outlive deleted users.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id UUID,
+    actor_role TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    endpoint TEXT NOT NULL,
+    action TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id TEXT NOT NULL DEFAULT '',
+    before_value TEXT,
+    after_value TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT UNIQUE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+
+-- The log is append-only. This stops accidental edits from the application
+-- or a careless query; deliberate tampering is caught by the hash chain.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,27 @@
+-- API keys for machine-to-machine access.
+--
+-- Keys are shown once when created; only their SHA-256 is stored. scopes is
+-- a space separated list such as 'employees:read jobs:read'.
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT UNIQUE NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '',
+    created_by UUID REFERENCES Users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    last_used_ip TEXT,
+    revoked_at TIMESTAMP
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('api_key.manage', 'Create, list and revoke API keys')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'api_key.manage'
+WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,17 @@
+-- Single sign-on.
+--
+-- auth_provider says how a user signs in: 'local' users have a password,
+-- users provisioned from an identity provider do not. user_identities links
+-- an external account (issuer + subject) to a user. Safe to run more than
+-- once.
+
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS auth_provider TEXT NOT NULL DEFAULT 'local';
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login TIMESTAMP,
+    PRIMARY KEY (provider, subject)
+);
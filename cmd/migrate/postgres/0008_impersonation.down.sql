@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE name = 'user.impersonate';
+
+DROP INDEX IF EXISTS audit_log_impersonator_idx;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS impersonator_id;
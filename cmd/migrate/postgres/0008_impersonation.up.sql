@@ -0,0 +1,17 @@
+-- Admin impersonation ("view as").
+--
+-- Changes made while impersonating are logged with the impersonated user as
+-- actor_id and the admin as impersonator_id. Safe to run more than once.
+
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS impersonator_id UUID;
+
+CREATE INDEX IF NOT EXISTS audit_log_impersonator_idx ON audit_log (impersonator_id) WHERE impersonator_id IS NOT NULL;
+
+INSERT INTO permissions (name, description) VALUES
+    ('user.impersonate', 'Sign in as another user to reproduce what they see')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'user.impersonate'
+WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
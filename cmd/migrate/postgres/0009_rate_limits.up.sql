@@ -0,0 +1,12 @@
+-- Token buckets of the rate limiter, for servers that run on several nodes.
+--
+-- bucket is the route and client IP. Rather than a token count, a bucket is
+-- stored as the time at which it is full again, so that a request takes a
+-- token with one conditional UPDATE. Buckets that are full are deleted.
+
+CREATE TABLE IF NOT EXISTS rate_limits (
+    bucket TEXT PRIMARY KEY,
+    full_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_full_at_idx ON rate_limits (full_at);
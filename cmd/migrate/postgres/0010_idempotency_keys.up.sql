@@ -0,0 +1,16 @@
+-- Responses to requests made with an Idempotency-Key header.
+--
+-- key is the route, the caller and the client's key. A row with status 0 is
+-- a request still in progress; it expires after a short lease in case the
+-- server stops before the request completes. Expired rows are deleted.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    status INTEGER NOT NULL DEFAULT 0,
+    content_type TEXT NOT NULL DEFAULT '',
+    body BYTEA,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS external_applications_phone_key_idx;
+DROP INDEX IF EXISTS external_applications_email_key_idx;
+DROP INDEX IF EXISTS internal_applications_file_number_key_idx;
+
+ALTER TABLE external_applications DROP COLUMN IF EXISTS phone_key;
+ALTER TABLE external_applications DROP COLUMN IF EXISTS email_key;
+ALTER TABLE internal_applications DROP COLUMN IF EXISTS file_number_key;
+ALTER TABLE internal_applications DROP COLUMN IF EXISTS file_number;
+ALTER TABLE jobs DROP COLUMN IF EXISTS duplicate_policy;
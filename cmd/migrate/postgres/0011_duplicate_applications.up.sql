@@ -0,0 +1,24 @@
+-- Duplicate application detection.
+--
+-- An application is a duplicate of an earlier one for the same job with the
+-- same normalised email, phone or file number. duplicate_policy says whether
+-- a job rejects duplicates or merges them into the earlier application. The
+-- *_key columns hold the normalised values the application code computes;
+-- the backfill below approximates it for phone numbers in the usual formats.
+-- Safe to run more than once.
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS duplicate_policy TEXT NOT NULL DEFAULT 'reject';
+
+ALTER TABLE internal_applications ADD COLUMN IF NOT EXISTS file_number TEXT;
+ALTER TABLE internal_applications ADD COLUMN IF NOT EXISTS file_number_key TEXT;
+ALTER TABLE external_applications ADD COLUMN IF NOT EXISTS email_key TEXT;
+ALTER TABLE external_applications ADD COLUMN IF NOT EXISTS phone_key TEXT;
+
+UPDATE external_applications SET
+    email_key = lower(trim(email)),
+    phone_key = replace(replace(replace(replace(replace(replace(phone, ' ', ''), '-', ''), '(', ''), ')', ''), '+', ''), '.', '')
+WHERE email_key IS NULL AND phone_key IS NULL;
+
+CREATE INDEX IF NOT EXISTS internal_applications_file_number_key_idx ON internal_applications (jobid, file_number_key);
+CREATE INDEX IF NOT EXISTS external_applications_email_key_idx ON external_applications (jobid, email_key);
+CREATE INDEX IF NOT EXISTS external_applications_phone_key_idx ON external_applications (jobid, phone_key);
@@ -0,0 +1,8 @@
+-- Lockout after repeated invalid two-factor codes.
+--
+-- failed_attempts counts invalid codes since the last valid one. When it
+-- reaches the limit the enrolment is locked until locked_until and the count
+-- starts over. Safe to run more than once.
+
+ALTER TABLE user_mfa ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE user_mfa ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
@@ -0,0 +1,8 @@
+-- Revocation of session tokens.
+--
+-- Session tokens carry the user's token_version when they are issued and
+-- are only accepted while it is unchanged. Disabling a user or changing
+-- their roles increments it, which ends every session they have open. Safe
+-- to run more than once.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+-- Revisions of duplicate applications.
+--
+-- On a job whose duplicate_policy is merge, a later application from the
+-- same person is stored as a revision of the earlier one, linked by
+-- revision_of, rather than updating it: an application form needs no
+-- sign-in, so an update could be made by anyone who knows the applicant's
+-- email, phone or file number.
+--
+-- phone_key is recomputed with only the digits of the phone, as the
+-- application code does; 0011 only stripped the usual punctuation. Safe to
+-- run more than once.
+
+ALTER TABLE internal_applications ADD COLUMN IF NOT EXISTS revision_of UUID REFERENCES internal_applications(id) ON DELETE SET NULL;
+ALTER TABLE external_applications ADD COLUMN IF NOT EXISTS revision_of UUID REFERENCES external_applications(id) ON DELETE SET NULL;
+
+UPDATE external_applications SET phone_key = regexp_replace(phone, '\D', '', 'g') WHERE phone IS NOT NULL;
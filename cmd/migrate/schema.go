@@ -0,0 +1,110 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/brehan/bank/cmd/repository"
+)
+
+// expectedColumns lists the tables and columns the repositories query. A
+// migration that adds a table or column the code uses should add it here as
+// well. Extra columns in the database, such as those left over from the old
+// schema, are not drift.
+var expectedColumns = map[string][]string{
+	"employee": {
+		"id", "file_number", "full_name", "sex", "employment_date", "doe", "individual_pms", "last_dop",
+		"job_grade", "new_salary", "job_category", "new_position", "branch", "department", "district",
+		"twin_branch", "region", "field_of_study", "educational_level", "cluster", "indpms25", "totalexp20",
+		"totalexp", "relatedexp", "expafterpromo", "tmdrec20", "disrec15", "total",
+	},
+	"jobs": {
+		"id", "title", "description", "qualifications", "department", "location", "job_type", "salary",
+		"created_at", "deadline", "status", "duplicate_policy",
+	},
+	"internal_applications": {
+		"id", "first_name", "last_name", "jobid", "other_bank_exp", "matched_employee_id", "promotion_status",
+		"resume_path", "file_number", "file_number_key", "revision_of",
+	},
+	"external_applications": {
+		"id", "first_name", "last_name", "email", "phone", "jobid", "other_job_exp", "other_job_exp_year",
+		"resume_path", "email_key", "phone_key", "revision_of",
+	},
+	"application_links": {"id", "job_id", "token", "type", "expires_at", "is_used", "created_at"},
+	"users": {
+		"id", "name", "password", "email", "branch", "status", "auth_provider", "last_login", "created_at",
+		"updated_at", "token_version",
+	},
+	"roles":            {"id", "name", "description"},
+	"permissions":      {"id", "name", "description"},
+	"role_permissions": {"role_id", "permission_id"},
+	"user_roles":       {"user_id", "role_id", "district"},
+	"user_mfa": {
+		"user_id", "secret", "enabled", "last_used_step", "created_at", "enabled_at", "failed_attempts",
+		"locked_until",
+	},
+	"user_recovery_codes": {"user_id", "code_hash", "used_at"},
+	"audit_log": {
+		"id", "actor_id", "actor_role", "impersonator_id", "ip", "method", "endpoint", "action", "entity_type",
+		"entity_id", "before_value", "after_value", "created_at", "prev_hash", "hash",
+	},
+	"api_keys": {
+		"id", "name", "prefix", "key_hash", "scopes", "created_by", "created_at", "expires_at", "last_used_at",
+		"last_used_ip", "revoked_at",
+	},
+	"user_identities":  {"provider", "subject", "user_id", "created_at", "last_login"},
+	"rate_limits":      {"bucket", "full_at"},
+	"idempotency_keys": {"key", "fingerprint", "status", "content_type", "body", "expires_at"},
+}
+
+// liveColumns lists every table and column of the database
+var liveColumns = map[repository.Dialect]string{
+	repository.Postgres: `SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = current_schema()`,
+	repository.SQLite:   `SELECT m.name, p.name FROM sqlite_master m JOIN pragma_table_info(m.name) p WHERE m.type = 'table'`,
+}
+
+// missingColumns compares expectedColumns with the live schema
+func missingColumns(ctx context.Context, db *repository.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, liveColumns[db.Dialect])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	live := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		if live[table] == nil {
+			live[table] = make(map[string]bool)
+		}
+		live[table][column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tables := make([]string, 0, len(expectedColumns))
+	for table := range expectedColumns {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var problems []string
+	for _, table := range tables {
+		columns, ok := live[table]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("table %s is missing", table))
+			continue
+		}
+		for _, column := range expectedColumns[table] {
+			if !columns[column] {
+				problems = append(problems, fmt.Sprintf("column %s.%s is missing", table, column))
+			}
+		}
+	}
+	return problems, nil
+}
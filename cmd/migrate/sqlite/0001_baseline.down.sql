@@ -0,0 +1,8 @@
+-- Drops the core tables and everything in them
+
+DROP TABLE IF EXISTS application_links;
+DROP TABLE IF EXISTS internal_applications;
+DROP TABLE IF EXISTS external_applications;
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS employee;
+DROP TABLE IF EXISTS users;
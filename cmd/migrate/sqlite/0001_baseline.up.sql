@@ -0,0 +1,93 @@
+-- Core tables: employees, job postings, applications and users.
+--
+-- UUID columns are TEXT. Tables whose id the database generates use a
+-- random version 4 UUID as default, like uuid_generate_v4() in PostgreSQL.
+
+CREATE TABLE employee (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    file_number TEXT,
+    full_name TEXT,
+    sex TEXT,
+    employment_date DATE,
+    doe DATE,
+    individual_pms REAL,
+    last_dop DATE,
+    job_grade TEXT,
+    new_salary REAL,
+    job_category TEXT,
+    new_position TEXT,
+    branch TEXT,
+    department TEXT,
+    district TEXT,
+    twin_branch TEXT,
+    region TEXT,
+    field_of_study TEXT,
+    educational_level TEXT,
+    cluster TEXT,
+    indpms25 REAL,
+    totalexp20 REAL,
+    totalexp INTEGER,
+    relatedexp INTEGER,
+    expafterpromo REAL,
+    tmdrec20 REAL,
+    disrec15 REAL,
+    total REAL
+);
+
+CREATE TABLE jobs (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (abs(random()) % 4), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    title TEXT,
+    description TEXT,
+    qualifications TEXT,
+    department TEXT,
+    location TEXT,
+    job_type TEXT,
+    salary TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deadline TIMESTAMP,
+    status TEXT
+);
+
+-- matched_employee_id is set when an application is matched to an existing
+-- employee by name
+CREATE TABLE internal_applications (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (abs(random()) % 4), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    first_name TEXT,
+    last_name TEXT,
+    jobid TEXT REFERENCES jobs(id),
+    other_bank_exp TEXT,
+    matched_employee_id INTEGER REFERENCES employee(id) ON DELETE CASCADE,
+    promotion_status TEXT,
+    resume_path TEXT
+);
+
+CREATE TABLE external_applications (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (abs(random()) % 4), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    first_name TEXT,
+    last_name TEXT,
+    email TEXT,
+    phone TEXT,
+    jobid TEXT REFERENCES jobs(id),
+    other_job_exp TEXT,
+    other_job_exp_year INTEGER,
+    resume_path TEXT
+);
+
+-- Single-use links that let a candidate apply for one job
+CREATE TABLE application_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_id TEXT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    token TEXT NOT NULL UNIQUE,
+    type TEXT NOT NULL, -- 'internal' or 'external'
+    expires_at TIMESTAMP NOT NULL,
+    is_used BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE users (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (abs(random()) % 4), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    name TEXT,
+    password TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
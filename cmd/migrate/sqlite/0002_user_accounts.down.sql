@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS users_email_key;
+
+ALTER TABLE users DROP COLUMN last_login;
+ALTER TABLE users DROP COLUMN status;
+ALTER TABLE users DROP COLUMN branch;
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,8 @@
+-- Account details used by the admin user management screens
+
+ALTER TABLE users ADD COLUMN email TEXT;
+ALTER TABLE users ADD COLUMN branch TEXT;
+ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE users ADD COLUMN last_login TIMESTAMP;
+
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
@@ -0,0 +1,7 @@
+-- The old Admin, Manager and DistrictManager tables are not restored; if
+-- they were never dropped they still hold the roles they had before.
+
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
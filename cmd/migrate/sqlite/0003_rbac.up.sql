@@ -0,0 +1,82 @@
+-- Roles and permissions. See the PostgreSQL migration for the grants; the
+-- two must stay in step.
+
+CREATE TABLE roles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE permissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role_id INTEGER REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INTEGER REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+-- A user can hold several roles; district is only used by district-scoped roles
+CREATE TABLE user_roles (
+    user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER REFERENCES roles(id) ON DELETE CASCADE,
+    district TEXT,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full access to employees, jobs, applications and users'),
+    ('manager', 'Scores individual PMS and manager recommendations'),
+    ('district_manager', 'Gives district recommendations for employees in a district');
+
+INSERT INTO permissions (name, description) VALUES
+    ('employee.read', 'List and view employees'),
+    ('employee.write', 'Create and update employees'),
+    ('employee.pms.write', 'Set an employee''s individual PMS score'),
+    ('employee.manager_rec.write', 'Set an employee''s manager recommendation'),
+    ('employee.district.read', 'View employees of the own district'),
+    ('employee.district_rec.write', 'Set an employee''s district recommendation'),
+    ('employee.evaluation.read', 'View an employee''s promotion evaluation'),
+    ('job.read', 'List and view job postings in the admin area'),
+    ('job.write', 'Create, update and delete job postings'),
+    ('application.read', 'View internal and external applications'),
+    ('application_link.read', 'View generated application links'),
+    ('application_link.write', 'Generate application links'),
+    ('user.read', 'List users and roles'),
+    ('user.write', 'Manage users and their roles'),
+    ('audit.read', 'View the audit log'),
+    ('dashboard.admin', 'Open the admin dashboard'),
+    ('dashboard.manager', 'Open the manager dashboard'),
+    ('dashboard.district', 'Open the district manager dashboard');
+
+WITH grants (role_name, permission_name) AS (VALUES
+    ('admin', 'dashboard.admin'),
+    ('admin', 'employee.read'),
+    ('admin', 'employee.write'),
+    ('admin', 'job.read'),
+    ('admin', 'job.write'),
+    ('admin', 'application.read'),
+    ('admin', 'application_link.read'),
+    ('admin', 'application_link.write'),
+    ('admin', 'user.read'),
+    ('admin', 'user.write'),
+    ('admin', 'audit.read'),
+    ('manager', 'dashboard.manager'),
+    ('manager', 'employee.read'),
+    ('manager', 'employee.pms.write'),
+    ('manager', 'employee.manager_rec.write'),
+    ('manager', 'employee.evaluation.read'),
+    ('district_manager', 'dashboard.district'),
+    ('district_manager', 'employee.read'),
+    ('district_manager', 'employee.district.read'),
+    ('district_manager', 'employee.district_rec.write'),
+    ('district_manager', 'employee.evaluation.read')
+)
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM grants
+JOIN roles r ON r.name = grants.role_name
+JOIN permissions p ON p.name = grants.permission_name;
@@ -0,0 +1,19 @@
+-- TOTP two-factor authentication
+
+-- Enrolment, one row per user
+CREATE TABLE user_mfa (
+    user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    enabled_at TIMESTAMP
+);
+
+-- Single-use recovery codes, stored as SHA-256 hashes
+CREATE TABLE user_recovery_codes (
+    user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,3 @@
+-- Drops the audit log and its whole history
+
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,34 @@
+-- Tamper-evident audit log. See the PostgreSQL migration for how the hash
+-- chain works.
+
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id TEXT,
+    actor_role TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    endpoint TEXT NOT NULL,
+    action TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id TEXT NOT NULL DEFAULT '',
+    before_value TEXT,
+    after_value TEXT,
+    created_at TIMESTAMP NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT UNIQUE NOT NULL
+);
+
+CREATE INDEX audit_log_entity_idx ON audit_log (entity_type, entity_id);
+CREATE INDEX audit_log_actor_idx ON audit_log (actor_id);
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
+
+-- The log is append-only
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
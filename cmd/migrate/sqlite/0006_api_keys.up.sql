@@ -0,0 +1,23 @@
+-- API keys for machine-to-machine access. Only the SHA-256 of a key is
+-- stored.
+
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT UNIQUE NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '',
+    created_by TEXT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    last_used_ip TEXT,
+    revoked_at TIMESTAMP
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('api_key.manage', 'Create, list and revoke API keys');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'api_key.manage'
+WHERE r.name = 'admin';
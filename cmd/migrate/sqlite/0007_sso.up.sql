@@ -0,0 +1,13 @@
+-- Single sign-on. auth_provider says how a user signs in; user_identities
+-- links an external account (issuer + subject) to a user.
+
+ALTER TABLE users ADD COLUMN auth_provider TEXT NOT NULL DEFAULT 'local';
+
+CREATE TABLE user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login TIMESTAMP,
+    PRIMARY KEY (provider, subject)
+);
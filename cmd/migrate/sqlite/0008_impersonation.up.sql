@@ -0,0 +1,12 @@
+-- Admin impersonation ("view as")
+
+ALTER TABLE audit_log ADD COLUMN impersonator_id TEXT;
+
+CREATE INDEX audit_log_impersonator_idx ON audit_log (impersonator_id) WHERE impersonator_id IS NOT NULL;
+
+INSERT INTO permissions (name, description) VALUES
+    ('user.impersonate', 'Sign in as another user to reproduce what they see');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'user.impersonate'
+WHERE r.name = 'admin';
@@ -0,0 +1,9 @@
+-- Token buckets of the rate limiter, shared by every node. A bucket is
+-- stored as the time at which it is full again.
+
+CREATE TABLE rate_limits (
+    bucket TEXT PRIMARY KEY,
+    full_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX rate_limits_full_at_idx ON rate_limits (full_at);
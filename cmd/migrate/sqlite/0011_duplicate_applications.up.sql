@@ -0,0 +1,16 @@
+-- Duplicate application detection
+
+ALTER TABLE jobs ADD COLUMN duplicate_policy TEXT NOT NULL DEFAULT 'reject';
+
+ALTER TABLE internal_applications ADD COLUMN file_number TEXT;
+ALTER TABLE internal_applications ADD COLUMN file_number_key TEXT;
+ALTER TABLE external_applications ADD COLUMN email_key TEXT;
+ALTER TABLE external_applications ADD COLUMN phone_key TEXT;
+
+UPDATE external_applications SET
+    email_key = lower(trim(email)),
+    phone_key = replace(replace(replace(replace(replace(replace(phone, ' ', ''), '-', ''), '(', ''), ')', ''), '+', ''), '.', '');
+
+CREATE INDEX internal_applications_file_number_key_idx ON internal_applications (jobid, file_number_key);
+CREATE INDEX external_applications_email_key_idx ON external_applications (jobid, email_key);
+CREATE INDEX external_applications_phone_key_idx ON external_applications (jobid, phone_key);
@@ -0,0 +1,2 @@
+ALTER TABLE user_mfa DROP COLUMN locked_until;
+ALTER TABLE user_mfa DROP COLUMN failed_attempts;
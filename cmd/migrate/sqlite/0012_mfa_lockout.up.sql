@@ -0,0 +1,4 @@
+-- Lockout after repeated invalid two-factor codes
+
+ALTER TABLE user_mfa ADD COLUMN failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE user_mfa ADD COLUMN locked_until TIMESTAMP;
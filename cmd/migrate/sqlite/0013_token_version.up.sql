@@ -0,0 +1,3 @@
+-- Revocation of session tokens
+
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE internal_applications DROP COLUMN revision_of;
+ALTER TABLE external_applications DROP COLUMN revision_of;
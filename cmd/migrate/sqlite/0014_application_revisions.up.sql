@@ -0,0 +1,19 @@
+-- Revisions of duplicate applications, and digits-only phone keys.
+-- revision_of has no foreign key, which SQLite could not drop again.
+
+ALTER TABLE internal_applications ADD COLUMN revision_of TEXT;
+ALTER TABLE external_applications ADD COLUMN revision_of TEXT;
+
+-- SQLite has no regexp_replace, so the digits are picked out one character
+-- at a time
+UPDATE external_applications SET phone_key = (
+    WITH RECURSIVE digits(i, kept) AS (
+        SELECT 1, ''
+        UNION ALL
+        SELECT i + 1, kept || CASE WHEN substr(external_applications.phone, i, 1) GLOB '[0-9]'
+                                   THEN substr(external_applications.phone, i, 1) ELSE '' END
+        FROM digits WHERE i <= length(external_applications.phone)
+    )
+    SELECT kept FROM digits ORDER BY i DESC LIMIT 1
+)
+WHERE phone IS NOT NULL;
@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"context"
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"github.com/brehan/bank/cmd/data"
 	"time"
@@ -18,7 +20,7 @@ func generateRandomToken(length int) (string, error) {
 }
 
 // CreateApplicationLink creates a new application link for a job
-func (repo *Repository) CreateApplicationLink(jobID, linkType string) (data.ApplicationLink, error) {
+func (repo *Repository) CreateApplicationLink(ctx context.Context, jobID, linkType string) (data.ApplicationLink, error) {
 	// Generate a random token
 	token, err := generateRandomToken(24) // 24 bytes = 32 chars after base64
 	if err != nil {
@@ -43,7 +45,7 @@ func (repo *Repository) CreateApplicationLink(jobID, linkType string) (data.Appl
 			  VALUES ($1, $2, $3, $4, $5, $6)
 			  RETURNING id`
 
-	err = repo.DB.QueryRow(query,
+	err = repo.DB.QueryRowContext(ctx, query,
 		link.JobID,
 		link.Token,
 		link.Type,
@@ -55,13 +57,13 @@ func (repo *Repository) CreateApplicationLink(jobID, linkType string) (data.Appl
 }
 
 // GetApplicationLinkByToken retrieves an application link by its token
-func (repo *Repository) GetApplicationLinkByToken(token string) (data.ApplicationLink, error) {
+func (repo *Repository) GetApplicationLinkByToken(ctx context.Context, token string) (data.ApplicationLink, error) {
 	query := `SELECT id, job_id, token, type, expires_at, is_used, created_at
 			  FROM application_links
 			  WHERE token = $1`
 
 	var link data.ApplicationLink
-	err := repo.DB.QueryRow(query, token).Scan(
+	err := repo.DB.QueryRowContext(ctx, query, token).Scan(
 		&link.ID,
 		&link.JobID,
 		&link.Token,
@@ -74,12 +76,12 @@ func (repo *Repository) GetApplicationLinkByToken(token string) (data.Applicatio
 }
 
 // GetApplicationLinksByJobID retrieves all application links for a job
-func (repo *Repository) GetApplicationLinksByJobID(jobID string) ([]data.ApplicationLink, error) {
+func (repo *Repository) GetApplicationLinksByJobID(ctx context.Context, jobID string) ([]data.ApplicationLink, error) {
 	query := `SELECT id, job_id, token, type, expires_at, is_used, created_at
 			  FROM application_links
 			  WHERE job_id = $1`
 
-	rows, err := repo.DB.Query(query, jobID)
+	rows, err := repo.DB.QueryContext(ctx, query, jobID)
 	if err != nil {
 		return nil, err
 	}
@@ -105,30 +107,23 @@ func (repo *Repository) GetApplicationLinksByJobID(jobID string) ([]data.Applica
 	return links, nil
 }
 
-// MarkApplicationLinkAsUsed marks an application link as used
-func (repo *Repository) MarkApplicationLinkAsUsed(token string) error {
+// MarkApplicationLinkAsUsed marks an application link as used, if it is
+// still unused and unexpired at now
+func (repo *Repository) MarkApplicationLinkAsUsed(ctx context.Context, token string, now time.Time) error {
 	query := `UPDATE application_links
 			  SET is_used = true
-			  WHERE token = $1`
+			  WHERE token = $1 AND is_used = false AND expires_at > $2`
 
-	_, err := repo.DB.Exec(query, token)
-	return err
+	result, err := repo.DB.ExecContext(ctx, query, token, now)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
-
-// CreateApplicationLinksTable creates the application_links table if it doesn't exist
-func (repo *Repository) CreateApplicationLinksTable() error {
-	query := `
-		CREATE TABLE IF NOT EXISTS application_links (
-			id SERIAL PRIMARY KEY,
-			job_id VARCHAR(36) NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
-			token VARCHAR(255) NOT NULL UNIQUE,
-			type VARCHAR(20) NOT NULL, -- 'internal' or 'external'
-			expires_at TIMESTAMP NOT NULL,
-			is_used BOOLEAN NOT NULL DEFAULT false,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-		)
-	`
-
-	_, err := repo.DB.Exec(query)
-	return err
-} 
\ No newline at end of file
@@ -1,16 +1,17 @@
 package repository
 
 import (
+	"context"
 	"github.com/brehan/bank/cmd/data"
 )
 
-// Create a new job
-func (repo *Repository) CreateJob(job data.Job) error {
-	query := `INSERT INTO jobs (title, description, qualifications, department, location, job_type, salary, created_at, deadline, status)
-			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+// Create a new job. The generated ID is set on job.
+func (repo *Repository) CreateJob(ctx context.Context, job *data.Job) error {
+	query := `INSERT INTO jobs (title, description, qualifications, department, location, job_type, salary, created_at, deadline, status, duplicate_policy)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 			  RETURNING id`
 
-	err := repo.DB.QueryRow(query,
+	err := repo.DB.QueryRowContext(ctx, query,
 		job.Title,
 		job.Description,
 		job.Qualifications,
@@ -20,18 +21,19 @@ func (repo *Repository) CreateJob(job data.Job) error {
 		job.Salary,
 		job.CreatedAt,
 		job.Deadline,
-		job.Status).Scan(&job.ID)
+		job.Status,
+		job.DuplicatePolicy).Scan(&job.ID)
 
 	return err
 }
 
 // Get all jobs
-func (repo *Repository) GetAllJobs() ([]data.Job, error) {
-	query := `SELECT id, title, description, qualifications, department, location, job_type, salary, created_at, deadline, status 
+func (repo *Repository) GetAllJobs(ctx context.Context) ([]data.Job, error) {
+	query := `SELECT id, title, description, qualifications, department, location, job_type, salary, created_at, deadline, status, duplicate_policy 
 			  FROM jobs 
 			  ORDER BY created_at DESC`
 
-	rows, err := repo.DB.Query(query)
+	rows, err := repo.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +53,8 @@ func (repo *Repository) GetAllJobs() ([]data.Job, error) {
 			&job.Salary,
 			&job.CreatedAt,
 			&job.Deadline,
-			&job.Status)
+			&job.Status,
+			&job.DuplicatePolicy)
 		if err != nil {
 			return nil, err
 		}
@@ -62,13 +65,13 @@ func (repo *Repository) GetAllJobs() ([]data.Job, error) {
 }
 
 // Get job by ID
-func (repo *Repository) GetJobById(id string) (data.Job, error) {
-	query := `SELECT id, title, description, qualifications, department, location, job_type, salary, created_at, deadline, status 
+func (repo *Repository) GetJobById(ctx context.Context, id string) (data.Job, error) {
+	query := `SELECT id, title, description, qualifications, department, location, job_type, salary, created_at, deadline, status, duplicate_policy 
 			  FROM jobs 
 			  WHERE id = $1`
 
 	var job data.Job
-	err := repo.DB.QueryRow(query, id).Scan(
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(
 		&job.ID,
 		&job.Title,
 		&job.Description,
@@ -79,19 +82,20 @@ func (repo *Repository) GetJobById(id string) (data.Job, error) {
 		&job.Salary,
 		&job.CreatedAt,
 		&job.Deadline,
-		&job.Status)
+		&job.Status,
+		&job.DuplicatePolicy)
 
 	return job, err
 }
 
 // Update job
-func (repo *Repository) UpdateJob(job data.Job) error {
+func (repo *Repository) UpdateJob(ctx context.Context, job data.Job) error {
 	query := `UPDATE jobs 
 			  SET title = $1, description = $2, qualifications = $3, department = $4, location = $5, 
-			      job_type = $6, salary = $7, deadline = $8, status = $9
-			  WHERE id = $10`
+			      job_type = $6, salary = $7, deadline = $8, status = $9, duplicate_policy = $10
+			  WHERE id = $11`
 
-	_, err := repo.DB.Exec(query,
+	_, err := repo.DB.ExecContext(ctx, query,
 		job.Title,
 		job.Description,
 		job.Qualifications,
@@ -101,25 +105,26 @@ func (repo *Repository) UpdateJob(job data.Job) error {
 		job.Salary,
 		job.Deadline,
 		job.Status,
+		job.DuplicatePolicy,
 		job.ID)
 
 	return err
 }
 
 // Delete job
-func (repo *Repository) DeleteJob(id string) error {
+func (repo *Repository) DeleteJob(ctx context.Context, id string) error {
 	query := `DELETE FROM jobs WHERE id = $1`
-	_, err := repo.DB.Exec(query, id)
+	_, err := repo.DB.ExecContext(ctx, query, id)
 	return err
 }
 
 // Get internal applications by job ID
-func (repo *Repository) GetInternalApplicationsByJobID(jobID string) ([]data.InternalEmployee, error) {
-	query := `SELECT first_name, last_name, other_bank_exp, jobid, resume_path 
-			  FROM internalemployee 
+func (repo *Repository) GetInternalApplicationsByJobID(ctx context.Context, jobID string) ([]data.InternalEmployee, error) {
+	query := `SELECT first_name, last_name, COALESCE(file_number, ''), other_bank_exp, jobid, resume_path 
+			  FROM internal_applications 
 			  WHERE jobid = $1`
 
-	rows, err := repo.DB.Query(query, jobID)
+	rows, err := repo.DB.QueryContext(ctx, query, jobID)
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +133,7 @@ func (repo *Repository) GetInternalApplicationsByJobID(jobID string) ([]data.Int
 	var applications []data.InternalEmployee
 	for rows.Next() {
 		var app data.InternalEmployee
-		err := rows.Scan(&app.FirstName, &app.LastName, &app.OtherBankExp, &app.Jobid, &app.Resumepath)
+		err := rows.Scan(&app.FirstName, &app.LastName, &app.FileNumber, &app.OtherBankExp, &app.Jobid, &app.Resumepath)
 		if err != nil {
 			return nil, err
 		}
@@ -139,12 +144,12 @@ func (repo *Repository) GetInternalApplicationsByJobID(jobID string) ([]data.Int
 }
 
 // Get external applications by job ID
-func (repo *Repository) GetExternalApplicationsByJobID(jobID string) ([]data.ExternalEmployee, error) {
+func (repo *Repository) GetExternalApplicationsByJobID(ctx context.Context, jobID string) ([]data.ExternalEmployee, error) {
 	query := `SELECT first_name, last_name, email, phone, jobid, other_job_exp, other_job_exp_year, resume_path 
-			  FROM externalemployee 
+			  FROM external_applications 
 			  WHERE jobid = $1`
 
-	rows, err := repo.DB.Query(query, jobID)
+	rows, err := repo.DB.QueryContext(ctx, query, jobID)
 	if err != nil {
 		return nil, err
 	}
@@ -166,13 +171,13 @@ func (repo *Repository) GetExternalApplicationsByJobID(jobID string) ([]data.Ext
 // Create the jobs table
 
 // Get jobs by type
-func (repo *Repository) GetJobByType(jobType string) ([]data.Job, error) {
-	query := `SELECT id, title, description, qualifications, department, location, job_type, salary, created_at, deadline, status 
+func (repo *Repository) GetJobByType(ctx context.Context, jobType string) ([]data.Job, error) {
+	query := `SELECT id, title, description, qualifications, department, location, job_type, salary, created_at, deadline, status, duplicate_policy 
 			  FROM jobs 
 			  WHERE job_type = $1
 			  ORDER BY created_at DESC`
 
-	rows, err := repo.DB.Query(query, jobType)
+	rows, err := repo.DB.QueryContext(ctx, query, jobType)
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +197,8 @@ func (repo *Repository) GetJobByType(jobType string) ([]data.Job, error) {
 			&job.Salary,
 			&job.CreatedAt,
 			&job.Deadline,
-			&job.Status)
+			&job.Status,
+			&job.DuplicatePolicy)
 		if err != nil {
 			return nil, err
 		}
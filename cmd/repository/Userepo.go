@@ -1,71 +1,81 @@
 package repository
 
 import (
-	"database/sql"
-
+	"context"
 	"github.com/brehan/bank/cmd/data"
+	"github.com/google/uuid"
 )
 
 type Repository struct {
-	DB *sql.DB
+	DB Querier
 }
 
-func NewRepository(db *sql.DB) *Repository {
+func NewRepository(db Querier) *Repository {
 	return &Repository{DB: db}
 }
 
+// inTx runs fn with a copy of the repository bound to a transaction, or to
+// the caller's transaction if there already is one
+func (repo *Repository) inTx(ctx context.Context, fn func(tx *Repository) error) error {
+	return inTx(ctx, repo.DB, func(tx *Tx) error {
+		return fn(NewRepository(tx))
+	})
+}
+
 
-func (repo *Repository) CreateUser(user data.User) error {
+func (repo *Repository) CreateUser(ctx context.Context, user data.User) error {
 	query := `INSERT INTO users (name, password) VALUES ($1, $2)`
-	_, err := repo.DB.Exec(query, user.Name, user.Password)
+	_, err := repo.DB.ExecContext(ctx, query, user.Name, user.Password)
 	return err
 }
 
 
-func (repo *Repository) CreateAdmin(admin data.Admin) error {
+func (repo *Repository) CreateAdmin(ctx context.Context, admin data.Admin) error {
 
-	err := repo.CreateUser(admin.User)
+	err := repo.CreateUser(ctx, admin.User)
 	if err != nil {
 		return err
 	}
 
 
-	query := `INSERT INTO admin (user_id) VALUES ($1)`
-	_, err = repo.DB.Exec(query, admin.User.Id)
-	return err
+	return repo.grantRole(ctx, admin.User.Id, data.RoleAdmin, "")
 }
 
 
-func (repo *Repository) CreateManager(manager data.Manager) error {
+func (repo *Repository) CreateManager(ctx context.Context, manager data.Manager) error {
 
-	err := repo.CreateUser(manager.User)
+	err := repo.CreateUser(ctx, manager.User)
 	if err != nil {
 		return err
 	}
 
 	// Insert manager role by referencing the User ID
-	query := `INSERT INTO manager (user_id) VALUES ($1)`
-	_, err = repo.DB.Exec(query, manager.User.Id)
-	return err
+	return repo.grantRole(ctx, manager.User.Id, data.RoleManager, "")
 }
 
 
-func (repo *Repository) CreateDistrictManager(districtManager data.DistrictManager) error {
+func (repo *Repository) CreateDistrictManager(ctx context.Context, districtManager data.DistrictManager) error {
 	// Ensure the district manager is inserted into the Users table first
-	err := repo.CreateUser(districtManager.User)
+	err := repo.CreateUser(ctx, districtManager.User)
 	if err != nil {
 		return err
 	}
 
 
-	query := `INSERT INTO district_manager (user_id, district) VALUES ($1, $2)`
-	_, err = repo.DB.Exec(query, districtManager.User.Id, districtManager.District)
+	return repo.grantRole(ctx, districtManager.User.Id, data.RoleDistrictManager, districtManager.District)
+}
+
+// grantRole adds a row to user_roles for the named role
+func (repo *Repository) grantRole(ctx context.Context, userID uuid.UUID, role, district string) error {
+	query := `INSERT INTO user_roles (user_id, role_id, district)
+			  SELECT $1, id, NULLIF($2, '') FROM roles WHERE name = $3`
+	_, err := repo.DB.ExecContext(ctx, query, userID, district, role)
 	return err
 }
-func (repo *Repository) GetUserById(id string) (data.User, error) {
+func (repo *Repository) GetUserById(ctx context.Context, id string) (data.User, error) {
 	var user data.User
-	query := `SELECT * FROM users WHERE id = $1`
-	err := repo.DB.QueryRow(query, id).Scan(&user.Id, &user.Name, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	query := `SELECT id, name, password, created_at, updated_at FROM users WHERE id = $1`
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(&user.Id, &user.Name, &user.Password, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return user, err
 	}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/google/uuid"
+)
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, COALESCE(created_by, '00000000-0000-0000-0000-000000000000'), created_at, expires_at, last_used_at, COALESCE(last_used_ip, ''), revoked_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*data.APIKey, error) {
+	var key data.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.CreatedBy, &key.CreatedAt,
+		&expiresAt, &lastUsedAt, &key.LastUsedIP, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+func (repo *AuthRepository) CreateAPIKey(ctx context.Context, key *data.APIKey) error {
+	query := `INSERT INTO api_keys (id, name, prefix, key_hash, scopes, created_by, created_at, expires_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := repo.DB.ExecContext(ctx, query, key.ID, key.Name, key.Prefix, key.Hash, strings.Join(key.Scopes, " "),
+		key.CreatedBy, key.CreatedAt, key.ExpiresAt)
+	return err
+}
+
+// GetAPIKeyByHash returns the key with the given hash, or nil if there is none
+func (repo *AuthRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*data.APIKey, error) {
+	key, err := scanAPIKey(repo.DB.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// GetAPIKey returns the key with the given ID, or nil if there is none
+func (repo *AuthRepository) GetAPIKey(ctx context.Context, id uuid.UUID) (*data.APIKey, error) {
+	key, err := scanAPIKey(repo.DB.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+func (repo *AuthRepository) GetAPIKeys(ctx context.Context) ([]data.APIKey, error) {
+	rows, err := repo.DB.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []data.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// TouchAPIKey records the time and client IP of the key's latest use
+func (repo *AuthRepository) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time, ip string) error {
+	_, err := repo.DB.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $1, last_used_ip = $2 WHERE id = $3`, at, ip, id)
+	return err
+}
+
+// RevokeAPIKey marks a key as revoked. It returns false if the key does not
+// exist or was already revoked.
+func (repo *AuthRepository) RevokeAPIKey(ctx context.Context, id uuid.UUID, at time.Time) (bool, error) {
+	result, err := repo.DB.ExecContext(ctx, `UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`, at, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
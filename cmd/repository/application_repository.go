@@ -1,15 +1,16 @@
 package repository
 
 import (
+	"context"
 	"github.com/brehan/bank/cmd/data"
 )
 
 // Get all internal applications
-func (repo *Repository) GetAllInternalApplications() ([]data.InternalEmployee, error) {
-	query := `SELECT id, first_name, last_name, other_bank_exp, jobid, resume_path 
-			  FROM internalemployee`
+func (repo *Repository) GetAllInternalApplications(ctx context.Context) ([]data.InternalEmployee, error) {
+	query := `SELECT id, first_name, last_name, COALESCE(file_number, ''), other_bank_exp, jobid, resume_path 
+			  FROM internal_applications`
 	
-	rows, err := repo.DB.Query(query)
+	rows, err := repo.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -19,7 +20,7 @@ func (repo *Repository) GetAllInternalApplications() ([]data.InternalEmployee, e
 	for rows.Next() {
 		var app data.InternalEmployee
 		var id string
-		err := rows.Scan(&id, &app.FirstName, &app.LastName, &app.OtherBankExp, &app.Jobid, &app.Resumepath)
+		err := rows.Scan(&id, &app.FirstName, &app.LastName, &app.FileNumber, &app.OtherBankExp, &app.Jobid, &app.Resumepath)
 		if err != nil {
 			return nil, err
 		}
@@ -30,11 +31,11 @@ func (repo *Repository) GetAllInternalApplications() ([]data.InternalEmployee, e
 }
 
 // Get all external applications
-func (repo *Repository) GetAllExternalApplications() ([]data.ExternalEmployee, error) {
+func (repo *Repository) GetAllExternalApplications(ctx context.Context) ([]data.ExternalEmployee, error) {
 	query := `SELECT id, first_name, last_name, email, phone, jobid, other_job_exp, other_job_exp_year, resume_path 
-			  FROM externalemployee`
+			  FROM external_applications`
 	
-	rows, err := repo.DB.Query(query)
+	rows, err := repo.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -55,27 +56,33 @@ func (repo *Repository) GetAllExternalApplications() ([]data.ExternalEmployee, e
 	return applications, nil
 }
 
-// Apply for a job (internal employee)
-func (repo *Repository) ApplyInternal(internalApp data.InternalEmployee) error {
-	query := `INSERT INTO internalemployee (first_name, last_name, jobid, other_bank_exp, resume_path)
-			  VALUES ($1, $2, $3, $4, $5)`
+// Apply for a job (internal employee), returning the application's ID
+func (repo *Repository) ApplyInternal(ctx context.Context, internalApp data.InternalEmployee) (string, error) {
+	query := `INSERT INTO internal_applications (first_name, last_name, jobid, other_bank_exp, resume_path, file_number, file_number_key)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7)
+			  RETURNING id`
 	
-	_, err := repo.DB.Exec(query, 
+	var id string
+	err := repo.DB.QueryRowContext(ctx, query, 
 		internalApp.FirstName, 
 		internalApp.LastName,
 		internalApp.Jobid,
 		internalApp.OtherBankExp,
-		internalApp.Resumepath)
+		internalApp.Resumepath,
+		internalApp.FileNumber,
+		data.NormalizeFileNumber(internalApp.FileNumber)).Scan(&id)
 	
-	return err
+	return id, err
 }
 
-// Apply for a job (external applicant)
-func (repo *Repository) ApplyExternal(externalApp data.ExternalEmployee) error {
-	query := `INSERT INTO externalemployee (first_name, last_name, email, phone, jobid, other_job_exp, other_job_exp_year, resume_path)
-			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+// Apply for a job (external applicant), returning the application's ID
+func (repo *Repository) ApplyExternal(ctx context.Context, externalApp data.ExternalEmployee) (string, error) {
+	query := `INSERT INTO external_applications (first_name, last_name, email, phone, jobid, other_job_exp, other_job_exp_year, resume_path, email_key, phone_key)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			  RETURNING id`
 	
-	_, err := repo.DB.Exec(query, 
+	var id string
+	err := repo.DB.QueryRowContext(ctx, query, 
 		externalApp.FirstName, 
 		externalApp.LastName,
 		externalApp.Email,
@@ -83,7 +90,9 @@ func (repo *Repository) ApplyExternal(externalApp data.ExternalEmployee) error {
 		externalApp.Jobid,
 		externalApp.OtherJobExp,
 		externalApp.OtherJobYear,
-		externalApp.Resumepath)
+		externalApp.Resumepath,
+		data.NormalizeEmail(externalApp.Email),
+		data.NormalizePhone(externalApp.Phone)).Scan(&id)
 	
-	return err
+	return id, err
 } 
\ No newline at end of file
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/google/uuid"
+)
+
+type AuditRepository struct {
+	DB Querier
+}
+
+func NewAuditRepository(db Querier) *AuditRepository {
+	return &AuditRepository{DB: db}
+}
+
+const auditColumns = `id, actor_id, impersonator_id, actor_role, ip, method, endpoint, action, entity_type, entity_id, before_value, after_value, created_at, prev_hash, hash`
+
+// AppendAuditEntry links the entry to the current end of the chain, hashes it
+// and inserts it. Writers are serialised with a table lock so two entries can
+// never claim the same predecessor; SQLite has a single writer anyway.
+func (repo *AuditRepository) AppendAuditEntry(ctx context.Context, entry *data.AuditEntry) error {
+	return inTx(ctx, repo.DB, func(tx *Tx) error {
+		if tx.Dialect == Postgres {
+			if _, err := tx.ExecContext(ctx, `LOCK TABLE audit_log IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+				return err
+			}
+		}
+
+		var prevHash string
+		err := tx.QueryRowContext(ctx, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		entry.PrevHash = prevHash
+		entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+		entry.Hash = entry.ComputeHash()
+
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO audit_log (actor_id, impersonator_id, actor_role, ip, method, endpoint, action, entity_type, entity_id, before_value, after_value, created_at, prev_hash, hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			RETURNING id`,
+			entry.ActorID, entry.ImpersonatorID, entry.ActorRole, entry.IP, entry.Method, entry.Endpoint, entry.Action,
+			entry.EntityType, entry.EntityID, nullableJSON(entry.Before), nullableJSON(entry.After),
+			entry.CreatedAt, entry.PrevHash, entry.Hash,
+		).Scan(&entry.ID)
+	})
+}
+
+// GetAuditEntries returns entries matching the filter, newest first
+func (repo *AuditRepository) GetAuditEntries(ctx context.Context, filter data.AuditFilter) ([]data.AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ActorID != nil {
+		where("actor_id = $%d", *filter.ActorID)
+	}
+	if filter.ImpersonatorID != nil {
+		where("impersonator_id = $%d", *filter.ImpersonatorID)
+	}
+	if filter.EntityType != "" {
+		where("entity_type = $%d", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		where("entity_id = $%d", filter.EntityID)
+	}
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if filter.From != nil {
+		where("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		where("created_at < $%d", *filter.To)
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM audit_log`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	rows, err := repo.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []data.AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, rows.Err()
+}
+
+// WalkAuditLog calls fn for every entry in chain order. It stops at the first
+// error fn returns.
+func (repo *AuditRepository) WalkAuditLog(ctx context.Context, fn func(entry *data.AuditEntry) error) error {
+	rows, err := repo.DB.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_log ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func scanAuditEntry(rows *sql.Rows) (*data.AuditEntry, error) {
+	var entry data.AuditEntry
+	var actorID, impersonatorID uuid.NullUUID
+	var before, after sql.NullString
+	err := rows.Scan(&entry.ID, &actorID, &impersonatorID, &entry.ActorRole, &entry.IP, &entry.Method, &entry.Endpoint,
+		&entry.Action, &entry.EntityType, &entry.EntityID, &before, &after, &entry.CreatedAt,
+		&entry.PrevHash, &entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if actorID.Valid {
+		entry.ActorID = &actorID.UUID
+	}
+	if impersonatorID.Valid {
+		entry.ImpersonatorID = &impersonatorID.UUID
+	}
+	if before.Valid {
+		entry.Before = json.RawMessage(before.String)
+	}
+	if after.Valid {
+		entry.After = json.RawMessage(after.String)
+	}
+	entry.CreatedAt = entry.CreatedAt.UTC()
+	return &entry, nil
+}
+
+func nullableJSON(value json.RawMessage) interface{} {
+	if value == nil {
+		return nil
+	}
+	return string(value)
+}
@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/brehan/bank/cmd/data"
@@ -9,222 +11,402 @@ import (
 )
 
 type AuthRepository struct {
-	DB *sql.DB
+	DB Querier
 }
 
-func NewAuthRepository(db *sql.DB) *AuthRepository {
+func NewAuthRepository(db Querier) *AuthRepository {
 	return &AuthRepository{DB: db}
 }
 
-func (repo *AuthRepository) CreateUser(user *data.User, role, district string) error {
-	// Insert into users table
-	query := `INSERT INTO users (id, name, password, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)`
-	_, err := repo.DB.Exec(query, user.Id, user.Name, user.Password, user.CreatedAt, user.UpdatedAt)
-	if err != nil {
-		return err
-	}
+// inTx runs fn with a copy of the repository bound to a transaction, or to
+// the caller's transaction if there already is one
+func (repo *AuthRepository) inTx(ctx context.Context, fn func(tx *AuthRepository) error) error {
+	return inTx(ctx, repo.DB, func(tx *Tx) error {
+		return fn(NewAuthRepository(tx))
+	})
+}
+
+// ErrUnknownRole is returned when a role name is not in the roles table
+var ErrUnknownRole = errors.New("unknown role")
+
+// CreateUser inserts a user and grants them a role in one transaction, so an
+// unknown role does not leave behind a user without one
+func (repo *AuthRepository) CreateUser(ctx context.Context, user *data.User, role, district string) error {
+	return repo.inTx(ctx, func(tx *AuthRepository) error {
+		// Insert into users table
+		if err := insertUser(ctx, tx.DB, user); err != nil {
+			return err
+		}
 
-	// Insert into role-specific table
-	switch role {
-	case "admin":
-		query = `INSERT INTO admin (user_id) VALUES ($1)`
-		_, err = repo.DB.Exec(query, user.Id)
-	case "manager":
-		query = `INSERT INTO manager (user_id) VALUES ($1)`
-		_, err = repo.DB.Exec(query, user.Id)
-	case "district_manager":
-		query = `INSERT INTO district_manager (user_id, district) VALUES ($1, $2)`
-		_, err = repo.DB.Exec(query, user.Id, district)
-	default:
-		return sql.ErrNoRows // Invalid role
+		return tx.AddUserRole(ctx, user.Id, role, district)
+	})
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertUser(ctx context.Context, db execer, user *data.User) error {
+	if user.Status == "" {
+		user.Status = data.UserStatusActive
 	}
+	if user.AuthProvider == "" {
+		user.AuthProvider = data.AuthProviderLocal
+	}
+	query := `INSERT INTO users (id, name, password, email, branch, status, auth_provider, created_at, updated_at)
+			  VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9)`
+	_, err := db.ExecContext(ctx, query, user.Id, user.Name, user.Password, user.Email, user.Branch, user.Status, user.AuthProvider, user.CreatedAt, user.UpdatedAt)
 	return err
 }
 
-func (repo *AuthRepository) GetUserByName(name string) (*data.User, string, string, error) {
-	// Get user
+// userColumns is the column list scanned by scanUser
+const userColumns = `id, name, COALESCE(password, ''), COALESCE(email, ''), COALESCE(branch, ''), status, auth_provider, last_login, created_at, updated_at, token_version`
+
+func scanUser(row *sql.Row) (*data.User, error) {
 	var user data.User
-	query := `SELECT id, name, password, created_at, updated_at FROM users WHERE name = $1`
-	err := repo.DB.QueryRow(query, name).Scan(&user.Id, &user.Name, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	var lastLogin sql.NullTime
+	err := row.Scan(&user.Id, &user.Name, &user.Password, &user.Email, &user.Branch, &user.Status, &user.AuthProvider, &lastLogin, &user.CreatedAt, &user.UpdatedAt, &user.TokenVersion)
 	if err == sql.ErrNoRows {
-		return nil, "", "", nil // User not found
+		return nil, nil // User not found
 	}
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
 	}
-
-	// Determine role and district
-	role, district, err := repo.getRoleAndDistrict(user.Id)
-	if err != nil {
-		return nil, "", "", err
+	if lastLogin.Valid {
+		user.LastLogin = &lastLogin.Time
 	}
+	return &user, nil
+}
 
-	return &user, role, district, nil
+func (repo *AuthRepository) GetUserByName(ctx context.Context, name string) (*data.User, error) {
+	return scanUser(repo.DB.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE name = $1`, name))
 }
 
-func (repo *AuthRepository) GetUserByID(id uuid.UUID) (*data.User, string, string, error) {
-	// Get user
-	var user data.User
-	query := `SELECT id, name, password, created_at, updated_at FROM users WHERE id = $1`
-	err := repo.DB.QueryRow(query, id).Scan(&user.Id, &user.Name, &user.Password, &user.CreatedAt, &user.UpdatedAt)
-	if err == sql.ErrNoRows {
-		return nil, "", "", nil // User not found
-	}
+func (repo *AuthRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*data.User, error) {
+	return scanUser(repo.DB.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
+
+// GetUserByEmail looks a user up by email, ignoring case
+func (repo *AuthRepository) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	return scanUser(repo.DB.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE lower(email) = lower($1)`, email))
+}
+
+// UpdateUser saves a user's name, email, branch and auth provider
+func (repo *AuthRepository) UpdateUser(ctx context.Context, user *data.User) error {
+	query := `UPDATE users SET name = $2, email = NULLIF($3, ''), branch = NULLIF($4, ''), auth_provider = $5, updated_at = $6 WHERE id = $1`
+	_, err := repo.DB.ExecContext(ctx, query, user.Id, user.Name, user.Email, user.Branch, user.AuthProvider, user.UpdatedAt)
+	return err
+}
+
+// SetUserStatus enables or disables an account
+func (repo *AuthRepository) SetUserStatus(ctx context.Context, userID uuid.UUID, status string) error {
+	query := `UPDATE users SET status = $2, updated_at = $3 WHERE id = $1`
+	_, err := repo.DB.ExecContext(ctx, query, userID, status, time.Now())
+	return err
+}
+
+// BumpTokenVersion increments a user's token version, which ends every
+// session the user has open
+func (repo *AuthRepository) BumpTokenVersion(ctx context.Context, userID uuid.UUID) error {
+	_, err := repo.DB.ExecContext(ctx, `UPDATE users SET token_version = token_version + 1 WHERE id = $1`, userID)
+	return err
+}
+
+// LockUser locks the user's row with an update that changes nothing, like
+// LockJob
+func (repo *AuthRepository) LockUser(ctx context.Context, userID uuid.UUID) error {
+	result, err := repo.DB.ExecContext(ctx, `UPDATE users SET token_version = token_version WHERE id = $1`, userID)
 	if err != nil {
-		return nil, "", "", err
+		return err
 	}
-
-	// Determine role and district
-	role, district, err := repo.getRoleAndDistrict(user.Id)
+	rows, err := result.RowsAffected()
 	if err != nil {
-		return nil, "", "", err
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
 	}
+	return nil
+}
 
-	return &user, role, district, nil
+// RecordLogin stores the time of a user's last successful login
+func (repo *AuthRepository) RecordLogin(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	_, err := repo.DB.ExecContext(ctx, `UPDATE users SET last_login = $2 WHERE id = $1`, userID, at)
+	return err
 }
 
-// Helper to determine role and district
-func (repo *AuthRepository) getRoleAndDistrict(userID uuid.UUID) (string, string, error) {
-	// Check admin
-	var id uuid.UUID
-	query := `SELECT user_id FROM admin WHERE user_id = $1`
-	err := repo.DB.QueryRow(query, userID).Scan(&id)
-	if err == nil {
-		return "admin", "", nil
-	}
-	if err != sql.ErrNoRows {
-		return "", "", err
+// SetUserRole replaces all of a user's roles with a single role
+func (repo *AuthRepository) SetUserRole(ctx context.Context, userID uuid.UUID, role, district string) error {
+	return repo.SetUserRoles(ctx, userID, []data.UserRole{{Role: role, District: district}})
+}
+
+// SetUserRoles replaces all of a user's roles in one transaction, so the
+// user never ends up with no role or a mix of old and new roles
+func (repo *AuthRepository) SetUserRoles(ctx context.Context, userID uuid.UUID, roles []data.UserRole) error {
+	return inTx(ctx, repo.DB, func(tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		return insertUserRoles(ctx, tx, userID, roles)
+	})
+}
+
+// insertUserRoles grants each role, returning ErrUnknownRole if one of them
+// is not in the roles table
+func insertUserRoles(ctx context.Context, db execer, userID uuid.UUID, roles []data.UserRole) error {
+	query := `INSERT INTO user_roles (user_id, role_id, district)
+			  SELECT $1, id, NULLIF($2, '') FROM roles WHERE name = $3`
+	for _, role := range roles {
+		result, err := db.ExecContext(ctx, query, userID, role.District, role.Role)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != 1 {
+			return ErrUnknownRole
+		}
 	}
+	return nil
+}
+
+// GetUserAccess resolves the roles a user holds and the union of their
+// permissions
+func (repo *AuthRepository) GetUserAccess(ctx context.Context, userID uuid.UUID) (data.UserAccess, error) {
+	var access data.UserAccess
 
-	// Check manager
-	query = `SELECT user_id FROM manager WHERE user_id = $1`
-	err = repo.DB.QueryRow(query, userID).Scan(&id)
-	if err == nil {
-		return "manager", "", nil
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT r.name, COALESCE(ur.district, '')
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = $1
+		ORDER BY r.name`, userID)
+	if err != nil {
+		return access, err
 	}
-	if err != sql.ErrNoRows {
-		return "", "", err
+	defer rows.Close()
+	for rows.Next() {
+		var role data.UserRole
+		if err := rows.Scan(&role.Role, &role.District); err != nil {
+			return access, err
+		}
+		access.Roles = append(access.Roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return access, err
 	}
 
-	// Check district_manager
-	var district string
-	query = `SELECT district FROM district_manager WHERE user_id = $1`
-	err = repo.DB.QueryRow(query, userID).Scan(&district)
-	if err == nil {
-		return "district_manager", district, nil
+	permRows, err := repo.DB.QueryContext(ctx, `
+		SELECT DISTINCT p.name
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = $1
+		ORDER BY p.name`, userID)
+	if err != nil {
+		return access, err
 	}
-	if err != sql.ErrNoRows {
-		return "", "", err
+	defer permRows.Close()
+	for permRows.Next() {
+		var permission string
+		if err := permRows.Scan(&permission); err != nil {
+			return access, err
+		}
+		access.Permissions = append(access.Permissions, permission)
 	}
+	return access, permRows.Err()
+}
 
-	return "", "", sql.ErrNoRows // No role found
+// GetRoleByName returns a role and its permissions, or nil if it does not exist
+func (repo *AuthRepository) GetRoleByName(ctx context.Context, name string) (*data.Role, error) {
+	roles, err := repo.GetRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if roles[i].Name == name {
+			return &roles[i], nil
+		}
+	}
+	return nil, nil
 }
 
-// DeleteUser removes a user and their role information
-func (repo *AuthRepository) DeleteUser(userID uuid.UUID) error {
-	// Start a transaction
-	tx, err := repo.DB.Begin()
+// GetRoles lists all roles with their permissions
+func (repo *AuthRepository) GetRoles(ctx context.Context) ([]data.Role, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT r.id, r.name, r.description, COALESCE(p.name, '')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		ORDER BY r.name, p.name`)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-			return
+	defer rows.Close()
+
+	roles := []data.Role{}
+	for rows.Next() {
+		var role data.Role
+		var permission string
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &permission); err != nil {
+			return nil, err
 		}
-		err = tx.Commit()
-	}()
+		if n := len(roles); n > 0 && roles[n-1].ID == role.ID {
+			roles[n-1].Permissions = append(roles[n-1].Permissions, permission)
+			continue
+		}
+		role.Permissions = []string{}
+		if permission != "" {
+			role.Permissions = append(role.Permissions, permission)
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// AddUserRole grants a role to a user. Granting a role the user already holds
+// updates its district.
+func (repo *AuthRepository) AddUserRole(ctx context.Context, userID uuid.UUID, role, district string) error {
+	var districtValue sql.NullString
+	if district != "" {
+		districtValue = sql.NullString{String: district, Valid: true}
+	}
 
-	// Determine the role first
-	role, _, err := repo.getRoleAndDistrict(userID)
+	query := `INSERT INTO user_roles (user_id, role_id, district)
+			  SELECT $1, id, $2 FROM roles WHERE name = $3
+			  ON CONFLICT (user_id, role_id) DO UPDATE SET district = EXCLUDED.district`
+	result, err := repo.DB.ExecContext(ctx, query, userID, districtValue, role)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if rows == 0 {
+		return ErrUnknownRole
+	}
+	return nil
+}
 
-	// Delete from role-specific table first to maintain foreign key constraints
-	switch role {
-	case "admin":
-		_, err = tx.Exec("DELETE FROM admin WHERE user_id = $1", userID)
-	case "manager":
-		_, err = tx.Exec("DELETE FROM manager WHERE user_id = $1", userID)
-	case "district_manager":
-		_, err = tx.Exec("DELETE FROM district_manager WHERE user_id = $1", userID)
-	default:
-		return sql.ErrNoRows // No role found
+// RemoveUserRole revokes a role from a user
+func (repo *AuthRepository) RemoveUserRole(ctx context.Context, userID uuid.UUID, role string) error {
+	query := `DELETE FROM user_roles WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)`
+	result, err := repo.DB.ExecContext(ctx, query, userID, role)
+	if err != nil {
+		return err
 	}
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
 
-	// Finally, delete from users table
-	_, err = tx.Exec("DELETE FROM users WHERE id = $1", userID)
-	return err
+// DeleteUser removes a user and their roles
+func (repo *AuthRepository) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	return inTx(ctx, repo.DB, func(tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+
+		// Finally, delete from users table
+		_, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+		return err
+	})
 }
 
 // GetAllUsers retrieves all users with their roles and districts
-func (repo *AuthRepository) GetAllUsers() ([]map[string]interface{}, error) {
-	// Use the correct table name "districtmanager" (all lowercase)
-	// and ensure the alias 'dm' is consistently used for it.
-	query := `
-        SELECT u.id, u.name, u.created_at, u.updated_at,
-               CASE
-                   WHEN a.user_id IS NOT NULL THEN 'admin'
-                   WHEN m.user_id IS NOT NULL THEN 'manager'
-                   WHEN dm.user_id IS NOT NULL THEN 'DistrictManager' -- This is the role name, can be different from table name
-                   ELSE 'unknown'
-               END as role,
-               dm.district
-        FROM users u
-        LEFT JOIN admin a ON u.id = a.user_id
-        LEFT JOIN manager m ON u.id = m.user_id
-        LEFT JOIN districtmanager dm ON u.id = dm.user_id -- <<<< CORRECTED TABLE NAME HERE
-        ORDER BY u.created_at DESC
-    `
-
-	rows, err := repo.DB.Query(query)
+func (repo *AuthRepository) GetAllUsers(ctx context.Context) ([]map[string]interface{}, error) {
+	rows, err := repo.DB.QueryContext(ctx, `SELECT id, name, COALESCE(email, ''), COALESCE(branch, ''), status, auth_provider, last_login, created_at, updated_at FROM users ORDER BY created_at DESC`)
 	if err != nil {
-		// It's good practice to log the error for more details during debugging
-		// log.Printf("Error executing GetAllUsers query: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	users := []map[string]interface{}{} // Initialize with empty slice
+	byID := make(map[uuid.UUID]*data.UserAccess)
+	var order []uuid.UUID
 
 	for rows.Next() {
 		var (
 			id        uuid.UUID
 			name      string
+			email     string
+			branch    string
+			status    string
+			provider  string
+			lastLogin sql.NullTime
 			createdAt time.Time
 			updatedAt time.Time
-			role      string
-			district  sql.NullString // For dm.district which can be NULL
 		)
-
-		if err := rows.Scan(&id, &name, &createdAt, &updatedAt, &role, &district); err != nil {
-			// log.Printf("Error scanning user row: %v", err)
+		if err := rows.Scan(&id, &name, &email, &branch, &status, &provider, &lastLogin, &createdAt, &updatedAt); err != nil {
 			return nil, err
 		}
 
 		user := map[string]interface{}{
-			"id":         id.String(),
-			"name":       name,
-			"created_at": createdAt,
-			"updated_at": updatedAt,
-			"role":       role,
-			"district":   nil, // Default to nil
+			"id":            id.String(),
+			"name":          name,
+			"email":         email,
+			"branch":        branch,
+			"status":        status,
+			"auth_provider": provider,
+			"last_login":    nil,
+			"created_at":    createdAt,
+			"updated_at":    updatedAt,
 		}
-		if district.Valid {
-			user["district"] = district.String // Set district if it's not NULL
+		if lastLogin.Valid {
+			user["last_login"] = lastLogin.Time
 		}
-
 		users = append(users, user)
+		byID[id] = &data.UserAccess{}
+		order = append(order, id)
 	}
-
 	if err := rows.Err(); err != nil {
-		// log.Printf("Error after iterating user rows: %v", err)
 		return nil, err
 	}
 
+	roleRows, err := repo.DB.QueryContext(ctx, `
+		SELECT ur.user_id, r.name, COALESCE(ur.district, '')
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		ORDER BY r.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer roleRows.Close()
+	for roleRows.Next() {
+		var userID uuid.UUID
+		var role data.UserRole
+		if err := roleRows.Scan(&userID, &role.Role, &role.District); err != nil {
+			return nil, err
+		}
+		if access, ok := byID[userID]; ok {
+			access.Roles = append(access.Roles, role)
+		}
+	}
+	if err := roleRows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, id := range order {
+		access := byID[id]
+		primary := access.PrimaryRole()
+		users[i]["role"] = primary.Role
+		if primary.Role == "" {
+			users[i]["role"] = "unknown"
+		}
+		users[i]["roles"] = access.RoleNames()
+		users[i]["district"] = nil // Default to nil
+		if district := access.District(); district != "" {
+			users[i]["district"] = district
+		}
+	}
+
 	return users, nil
 }
@@ -1,41 +1,218 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
-func InitDB(datasource string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", datasource)
-	if err != nil {
-		return nil, err
+// Dialect is the SQL flavour of a database
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// DB is a database handle that accepts queries written for PostgreSQL and
+// rewrites them for its dialect. Repositories are written against it so
+// that the same queries run on both engines.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// Open connects to a PostgreSQL database, or opens (creating if needed) a
+// SQLite database file
+func Open(dialect Dialect, datasource string) (*DB, error) {
+	switch dialect {
+	case Postgres:
+		db, err := sql.Open("postgres", datasource)
+		if err != nil {
+			return nil, err
+		}
+		return &DB{DB: db, Dialect: Postgres}, nil
+	case SQLite:
+		db, err := sql.Open("sqlite3", sqliteDSN(datasource))
+		if err != nil {
+			return nil, err
+		}
+		// SQLite allows one writer at a time, and every connection to
+		// :memory: would otherwise be a separate empty database
+		db.SetMaxOpenConns(1)
+		return &DB{DB: db, Dialect: SQLite}, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", dialect)
 	}
+}
 
-	// Test the connection
-	if err = db.Ping(); err != nil {
-		return nil, err
+// sqliteDSN turns on foreign keys, which SQLite leaves off by default
+func sqliteDSN(datasource string) string {
+	if strings.Contains(datasource, "_foreign_keys=") || strings.Contains(datasource, "_fk=") {
+		return datasource
+	}
+	if strings.Contains(datasource, "?") {
+		return datasource + "&_foreign_keys=on"
 	}
+	return datasource + "?_foreign_keys=on"
+}
+
+var (
+	placeholder = regexp.MustCompile(`\$(\d+)`)
+	ilike       = regexp.MustCompile(`(?i)\bILIKE\b`)
+)
+
+// Rebind rewrites a PostgreSQL query for the dialect: $1 placeholders
+// become ?1 and ILIKE becomes LIKE, which SQLite already matches without
+// regard to case. String literals are left alone.
+func (db *DB) Rebind(query string) string {
+	return rebind(db.Dialect, query)
+}
+
+func rebind(dialect Dialect, query string) string {
+	if dialect != SQLite {
+		return query
+	}
+
+	// Split on single quotes: even parts are SQL, odd parts are literals
+	parts := strings.Split(query, "'")
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = placeholder.ReplaceAllString(parts[i], "?$1")
+		parts[i] = ilike.ReplaceAllString(parts[i], "LIKE")
+	}
+	return strings.Join(parts, "'")
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.Rebind(query), args...)
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuery(ctx, db.Dialect, query)
+	result, err := db.DB.ExecContext(ctx, db.Rebind(query), args...)
+	endQuery(span, err)
+	return result, err
+}
 
-	// Create auth tables if they don't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS Users (
-			id UUID PRIMARY KEY,
-			name TEXT UNIQUE NOT NULL,
-			password TEXT NOT NULL,
-			created_at TIMESTAMP NOT NULL,
-			updated_at TIMESTAMP NOT NULL
-		);
-
-		CREATE TABLE IF NOT EXISTS Admin (
-			user_id UUID PRIMARY KEY REFERENCES Users(id) ON DELETE CASCADE
-		);
-
-		CREATE TABLE IF NOT EXISTS Manager (
-			user_id UUID PRIMARY KEY REFERENCES Users(id) ON DELETE CASCADE
-		);
-	`)
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.Rebind(query), args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startQuery(ctx, db.Dialect, query)
+	rows, err := db.DB.QueryContext(ctx, db.Rebind(query), args...)
+	endQuery(span, err)
+	return rows, err
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.Rebind(query), args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := startQuery(ctx, db.Dialect, query)
+	row := db.DB.QueryRowContext(ctx, db.Rebind(query), args...)
+	endQuery(span, row.Err())
+	return row
+}
+
+// Begin starts a transaction whose queries are rewritten like the DB's
+func (db *DB) Begin() (*Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
+	return &Tx{Tx: tx, Dialect: db.Dialect}, nil
+}
+
+// Tx is a transaction started by DB.Begin
+type Tx struct {
+	*sql.Tx
+	Dialect Dialect
+}
+
+// Querier is what the repositories run their queries on: a *DB, or a *Tx
+// when they are part of a unit of work
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	dialect() Dialect
+}
+
+func (db *DB) dialect() Dialect { return db.Dialect }
+func (tx *Tx) dialect() Dialect { return tx.Dialect }
+
+// inTx runs fn in a transaction that commits if fn returns nil and rolls
+// back otherwise. If q already is a transaction fn joins it, so repository
+// methods keep working inside WithTx.
+func inTx(ctx context.Context, q Querier, fn func(tx *Tx) error) (err error) {
+	if tx, ok := q.(*Tx); ok {
+		return fn(tx)
+	}
+	tx, err := q.(*DB).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+	return fn(tx)
+}
+
+// WithTx runs fn with stores bound to a new transaction
+func (db *DB) WithTx(ctx context.Context, fn func(tx Stores) error) error {
+	return inTx(ctx, db, func(tx *Tx) error {
+		return fn(NewStores(tx))
+	})
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(rebind(tx.Dialect, query), args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuery(ctx, tx.Dialect, query)
+	result, err := tx.Tx.ExecContext(ctx, rebind(tx.Dialect, query), args...)
+	endQuery(span, err)
+	return result, err
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(rebind(tx.Dialect, query), args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startQuery(ctx, tx.Dialect, query)
+	rows, err := tx.Tx.QueryContext(ctx, rebind(tx.Dialect, query), args...)
+	endQuery(span, err)
+	return rows, err
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(rebind(tx.Dialect, query), args...)
+}
 
-	return db, nil
-} 
\ No newline at end of file
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := startQuery(ctx, tx.Dialect, query)
+	row := tx.Tx.QueryRowContext(ctx, rebind(tx.Dialect, query), args...)
+	endQuery(span, row.Err())
+	return row
+}
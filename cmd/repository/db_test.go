@@ -0,0 +1,23 @@
+package repository
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{`SELECT * FROM users WHERE id = $1`, `SELECT * FROM users WHERE id = ?1`},
+		{`UPDATE t SET a = $2 WHERE b = $1 AND c = $2`, `UPDATE t SET a = ?2 WHERE b = ?1 AND c = ?2`},
+		{`SELECT 1 FROM employee WHERE full_name ilike $1`, `SELECT 1 FROM employee WHERE full_name LIKE ?1`},
+		{`SELECT '$1 costs', name FROM t WHERE x = $1`, `SELECT '$1 costs', name FROM t WHERE x = ?1`},
+		{`SELECT 'it''s $2' WHERE y = $3`, `SELECT 'it''s $2' WHERE y = ?3`},
+	}
+	for _, test := range tests {
+		if got := rebind(SQLite, test.query); got != test.want {
+			t.Errorf("rebind(%q) = %q, want %q", test.query, got, test.want)
+		}
+		if got := rebind(Postgres, test.query); got != test.query {
+			t.Errorf("postgres queries should be left alone, got %q", got)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/brehan/bank/cmd/data"
+)
+
+// LockJob locks the job's row with an update that changes nothing. On
+// PostgreSQL that holds the row until the transaction ends; SQLite locks
+// the whole database for writing.
+func (repo *Repository) LockJob(ctx context.Context, id string) error {
+	result, err := repo.DB.ExecContext(ctx, `UPDATE jobs SET duplicate_policy = duplicate_policy WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FindInternalDuplicate compares the file_number_key written by
+// ApplyInternal. An application without a file number has no duplicates.
+func (repo *Repository) FindInternalDuplicate(ctx context.Context, app data.InternalEmployee) (string, error) {
+	fileNumber := data.NormalizeFileNumber(app.FileNumber)
+	if fileNumber == "" {
+		return "", sql.ErrNoRows
+	}
+	var id string
+	err := repo.DB.QueryRowContext(ctx, `SELECT COALESCE(revision_of, id) FROM internal_applications
+			  WHERE jobid = $1 AND file_number_key = $2 LIMIT 1`, app.Jobid, fileNumber).Scan(&id)
+	return id, err
+}
+
+// FindExternalDuplicate compares the email_key and phone_key written by
+// ApplyExternal. Empty keys match nothing.
+func (repo *Repository) FindExternalDuplicate(ctx context.Context, app data.ExternalEmployee) (string, error) {
+	email, phone := data.NormalizeEmail(app.Email), data.NormalizePhone(app.Phone)
+	if email == "" && phone == "" {
+		return "", sql.ErrNoRows
+	}
+	var id string
+	err := repo.DB.QueryRowContext(ctx, `SELECT COALESCE(revision_of, id) FROM external_applications
+			  WHERE jobid = $1 AND (($2 <> '' AND email_key = $2) OR ($3 <> '' AND phone_key = $3)) LIMIT 1`,
+		app.Jobid, email, phone).Scan(&id)
+	return id, err
+}
+
+func (repo *Repository) ReviseInternalApplication(ctx context.Context, id string, app data.InternalEmployee) (string, error) {
+	var revision string
+	err := repo.DB.QueryRowContext(ctx, `INSERT INTO internal_applications
+			      (first_name, last_name, jobid, other_bank_exp, resume_path, file_number, file_number_key, revision_of)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			  RETURNING id`,
+		app.FirstName, app.LastName, app.Jobid, app.OtherBankExp, app.Resumepath, app.FileNumber,
+		data.NormalizeFileNumber(app.FileNumber), id).Scan(&revision)
+	return revision, err
+}
+
+func (repo *Repository) ReviseExternalApplication(ctx context.Context, id string, app data.ExternalEmployee) (string, error) {
+	var revision string
+	err := repo.DB.QueryRowContext(ctx, `INSERT INTO external_applications
+			      (first_name, last_name, email, phone, jobid, other_job_exp, other_job_exp_year, resume_path,
+			       email_key, phone_key, revision_of)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			  RETURNING id`,
+		app.FirstName, app.LastName, app.Email, app.Phone, app.Jobid, app.OtherJobExp, app.OtherJobYear, app.Resumepath,
+		data.NormalizeEmail(app.Email), data.NormalizePhone(app.Phone), id).Scan(&revision)
+	return revision, err
+}
+
+func (repo *Repository) GetApplicationContacts(ctx context.Context) ([]data.ApplicationContact, error) {
+	queries := []struct {
+		kind, query string
+	}{
+		{data.ApplicationKindInternal, `SELECT a.id, COALESCE(CAST(a.jobid AS TEXT), ''), COALESCE(j.title, ''),
+			      COALESCE(a.first_name, ''), COALESCE(a.last_name, ''), '', '', COALESCE(a.file_number, ''),
+			      COALESCE(CAST(a.revision_of AS TEXT), '')
+			  FROM internal_applications a LEFT JOIN jobs j ON j.id = a.jobid`},
+		{data.ApplicationKindExternal, `SELECT a.id, COALESCE(CAST(a.jobid AS TEXT), ''), COALESCE(j.title, ''),
+			      COALESCE(a.first_name, ''), COALESCE(a.last_name, ''), COALESCE(a.email, ''), COALESCE(a.phone, ''), '',
+			      COALESCE(CAST(a.revision_of AS TEXT), '')
+			  FROM external_applications a LEFT JOIN jobs j ON j.id = a.jobid`},
+	}
+
+	var contacts []data.ApplicationContact
+	for _, q := range queries {
+		rows, err := repo.DB.QueryContext(ctx, q.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			contact := data.ApplicationContact{Kind: q.kind}
+			err := rows.Scan(&contact.ID, &contact.JobID, &contact.JobTitle, &contact.FirstName, &contact.LastName,
+				&contact.Email, &contact.Phone, &contact.FileNumber, &contact.RevisionOf)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			contacts = append(contacts, contact)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return contacts, nil
+}
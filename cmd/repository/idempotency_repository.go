@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+)
+
+type IdempotencyRepository struct {
+	DB Querier
+}
+
+func NewIdempotencyRepository(db Querier) *IdempotencyRepository {
+	return &IdempotencyRepository{DB: db}
+}
+
+// ReserveIdempotencyKey clears an expired request with the key, then
+// inserts req unless another request holds the key. The insert decides
+// between concurrent requests, so only one of them runs.
+func (repo *IdempotencyRepository) ReserveIdempotencyKey(ctx context.Context, req data.IdempotentRequest, now time.Time) (*data.IdempotentRequest, error) {
+	// A request found by the insert can expire before the select; try again
+	for attempt := 0; attempt < 3; attempt++ {
+		_, err := repo.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND expires_at <= $2`, req.Key, now.UTC())
+		if err != nil {
+			return nil, err
+		}
+		result, err := repo.DB.ExecContext(ctx, `INSERT INTO idempotency_keys (key, fingerprint, status, content_type, body, expires_at)
+			  VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (key) DO NOTHING`,
+			req.Key, req.Fingerprint, req.Status, req.ContentType, req.Body, req.ExpiresAt.UTC())
+		if err != nil {
+			return nil, err
+		}
+		if rows, err := result.RowsAffected(); err != nil || rows == 1 {
+			return nil, err
+		}
+
+		var held data.IdempotentRequest
+		err = repo.DB.QueryRowContext(ctx, `SELECT key, fingerprint, status, content_type, body, expires_at
+			  FROM idempotency_keys WHERE key = $1 AND expires_at > $2`, req.Key, now.UTC()).
+			Scan(&held.Key, &held.Fingerprint, &held.Status, &held.ContentType, &held.Body, &held.ExpiresAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		held.ExpiresAt = held.ExpiresAt.UTC()
+		return &held, nil
+	}
+	return nil, fmt.Errorf("idempotency key %q keeps changing", req.Key)
+}
+
+func (repo *IdempotencyRepository) CompleteIdempotencyKey(ctx context.Context, req data.IdempotentRequest) error {
+	_, err := repo.DB.ExecContext(ctx, `UPDATE idempotency_keys SET status = $2, content_type = $3, body = $4, expires_at = $5
+			  WHERE key = $1`, req.Key, req.Status, req.ContentType, req.Body, req.ExpiresAt.UTC())
+	return err
+}
+
+func (repo *IdempotencyRepository) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	_, err := repo.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1`, key)
+	return err
+}
+
+func (repo *IdempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, t time.Time) error {
+	_, err := repo.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, t.UTC())
+	return err
+}
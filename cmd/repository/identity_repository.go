@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/google/uuid"
+)
+
+// GetUserByIdentity returns the user linked to an external account, or nil
+// if the account has not signed in before
+func (repo *AuthRepository) GetUserByIdentity(ctx context.Context, provider, subject string) (*data.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+			  WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)`
+	return scanUser(repo.DB.QueryRowContext(ctx, query, provider, subject))
+}
+
+// CreateExternalUser creates a user provisioned by an identity provider,
+// links the external account and grants the roles, all in one transaction
+func (repo *AuthRepository) CreateExternalUser(ctx context.Context, user *data.User, identity data.ExternalIdentity, roles []data.UserRole) error {
+	return inTx(ctx, repo.DB, func(tx *Tx) error {
+		if err := insertUser(ctx, tx, user); err != nil {
+			return err
+		}
+		if err := linkIdentity(ctx, tx, user.Id, identity, user.CreatedAt); err != nil {
+			return err
+		}
+		return insertUserRoles(ctx, tx, user.Id, roles)
+	})
+}
+
+// LinkIdentity links an external account to an existing user
+func (repo *AuthRepository) LinkIdentity(ctx context.Context, userID uuid.UUID, identity data.ExternalIdentity) error {
+	return linkIdentity(ctx, repo.DB, userID, identity, time.Now())
+}
+
+// TouchIdentity records a sign-in through an external account
+func (repo *AuthRepository) TouchIdentity(ctx context.Context, provider, subject string, at time.Time) error {
+	_, err := repo.DB.ExecContext(ctx, `UPDATE user_identities SET last_login = $1 WHERE provider = $2 AND subject = $3`, at, provider, subject)
+	return err
+}
+
+func linkIdentity(ctx context.Context, db execer, userID uuid.UUID, identity data.ExternalIdentity, at time.Time) error {
+	query := `INSERT INTO user_identities (provider, subject, user_id, created_at) VALUES ($1, $2, $3, $4)`
+	_, err := db.ExecContext(ctx, query, identity.Provider, identity.Subject, userID, at)
+	return err
+}
@@ -0,0 +1,202 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/google/uuid"
+)
+
+// ApplyInternal stores an application. Its match fields are dropped: they
+// are set by AutoMatchInternalApplication, and like the SQL queries the
+// listings do not return them.
+func (s *Store) ApplyInternal(ctx context.Context, app data.InternalEmployee) (string, error) {
+	defer s.lock()()
+
+	if s.job(app.Jobid) < 0 {
+		return "", constraint("job %q does not exist", app.Jobid)
+	}
+	app.MatchedEmployee, app.PromotionStatus = "", ""
+	id := uuid.NewString()
+	s.internals = append(s.internals, internalApplication{id: id, app: app})
+	return id, nil
+}
+
+func (s *Store) ApplyExternal(ctx context.Context, app data.ExternalEmployee) (string, error) {
+	defer s.lock()()
+
+	if s.job(app.Jobid) < 0 {
+		return "", constraint("job %q does not exist", app.Jobid)
+	}
+	id := uuid.NewString()
+	s.externals = append(s.externals, externalApplication{id: id, app: app})
+	return id, nil
+}
+
+func (s *Store) GetAllInternalApplications(ctx context.Context) ([]data.InternalEmployee, error) {
+	return s.internalsWhere(func(data.InternalEmployee) bool { return true }), nil
+}
+
+func (s *Store) GetInternalApplicationsByJobID(ctx context.Context, jobID string) ([]data.InternalEmployee, error) {
+	return s.internalsWhere(func(app data.InternalEmployee) bool { return app.Jobid == jobID }), nil
+}
+
+func (s *Store) internalsWhere(match func(data.InternalEmployee) bool) []data.InternalEmployee {
+	defer s.rlock()()
+
+	var apps []data.InternalEmployee
+	for _, internal := range s.internals {
+		if match(internal.app) {
+			apps = append(apps, internal.app)
+		}
+	}
+	return apps
+}
+
+func (s *Store) GetAllExternalApplications(ctx context.Context) ([]data.ExternalEmployee, error) {
+	return s.externalsWhere(func(data.ExternalEmployee) bool { return true }), nil
+}
+
+func (s *Store) GetExternalApplicationsByJobID(ctx context.Context, jobID string) ([]data.ExternalEmployee, error) {
+	return s.externalsWhere(func(app data.ExternalEmployee) bool { return app.Jobid == jobID }), nil
+}
+
+func (s *Store) externalsWhere(match func(data.ExternalEmployee) bool) []data.ExternalEmployee {
+	defer s.rlock()()
+
+	var apps []data.ExternalEmployee
+	for _, external := range s.externals {
+		if match(external.app) {
+			apps = append(apps, external.app)
+		}
+	}
+	return apps
+}
+
+func (s *Store) AutoMatchInternalApplication(ctx context.Context, app data.InternalEmployee) (data.Employee, error) {
+	defer s.lock()()
+
+	employees := s.employeesByName(app.FirstName + " " + app.LastName)
+	if len(employees) == 0 {
+		return data.Employee{}, nil
+	}
+	matched := employees[0]
+
+	for i := range s.internals {
+		internal := &s.internals[i]
+		if internal.app.FirstName == app.FirstName && internal.app.LastName == app.LastName && internal.app.Jobid == app.Jobid {
+			internal.matchedEmployeeID = matched.ID
+			internal.promotionStatus = "pending"
+		}
+	}
+
+	// Start a clean evaluation
+	if i := s.employee(matched.ID); i >= 0 {
+		emp := &s.employees[i]
+		emp.Indpms25, emp.Totalexp20, emp.Tmdrec20, emp.Disrec15, emp.Total =
+			sql.NullFloat64{}, sql.NullFloat64{}, sql.NullFloat64{}, sql.NullFloat64{}, sql.NullFloat64{}
+	}
+	return matched, nil
+}
+
+// FindInternalDuplicate compares file numbers as the SQL version compares
+// the file_number_key column
+func (s *Store) FindInternalDuplicate(ctx context.Context, app data.InternalEmployee) (string, error) {
+	defer s.rlock()()
+
+	fileNumber := data.NormalizeFileNumber(app.FileNumber)
+	for _, internal := range s.internals {
+		if fileNumber != "" && internal.app.Jobid == app.Jobid && data.NormalizeFileNumber(internal.app.FileNumber) == fileNumber {
+			return original(internal.id, internal.revisionOf), nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (s *Store) FindExternalDuplicate(ctx context.Context, app data.ExternalEmployee) (string, error) {
+	defer s.rlock()()
+
+	email, phone := data.NormalizeEmail(app.Email), data.NormalizePhone(app.Phone)
+	for _, external := range s.externals {
+		if external.app.Jobid != app.Jobid {
+			continue
+		}
+		if email != "" && data.NormalizeEmail(external.app.Email) == email ||
+			phone != "" && data.NormalizePhone(external.app.Phone) == phone {
+			return original(external.id, external.revisionOf), nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (s *Store) ReviseInternalApplication(ctx context.Context, id string, app data.InternalEmployee) (string, error) {
+	defer s.lock()()
+
+	if s.job(app.Jobid) < 0 {
+		return "", constraint("job %q does not exist", app.Jobid)
+	}
+	app.MatchedEmployee, app.PromotionStatus = "", ""
+	revision := uuid.NewString()
+	s.internals = append(s.internals, internalApplication{id: revision, app: app, revisionOf: id})
+	return revision, nil
+}
+
+func (s *Store) ReviseExternalApplication(ctx context.Context, id string, app data.ExternalEmployee) (string, error) {
+	defer s.lock()()
+
+	if s.job(app.Jobid) < 0 {
+		return "", constraint("job %q does not exist", app.Jobid)
+	}
+	revision := uuid.NewString()
+	s.externals = append(s.externals, externalApplication{id: revision, app: app, revisionOf: id})
+	return revision, nil
+}
+
+// original returns the ID of the application a row revises, or its own
+func original(id, revisionOf string) string {
+	if revisionOf != "" {
+		return revisionOf
+	}
+	return id
+}
+
+func (s *Store) GetApplicationContacts(ctx context.Context) ([]data.ApplicationContact, error) {
+	defer s.rlock()()
+
+	var contacts []data.ApplicationContact
+	for _, internal := range s.internals {
+		contacts = append(contacts, data.ApplicationContact{
+			ID:         internal.id,
+			Kind:       data.ApplicationKindInternal,
+			JobID:      internal.app.Jobid,
+			JobTitle:   s.jobTitle(internal.app.Jobid),
+			FirstName:  internal.app.FirstName,
+			LastName:   internal.app.LastName,
+			FileNumber: internal.app.FileNumber,
+			RevisionOf: internal.revisionOf,
+		})
+	}
+	for _, external := range s.externals {
+		contacts = append(contacts, data.ApplicationContact{
+			ID:         external.id,
+			Kind:       data.ApplicationKindExternal,
+			JobID:      external.app.Jobid,
+			JobTitle:   s.jobTitle(external.app.Jobid),
+			FirstName:  external.app.FirstName,
+			LastName:   external.app.LastName,
+			Email:      external.app.Email,
+			Phone:      external.app.Phone,
+			RevisionOf: external.revisionOf,
+		})
+	}
+	return contacts, nil
+}
+
+// jobTitle returns the title of a job, or "". The caller holds the lock.
+func (s *Store) jobTitle(id string) string {
+	if i := s.job(id); i >= 0 {
+		return s.jobs[i].Title
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+)
+
+// copyAuditEntry returns an entry that shares no memory with e
+func copyAuditEntry(e data.AuditEntry) data.AuditEntry {
+	if e.Before != nil {
+		e.Before = append(json.RawMessage(nil), e.Before...)
+	}
+	if e.After != nil {
+		e.After = append(json.RawMessage(nil), e.After...)
+	}
+	return e
+}
+
+// AppendAuditEntry links the entry to the end of the chain, hashes it and
+// stores it, setting ID, PrevHash and Hash on entry
+func (s *Store) AppendAuditEntry(ctx context.Context, entry *data.AuditEntry) error {
+	defer s.lock()()
+
+	entry.PrevHash = ""
+	if n := len(s.audit); n > 0 {
+		entry.PrevHash = s.audit[n-1].Hash
+	}
+	entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+	entry.Hash = entry.ComputeHash()
+	entry.ID = int64(len(s.audit) + 1)
+	s.audit = append(s.audit, copyAuditEntry(*entry))
+	return nil
+}
+
+// GetAuditEntries returns entries matching the filter, newest first
+func (s *Store) GetAuditEntries(ctx context.Context, filter data.AuditFilter) ([]data.AuditEntry, error) {
+	defer s.rlock()()
+
+	entries := []data.AuditEntry{}
+	skipped := 0
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		e := s.audit[i]
+		switch {
+		case filter.ActorID != nil && (e.ActorID == nil || *e.ActorID != *filter.ActorID),
+			filter.ImpersonatorID != nil && (e.ImpersonatorID == nil || *e.ImpersonatorID != *filter.ImpersonatorID),
+			filter.EntityType != "" && e.EntityType != filter.EntityType,
+			filter.EntityID != "" && e.EntityID != filter.EntityID,
+			filter.Action != "" && e.Action != filter.Action,
+			filter.From != nil && e.CreatedAt.Before(*filter.From),
+			filter.To != nil && !e.CreatedAt.Before(*filter.To):
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		entries = append(entries, copyAuditEntry(e))
+	}
+	return entries, nil
+}
+
+// WalkAuditLog calls fn for every entry in chain order. fn may write to the
+// store; it sees the log as it was when the walk started.
+func (s *Store) WalkAuditLog(ctx context.Context, fn func(entry *data.AuditEntry) error) error {
+	unlock := s.rlock()
+	entries := make([]data.AuditEntry, len(s.audit))
+	for i, e := range s.audit {
+		entries[i] = copyAuditEntry(e)
+	}
+	unlock()
+
+	for i := range entries {
+		if err := fn(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
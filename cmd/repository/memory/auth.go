@@ -0,0 +1,191 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/google/uuid"
+)
+
+func (s *Store) GetUserMFA(ctx context.Context, userID uuid.UUID) (*data.UserMFA, error) {
+	defer s.rlock()()
+
+	mfa, ok := s.mfa[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &mfa, nil
+}
+
+// SaveUserMFA starts a new, not yet enabled, enrolment and replaces the
+// recovery codes
+func (s *Store) SaveUserMFA(ctx context.Context, mfa *data.UserMFA, recoveryCodeHashes []string) error {
+	defer s.lock()()
+
+	if s.userByID(mfa.UserID) < 0 {
+		return constraint("user %s does not exist", mfa.UserID)
+	}
+	previous := s.mfa[mfa.UserID]
+	s.mfa[mfa.UserID] = data.UserMFA{
+		UserID:         mfa.UserID,
+		Secret:         mfa.Secret,
+		CreatedAt:      mfa.CreatedAt,
+		FailedAttempts: previous.FailedAttempts,
+		LockedUntil:    previous.LockedUntil,
+	}
+	codes := make(map[string]*time.Time, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = nil
+	}
+	s.recoveryCodes[mfa.UserID] = codes
+	return nil
+}
+
+func (s *Store) EnableUserMFA(ctx context.Context, userID uuid.UUID, step int64) error {
+	defer s.lock()()
+
+	if mfa, ok := s.mfa[userID]; ok {
+		now := s.now()
+		mfa.Enabled, mfa.EnabledAt, mfa.LastUsedStep = true, &now, step
+		s.mfa[userID] = mfa
+	}
+	return nil
+}
+
+// AdvanceMFAStep reports false if step is not newer than the last one used
+func (s *Store) AdvanceMFAStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	defer s.lock()()
+
+	mfa, ok := s.mfa[userID]
+	if !ok || mfa.LastUsedStep >= step {
+		return false, nil
+	}
+	mfa.LastUsedStep = step
+	s.mfa[userID] = mfa
+	return true, nil
+}
+
+// UseRecoveryCode reports false if the hash is not an unused code of the
+// user
+func (s *Store) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	defer s.lock()()
+
+	usedAt, ok := s.recoveryCodes[userID][codeHash]
+	if !ok || usedAt != nil {
+		return false, nil
+	}
+	now := s.now()
+	s.recoveryCodes[userID][codeHash] = &now
+	return true, nil
+}
+
+func (s *Store) DeleteUserMFA(ctx context.Context, userID uuid.UUID) error {
+	defer s.lock()()
+
+	delete(s.mfa, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+// RecordMFAFailure locks the enrolment on the attempt that reaches limit
+func (s *Store) RecordMFAFailure(ctx context.Context, userID uuid.UUID, limit int, lockUntil time.Time) error {
+	defer s.lock()()
+
+	mfa, ok := s.mfa[userID]
+	if !ok {
+		return nil
+	}
+	mfa.FailedAttempts++
+	if mfa.FailedAttempts >= limit {
+		mfa.FailedAttempts, mfa.LockedUntil = 0, &lockUntil
+	}
+	s.mfa[userID] = mfa
+	return nil
+}
+
+func (s *Store) ResetMFAFailures(ctx context.Context, userID uuid.UUID) error {
+	defer s.lock()()
+
+	if mfa, ok := s.mfa[userID]; ok {
+		mfa.FailedAttempts, mfa.LockedUntil = 0, nil
+		s.mfa[userID] = mfa
+	}
+	return nil
+}
+
+// copyAPIKey returns a key that shares no memory with the stored one
+func copyAPIKey(key data.APIKey) *data.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	return &key
+}
+
+func (s *Store) CreateAPIKey(ctx context.Context, key *data.APIKey) error {
+	defer s.lock()()
+
+	for _, existing := range s.apiKeys {
+		if existing.ID == key.ID || existing.Hash == key.Hash {
+			return constraint("API key %s already exists", key.ID)
+		}
+	}
+	stored := copyAPIKey(*key)
+	stored.LastUsedAt, stored.LastUsedIP, stored.RevokedAt = nil, "", nil
+	s.apiKeys = append(s.apiKeys, *stored)
+	return nil
+}
+
+func (s *Store) getAPIKey(match func(*data.APIKey) bool) (*data.APIKey, error) {
+	defer s.rlock()()
+
+	for i := range s.apiKeys {
+		if match(&s.apiKeys[i]) {
+			return copyAPIKey(s.apiKeys[i]), nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Store) GetAPIKey(ctx context.Context, id uuid.UUID) (*data.APIKey, error) {
+	return s.getAPIKey(func(key *data.APIKey) bool { return key.ID == id })
+}
+
+func (s *Store) GetAPIKeyByHash(ctx context.Context, hash string) (*data.APIKey, error) {
+	return s.getAPIKey(func(key *data.APIKey) bool { return key.Hash == hash })
+}
+
+// GetAPIKeys lists the keys newest first
+func (s *Store) GetAPIKeys(ctx context.Context) ([]data.APIKey, error) {
+	defer s.rlock()()
+
+	keys := []data.APIKey{}
+	for _, key := range s.apiKeys {
+		keys = append(keys, *copyAPIKey(key))
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *Store) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time, ip string) error {
+	defer s.lock()()
+
+	for i := range s.apiKeys {
+		if s.apiKeys[i].ID == id {
+			s.apiKeys[i].LastUsedAt, s.apiKeys[i].LastUsedIP = &at, ip
+		}
+	}
+	return nil
+}
+
+// RevokeAPIKey reports false if the key does not exist or is already revoked
+func (s *Store) RevokeAPIKey(ctx context.Context, id uuid.UUID, at time.Time) (bool, error) {
+	defer s.lock()()
+
+	for i := range s.apiKeys {
+		if s.apiKeys[i].ID == id && s.apiKeys[i].RevokedAt == nil {
+			s.apiKeys[i].RevokedAt = &at
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/brehan/bank/cmd/data"
+)
+
+// employee returns the index of an employee, or -1. The caller holds the
+// lock.
+func (s *Store) employee(id int) int {
+	for i := range s.employees {
+		if s.employees[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) GetEmployeesByID(ctx context.Context, id int) (data.Employee, error) {
+	return s.GetEmployeeByID(ctx, id)
+}
+
+func (s *Store) GetEmployeeByID(ctx context.Context, id int) (data.Employee, error) {
+	defer s.rlock()()
+
+	i := s.employee(id)
+	if i < 0 {
+		return data.Employee{}, sql.ErrNoRows
+	}
+	return s.employees[i], nil
+}
+
+func (s *Store) GetEmployeeByFileNumber(ctx context.Context, fileNumber string) (data.Employee, error) {
+	defer s.rlock()()
+
+	for _, emp := range s.employees {
+		if emp.FileNumber == fileNumber {
+			return emp, nil
+		}
+	}
+	return data.Employee{}, sql.ErrNoRows
+}
+
+func (s *Store) GetEmployeesByName(ctx context.Context, name string) ([]data.Employee, error) {
+	defer s.rlock()()
+	return s.employeesByName(name), nil
+}
+
+// employeesByName is GetEmployeesByName for callers holding the lock
+func (s *Store) employeesByName(name string) []data.Employee {
+	var employees []data.Employee
+	for _, emp := range s.employees {
+		if strings.Contains(strings.ToLower(emp.FullName), strings.ToLower(name)) {
+			employees = append(employees, emp)
+		}
+	}
+	return employees
+}
+
+func (s *Store) GetAllEmployees(ctx context.Context) ([]data.Employee, error) {
+	defer s.rlock()()
+
+	return append([]data.Employee(nil), s.employees...), nil
+}
+
+// CreateEmployee ignores emp.ID and assigns the next one, like the serial
+// column does
+func (s *Store) CreateEmployee(ctx context.Context, emp data.Employee) error {
+	defer s.lock()()
+
+	emp.ID = s.nextEmployeeID
+	s.nextEmployeeID++
+	s.employees = append(s.employees, emp)
+	return nil
+}
+
+// UpdateEmployee saves every column but doe. Updating an employee that does
+// not exist is not an error.
+func (s *Store) UpdateEmployee(ctx context.Context, emp data.Employee) error {
+	defer s.lock()()
+
+	if i := s.employee(emp.ID); i >= 0 {
+		emp.DoE = s.employees[i].DoE
+		s.employees[i] = emp
+	}
+	return nil
+}
+
+// updateScore applies fn to an employee and recalculates the total
+func (s *Store) updateScore(id int, fn func(emp *data.Employee)) {
+	defer s.lock()()
+
+	if i := s.employee(id); i >= 0 {
+		fn(&s.employees[i])
+		recalculateTotal(&s.employees[i])
+	}
+}
+
+func (s *Store) UpdateEmployeeIndividualPMS(ctx context.Context, employeeID int, pmsScore float64) error {
+	s.updateScore(employeeID, func(emp *data.Employee) {
+		emp.IndividualPMS = sql.NullFloat64{Float64: pmsScore, Valid: true}
+		emp.Indpms25 = sql.NullFloat64{Float64: pmsScore * 0.25, Valid: true}
+	})
+	return nil
+}
+
+func (s *Store) UpdateEmployeeManagerRecommendation(ctx context.Context, employeeID int, recScore float64) error {
+	s.updateScore(employeeID, func(emp *data.Employee) {
+		emp.Tmdrec20 = sql.NullFloat64{Float64: recScore * 0.20, Valid: true}
+	})
+	return nil
+}
+
+func (s *Store) UpdateEmployeeDistrictRecommendation(ctx context.Context, employeeID int, recScore float64) error {
+	s.updateScore(employeeID, func(emp *data.Employee) {
+		emp.Disrec15 = sql.NullFloat64{Float64: recScore * 0.15, Valid: true}
+	})
+	return nil
+}
+
+func (s *Store) CalculateExperienceScore(ctx context.Context, employeeID int) error {
+	defer s.lock()()
+
+	i := s.employee(employeeID)
+	if i < 0 {
+		return sql.ErrNoRows
+	}
+	emp := &s.employees[i]
+	var score float64
+	if emp.Totalexp.Valid {
+		score = float64(emp.Totalexp.Int64) * 0.20
+	}
+	emp.Totalexp20 = sql.NullFloat64{Float64: score, Valid: true}
+	recalculateTotal(emp)
+	return nil
+}
+
+// recalculateTotal sums the weighted scores, counting missing ones as 0
+func recalculateTotal(emp *data.Employee) {
+	emp.Total = sql.NullFloat64{
+		Float64: emp.Indpms25.Float64 + emp.Totalexp20.Float64 + emp.Tmdrec20.Float64 + emp.Disrec15.Float64,
+		Valid:   true,
+	}
+}
+
+// GetmaxValuesofexp returns the largest total experience, related
+// experience and experience score. Columns without any value count as 0.
+func (s *Store) GetmaxValuesofexp(ctx context.Context, emp data.Employee) (int, int, int, error) {
+	defer s.rlock()()
+
+	var maxTotalExp, maxRelatedExp, maxTotalExp20 int
+	for _, e := range s.employees {
+		if e.Totalexp.Valid && int(e.Totalexp.Int64) > maxTotalExp {
+			maxTotalExp = int(e.Totalexp.Int64)
+		}
+		if e.Relatedexp.Valid && int(e.Relatedexp.Int64) > maxRelatedExp {
+			maxRelatedExp = int(e.Relatedexp.Int64)
+		}
+		if e.Totalexp20.Valid && int(e.Totalexp20.Float64) > maxTotalExp20 {
+			maxTotalExp20 = int(e.Totalexp20.Float64)
+		}
+	}
+	return maxTotalExp, maxRelatedExp, maxTotalExp20, nil
+}
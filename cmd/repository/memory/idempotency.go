@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+)
+
+var _ repository.IdempotencyStore = (*idempotencyKeys)(nil)
+
+// idempotencyKeys holds requests made with an Idempotency-Key. Like rate
+// limits they are not part of transactions.
+type idempotencyKeys struct {
+	mu       sync.Mutex
+	requests map[string]data.IdempotentRequest
+}
+
+// copyIdempotentRequest returns a request that shares no memory with r
+func copyIdempotentRequest(r data.IdempotentRequest) data.IdempotentRequest {
+	r.Body = append([]byte(nil), r.Body...)
+	r.ExpiresAt = r.ExpiresAt.UTC()
+	return r
+}
+
+func (k *idempotencyKeys) ReserveIdempotencyKey(ctx context.Context, req data.IdempotentRequest, now time.Time) (*data.IdempotentRequest, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if held, ok := k.requests[req.Key]; ok && held.ExpiresAt.After(now) {
+		held = copyIdempotentRequest(held)
+		return &held, nil
+	}
+	k.requests[req.Key] = copyIdempotentRequest(req)
+	return nil, nil
+}
+
+func (k *idempotencyKeys) CompleteIdempotencyKey(ctx context.Context, req data.IdempotentRequest) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if held, ok := k.requests[req.Key]; ok {
+		held.Status, held.ContentType, held.ExpiresAt = req.Status, req.ContentType, req.ExpiresAt
+		held.Body = req.Body
+		k.requests[req.Key] = copyIdempotentRequest(held)
+	}
+	return nil
+}
+
+func (k *idempotencyKeys) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.requests, key)
+	return nil
+}
+
+func (k *idempotencyKeys) DeleteExpiredIdempotencyKeys(ctx context.Context, t time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for key, req := range k.requests {
+		if !req.ExpiresAt.After(t) {
+			delete(k.requests, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/google/uuid"
+)
+
+// job returns the index of a job, or -1. The caller holds the lock.
+func (s *Store) job(id string) int {
+	for i := range s.jobs {
+		if s.jobs[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) CreateJob(ctx context.Context, job *data.Job) error {
+	defer s.lock()()
+
+	job.ID = uuid.NewString()
+	s.jobs = append(s.jobs, *job)
+	return nil
+}
+
+// GetAllJobs returns the jobs newest first
+func (s *Store) GetAllJobs(ctx context.Context) ([]data.Job, error) {
+	return s.jobsWhere(func(data.Job) bool { return true }), nil
+}
+
+func (s *Store) GetJobByType(ctx context.Context, jobType string) ([]data.Job, error) {
+	return s.jobsWhere(func(job data.Job) bool { return job.JobType == jobType }), nil
+}
+
+func (s *Store) jobsWhere(match func(data.Job) bool) []data.Job {
+	defer s.rlock()()
+
+	var jobs []data.Job
+	for _, job := range s.jobs {
+		if match(job) {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+func (s *Store) GetJobById(ctx context.Context, id string) (data.Job, error) {
+	defer s.rlock()()
+
+	i := s.job(id)
+	if i < 0 {
+		return data.Job{}, sql.ErrNoRows
+	}
+	return s.jobs[i], nil
+}
+
+// UpdateJob saves every field but CreatedAt
+func (s *Store) UpdateJob(ctx context.Context, job data.Job) error {
+	defer s.lock()()
+
+	if i := s.job(job.ID); i >= 0 {
+		job.CreatedAt = s.jobs[i].CreatedAt
+		s.jobs[i] = job
+	}
+	return nil
+}
+
+// LockJob only checks that the job exists: WithTx already runs one
+// transaction at a time
+func (s *Store) LockJob(ctx context.Context, id string) error {
+	defer s.lock()()
+
+	if s.job(id) < 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteJob removes a job and its application links. Like the foreign key,
+// it refuses to delete a job that has applications.
+func (s *Store) DeleteJob(ctx context.Context, id string) error {
+	defer s.lock()()
+
+	i := s.job(id)
+	if i < 0 {
+		return nil
+	}
+	for _, internal := range s.internals {
+		if internal.app.Jobid == id {
+			return constraint("job %s has internal applications", id)
+		}
+	}
+	for _, external := range s.externals {
+		if external.app.Jobid == id {
+			return constraint("job %s has external applications", id)
+		}
+	}
+
+	s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+	links := s.links[:0]
+	for _, link := range s.links {
+		if link.JobID != id {
+			links = append(links, link)
+		}
+	}
+	s.links = links
+	return nil
+}
+
+func (s *Store) CreateApplicationLink(ctx context.Context, jobID, linkType string) (data.ApplicationLink, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return data.ApplicationLink{}, err
+	}
+
+	defer s.lock()()
+
+	if s.job(jobID) < 0 {
+		return data.ApplicationLink{}, constraint("job %s does not exist", jobID)
+	}
+	now := s.now()
+	link := data.ApplicationLink{
+		ID:        strconv.Itoa(s.nextLinkID),
+		JobID:     jobID,
+		Token:     base64.URLEncoding.EncodeToString(bytes),
+		Type:      linkType,
+		ExpiresAt: now.AddDate(0, 0, 7),
+		CreatedAt: now,
+	}
+	s.nextLinkID++
+	s.links = append(s.links, link)
+	return link, nil
+}
+
+func (s *Store) GetApplicationLinkByToken(ctx context.Context, token string) (data.ApplicationLink, error) {
+	defer s.rlock()()
+
+	for _, link := range s.links {
+		if link.Token == token {
+			return link, nil
+		}
+	}
+	return data.ApplicationLink{}, sql.ErrNoRows
+}
+
+func (s *Store) GetApplicationLinksByJobID(ctx context.Context, jobID string) ([]data.ApplicationLink, error) {
+	defer s.rlock()()
+
+	var links []data.ApplicationLink
+	for _, link := range s.links {
+		if link.JobID == jobID {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+func (s *Store) MarkApplicationLinkAsUsed(ctx context.Context, token string, now time.Time) error {
+	defer s.lock()()
+
+	for i := range s.links {
+		if s.links[i].Token == token && !s.links[i].IsUsed && s.links[i].ExpiresAt.After(now) {
+			s.links[i].IsUsed = true
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/brehan/bank/cmd/repository"
+)
+
+var _ repository.RateLimitStore = (*RateLimits)(nil)
+
+// RateLimits holds token buckets in memory. It is the rate limit store of a
+// server on a single node, whatever its database, and is safe for
+// concurrent use.
+type RateLimits struct {
+	mu      sync.Mutex
+	buckets map[string]time.Time
+}
+
+// NewRateLimits returns a RateLimits without buckets
+func NewRateLimits() *RateLimits {
+	return &RateLimits{buckets: make(map[string]time.Time)}
+}
+
+func (r *RateLimits) GetTokenBucket(ctx context.Context, bucket string) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buckets[bucket], nil
+}
+
+func (r *RateLimits) SetTokenBucket(ctx context.Context, bucket string, prev, fullAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.buckets[bucket].Equal(prev) {
+		return false, nil
+	}
+	r.buckets[bucket] = fullAt.UTC()
+	return true, nil
+}
+
+func (r *RateLimits) DeleteTokenBuckets(ctx context.Context, t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for bucket, fullAt := range r.buckets {
+		if !fullAt.After(t) {
+			delete(r.buckets, bucket)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+// Package memory implements the repository store interfaces in memory, for
+// unit tests and for running the server without a database.
+//
+// A Store behaves like the SQL repositories on a freshly migrated database:
+// the roles and permissions are seeded, generated IDs follow the same
+// scheme and lookups report a missing row the same way. Constraints that
+// the schema enforces, such as unique emails and references to jobs and
+// users, return an error wrapping ErrConstraint.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/google/uuid"
+)
+
+// ErrConstraint is wrapped by errors for writes the database would reject
+var ErrConstraint = errors.New("constraint violation")
+
+func constraint(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrConstraint, fmt.Sprintf(format, args...))
+}
+
+var (
+	_ repository.EmployeeStore    = (*Store)(nil)
+	_ repository.JobStore         = (*Store)(nil)
+	_ repository.ApplicationStore = (*Store)(nil)
+	_ repository.LinkStore        = (*Store)(nil)
+	_ repository.UserStore        = (*Store)(nil)
+	_ repository.MFAStore         = (*Store)(nil)
+	_ repository.APIKeyStore      = (*Store)(nil)
+	_ repository.AuditStore       = (*Store)(nil)
+	_ repository.UnitOfWork       = (*Store)(nil)
+)
+
+// Store holds every aggregate behind one lock. It is safe for concurrent
+// use; values are copied in and out, so callers never share its state.
+type Store struct {
+	mu sync.RWMutex
+	// tx is set on the view of the store that WithTx hands out. Its methods
+	// skip locking because WithTx holds the lock for the whole transaction.
+	tx bool
+	// now is the clock for timestamps the SQL versions take from time.Now
+	now func() time.Time
+	// rateLimits and idempotency are shared with the views that WithTx
+	// hands out
+	rateLimits  *RateLimits
+	idempotency *idempotencyKeys
+
+	*state
+}
+
+// state is the data of a Store, what WithTx copies and swaps back in
+type state struct {
+	employees      []data.Employee
+	nextEmployeeID int
+
+	jobs       []data.Job
+	internals  []internalApplication
+	externals  []externalApplication
+	links      []data.ApplicationLink
+	nextLinkID int
+
+	users      []data.User
+	roles      []data.Role
+	userRoles  map[uuid.UUID]map[string]string // role name to district
+	identities map[identityKey]*identity
+
+	mfa           map[uuid.UUID]data.UserMFA
+	recoveryCodes map[uuid.UUID]map[string]*time.Time // code hash to used_at
+
+	apiKeys []data.APIKey
+	audit   []data.AuditEntry
+}
+
+// internalApplication is a row of internal_applications. The match columns
+// are written by AutoMatchInternalApplication but, as in SQL, not read back.
+type internalApplication struct {
+	id                string
+	app               data.InternalEmployee
+	matchedEmployeeID int
+	promotionStatus   string
+	// revisionOf is the ID of the application this one revises
+	revisionOf string
+}
+
+// externalApplication is a row of external_applications
+type externalApplication struct {
+	id         string
+	app        data.ExternalEmployee
+	revisionOf string
+}
+
+type identityKey struct {
+	provider, subject string
+}
+
+type identity struct {
+	userID    uuid.UUID
+	createdAt time.Time
+	lastLogin *time.Time
+}
+
+// New returns an empty Store with the roles and permissions of the
+// migrations
+func New() *Store {
+	s := &Store{
+		now:         time.Now,
+		rateLimits:  NewRateLimits(),
+		idempotency: &idempotencyKeys{requests: make(map[string]data.IdempotentRequest)},
+		state: &state{
+			nextEmployeeID: 1,
+			nextLinkID:     1,
+			userRoles:      make(map[uuid.UUID]map[string]string),
+			identities:     make(map[identityKey]*identity),
+			mfa:            make(map[uuid.UUID]data.UserMFA),
+			recoveryCodes:  make(map[uuid.UUID]map[string]*time.Time),
+		},
+	}
+	for i, role := range seedRoles {
+		role.ID = i + 1
+		role.Permissions = append([]string(nil), role.Permissions...)
+		sort.Strings(role.Permissions)
+		s.roles = append(s.roles, role)
+	}
+	return s
+}
+
+// Stores returns s as every store
+func (s *Store) Stores() repository.Stores {
+	return repository.Stores{
+		Employees:    s,
+		Jobs:         s,
+		Applications: s,
+		Links:        s,
+		Users:        s,
+		MFA:          s,
+		APIKeys:      s,
+		Audit:        s,
+		RateLimits:   s.rateLimits,
+		Idempotency:  s.idempotency,
+	}
+}
+
+// WithTx runs fn on a copy of the store's data and keeps the copy only if
+// fn succeeds. The store stays locked until fn returns, so transactions run
+// one at a time as they do on SQLite.
+func (s *Store) WithTx(ctx context.Context, fn func(tx repository.Stores) error) error {
+	if s.tx {
+		return fn(s.Stores())
+	}
+	defer s.lock()()
+
+	tx := &Store{tx: true, now: s.now, rateLimits: s.rateLimits, idempotency: s.idempotency, state: s.state.clone()}
+	if err := fn(tx.Stores()); err != nil {
+		return err
+	}
+	s.state = tx.state
+	return nil
+}
+
+// lock takes the write lock and returns the function that releases it
+func (s *Store) lock() (unlock func()) {
+	if s.tx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// rlock takes the read lock and returns the function that releases it
+func (s *Store) rlock() (unlock func()) {
+	if s.tx {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+// clone returns a deep copy of st. Stored values are replaced rather than
+// changed in place, except for identities, so copying the slices and maps
+// is enough.
+func (st *state) clone() *state {
+	c := *st
+	c.employees = append([]data.Employee(nil), st.employees...)
+	c.jobs = append([]data.Job(nil), st.jobs...)
+	c.internals = append([]internalApplication(nil), st.internals...)
+	c.externals = append([]externalApplication(nil), st.externals...)
+	c.links = append([]data.ApplicationLink(nil), st.links...)
+	c.users = append([]data.User(nil), st.users...)
+	c.roles = append([]data.Role(nil), st.roles...)
+	c.apiKeys = append([]data.APIKey(nil), st.apiKeys...)
+	c.audit = append([]data.AuditEntry(nil), st.audit...)
+
+	c.userRoles = make(map[uuid.UUID]map[string]string, len(st.userRoles))
+	for id, held := range st.userRoles {
+		c.userRoles[id] = make(map[string]string, len(held))
+		for role, district := range held {
+			c.userRoles[id][role] = district
+		}
+	}
+	c.identities = make(map[identityKey]*identity, len(st.identities))
+	for key, id := range st.identities {
+		copied := *id
+		c.identities[key] = &copied
+	}
+	c.mfa = make(map[uuid.UUID]data.UserMFA, len(st.mfa))
+	for id, mfa := range st.mfa {
+		c.mfa[id] = mfa
+	}
+	c.recoveryCodes = make(map[uuid.UUID]map[string]*time.Time, len(st.recoveryCodes))
+	for id, codes := range st.recoveryCodes {
+		c.recoveryCodes[id] = make(map[string]*time.Time, len(codes))
+		for hash, usedAt := range codes {
+			c.recoveryCodes[id][hash] = usedAt
+		}
+	}
+	return &c
+}
+
+// seedRoles are the roles and grants inserted by the rbac, api_keys and
+// impersonation migrations
+var seedRoles = []data.Role{
+	{
+		Name:        data.RoleAdmin,
+		Description: "Full access to employees, jobs, applications and users",
+		Permissions: []string{
+			data.PermDashboardAdmin, data.PermEmployeeRead, data.PermEmployeeWrite, data.PermJobRead,
+			data.PermJobWrite, data.PermApplicationRead, data.PermApplicationLinkRead,
+			data.PermApplicationLinkWrite, data.PermUserRead, data.PermUserWrite, data.PermAuditRead,
+			data.PermAPIKeyManage, data.PermUserImpersonate,
+		},
+	},
+	{
+		Name:        data.RoleManager,
+		Description: "Scores individual PMS and manager recommendations",
+		Permissions: []string{
+			data.PermDashboardManager, data.PermEmployeeRead, data.PermEmployeePMSWrite,
+			data.PermEmployeeManagerRecWrite, data.PermEmployeeEvaluationRead,
+		},
+	},
+	{
+		Name:        data.RoleDistrictManager,
+		Description: "Gives district recommendations for employees in a district",
+		Permissions: []string{
+			data.PermDashboardDistrict, data.PermEmployeeRead, data.PermEmployeeDistrictRead,
+			data.PermEmployeeDistrictRecWrite, data.PermEmployeeEvaluationRead,
+		},
+	},
+}
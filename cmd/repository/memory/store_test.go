@@ -0,0 +1,678 @@
+package memory_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/migrate"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/repository/memory"
+	"github.com/google/uuid"
+)
+
+// ctx is the context of the store and service calls in these tests
+var ctx = context.Background()
+
+// backend bundles the stores the contract tests run against
+type backend struct {
+	employees    repository.EmployeeStore
+	jobs         repository.JobStore
+	applications repository.ApplicationStore
+	links        repository.LinkStore
+	users        repository.UserStore
+	mfa          repository.MFAStore
+	apiKeys      repository.APIKeyStore
+	audit        repository.AuditStore
+	rateLimits   repository.RateLimitStore
+	idempotency  repository.IdempotencyStore
+	uow          repository.UnitOfWork
+}
+
+// backends returns the memory store and the SQL repositories on a migrated
+// SQLite database, so that every test checks that they agree
+func backends(t *testing.T) map[string]backend {
+	t.Helper()
+	s := memory.New()
+
+	db, err := repository.Open(repository.SQLite, filepath.Join(t.TempDir(), "bank.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := migrate.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewRepository(db)
+	auth := repository.NewAuthRepository(db)
+
+	return map[string]backend{
+		"memory": {s, s, s, s, s, s, s, s, s.Stores().RateLimits, s.Stores().Idempotency, s},
+		"sqlite": {
+			repo, repo, repo, repo, auth, auth, auth, repository.NewAuditRepository(db),
+			repository.NewRateLimitRepository(db), repository.NewIdempotencyRepository(db), db,
+		},
+	}
+}
+
+func newUser(t *testing.T, users repository.UserStore, name, role string) *data.User {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Second)
+	user := &data.User{Id: uuid.New(), Name: name, Email: name + "@example.com", CreatedAt: now, UpdatedAt: now}
+	if err := users.CreateUser(ctx, user, role, ""); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestNotFound(t *testing.T) {
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := b.employees.GetEmployeeByID(ctx, 1); err != sql.ErrNoRows {
+				t.Errorf("GetEmployeeByID = %v", err)
+			}
+			if _, err := b.employees.GetEmployeeByFileNumber(ctx, "F1"); err != sql.ErrNoRows {
+				t.Errorf("GetEmployeeByFileNumber = %v", err)
+			}
+			if _, err := b.jobs.GetJobById(ctx, uuid.NewString()); err != sql.ErrNoRows {
+				t.Errorf("GetJobById = %v", err)
+			}
+			if _, err := b.links.GetApplicationLinkByToken(ctx, "nope"); err != sql.ErrNoRows {
+				t.Errorf("GetApplicationLinkByToken = %v", err)
+			}
+			if user, err := b.users.GetUserByName(ctx, "nobody"); user != nil || err != nil {
+				t.Errorf("GetUserByName = %v, %v", user, err)
+			}
+			if user, err := b.users.GetUserByIdentity(ctx, "idp", "1"); user != nil || err != nil {
+				t.Errorf("GetUserByIdentity = %v, %v", user, err)
+			}
+			if role, err := b.users.GetRoleByName(ctx, "owner"); role != nil || err != nil {
+				t.Errorf("GetRoleByName = %v, %v", role, err)
+			}
+			if mfa, err := b.mfa.GetUserMFA(ctx, uuid.New()); mfa != nil || err != nil {
+				t.Errorf("GetUserMFA = %v, %v", mfa, err)
+			}
+			if key, err := b.apiKeys.GetAPIKeyByHash(ctx, "x"); key != nil || err != nil {
+				t.Errorf("GetAPIKeyByHash = %v, %v", key, err)
+			}
+		})
+	}
+}
+
+func TestRolesMatchMigrations(t *testing.T) {
+	b := backends(t)
+	want, err := b["sqlite"].users.GetRoles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b["memory"].users.GetRoles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("memory roles\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestUserRoles(t *testing.T) {
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			user := newUser(t, b.users, "alice", data.RoleManager)
+
+			orphan := &data.User{Id: uuid.New(), Name: "orphan", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+			if err := b.users.CreateUser(ctx, orphan, "owner", ""); err != repository.ErrUnknownRole {
+				t.Errorf("CreateUser with an unknown role = %v", err)
+			}
+			if got, _ := b.users.GetUserByName(ctx, "orphan"); got != nil {
+				t.Error("a failed CreateUser left the user behind")
+			}
+			if err := b.users.AddUserRole(ctx, user.Id, "owner", ""); err != repository.ErrUnknownRole {
+				t.Errorf("AddUserRole with an unknown role = %v", err)
+			}
+			if err := b.users.AddUserRole(ctx, user.Id, data.RoleDistrictManager, "North"); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.users.AddUserRole(ctx, user.Id, data.RoleDistrictManager, "South"); err != nil {
+				t.Fatal(err)
+			}
+			access, err := b.users.GetUserAccess(ctx, user.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantRoles := []data.UserRole{{Role: data.RoleDistrictManager, District: "South"}, {Role: data.RoleManager}}
+			if !reflect.DeepEqual(access.Roles, wantRoles) {
+				t.Errorf("roles = %+v, want %+v", access.Roles, wantRoles)
+			}
+			if !access.HasPermission(data.PermEmployeeDistrictRecWrite) || !access.HasPermission(data.PermEmployeePMSWrite) {
+				t.Errorf("permissions = %v", access.Permissions)
+			}
+
+			if err := b.users.RemoveUserRole(ctx, user.Id, data.RoleAdmin); err != sql.ErrNoRows {
+				t.Errorf("RemoveUserRole of a role not held = %v", err)
+			}
+			if err := b.users.LockUser(ctx, user.Id); err != nil {
+				t.Errorf("LockUser = %v", err)
+			}
+			if err := b.users.LockUser(ctx, uuid.New()); err != sql.ErrNoRows {
+				t.Errorf("LockUser of a missing user = %v", err)
+			}
+			err = b.users.SetUserRoles(ctx, user.Id, []data.UserRole{{Role: data.RoleAdmin}, {Role: "owner"}})
+			if err != repository.ErrUnknownRole {
+				t.Errorf("SetUserRoles with an unknown role = %v", err)
+			}
+			if access, _ := b.users.GetUserAccess(ctx, user.Id); len(access.Roles) != 2 {
+				t.Errorf("a failed SetUserRoles changed the roles to %+v", access.Roles)
+			}
+
+			users, err := b.users.GetAllUsers(ctx)
+			if err != nil || len(users) != 1 {
+				t.Fatalf("GetAllUsers = %v, %v", users, err)
+			}
+			if users[0]["role"] != data.RoleManager || users[0]["district"] != "South" {
+				t.Errorf("GetAllUsers = %+v", users[0])
+			}
+
+			if err := b.users.DeleteUser(ctx, user.Id); err != nil {
+				t.Fatal(err)
+			}
+			if access, _ := b.users.GetUserAccess(ctx, user.Id); len(access.Roles) != 0 {
+				t.Errorf("roles after DeleteUser = %+v", access.Roles)
+			}
+		})
+	}
+}
+
+func TestUniqueEmail(t *testing.T) {
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			newUser(t, b.users, "bob", data.RoleManager)
+			other := &data.User{Id: uuid.New(), Name: "robert", Email: "BOB@example.com"}
+			if err := b.users.CreateUser(ctx, other, data.RoleManager, ""); err == nil {
+				t.Error("an email differing only in case should be rejected")
+			}
+			user, err := b.users.GetUserByEmail(ctx, "Bob@Example.com")
+			if err != nil || user == nil || user.Name != "bob" {
+				t.Errorf("GetUserByEmail = %+v, %v", user, err)
+			}
+		})
+	}
+}
+
+func TestMFASteps(t *testing.T) {
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			user := newUser(t, b.users, "carol", data.RoleAdmin)
+			mfa := &data.UserMFA{UserID: user.Id, Secret: "SECRET", CreatedAt: time.Now()}
+			if err := b.mfa.SaveUserMFA(ctx, mfa, []string{"h1", "h2"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.mfa.EnableUserMFA(ctx, user.Id, 100); err != nil {
+				t.Fatal(err)
+			}
+			for _, step := range []struct {
+				step int64
+				want bool
+			}{{100, false}, {99, false}, {101, true}} {
+				if fresh, err := b.mfa.AdvanceMFAStep(ctx, user.Id, step.step); err != nil || fresh != step.want {
+					t.Errorf("AdvanceMFAStep(%d) = %v, %v", step.step, fresh, err)
+				}
+			}
+			if used, _ := b.mfa.UseRecoveryCode(ctx, user.Id, "h1"); !used {
+				t.Error("first use of a recovery code should succeed")
+			}
+			if used, _ := b.mfa.UseRecoveryCode(ctx, user.Id, "h1"); used {
+				t.Error("second use of a recovery code should fail")
+			}
+			got, err := b.mfa.GetUserMFA(ctx, user.Id)
+			if err != nil || !got.Enabled || got.LastUsedStep != 101 || got.EnabledAt == nil {
+				t.Errorf("GetUserMFA = %+v, %v", got, err)
+			}
+
+			// The attempt that reaches the limit locks the enrolment
+			lockUntil := time.Now().Add(time.Hour).Truncate(time.Second)
+			for i := 0; i < 3; i++ {
+				if err := b.mfa.RecordMFAFailure(ctx, user.Id, 3, lockUntil); err != nil {
+					t.Fatal(err)
+				}
+				got, _ = b.mfa.GetUserMFA(ctx, user.Id)
+				if (i < 2) != (got.LockedUntil == nil) {
+					t.Errorf("after %d failures: %+v", i+1, got)
+				}
+			}
+			if got.FailedAttempts != 0 || !got.LockedUntil.Equal(lockUntil) {
+				t.Errorf("locked enrolment = %d, %v", got.FailedAttempts, got.LockedUntil)
+			}
+			if err := b.mfa.SaveUserMFA(ctx, mfa, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ = b.mfa.GetUserMFA(ctx, user.Id); got.LockedUntil == nil {
+				t.Error("enrolling again lifted the lock")
+			}
+			if err := b.mfa.ResetMFAFailures(ctx, user.Id); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ = b.mfa.GetUserMFA(ctx, user.Id); got.FailedAttempts != 0 || got.LockedUntil != nil {
+				t.Errorf("after ResetMFAFailures: %+v", got)
+			}
+		})
+	}
+}
+
+func TestEmployeeScores(t *testing.T) {
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			err := b.employees.CreateEmployee(ctx, data.Employee{
+				FileNumber: "F1", FullName: "Dawit Bekele", Totalexp: sql.NullInt64{Int64: 7, Valid: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			emp, err := b.employees.GetEmployeeByFileNumber(ctx, "F1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := b.employees.UpdateEmployeeIndividualPMS(ctx, emp.ID, 80); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.employees.UpdateEmployeeManagerRecommendation(ctx, emp.ID, 50); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.employees.UpdateEmployeeDistrictRecommendation(ctx, emp.ID, 40); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.employees.CalculateExperienceScore(ctx, emp.ID); err != nil {
+				t.Fatal(err)
+			}
+			emp, err = b.employees.GetEmployeeByID(ctx, emp.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 80*0.25 + 7*0.20 + 50*0.20 + 40*0.15
+			if total := emp.Total.Float64; total < 37.39 || total > 37.41 {
+				t.Errorf("total = %v, want 37.4", total)
+			}
+
+			found, err := b.employees.GetEmployeesByName(ctx, "dawit")
+			if err != nil || len(found) != 1 {
+				t.Errorf("GetEmployeesByName = %+v, %v", found, err)
+			}
+		})
+	}
+}
+
+func TestJobsAndLinks(t *testing.T) {
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			older := data.Job{Title: "Teller", JobType: "internal", CreatedAt: time.Now().Add(-time.Hour)}
+			newer := data.Job{Title: "Auditor", JobType: "external", CreatedAt: time.Now()}
+			for _, job := range []*data.Job{&older, &newer} {
+				if err := b.jobs.CreateJob(ctx, job); err != nil {
+					t.Fatal(err)
+				}
+			}
+			jobs, err := b.jobs.GetAllJobs(ctx)
+			if err != nil || len(jobs) != 2 || jobs[0].ID != newer.ID {
+				t.Errorf("GetAllJobs should list the newest job first: %+v, %v", jobs, err)
+			}
+			internal, err := b.jobs.GetJobByType(ctx, "internal")
+			if err != nil || len(internal) != 1 || internal[0].ID != older.ID {
+				t.Errorf("GetJobByType = %+v, %v", internal, err)
+			}
+
+			if _, err := b.links.CreateApplicationLink(ctx, uuid.NewString(), "internal"); err == nil {
+				t.Error("a link to a missing job should be rejected")
+			}
+			link, err := b.links.CreateApplicationLink(ctx, older.ID, "internal")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Only an unused link that has not expired can be used
+			if err := b.links.MarkApplicationLinkAsUsed(ctx, link.Token, link.ExpiresAt); err != sql.ErrNoRows {
+				t.Errorf("using an expired link = %v, want sql.ErrNoRows", err)
+			}
+			if err := b.links.MarkApplicationLinkAsUsed(ctx, link.Token, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := b.links.GetApplicationLinkByToken(ctx, link.Token); !got.IsUsed || got.JobID != older.ID {
+				t.Errorf("link = %+v", got)
+			}
+			if err := b.links.MarkApplicationLinkAsUsed(ctx, link.Token, time.Now()); err != sql.ErrNoRows {
+				t.Errorf("using a link twice = %v, want sql.ErrNoRows", err)
+			}
+			if err := b.links.MarkApplicationLinkAsUsed(ctx, "missing", time.Now()); err != sql.ErrNoRows {
+				t.Errorf("using a missing link = %v, want sql.ErrNoRows", err)
+			}
+
+			if _, err := b.applications.ApplyExternal(ctx, data.ExternalEmployee{FirstName: "Hana", Jobid: newer.ID}); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.jobs.DeleteJob(ctx, newer.ID); err == nil {
+				t.Error("deleting a job with applications should fail")
+			}
+			if err := b.jobs.DeleteJob(ctx, older.ID); err != nil {
+				t.Fatal(err)
+			}
+			if links, _ := b.links.GetApplicationLinksByJobID(ctx, older.ID); len(links) != 0 {
+				t.Errorf("links of a deleted job = %+v", links)
+			}
+		})
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	errAbort := errors.New("abort")
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			job := data.Job{Title: "Teller", JobType: "internal", CreatedAt: time.Now()}
+			var link data.ApplicationLink
+			err := b.uow.WithTx(ctx, func(tx repository.Stores) error {
+				if err := tx.Jobs.CreateJob(ctx, &job); err != nil {
+					return err
+				}
+				var err error
+				link, err = tx.Links.CreateApplicationLink(ctx, job.ID, "internal")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := b.links.GetApplicationLinkByToken(ctx, link.Token); err != nil || got.JobID != job.ID {
+				t.Errorf("committed link = %+v, %v", got, err)
+			}
+
+			err = b.uow.WithTx(ctx, func(tx repository.Stores) error {
+				if _, err := tx.Applications.ApplyInternal(ctx, data.InternalEmployee{FirstName: "Abel", Jobid: job.ID}); err != nil {
+					return err
+				}
+				if err := tx.Links.MarkApplicationLinkAsUsed(ctx, link.Token, time.Now()); err != nil {
+					return err
+				}
+				newUser(t, tx.Users, "bob", data.RoleManager)
+				return errAbort
+			})
+			if err != errAbort {
+				t.Errorf("WithTx = %v, want the error from fn", err)
+			}
+			if apps, _ := b.applications.GetAllInternalApplications(ctx); len(apps) != 0 {
+				t.Errorf("applications after rollback = %+v", apps)
+			}
+			if got, _ := b.links.GetApplicationLinkByToken(ctx, link.Token); got.IsUsed {
+				t.Error("link marked used after rollback")
+			}
+			if got, _ := b.users.GetUserByName(ctx, "bob"); got != nil {
+				t.Error("user created after rollback")
+			}
+		})
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	b := backends(t)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	var hashes [2][]string
+	for i, name := range []string{"memory", "sqlite"} {
+		for _, action := range []string{data.AuditActionCreate, data.AuditActionDelete} {
+			entry := &data.AuditEntry{Action: action, EntityType: "job", EntityID: "j1", CreatedAt: at, After: []byte(`{"a":1}`)}
+			if err := b[name].audit.AppendAuditEntry(ctx, entry); err != nil {
+				t.Fatal(err)
+			}
+			hashes[i] = append(hashes[i], entry.Hash)
+		}
+		entries, err := b[name].audit.GetAuditEntries(ctx, data.AuditFilter{Action: data.AuditActionCreate, Limit: 10})
+		if err != nil || len(entries) != 1 || entries[0].ID != 1 {
+			t.Errorf("%s: GetAuditEntries = %+v, %v", name, entries, err)
+		}
+	}
+	if !reflect.DeepEqual(hashes[0], hashes[1]) {
+		t.Errorf("memory hashes %v differ from sqlite hashes %v", hashes[0], hashes[1])
+	}
+}
+
+func TestTokenBuckets(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 9, 30, 0, 123456000, time.UTC)
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if fullAt, err := b.rateLimits.GetTokenBucket(ctx, "login 192.0.2.1"); err != nil || !fullAt.IsZero() {
+				t.Fatalf("missing bucket = %v, %v", fullAt, err)
+			}
+
+			// Only one of two requests that saw the same bucket can change it
+			if ok, err := b.rateLimits.SetTokenBucket(ctx, "login 192.0.2.1", time.Time{}, now); !ok || err != nil {
+				t.Fatalf("creating the bucket = %v, %v", ok, err)
+			}
+			if ok, _ := b.rateLimits.SetTokenBucket(ctx, "login 192.0.2.1", time.Time{}, now.Add(time.Second)); ok {
+				t.Error("a bucket was created twice")
+			}
+			fullAt, err := b.rateLimits.GetTokenBucket(ctx, "login 192.0.2.1")
+			if err != nil || !fullAt.Equal(now) {
+				t.Fatalf("bucket = %v, %v, want %v", fullAt, err, now)
+			}
+			if ok, _ := b.rateLimits.SetTokenBucket(ctx, "login 192.0.2.1", fullAt, now.Add(time.Minute)); !ok {
+				t.Error("the bucket was not updated")
+			}
+			if ok, _ := b.rateLimits.SetTokenBucket(ctx, "login 192.0.2.1", fullAt, now.Add(2*time.Minute)); ok {
+				t.Error("a stale bucket was updated")
+			}
+
+			b.rateLimits.SetTokenBucket(ctx, "login 192.0.2.2", time.Time{}, now)
+			if err := b.rateLimits.DeleteTokenBuckets(ctx, now); err != nil {
+				t.Fatal(err)
+			}
+			if fullAt, _ := b.rateLimits.GetTokenBucket(ctx, "login 192.0.2.2"); !fullAt.IsZero() {
+				t.Error("a full bucket was kept")
+			}
+			if fullAt, _ := b.rateLimits.GetTokenBucket(ctx, "login 192.0.2.1"); !fullAt.Equal(now.Add(time.Minute)) {
+				t.Errorf("bucket = %v, want it kept", fullAt)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 9, 30, 0, 0, time.UTC)
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			req := data.IdempotentRequest{Key: "apply_external k1", Fingerprint: "f1", ExpiresAt: now.Add(time.Minute)}
+			if held, err := b.idempotency.ReserveIdempotencyKey(ctx, req, now); held != nil || err != nil {
+				t.Fatalf("reserving a new key = %+v, %v", held, err)
+			}
+			other := req
+			other.Fingerprint = "f2"
+			held, err := b.idempotency.ReserveIdempotencyKey(ctx, other, now)
+			if err != nil || held == nil || held.Fingerprint != "f1" || held.Done() {
+				t.Fatalf("reserving a held key = %+v, %v, want the request in progress", held, err)
+			}
+
+			req.Status, req.ContentType, req.Body = 201, "application/json", []byte(`{"id":1}`)
+			req.ExpiresAt = now.Add(24 * time.Hour)
+			if err := b.idempotency.CompleteIdempotencyKey(ctx, req); err != nil {
+				t.Fatal(err)
+			}
+			held, err = b.idempotency.ReserveIdempotencyKey(ctx, other, now.Add(time.Hour))
+			if err != nil || held == nil || held.Status != 201 || string(held.Body) != `{"id":1}` ||
+				held.ContentType != "application/json" || !held.ExpiresAt.Equal(req.ExpiresAt) {
+				t.Fatalf("completed request = %+v, %v", held, err)
+			}
+
+			// An expired request frees its key
+			later := now.Add(25 * time.Hour)
+			other.ExpiresAt = later.Add(time.Minute)
+			if held, err := b.idempotency.ReserveIdempotencyKey(ctx, other, later); held != nil || err != nil {
+				t.Fatalf("reserving an expired key = %+v, %v", held, err)
+			}
+			if err := b.idempotency.DeleteIdempotencyKey(ctx, other.Key); err != nil {
+				t.Fatal(err)
+			}
+			if held, _ := b.idempotency.ReserveIdempotencyKey(ctx, req, later); held != nil {
+				t.Errorf("released key is held by %+v", held)
+			}
+
+			if err := b.idempotency.DeleteExpiredIdempotencyKeys(ctx, later.Add(24*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if held, _ := b.idempotency.ReserveIdempotencyKey(ctx, other, now); held != nil {
+				t.Errorf("expired request kept: %+v", held)
+			}
+		})
+	}
+}
+
+func TestDuplicateApplications(t *testing.T) {
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			job := data.Job{Title: "Teller", DuplicatePolicy: data.DuplicatePolicyMerge}
+			other := data.Job{Title: "Auditor"}
+			for _, j := range []*data.Job{&job, &other} {
+				if err := b.jobs.CreateJob(ctx, j); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, _ := b.jobs.GetJobById(ctx, job.ID); got.DuplicatePolicy != data.DuplicatePolicyMerge {
+				t.Errorf("duplicate policy = %q", got.DuplicatePolicy)
+			}
+
+			hana := data.ExternalEmployee{FirstName: "Hana", Email: "Hana@Example.com ", Phone: "+251 911-000-111", Jobid: job.ID, OtherJobExp: "Teller"}
+			abel := data.InternalEmployee{FirstName: "Abel", FileNumber: "BB-0002", Jobid: job.ID}
+			hanaID, err := b.applications.ApplyExternal(ctx, hana)
+			if err != nil || hanaID == "" {
+				t.Fatalf("ApplyExternal = %q, %v", hanaID, err)
+			}
+			if _, err := b.applications.ApplyExternal(ctx, data.ExternalEmployee{FirstName: "Sara", Jobid: job.ID}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.applications.ApplyInternal(ctx, abel); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, app := range []data.ExternalEmployee{
+				{Email: "hana@example.com", Jobid: job.ID},
+				{Phone: "251911000111", Jobid: job.ID},
+			} {
+				if id, err := b.applications.FindExternalDuplicate(ctx, app); err != nil || id != hanaID {
+					t.Errorf("FindExternalDuplicate(%+v) = %q, %v, want Hana's application", app, id, err)
+				}
+			}
+			for _, app := range []data.ExternalEmployee{
+				{Email: "hana@example.com", Jobid: other.ID},
+				{Email: "sara@example.com", Jobid: job.ID},
+				{Jobid: job.ID},
+			} {
+				if id, err := b.applications.FindExternalDuplicate(ctx, app); !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("FindExternalDuplicate(%+v) = %q, %v, want sql.ErrNoRows", app, id, err)
+				}
+			}
+			if _, err := b.applications.FindInternalDuplicate(ctx, data.InternalEmployee{Jobid: job.ID}); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("an application without a file number matched %v", err)
+			}
+			abelID, err := b.applications.FindInternalDuplicate(ctx, data.InternalEmployee{FileNumber: "bb 0002", Jobid: job.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A revision is a new application that leaves the earlier one
+			// as it is
+			revision := data.InternalEmployee{FirstName: "Abel", FileNumber: "BB 0002", OtherBankExp: "5 years", Jobid: job.ID}
+			revisionID, err := b.applications.ReviseInternalApplication(ctx, abelID, revision)
+			if err != nil || revisionID == "" || revisionID == abelID {
+				t.Fatalf("ReviseInternalApplication = %q, %v", revisionID, err)
+			}
+			internals, _ := b.applications.GetInternalApplicationsByJobID(ctx, job.ID)
+			if len(internals) != 2 || !reflect.DeepEqual(internals[0], abel) && !reflect.DeepEqual(internals[1], abel) {
+				t.Errorf("internal applications = %+v, want %+v unchanged among them", internals, abel)
+			}
+			if id, _ := b.applications.FindInternalDuplicate(ctx, revision); id != abelID {
+				t.Errorf("FindInternalDuplicate found %q, want the revised application", id)
+			}
+			// A match with only the revision finds the application it revises
+			later := data.ExternalEmployee{FirstName: "Hana", Email: "hana.b@example.com", Phone: "0911 222 333", Jobid: job.ID}
+			if _, err := b.applications.ReviseExternalApplication(ctx, hanaID, later); err != nil {
+				t.Fatal(err)
+			}
+			if id, err := b.applications.FindExternalDuplicate(ctx, data.ExternalEmployee{Phone: "0911-222-333", Jobid: job.ID}); id != hanaID {
+				t.Errorf("FindExternalDuplicate by the revision's phone = %q, %v, want Hana's application", id, err)
+			}
+			if _, err := b.applications.ReviseExternalApplication(ctx, hanaID, data.ExternalEmployee{Jobid: "missing"}); err == nil {
+				t.Error("a revision for a missing job was stored")
+			}
+
+			contacts, err := b.applications.GetApplicationContacts(ctx)
+			if err != nil || len(contacts) != 5 {
+				t.Fatalf("GetApplicationContacts = %+v, %v", contacts, err)
+			}
+			byID := make(map[string]data.ApplicationContact)
+			for _, c := range contacts {
+				byID[c.ID] = c
+			}
+			if c := byID[abelID]; c.Kind != data.ApplicationKindInternal || c.JobTitle != "Teller" || c.FileNumber != "BB-0002" || c.RevisionOf != "" {
+				t.Errorf("internal contact = %+v", c)
+			}
+			if c := byID[revisionID]; c.Kind != data.ApplicationKindInternal || c.RevisionOf != abelID {
+				t.Errorf("revision contact = %+v", c)
+			}
+			if c := byID[hanaID]; c.Kind != data.ApplicationKindExternal || c.JobTitle != "Teller" || c.Email != hana.Email || c.Phone != hana.Phone {
+				t.Errorf("external contact = %+v", c)
+			}
+
+			// Rows from before the application code set every column
+			if db, ok := b.uow.(*repository.DB); ok {
+				for _, table := range []string{"internal_applications", "external_applications"} {
+					if _, err := db.Exec(`INSERT INTO ` + table + ` (jobid) VALUES (NULL)`); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if contacts, err := b.applications.GetApplicationContacts(ctx); err != nil || len(contacts) != 7 {
+					t.Errorf("GetApplicationContacts with empty rows = %d contacts, %v", len(contacts), err)
+				}
+			}
+
+			if err := b.jobs.LockJob(ctx, job.ID); err != nil {
+				t.Errorf("LockJob = %v", err)
+			}
+			if err := b.jobs.LockJob(ctx, uuid.NewString()); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("LockJob of a missing job = %v, want sql.ErrNoRows", err)
+			}
+		})
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	s := memory.New()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.CreateEmployee(ctx, data.Employee{FullName: "Employee"})
+			s.AppendAuditEntry(ctx, &data.AuditEntry{Action: data.AuditActionCreate, CreatedAt: time.Now()})
+			s.GetAllEmployees(ctx)
+		}()
+	}
+	wg.Wait()
+
+	employees, _ := s.GetAllEmployees(ctx)
+	if len(employees) != 20 {
+		t.Errorf("%d employees, want 20", len(employees))
+	}
+	prev := ""
+	err := s.WalkAuditLog(ctx, func(entry *data.AuditEntry) error {
+		if entry.PrevHash != prev {
+			return errors.New("chain broken")
+		}
+		prev = entry.Hash
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,399 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/google/uuid"
+)
+
+// user returns the index of a user, or -1. The caller holds the lock.
+func (s *Store) user(match func(*data.User) bool) int {
+	for i := range s.users {
+		if match(&s.users[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) userByID(id uuid.UUID) int {
+	return s.user(func(u *data.User) bool { return u.Id == id })
+}
+
+// getUser returns a copy of the first matching user, or nil
+func (s *Store) getUser(match func(*data.User) bool) (*data.User, error) {
+	defer s.rlock()()
+
+	i := s.user(match)
+	if i < 0 {
+		return nil, nil
+	}
+	user := s.users[i]
+	return &user, nil
+}
+
+// insertUser applies the column defaults to user and stores a copy. The
+// caller holds the lock.
+func (s *Store) insertUser(user *data.User) error {
+	if user.Status == "" {
+		user.Status = data.UserStatusActive
+	}
+	if user.AuthProvider == "" {
+		user.AuthProvider = data.AuthProviderLocal
+	}
+	if s.userByID(user.Id) >= 0 {
+		return constraint("user %s already exists", user.Id)
+	}
+	if err := s.checkEmail(user.Id, user.Email); err != nil {
+		return err
+	}
+	s.users = append(s.users, *user)
+	return nil
+}
+
+// checkEmail enforces the unique index on lower(email)
+func (s *Store) checkEmail(id uuid.UUID, email string) error {
+	if email == "" {
+		return nil
+	}
+	other := s.user(func(u *data.User) bool { return u.Id != id && strings.EqualFold(u.Email, email) })
+	if other >= 0 {
+		return constraint("email %s is already in use", email)
+	}
+	return nil
+}
+
+// CreateUser creates the user with one role, or nothing if the role is unknown
+func (s *Store) CreateUser(ctx context.Context, user *data.User, role, district string) error {
+	defer s.lock()()
+
+	if !s.hasRole(role) {
+		return repository.ErrUnknownRole
+	}
+	if err := s.insertUser(user); err != nil {
+		return err
+	}
+	s.replaceRoles(user.Id, []data.UserRole{{Role: role, District: district}})
+	return nil
+}
+
+// CreateExternalUser creates the user, identity and roles, or nothing at all
+func (s *Store) CreateExternalUser(ctx context.Context, user *data.User, ext data.ExternalIdentity, roles []data.UserRole) error {
+	defer s.lock()()
+
+	if err := s.checkRoles(roles); err != nil {
+		return err
+	}
+	key := identityKey{ext.Provider, ext.Subject}
+	if _, ok := s.identities[key]; ok {
+		return constraint("identity %s/%s is already linked", ext.Provider, ext.Subject)
+	}
+	if err := s.insertUser(user); err != nil {
+		return err
+	}
+	s.identities[key] = &identity{userID: user.Id, createdAt: user.CreatedAt}
+	s.replaceRoles(user.Id, roles)
+	return nil
+}
+
+func (s *Store) GetUserByName(ctx context.Context, name string) (*data.User, error) {
+	return s.getUser(func(u *data.User) bool { return u.Name == name })
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id uuid.UUID) (*data.User, error) {
+	return s.getUser(func(u *data.User) bool { return u.Id == id })
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	return s.getUser(func(u *data.User) bool { return u.Email != "" && strings.EqualFold(u.Email, email) })
+}
+
+func (s *Store) GetUserByIdentity(ctx context.Context, provider, subject string) (*data.User, error) {
+	unlock := s.rlock()
+	id, ok := s.identities[identityKey{provider, subject}]
+	unlock()
+	if !ok {
+		return nil, nil
+	}
+	return s.GetUserByID(ctx, id.userID)
+}
+
+// UpdateUser saves a user's name, email, branch and auth provider
+func (s *Store) UpdateUser(ctx context.Context, user *data.User) error {
+	defer s.lock()()
+
+	i := s.userByID(user.Id)
+	if i < 0 {
+		return nil
+	}
+	if err := s.checkEmail(user.Id, user.Email); err != nil {
+		return err
+	}
+	stored := &s.users[i]
+	stored.Name, stored.Email, stored.Branch = user.Name, user.Email, user.Branch
+	stored.AuthProvider, stored.UpdatedAt = user.AuthProvider, user.UpdatedAt
+	return nil
+}
+
+func (s *Store) SetUserStatus(ctx context.Context, userID uuid.UUID, status string) error {
+	defer s.lock()()
+
+	if i := s.userByID(userID); i >= 0 {
+		s.users[i].Status = status
+		s.users[i].UpdatedAt = s.now()
+	}
+	return nil
+}
+
+func (s *Store) BumpTokenVersion(ctx context.Context, userID uuid.UUID) error {
+	defer s.lock()()
+
+	if i := s.userByID(userID); i >= 0 {
+		s.users[i].TokenVersion++
+	}
+	return nil
+}
+
+// LockUser only checks that the user exists: WithTx already runs one
+// transaction at a time
+func (s *Store) LockUser(ctx context.Context, userID uuid.UUID) error {
+	defer s.lock()()
+
+	if s.userByID(userID) < 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) RecordLogin(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	defer s.lock()()
+
+	if i := s.userByID(userID); i >= 0 {
+		s.users[i].LastLogin = &at
+	}
+	return nil
+}
+
+// DeleteUser removes a user with everything that cascades from it. API
+// keys the user created are kept with no creator.
+func (s *Store) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	defer s.lock()()
+
+	i := s.userByID(userID)
+	if i < 0 {
+		return nil
+	}
+	s.users = append(s.users[:i], s.users[i+1:]...)
+	delete(s.userRoles, userID)
+	delete(s.mfa, userID)
+	delete(s.recoveryCodes, userID)
+	for key, id := range s.identities {
+		if id.userID == userID {
+			delete(s.identities, key)
+		}
+	}
+	for i := range s.apiKeys {
+		if s.apiKeys[i].CreatedBy == userID {
+			s.apiKeys[i].CreatedBy = uuid.Nil
+		}
+	}
+	return nil
+}
+
+// GetAllUsers lists users newest first in the shape of
+// AuthRepository.GetAllUsers
+func (s *Store) GetAllUsers(ctx context.Context) ([]map[string]interface{}, error) {
+	unlock := s.rlock()
+	users := append([]data.User(nil), s.users...)
+	unlock()
+	sort.SliceStable(users, func(i, j int) bool { return users[i].CreatedAt.After(users[j].CreatedAt) })
+
+	out := []map[string]interface{}{}
+	for _, user := range users {
+		access, err := s.GetUserAccess(ctx, user.Id)
+		if err != nil {
+			return nil, err
+		}
+		m := map[string]interface{}{
+			"id":            user.Id.String(),
+			"name":          user.Name,
+			"email":         user.Email,
+			"branch":        user.Branch,
+			"status":        user.Status,
+			"auth_provider": user.AuthProvider,
+			"last_login":    nil,
+			"created_at":    user.CreatedAt,
+			"updated_at":    user.UpdatedAt,
+			"role":          access.PrimaryRole().Role,
+			"roles":         access.RoleNames(),
+			"district":      nil,
+		}
+		if user.LastLogin != nil {
+			m["last_login"] = *user.LastLogin
+		}
+		if m["role"] == "" {
+			m["role"] = "unknown"
+		}
+		if district := access.District(); district != "" {
+			m["district"] = district
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// GetUserAccess returns the user's roles by name and the sorted union of
+// their permissions
+func (s *Store) GetUserAccess(ctx context.Context, userID uuid.UUID) (data.UserAccess, error) {
+	defer s.rlock()()
+
+	var access data.UserAccess
+	permissions := make(map[string]bool)
+	for _, role := range s.roles {
+		district, ok := s.userRoles[userID][role.Name]
+		if !ok {
+			continue
+		}
+		access.Roles = append(access.Roles, data.UserRole{Role: role.Name, District: district})
+		for _, permission := range role.Permissions {
+			permissions[permission] = true
+		}
+	}
+	sort.Slice(access.Roles, func(i, j int) bool { return access.Roles[i].Role < access.Roles[j].Role })
+	for permission := range permissions {
+		access.Permissions = append(access.Permissions, permission)
+	}
+	sort.Strings(access.Permissions)
+	return access, nil
+}
+
+func (s *Store) GetRoleByName(ctx context.Context, name string) (*data.Role, error) {
+	roles, err := s.GetRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if roles[i].Name == name {
+			return &roles[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// GetRoles lists the roles by name
+func (s *Store) GetRoles(ctx context.Context) ([]data.Role, error) {
+	defer s.rlock()()
+
+	roles := make([]data.Role, len(s.roles))
+	for i, role := range s.roles {
+		role.Permissions = append([]string{}, role.Permissions...)
+		roles[i] = role
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// hasRole reports whether a role exists. The caller holds the lock.
+func (s *Store) hasRole(name string) bool {
+	for _, role := range s.roles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRoles returns repository.ErrUnknownRole if a role does not exist
+func (s *Store) checkRoles(roles []data.UserRole) error {
+	for _, role := range roles {
+		if !s.hasRole(role.Role) {
+			return repository.ErrUnknownRole
+		}
+	}
+	return nil
+}
+
+// replaceRoles sets the user's roles. The caller holds the lock and has
+// checked the roles.
+func (s *Store) replaceRoles(userID uuid.UUID, roles []data.UserRole) {
+	held := make(map[string]string)
+	for _, role := range roles {
+		held[role.Role] = role.District
+	}
+	s.userRoles[userID] = held
+}
+
+// AddUserRole grants a role, or updates its district if the user holds it
+func (s *Store) AddUserRole(ctx context.Context, userID uuid.UUID, role, district string) error {
+	defer s.lock()()
+
+	if !s.hasRole(role) {
+		return repository.ErrUnknownRole
+	}
+	if s.userByID(userID) < 0 {
+		return constraint("user %s does not exist", userID)
+	}
+	if s.userRoles[userID] == nil {
+		s.userRoles[userID] = make(map[string]string)
+	}
+	s.userRoles[userID][role] = district
+	return nil
+}
+
+// RemoveUserRole returns sql.ErrNoRows if the user does not hold the role
+func (s *Store) RemoveUserRole(ctx context.Context, userID uuid.UUID, role string) error {
+	defer s.lock()()
+
+	if _, ok := s.userRoles[userID][role]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.userRoles[userID], role)
+	return nil
+}
+
+func (s *Store) SetUserRole(ctx context.Context, userID uuid.UUID, role, district string) error {
+	return s.SetUserRoles(ctx, userID, []data.UserRole{{Role: role, District: district}})
+}
+
+// SetUserRoles replaces all of a user's roles, or none if one is unknown
+func (s *Store) SetUserRoles(ctx context.Context, userID uuid.UUID, roles []data.UserRole) error {
+	defer s.lock()()
+
+	if err := s.checkRoles(roles); err != nil {
+		return err
+	}
+	if s.userByID(userID) < 0 {
+		return constraint("user %s does not exist", userID)
+	}
+	s.replaceRoles(userID, roles)
+	return nil
+}
+
+func (s *Store) LinkIdentity(ctx context.Context, userID uuid.UUID, ext data.ExternalIdentity) error {
+	defer s.lock()()
+
+	key := identityKey{ext.Provider, ext.Subject}
+	if _, ok := s.identities[key]; ok {
+		return constraint("identity %s/%s is already linked", ext.Provider, ext.Subject)
+	}
+	if s.userByID(userID) < 0 {
+		return constraint("user %s does not exist", userID)
+	}
+	s.identities[key] = &identity{userID: userID, createdAt: s.now()}
+	return nil
+}
+
+func (s *Store) TouchIdentity(ctx context.Context, provider, subject string, at time.Time) error {
+	defer s.lock()()
+
+	if id, ok := s.identities[identityKey{provider, subject}]; ok {
+		id.lastLogin = &at
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/google/uuid"
+)
+
+// GetUserMFA returns the TOTP enrolment for a user, or nil if the user has
+// never started enrolment.
+func (repo *AuthRepository) GetUserMFA(ctx context.Context, userID uuid.UUID) (*data.UserMFA, error) {
+	var mfa data.UserMFA
+	var enabledAt, lockedUntil sql.NullTime
+	query := `SELECT user_id, secret, enabled, last_used_step, created_at, enabled_at, failed_attempts, locked_until
+			  FROM user_mfa WHERE user_id = $1`
+	err := repo.DB.QueryRowContext(ctx, query, userID).Scan(&mfa.UserID, &mfa.Secret, &mfa.Enabled, &mfa.LastUsedStep,
+		&mfa.CreatedAt, &enabledAt, &mfa.FailedAttempts, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		mfa.EnabledAt = &enabledAt.Time
+	}
+	if lockedUntil.Valid {
+		mfa.LockedUntil = &lockedUntil.Time
+	}
+	return &mfa, nil
+}
+
+// SaveUserMFA stores a new, not yet enabled, TOTP secret for a user and
+// replaces any previous recovery codes with the given hashes. Failed
+// attempts and a lockout carry over, so enrolling again does not reset them.
+func (repo *AuthRepository) SaveUserMFA(ctx context.Context, mfa *data.UserMFA, recoveryCodeHashes []string) error {
+	return inTx(ctx, repo.DB, func(tx *Tx) error {
+		query := `INSERT INTO user_mfa (user_id, secret, enabled, last_used_step, created_at, enabled_at)
+				  VALUES ($1, $2, false, 0, $3, NULL)
+				  ON CONFLICT (user_id) DO UPDATE
+				  SET secret = EXCLUDED.secret, enabled = false, last_used_step = 0,
+				      created_at = EXCLUDED.created_at, enabled_at = NULL`
+		if _, err := tx.ExecContext(ctx, query, mfa.UserID, mfa.Secret, mfa.CreatedAt); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, mfa.UserID); err != nil {
+			return err
+		}
+		for _, hash := range recoveryCodeHashes {
+			_, err := tx.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, mfa.UserID, hash)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// EnableUserMFA marks an enrolment as confirmed and records the time step of
+// the confirming code so that it cannot be replayed at login.
+func (repo *AuthRepository) EnableUserMFA(ctx context.Context, userID uuid.UUID, step int64) error {
+	query := `UPDATE user_mfa SET enabled = true, enabled_at = $2, last_used_step = $3 WHERE user_id = $1`
+	_, err := repo.DB.ExecContext(ctx, query, userID, time.Now(), step)
+	return err
+}
+
+// AdvanceMFAStep records step as the last accepted TOTP time step. It reports
+// false when the step is not newer than the stored one, i.e. the code was
+// already used.
+func (repo *AuthRepository) AdvanceMFAStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	query := `UPDATE user_mfa SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+	result, err := repo.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// UseRecoveryCode consumes an unused recovery code. It reports false if the
+// hash does not match an unused code for the user.
+func (repo *AuthRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	query := `UPDATE user_recovery_codes SET used_at = $3 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := repo.DB.ExecContext(ctx, query, userID, codeHash, time.Now())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// DeleteUserMFA removes a user's TOTP enrolment and recovery codes
+func (repo *AuthRepository) DeleteUserMFA(ctx context.Context, userID uuid.UUID) error {
+	return inTx(ctx, repo.DB, func(tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+// RecordMFAFailure counts an invalid code. The attempt that reaches limit
+// locks the enrolment until lockUntil and starts the count over.
+func (repo *AuthRepository) RecordMFAFailure(ctx context.Context, userID uuid.UUID, limit int, lockUntil time.Time) error {
+	query := `UPDATE user_mfa SET
+			      failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+			      locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN $3 ELSE locked_until END
+			  WHERE user_id = $1`
+	_, err := repo.DB.ExecContext(ctx, query, userID, limit, lockUntil)
+	return err
+}
+
+// ResetMFAFailures clears the count of invalid codes after a valid one
+func (repo *AuthRepository) ResetMFAFailures(ctx context.Context, userID uuid.UUID) error {
+	query := `UPDATE user_mfa SET failed_attempts = 0, locked_until = NULL WHERE user_id = $1`
+	_, err := repo.DB.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RateLimitRepository struct {
+	DB Querier
+}
+
+func NewRateLimitRepository(db Querier) *RateLimitRepository {
+	return &RateLimitRepository{DB: db}
+}
+
+func (repo *RateLimitRepository) GetTokenBucket(ctx context.Context, bucket string) (time.Time, error) {
+	var fullAt time.Time
+	err := repo.DB.QueryRowContext(ctx, `SELECT full_at FROM rate_limits WHERE bucket = $1`, bucket).Scan(&fullAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return fullAt.UTC(), err
+}
+
+// SetTokenBucket inserts a missing bucket, or updates the bucket only if
+// full_at is still prev, so that two nodes cannot both take the last token
+func (repo *RateLimitRepository) SetTokenBucket(ctx context.Context, bucket string, prev, fullAt time.Time) (bool, error) {
+	var result sql.Result
+	var err error
+	if prev.IsZero() {
+		result, err = repo.DB.ExecContext(ctx, `INSERT INTO rate_limits (bucket, full_at) VALUES ($1, $2)
+			  ON CONFLICT (bucket) DO NOTHING`, bucket, fullAt.UTC())
+	} else {
+		result, err = repo.DB.ExecContext(ctx, `UPDATE rate_limits SET full_at = $3 WHERE bucket = $1 AND full_at = $2`,
+			bucket, prev.UTC(), fullAt.UTC())
+	}
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (repo *RateLimitRepository) DeleteTokenBuckets(ctx context.Context, t time.Time) error {
+	_, err := repo.DB.ExecContext(ctx, `DELETE FROM rate_limits WHERE full_at <= $1`, t.UTC())
+	return err
+}
@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/google/uuid"
+)
+
+// The store interfaces below are what the services depend on, one per
+// aggregate. Repository, AuthRepository and AuditRepository implement them on
+// top of SQL; package memory implements them in memory for tests and demos.
+// Implementations keep the SQL versions' not-found conventions: employee,
+// job and link getters return sql.ErrNoRows, while user, role, MFA and API
+// key getters return nil without an error.
+
+// EmployeeStore holds employees and their promotion scores
+type EmployeeStore interface {
+	GetEmployeesByID(ctx context.Context, id int) (data.Employee, error)
+	GetEmployeeByID(ctx context.Context, id int) (data.Employee, error)
+	GetEmployeeByFileNumber(ctx context.Context, fileNumber string) (data.Employee, error)
+	// GetEmployeesByName matches part of the full name, ignoring case
+	GetEmployeesByName(ctx context.Context, name string) ([]data.Employee, error)
+	GetAllEmployees(ctx context.Context) ([]data.Employee, error)
+	CreateEmployee(ctx context.Context, emp data.Employee) error
+	UpdateEmployee(ctx context.Context, emp data.Employee) error
+	UpdateEmployeeIndividualPMS(ctx context.Context, employeeID int, pmsScore float64) error
+	UpdateEmployeeManagerRecommendation(ctx context.Context, employeeID int, recScore float64) error
+	UpdateEmployeeDistrictRecommendation(ctx context.Context, employeeID int, recScore float64) error
+	CalculateExperienceScore(ctx context.Context, employeeID int) error
+	GetmaxValuesofexp(ctx context.Context, emp data.Employee) (int, int, int, error)
+}
+
+// JobStore holds job postings
+type JobStore interface {
+	// CreateJob sets the generated ID on job
+	CreateJob(ctx context.Context, job *data.Job) error
+	GetAllJobs(ctx context.Context) ([]data.Job, error)
+	GetJobById(ctx context.Context, id string) (data.Job, error)
+	GetJobByType(ctx context.Context, jobType string) ([]data.Job, error)
+	UpdateJob(ctx context.Context, job data.Job) error
+	DeleteJob(ctx context.Context, id string) error
+	// LockJob holds off other transactions that lock the job until this one
+	// ends. It returns sql.ErrNoRows if there is no such job.
+	LockJob(ctx context.Context, id string) error
+}
+
+// ApplicationStore holds internal and external job applications
+type ApplicationStore interface {
+	ApplyInternal(ctx context.Context, app data.InternalEmployee) (string, error)
+	ApplyExternal(ctx context.Context, app data.ExternalEmployee) (string, error)
+	GetAllInternalApplications(ctx context.Context) ([]data.InternalEmployee, error)
+	GetAllExternalApplications(ctx context.Context) ([]data.ExternalEmployee, error)
+	GetInternalApplicationsByJobID(ctx context.Context, jobID string) ([]data.InternalEmployee, error)
+	GetExternalApplicationsByJobID(ctx context.Context, jobID string) ([]data.ExternalEmployee, error)
+	// AutoMatchInternalApplication links an application to the first
+	// employee whose name matches and resets that employee's scores. It
+	// returns a zero Employee if nobody matches.
+	AutoMatchInternalApplication(ctx context.Context, app data.InternalEmployee) (data.Employee, error)
+	// FindInternalDuplicate returns the ID of an earlier application for
+	// the same job with the same normalised file number, or sql.ErrNoRows.
+	// A match with a revision returns the application it revises.
+	FindInternalDuplicate(ctx context.Context, app data.InternalEmployee) (string, error)
+	// FindExternalDuplicate returns the ID of an earlier application for
+	// the same job with the same normalised email or phone, or sql.ErrNoRows.
+	// A match with a revision returns the application it revises.
+	FindExternalDuplicate(ctx context.Context, app data.ExternalEmployee) (string, error)
+	// ReviseInternalApplication stores a later application for the same job
+	// as a revision of application id, which is left as it is, and returns
+	// the revision's ID
+	ReviseInternalApplication(ctx context.Context, id string, app data.InternalEmployee) (string, error)
+	// ReviseExternalApplication is ReviseInternalApplication for external
+	// applications
+	ReviseExternalApplication(ctx context.Context, id string, app data.ExternalEmployee) (string, error)
+	// GetApplicationContacts returns every application, internal ones first
+	GetApplicationContacts(ctx context.Context) ([]data.ApplicationContact, error)
+}
+
+// LinkStore holds the one-off application links sent to candidates
+type LinkStore interface {
+	CreateApplicationLink(ctx context.Context, jobID, linkType string) (data.ApplicationLink, error)
+	GetApplicationLinkByToken(ctx context.Context, token string) (data.ApplicationLink, error)
+	GetApplicationLinksByJobID(ctx context.Context, jobID string) ([]data.ApplicationLink, error)
+	// MarkApplicationLinkAsUsed uses up a link that is unused and has not
+	// expired at now. It returns sql.ErrNoRows for any other link, so that
+	// two submissions cannot both use one link.
+	MarkApplicationLinkAsUsed(ctx context.Context, token string, now time.Time) error
+}
+
+// UserStore holds accounts, their roles and linked external identities
+type UserStore interface {
+	CreateUser(ctx context.Context, user *data.User, role, district string) error
+	CreateExternalUser(ctx context.Context, user *data.User, identity data.ExternalIdentity, roles []data.UserRole) error
+	GetUserByName(ctx context.Context, name string) (*data.User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (*data.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*data.User, error)
+	GetUserByIdentity(ctx context.Context, provider, subject string) (*data.User, error)
+	UpdateUser(ctx context.Context, user *data.User) error
+	SetUserStatus(ctx context.Context, userID uuid.UUID, status string) error
+	BumpTokenVersion(ctx context.Context, userID uuid.UUID) error
+	// LockUser holds off other transactions that lock the user until this
+	// one ends, so role changes that check the user's other roles run one
+	// at a time. It returns sql.ErrNoRows if there is no such user.
+	LockUser(ctx context.Context, userID uuid.UUID) error
+	RecordLogin(ctx context.Context, userID uuid.UUID, at time.Time) error
+	DeleteUser(ctx context.Context, userID uuid.UUID) error
+	GetAllUsers(ctx context.Context) ([]map[string]interface{}, error)
+
+	GetUserAccess(ctx context.Context, userID uuid.UUID) (data.UserAccess, error)
+	GetRoleByName(ctx context.Context, name string) (*data.Role, error)
+	GetRoles(ctx context.Context) ([]data.Role, error)
+	AddUserRole(ctx context.Context, userID uuid.UUID, role, district string) error
+	RemoveUserRole(ctx context.Context, userID uuid.UUID, role string) error
+	SetUserRole(ctx context.Context, userID uuid.UUID, role, district string) error
+	SetUserRoles(ctx context.Context, userID uuid.UUID, roles []data.UserRole) error
+
+	LinkIdentity(ctx context.Context, userID uuid.UUID, identity data.ExternalIdentity) error
+	TouchIdentity(ctx context.Context, provider, subject string, at time.Time) error
+}
+
+// MFAStore holds TOTP enrolments and recovery codes
+type MFAStore interface {
+	GetUserMFA(ctx context.Context, userID uuid.UUID) (*data.UserMFA, error)
+	SaveUserMFA(ctx context.Context, mfa *data.UserMFA, recoveryCodeHashes []string) error
+	EnableUserMFA(ctx context.Context, userID uuid.UUID, step int64) error
+	AdvanceMFAStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error)
+	DeleteUserMFA(ctx context.Context, userID uuid.UUID) error
+	RecordMFAFailure(ctx context.Context, userID uuid.UUID, limit int, lockUntil time.Time) error
+	ResetMFAFailures(ctx context.Context, userID uuid.UUID) error
+}
+
+// APIKeyStore holds API keys for machine clients
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key *data.APIKey) error
+	GetAPIKey(ctx context.Context, id uuid.UUID) (*data.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*data.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]data.APIKey, error)
+	TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time, ip string) error
+	RevokeAPIKey(ctx context.Context, id uuid.UUID, at time.Time) (bool, error)
+}
+
+// AuditStore holds the hash-chained audit log
+type AuditStore interface {
+	AppendAuditEntry(ctx context.Context, entry *data.AuditEntry) error
+	GetAuditEntries(ctx context.Context, filter data.AuditFilter) ([]data.AuditEntry, error)
+	WalkAuditLog(ctx context.Context, fn func(entry *data.AuditEntry) error) error
+}
+
+// RateLimitStore holds the token buckets of the rate limiter. A bucket is
+// kept as the time at which it is full again; a bucket that is full may be
+// missing.
+type RateLimitStore interface {
+	// GetTokenBucket returns when the bucket is full, or the zero time if
+	// there is no bucket
+	GetTokenBucket(ctx context.Context, bucket string) (time.Time, error)
+	// SetTokenBucket sets when the bucket is full, provided that it is still
+	// prev, which is the zero time for a missing bucket. It reports false if
+	// another request changed the bucket in the meantime.
+	SetTokenBucket(ctx context.Context, bucket string, prev, fullAt time.Time) (bool, error)
+	// DeleteTokenBuckets removes the buckets that are full at t
+	DeleteTokenBuckets(ctx context.Context, t time.Time) error
+}
+
+// IdempotencyStore holds requests made with an Idempotency-Key and their
+// responses
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey stores req, unless a request with the same key
+	// has not expired at now. It returns that request instead, or nil if req
+	// was stored.
+	ReserveIdempotencyKey(ctx context.Context, req data.IdempotentRequest, now time.Time) (*data.IdempotentRequest, error)
+	// CompleteIdempotencyKey stores the response and new expiry of req
+	CompleteIdempotencyKey(ctx context.Context, req data.IdempotentRequest) error
+	// DeleteIdempotencyKey releases a key, so that it can be used again
+	DeleteIdempotencyKey(ctx context.Context, key string) error
+	// DeleteExpiredIdempotencyKeys removes the requests that have expired at t
+	DeleteExpiredIdempotencyKeys(ctx context.Context, t time.Time) error
+}
+
+// Stores holds one of each store, all running on the same database handle
+// or transaction
+type Stores struct {
+	Employees    EmployeeStore
+	Jobs         JobStore
+	Applications ApplicationStore
+	Links        LinkStore
+	Users        UserStore
+	MFA          MFAStore
+	APIKeys      APIKeyStore
+	Audit        AuditStore
+	RateLimits   RateLimitStore
+	Idempotency  IdempotencyStore
+}
+
+// NewStores returns the SQL stores on q
+func NewStores(q Querier) Stores {
+	repo := NewRepository(q)
+	auth := NewAuthRepository(q)
+	return Stores{
+		Employees:    repo,
+		Jobs:         repo,
+		Applications: repo,
+		Links:        repo,
+		Users:        auth,
+		MFA:          auth,
+		APIKeys:      auth,
+		Audit:        NewAuditRepository(q),
+		RateLimits:   NewRateLimitRepository(q),
+		Idempotency:  NewIdempotencyRepository(q),
+	}
+}
+
+// UnitOfWork groups writes to several stores into one transaction
+type UnitOfWork interface {
+	// WithTx calls fn with stores bound to a new transaction. What fn writes
+	// through them is committed if fn returns nil and discarded if it
+	// returns an error. fn must not use other stores in the meantime.
+	WithTx(ctx context.Context, fn func(tx Stores) error) error
+}
+
+var (
+	_ UnitOfWork       = (*DB)(nil)
+	_ EmployeeStore    = (*Repository)(nil)
+	_ JobStore         = (*Repository)(nil)
+	_ ApplicationStore = (*Repository)(nil)
+	_ LinkStore        = (*Repository)(nil)
+	_ UserStore        = (*AuthRepository)(nil)
+	_ MFAStore         = (*AuthRepository)(nil)
+	_ APIKeyStore      = (*AuthRepository)(nil)
+	_ AuditStore       = (*AuditRepository)(nil)
+	_ RateLimitStore   = (*RateLimitRepository)(nil)
+	_ IdempotencyStore = (*IdempotencyRepository)(nil)
+)
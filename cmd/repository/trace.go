@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/brehan/bank/cmd/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dbSystems maps dialects to the OpenTelemetry db.system values
+var dbSystems = map[Dialect]attribute.KeyValue{
+	Postgres: semconv.DBSystemPostgreSQL,
+	SQLite:   semconv.DBSystemSqlite,
+}
+
+// startQuery starts the span of a query. The statement is recorded as
+// written, with placeholders; argument values are never recorded.
+func startQuery(ctx context.Context, dialect Dialect, query string) (context.Context, trace.Span) {
+	operation := queryOperation(query)
+	return tracing.Tracer().Start(ctx, "sql "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			dbSystems[dialect],
+			semconv.DBOperation(operation),
+			semconv.DBStatement(strings.TrimSpace(query)),
+		),
+	)
+}
+
+// endQuery ends the span of a query. sql.ErrNoRows is how getters report a
+// missing row and is not a failure.
+func endQuery(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tracing.Fail(span, err)
+	}
+	span.End()
+}
+
+// queryOperation returns the first keyword of a query, e.g. SELECT
+func queryOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "UNKNOWN"
+	}
+	return strings.ToUpper(fields[0])
+}
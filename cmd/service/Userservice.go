@@ -1,58 +1,90 @@
 package service
 
 import (
+    "context"
+    "database/sql"
     "errors"
+    "net/mail"
     "strings"
     "time"
 
+    "github.com/brehan/bank/cmd/apperr"
     "github.com/brehan/bank/cmd/data"
     "github.com/brehan/bank/cmd/repository"
     "github.com/google/uuid"
     "golang.org/x/crypto/bcrypt"
+    "github.com/brehan/bank/cmd/tracing"
 )
 
 var (
-    ErrInvalidCredentials = errors.New("invalid credentials")
-    ErrUserExists         = errors.New("user already exists")
-    ErrInvalidRole        = errors.New("invalid role")
-    ErrInvalidDistrict    = errors.New("district required for district_manager")
+    ErrInvalidCredentials = apperr.Unauthorized("invalid_credentials", "invalid credentials")
+    ErrUserExists         = apperr.Conflict("user_exists", "user already exists")
+    ErrUserNotFound       = apperr.NotFound("user_not_found", "user not found")
+    ErrInvalidRole        = apperr.Validation("invalid_role", "role", "invalid role")
+    ErrInvalidDistrict    = apperr.Validation("district_required", "district", "district required for district_manager")
+    ErrLastRole           = apperr.Conflict("last_role", "user must keep at least one role")
+    ErrRoleNotHeld        = apperr.Conflict("role_not_held", "user does not hold this role")
+    ErrAccountDisabled    = apperr.Forbidden("account_disabled", "account is disabled")
+    ErrEmailExists        = apperr.Conflict("email_exists", "email is already in use")
+    ErrInvalidEmail       = apperr.Validation("invalid_email", "email", "invalid email address")
+    ErrInvalidName        = apperr.Validation("invalid_name", "name", "name must be at least 3 characters long")
+    ErrCannotDisableSelf  = apperr.Validation("cannot_disable_self", "", "you cannot disable your own account")
+    ErrNoDistrictRole     = apperr.Validation("no_district_role", "district", "district can only be set for users with a district role")
+    ErrSessionRevoked     = apperr.Unauthorized("session_revoked", "session is no longer valid, sign in again")
 )
 
+// UpdateUserInput holds the account fields an admin can edit. Nil fields are
+// left unchanged.
+type UpdateUserInput struct {
+    Name     *string
+    Email    *string
+    Branch   *string
+    District *string
+    // AuthProvider moves the user to another sign-in backend, e.g. ldap
+    AuthProvider *string
+}
+
 type AuthService struct {
-    repo        *repository.AuthRepository
+    repo        repository.UserStore
+    uow         repository.UnitOfWork
     userService *DefaultUserService
+    // backends are the password sign-in backends by auth_provider
+    backends        map[string]passwordBackend
+    defaultProvider string
 }
 
-func NewAuthService(repo *repository.AuthRepository) *AuthService {
+func NewAuthService(repo repository.UserStore, uow repository.UnitOfWork) *AuthService {
     return &AuthService{
         repo:        repo,
+        uow:         uow,
         userService: &DefaultUserService{},
+        backends: map[string]passwordBackend{
+            data.AuthProviderLocal: {auth: LocalAuthenticator{}},
+        },
+        defaultProvider: data.AuthProviderLocal,
     }
 }
 
-func (s *AuthService) Register(name, password, role, district string) (*data.User, string, string, error) {
-    if role != "admin" && role != "manager" && role != "district_manager" {
-        return nil, "", "", ErrInvalidRole
-    }
+func (s *AuthService) Register(ctx context.Context, name, password, role, district string) (*data.User, data.UserAccess, error) {
+    ctx, span := tracing.Start(ctx, "AuthService.Register")
+    defer span.End()
 
-    if role == "district_manager" && district == "" {
-        return nil, "", "", ErrInvalidDistrict
-    }
-    if role != "district_manager" {
-        district = ""
+    district, err := s.validateRole(ctx, role, district)
+    if err != nil {
+        return nil, data.UserAccess{}, err
     }
 
-    existingUser, _, _, err := s.repo.GetUserByName(name)
+    existingUser, err := s.repo.GetUserByName(ctx, name)
     if err != nil {
-        return nil, "", "", err
+        return nil, data.UserAccess{}, err
     }
     if existingUser != nil {
-        return nil, "", "", ErrUserExists
+        return nil, data.UserAccess{}, ErrUserExists
     }
 
     hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
     if err != nil {
-        return nil, "", "", err
+        return nil, data.UserAccess{}, err
     }
 
     user := &data.User{
@@ -64,47 +96,399 @@ func (s *AuthService) Register(name, password, role, district string) (*data.Use
     }
 
     if err := s.userService.ValidateUser(*user); err != nil {
-        return nil, "", "", err
+        return nil, data.UserAccess{}, err
     }
 
-    if err := s.repo.CreateUser(user, role, district); err != nil {
-        return nil, "", "", err
+    err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+        if err := tx.Users.CreateUser(ctx, user, role, district); err != nil {
+            return err
+        }
+        return auditUserCreated(ctx, tx, user.Id)
+    })
+    if err != nil {
+        return nil, data.UserAccess{}, err
     }
 
-    return user, role, district, nil
+    access, err := s.repo.GetUserAccess(ctx, user.Id)
+    if err != nil {
+        return nil, data.UserAccess{}, err
+    }
+    return user, access, nil
 }
 
-func (s *AuthService) Login(name, password string) (*data.User, string, string, error) {
-    user, role, district, err := s.repo.GetUserByName(name)
+// Login checks a name and password with the backend the user is assigned
+// to, or the default backend if there is no account with that name yet.
+// Users of a directory backend are provisioned on their first sign-in and
+// get their roles from their directory groups if a group mapping is set.
+func (s *AuthService) Login(ctx context.Context, name, password string) (*LoginResult, error) {
+    ctx, span := tracing.Start(ctx, "AuthService.Login")
+    defer span.End()
+
+    user, err := s.repo.GetUserByName(ctx, name)
     if err != nil {
-        return nil, "", "", err
+        return nil, err
     }
-    if user == nil {
-        return nil, "", "", ErrInvalidCredentials
+
+    provider := s.defaultProvider
+    if user != nil {
+        provider = user.AuthProvider
+    }
+    // Users of a provider without a password backend, such as OIDC, cannot
+    // sign in here
+    backend, ok := s.backends[provider]
+    if !ok {
+        return nil, ErrInvalidCredentials
     }
 
-    err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+    identity, err := backend.auth.Authenticate(name, password, user)
     if err != nil {
-        return nil, "", "", ErrInvalidCredentials
+        return nil, err
+    }
+    if identity == nil {
+        return s.loginUser(ctx, user)
+    }
+
+    if user != nil {
+        // Accounts an admin moved to this backend are linked by name
+        linked, err := s.repo.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+        if err != nil {
+            return nil, err
+        }
+        if linked == nil {
+            if err := s.repo.LinkIdentity(ctx, user.Id, *identity); err != nil {
+                return nil, err
+            }
+        } else if linked.Id != user.Id {
+            return nil, ErrInvalidCredentials
+        }
+    }
+
+    var roles []data.UserRole
+    if backend.groups != nil {
+        roles = backend.groups.Roles(identity.Groups)
+    }
+    if user != nil && (backend.groups == nil || backend.groups.Empty()) {
+        // Without a group mapping, roles are managed here
+        if err := s.repo.TouchIdentity(ctx, identity.Provider, identity.Subject, time.Now()); err != nil {
+            return nil, err
+        }
+        return s.loginUser(ctx, user)
+    }
+    return s.LoginExternal(ctx, *identity, provider, roles)
+}
+
+// loginUser completes a sign-in to an existing account
+func (s *AuthService) loginUser(ctx context.Context, user *data.User) (*LoginResult, error) {
+    if user.Status == data.UserStatusDisabled {
+        return nil, ErrAccountDisabled
     }
 
-    return user, role, district, nil
+    access, err := s.repo.GetUserAccess(ctx, user.Id)
+    if err != nil {
+        return nil, err
+    }
+    return &LoginResult{User: user, Access: access}, nil
 }
 
-func (s *AuthService) GetUserByID(id uuid.UUID) (*data.User, string, string, error) {
-    user, role, district, err := s.repo.GetUserByID(id)
+func (s *AuthService) GetUserByID(ctx context.Context, id uuid.UUID) (*data.User, data.UserAccess, error) {
+    ctx, span := tracing.Start(ctx, "AuthService.GetUserByID")
+    defer span.End()
+
+    user, err := s.repo.GetUserByID(ctx, id)
     if err != nil {
-        return nil, "", "", err
+        return nil, data.UserAccess{}, err
     }
     if user == nil {
-        return nil, "", "", errors.New("user not found")
+        return nil, data.UserAccess{}, ErrUserNotFound
     }
-    return user, role, district, nil
+    access, err := s.repo.GetUserAccess(ctx, user.Id)
+    if err != nil {
+        return nil, data.UserAccess{}, err
+    }
+    return user, access, nil
 }
 
 // Getallusers retrieves all users with their roles and districts
-func (s *AuthService) Getallusers() ([]map[string]interface{}, error) {
-    return s.repo.GetAllUsers()
+func (s *AuthService) Getallusers(ctx context.Context) ([]map[string]interface{}, error) {
+    ctx, span := tracing.Start(ctx, "AuthService.Getallusers")
+    defer span.End()
+
+    return s.repo.GetAllUsers(ctx)
+}
+
+// GetRoles lists all roles with their permissions
+func (s *AuthService) GetRoles(ctx context.Context) ([]data.Role, error) {
+    ctx, span := tracing.Start(ctx, "AuthService.GetRoles")
+    defer span.End()
+
+    return s.repo.GetRoles(ctx)
+}
+
+// GrantRole gives a user an additional role
+func (s *AuthService) GrantRole(ctx context.Context, userID uuid.UUID, role, district string) error {
+    ctx, span := tracing.Start(ctx, "AuthService.GrantRole")
+    defer span.End()
+
+    if _, _, err := s.GetUserByID(ctx, userID); err != nil {
+        return err
+    }
+    district, err := s.validateRole(ctx, role, district)
+    if err != nil {
+        return err
+    }
+    return s.changeUser(ctx, userID, func(tx repository.Stores) error {
+        if err := tx.Users.AddUserRole(ctx, userID, role, district); err != nil {
+            return err
+        }
+        return tx.Users.BumpTokenVersion(ctx, userID)
+    })
+}
+
+// RevokeRole removes one of a user's roles. The last role cannot be removed.
+func (s *AuthService) RevokeRole(ctx context.Context, userID uuid.UUID, role string) error {
+    ctx, span := tracing.Start(ctx, "AuthService.RevokeRole")
+    defer span.End()
+
+    // changeUser locks the user before their roles are read, so that two
+    // revokes of the user's last two roles can't both see the other one left
+    return s.changeUser(ctx, userID, func(tx repository.Stores) error {
+        access, err := tx.Users.GetUserAccess(ctx, userID)
+        if err != nil {
+            return err
+        }
+
+        held := false
+        for _, r := range access.Roles {
+            if r.Role == role {
+                held = true
+            }
+        }
+        if !held {
+            return ErrRoleNotHeld
+        }
+        if len(access.Roles) == 1 {
+            return ErrLastRole
+        }
+        if err := tx.Users.RemoveUserRole(ctx, userID, role); err != nil {
+            if errors.Is(err, sql.ErrNoRows) {
+                return ErrRoleNotHeld
+            }
+            return err
+        }
+        return tx.Users.BumpTokenVersion(ctx, userID)
+    })
+}
+
+// UpdateUser edits a user's name, email, branch and district. A new
+// district is a role change, so it is saved with the user in one
+// transaction and ends the user's sessions.
+func (s *AuthService) UpdateUser(ctx context.Context, id uuid.UUID, input UpdateUserInput) (*data.User, data.UserAccess, error) {
+    ctx, span := tracing.Start(ctx, "AuthService.UpdateUser")
+    defer span.End()
+
+    user, access, err := s.GetUserByID(ctx, id)
+    if err != nil {
+        return nil, data.UserAccess{}, err
+    }
+
+    if input.Name != nil {
+        name := strings.TrimSpace(*input.Name)
+        if len(name) < 3 {
+            return nil, data.UserAccess{}, ErrInvalidName
+        }
+        if name != user.Name {
+            existing, err := s.repo.GetUserByName(ctx, name)
+            if err != nil {
+                return nil, data.UserAccess{}, err
+            }
+            if existing != nil {
+                return nil, data.UserAccess{}, ErrUserExists
+            }
+        }
+        user.Name = name
+    }
+
+    if input.Email != nil {
+        email := strings.TrimSpace(*input.Email)
+        if email != "" {
+            addr, err := mail.ParseAddress(email)
+            if err != nil || addr.Address != email {
+                return nil, data.UserAccess{}, ErrInvalidEmail
+            }
+            existing, err := s.repo.GetUserByEmail(ctx, email)
+            if err != nil {
+                return nil, data.UserAccess{}, err
+            }
+            if existing != nil && existing.Id != user.Id {
+                return nil, data.UserAccess{}, ErrEmailExists
+            }
+        }
+        user.Email = email
+    }
+
+    if input.Branch != nil {
+        user.Branch = strings.TrimSpace(*input.Branch)
+    }
+
+    if input.AuthProvider != nil {
+        provider := strings.TrimSpace(*input.AuthProvider)
+        if !s.validAuthProvider(provider) {
+            return nil, data.UserAccess{}, ErrInvalidAuthProvider
+        }
+        user.AuthProvider = provider
+    }
+
+    district := ""
+    if input.District != nil {
+        district = strings.TrimSpace(*input.District)
+        if district == "" {
+            return nil, data.UserAccess{}, ErrInvalidDistrict
+        }
+        found := false
+        for _, r := range access.Roles {
+            if r.Role == data.RoleDistrictManager {
+                found = true
+            }
+        }
+        if !found {
+            return nil, data.UserAccess{}, ErrNoDistrictRole
+        }
+    }
+
+    user.UpdatedAt = time.Now()
+    err = s.changeUser(ctx, user.Id, func(tx repository.Stores) error {
+        if district != "" && district != access.District() {
+            if err := tx.Users.AddUserRole(ctx, user.Id, data.RoleDistrictManager, district); err != nil {
+                return err
+            }
+            if err := tx.Users.BumpTokenVersion(ctx, user.Id); err != nil {
+                return err
+            }
+        }
+        return tx.Users.UpdateUser(ctx, user)
+    })
+    if err != nil {
+        return nil, data.UserAccess{}, err
+    }
+
+    access, err = s.repo.GetUserAccess(ctx, user.Id)
+    if err != nil {
+        return nil, data.UserAccess{}, err
+    }
+    return user, access, nil
+}
+
+// ChangeRole replaces all of a user's roles with exactly one role
+func (s *AuthService) ChangeRole(ctx context.Context, id uuid.UUID, role, district string) (data.UserAccess, error) {
+    ctx, span := tracing.Start(ctx, "AuthService.ChangeRole")
+    defer span.End()
+
+    if _, _, err := s.GetUserByID(ctx, id); err != nil {
+        return data.UserAccess{}, err
+    }
+    district, err := s.validateRole(ctx, role, district)
+    if err != nil {
+        return data.UserAccess{}, err
+    }
+    err = s.changeUser(ctx, id, func(tx repository.Stores) error {
+        if err := tx.Users.SetUserRole(ctx, id, role, district); err != nil {
+            return err
+        }
+        return tx.Users.BumpTokenVersion(ctx, id)
+    })
+    if err != nil {
+        return data.UserAccess{}, err
+    }
+    return s.repo.GetUserAccess(ctx, id)
+}
+
+// SetUserStatus enables or disables an account. actorID is the admin making
+// the change, who cannot disable themselves. Disabling a user ends their
+// sessions.
+func (s *AuthService) SetUserStatus(ctx context.Context, id, actorID uuid.UUID, status string) error {
+    ctx, span := tracing.Start(ctx, "AuthService.SetUserStatus")
+    defer span.End()
+
+    if _, _, err := s.GetUserByID(ctx, id); err != nil {
+        return err
+    }
+    if status == data.UserStatusDisabled && id == actorID {
+        return ErrCannotDisableSelf
+    }
+    return s.changeUser(ctx, id, func(tx repository.Stores) error {
+        if err := tx.Users.SetUserStatus(ctx, id, status); err != nil {
+            return err
+        }
+        if status != data.UserStatusDisabled {
+            return nil
+        }
+        return tx.Users.BumpTokenVersion(ctx, id)
+    })
+}
+
+// DeleteUser removes an account
+func (s *AuthService) DeleteUser(ctx context.Context, id uuid.UUID) error {
+    ctx, span := tracing.Start(ctx, "AuthService.DeleteUser")
+    defer span.End()
+
+    return s.uow.WithTx(ctx, func(tx repository.Stores) error {
+        before, err := readUserRecord(ctx, tx.Users, id)
+        if err != nil {
+            return err
+        }
+        if err := tx.Users.DeleteUser(ctx, id); err != nil {
+            return err
+        }
+        return RecordAudit(ctx, tx, data.AuditActionDelete, data.AuditEntityUser, id.String(), before, nil)
+    })
+}
+
+// ValidateSession checks that a session token issued with tokenVersion is
+// still good: the user exists, is not disabled, and has had no role change
+// since. It implements middleware.SessionValidator.
+func (s *AuthService) ValidateSession(ctx context.Context, userID uuid.UUID, tokenVersion int) error {
+    ctx, span := tracing.Start(ctx, "AuthService.ValidateSession")
+    defer span.End()
+
+    user, err := s.repo.GetUserByID(ctx, userID)
+    if err != nil {
+        return err
+    }
+    if user == nil || user.TokenVersion != tokenVersion {
+        return ErrSessionRevoked
+    }
+    if user.Status == data.UserStatusDisabled {
+        return ErrAccountDisabled
+    }
+    return nil
+}
+
+// RecordLogin stores the current time as the user's last login
+func (s *AuthService) RecordLogin(ctx context.Context, id uuid.UUID) error {
+    ctx, span := tracing.Start(ctx, "AuthService.RecordLogin")
+    defer span.End()
+
+    return s.repo.RecordLogin(ctx, id, time.Now())
+}
+
+// validateRole checks that role exists and returns the district to store
+// with it, which is only kept for district managers
+func (s *AuthService) validateRole(ctx context.Context, role, district string) (string, error) {
+    existing, err := s.repo.GetRoleByName(ctx, role)
+    if err != nil {
+        return "", err
+    }
+    if existing == nil {
+        return "", ErrInvalidRole
+    }
+
+    if role == data.RoleDistrictManager && district == "" {
+        return "", ErrInvalidDistrict
+    }
+    if role != data.RoleDistrictManager {
+        district = ""
+    }
+    return district, nil
 }
 
 type UserService interface {
@@ -119,17 +503,17 @@ func (userSer *DefaultUserService) ValidateUser(user data.User) error {
     }
 
     if strings.TrimSpace(user.Name) == "" {
-        return errors.New("name is required")
+        return apperr.Validation("name_required", "name", "name is required")
     }
     if len(user.Name) < 3 {
-        return errors.New("name must be at least 3 characters long")
+        return ErrInvalidName
     }
 
     if strings.TrimSpace(user.Password) == "" {
-        return errors.New("password is required")
+        return apperr.Validation("password_required", "password", "password is required")
     }
     if len(user.Password) < 8 {
-        return errors.New("password must be at least 8 characters long")
+        return apperr.Validation("password_too_short", "password", "password must be at least 8 characters long")
     }
 
     if user.CreatedAt.IsZero() {
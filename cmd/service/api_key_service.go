@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAPIKeyNotFound = apperr.NotFound("api_key_not_found", "API key not found")
+	ErrInvalidScope   = apperr.Validation("invalid_scope", "scopes", "unknown API key scope")
+	ErrNoScopes       = apperr.Validation("scopes_required", "scopes", "at least one scope is required")
+	ErrInvalidExpiry  = apperr.Validation("invalid_expiry", "expires_at", "expiry must be in the future")
+	ErrInvalidKeyName = apperr.Validation("name_required", "name", "API key name is required")
+)
+
+// apiKeyPrefix makes keys easy to recognise, e.g. in secret scanners
+const apiKeyPrefix = "bbk_"
+
+// shown prefix length, including apiKeyPrefix
+const apiKeyDisplayLength = 12
+
+type APIKeyService struct {
+	repo repository.APIKeyStore
+	uow  repository.UnitOfWork
+}
+
+func NewAPIKeyService(repo repository.APIKeyStore, uow repository.UnitOfWork) *APIKeyService {
+	return &APIKeyService{repo: repo, uow: uow}
+}
+
+// Create issues and audits a new key and returns it together with the
+// plain text secret. The secret cannot be recovered later.
+func (s *APIKeyService) Create(ctx context.Context, name string, scopes []string, expiresAt *time.Time, createdBy uuid.UUID) (*data.APIKey, string, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Create")
+	defer span.End()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrInvalidKeyName
+	}
+	if len(scopes) == 0 {
+		return nil, "", ErrNoScopes
+	}
+	for _, scope := range scopes {
+		if _, ok := data.APIKeyScopes[scope]; !ok {
+			return nil, "", ErrInvalidScope
+		}
+	}
+	now := time.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, "", ErrInvalidExpiry
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	secret := apiKeyPrefix + hex.EncodeToString(raw)
+
+	key := &data.APIKey{
+		ID:        uuid.New(),
+		Name:      name,
+		Prefix:    secret[:apiKeyDisplayLength],
+		Hash:      hashAPIKey(secret),
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	err := s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		if err := tx.APIKeys.CreateAPIKey(ctx, key); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, tx, data.AuditActionCreate, data.AuditEntityAPIKey, key.ID.String(), nil, key)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// Authenticate looks up a presented key and records its use. It returns nil
+// for unknown, expired and revoked keys.
+func (s *APIKeyService) Authenticate(ctx context.Context, secret, ip string) (*data.APIKey, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Authenticate")
+	defer span.End()
+
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return nil, nil
+	}
+
+	key, err := s.repo.GetAPIKeyByHash(ctx, hashAPIKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if key == nil || !key.Active(now) {
+		return nil, nil
+	}
+
+	if err := s.repo.TouchAPIKey(ctx, key.ID, now, ip); err != nil {
+		return nil, err
+	}
+	key.LastUsedAt = &now
+	key.LastUsedIP = ip
+	return key, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context) ([]data.APIKey, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.List")
+	defer span.End()
+
+	return s.repo.GetAPIKeys(ctx)
+}
+
+func (s *APIKeyService) Get(ctx context.Context, id uuid.UUID) (*data.APIKey, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Get")
+	defer span.End()
+
+	key, err := s.repo.GetAPIKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+// Revoke disables a key immediately and audits the key as it was before
+// and after
+func (s *APIKeyService) Revoke(ctx context.Context, id uuid.UUID) error {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Revoke")
+	defer span.End()
+
+	return s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		before, err := tx.APIKeys.GetAPIKey(ctx, id)
+		if err != nil {
+			return err
+		}
+		revoked, err := tx.APIKeys.RevokeAPIKey(ctx, id, time.Now())
+		if err != nil {
+			return err
+		}
+		if before == nil || !revoked {
+			return ErrAPIKeyNotFound
+		}
+		after, err := tx.APIKeys.GetAPIKey(ctx, id)
+		if err != nil {
+			return err
+		}
+		return RecordAudit(ctx, tx, data.AuditActionUpdate, data.AuditEntityAPIKey, id.String(), before, after)
+	})
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
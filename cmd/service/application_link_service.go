@@ -1,78 +1,126 @@
 package service
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/brehan/bank/cmd/apperr"
 	"github.com/brehan/bank/cmd/data"
 	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+)
+
+// Application link tokens stand in for a login, so invalid links are
+// reported as unauthorized
+var (
+	ErrInvalidLink = apperr.Unauthorized("invalid_link", "invalid application link")
+	ErrLinkExpired = apperr.Unauthorized("link_expired", "application link has expired")
+	ErrLinkUsed    = apperr.Unauthorized("link_used", "application link has already been used")
 )
 
 type ApplicationLinkService struct {
-	repo *repository.Repository
+	repo repository.LinkStore
+	jobs repository.JobStore
+	uow  repository.UnitOfWork
 }
 
-func NewApplicationLinkService(repo *repository.Repository) *ApplicationLinkService {
-	return &ApplicationLinkService{repo: repo}
+func NewApplicationLinkService(repo repository.LinkStore, jobs repository.JobStore, uow repository.UnitOfWork) *ApplicationLinkService {
+	return &ApplicationLinkService{repo: repo, jobs: jobs, uow: uow}
 }
 
-// GenerateApplicationLinks creates both internal and external application links for a job
-func (s *ApplicationLinkService) GenerateApplicationLinks(jobID string) (internalLink, externalLink data.ApplicationLink, err error) {
+// GenerateApplicationLinks creates both internal and external application
+// links for a job. Both are audited in one entry for the job, without their
+// tokens, in the transaction that creates them.
+func (s *ApplicationLinkService) GenerateApplicationLinks(ctx context.Context, jobID string) (internalLink, externalLink data.ApplicationLink, err error) {
+	ctx, span := tracing.Start(ctx, "ApplicationLinkService.GenerateApplicationLinks")
+	defer span.End()
+
 	// First, get the job to make sure it exists
-	_, err = s.repo.GetJobById(jobID)
-	if err != nil {
-		return data.ApplicationLink{}, data.ApplicationLink{}, errors.New("job not found")
+	_, err = s.jobs.GetJobById(ctx, jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return data.ApplicationLink{}, data.ApplicationLink{}, ErrJobNotFound
 	}
-
-	// Create internal application link
-	internalLink, err = s.repo.CreateApplicationLink(jobID, "internal")
 	if err != nil {
-		return data.ApplicationLink{}, data.ApplicationLink{}, errors.New("failed to create internal application link")
+		return data.ApplicationLink{}, data.ApplicationLink{}, err
 	}
 
-	// Create external application link
-	externalLink, err = s.repo.CreateApplicationLink(jobID, "external")
+	err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		// Create internal application link
+		internalLink, err = tx.Links.CreateApplicationLink(ctx, jobID, "internal")
+		if err != nil {
+			return fmt.Errorf("failed to create internal application link: %w", err)
+		}
+
+		// Create external application link
+		externalLink, err = tx.Links.CreateApplicationLink(ctx, jobID, "external")
+		if err != nil {
+			return fmt.Errorf("failed to create external application link: %w", err)
+		}
+
+		audited := map[string]data.ApplicationLink{"internal": internalLink, "external": externalLink}
+		for kind, link := range audited {
+			link.Token = ""
+			audited[kind] = link
+		}
+		return RecordAudit(ctx, tx, data.AuditActionCreate, data.AuditEntityApplicationLink, jobID, nil, audited)
+	})
 	if err != nil {
-		return internalLink, data.ApplicationLink{}, errors.New("failed to create external application link")
+		return data.ApplicationLink{}, data.ApplicationLink{}, err
 	}
-
 	return internalLink, externalLink, nil
 }
 
 // GetApplicationLinksByJob returns all application links for a job
-func (s *ApplicationLinkService) GetApplicationLinksByJob(jobID string) ([]data.ApplicationLink, error) {
-	return s.repo.GetApplicationLinksByJobID(jobID)
+func (s *ApplicationLinkService) GetApplicationLinksByJob(ctx context.Context, jobID string) ([]data.ApplicationLink, error) {
+	ctx, span := tracing.Start(ctx, "ApplicationLinkService.GetApplicationLinksByJob")
+	defer span.End()
+
+	return s.repo.GetApplicationLinksByJobID(ctx, jobID)
 }
 
 // ValidateApplicationLink checks if a link is valid (exists, not expired, not used)
-func (s *ApplicationLinkService) ValidateApplicationLink(token string) (data.ApplicationLink, error) {
-	link, err := s.repo.GetApplicationLinkByToken(token)
+func (s *ApplicationLinkService) ValidateApplicationLink(ctx context.Context, token string) (data.ApplicationLink, error) {
+	ctx, span := tracing.Start(ctx, "ApplicationLinkService.ValidateApplicationLink")
+	defer span.End()
+
+	link, err := s.repo.GetApplicationLinkByToken(ctx, token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return data.ApplicationLink{}, ErrInvalidLink
+	}
 	if err != nil {
-		return data.ApplicationLink{}, errors.New("invalid application link")
+		return data.ApplicationLink{}, err
 	}
 
 	// Check if the link is expired
 	if time.Now().After(link.ExpiresAt) {
-		return data.ApplicationLink{}, errors.New("application link has expired")
+		return data.ApplicationLink{}, ErrLinkExpired
 	}
 
 	// Check if the link has been used
 	if link.IsUsed {
-		return data.ApplicationLink{}, errors.New("application link has already been used")
+		return data.ApplicationLink{}, ErrLinkUsed
 	}
 
 	return link, nil
 }
 
 // FormatApplicationLinksForResponse formats application links for response to client
-func (s *ApplicationLinkService) FormatApplicationLinksForResponse(
+func (s *ApplicationLinkService) FormatApplicationLinksForResponse(ctx context.Context, 
 	internalLink, externalLink data.ApplicationLink, baseURL string) ([]data.ApplicationLinkResponse, error) {
+	ctx, span := tracing.Start(ctx, "ApplicationLinkService.FormatApplicationLinksForResponse")
+	defer span.End()
+
 	
 	// Get the job to include its title
-	job, err := s.repo.GetJobById(internalLink.JobID)
+	job, err := s.jobs.GetJobById(ctx, internalLink.JobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
 	if err != nil {
-		return nil, errors.New("job not found")
+		return nil, err
 	}
 
 	responses := []data.ApplicationLinkResponse{
@@ -96,6 +144,29 @@ func (s *ApplicationLinkService) FormatApplicationLinksForResponse(
 }
 
 // MarkLinkAsUsed marks an application link as used
-func (s *ApplicationLinkService) MarkLinkAsUsed(token string) error {
-	return s.repo.MarkApplicationLinkAsUsed(token)
+func (s *ApplicationLinkService) MarkLinkAsUsed(ctx context.Context, token string) error {
+	ctx, span := tracing.Start(ctx, "ApplicationLinkService.MarkLinkAsUsed")
+	defer span.End()
+
+	return useLink(ctx, s.repo, token)
+}
+
+// useLink marks a link used, or reports why it cannot be used. In a
+// transaction it holds off other submissions on the link until it ends.
+func useLink(ctx context.Context, links repository.LinkStore, token string) error {
+	err := links.MarkApplicationLinkAsUsed(ctx, token, time.Now())
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	link, err := links.GetApplicationLinkByToken(ctx, token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidLink
+	}
+	if err != nil {
+		return err
+	}
+	if link.IsUsed {
+		return ErrLinkUsed
+	}
+	return ErrLinkExpired
 } 
\ No newline at end of file
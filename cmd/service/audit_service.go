@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+var ErrInvalidAuditFilter = apperr.Validation("invalid_audit_filter", "", "invalid audit filter")
+
+// AuditVerification is the result of walking the audit hash chain. When the
+// chain is broken, BrokenAt is the ID of the first entry that does not check
+// out and Reason says why.
+type AuditVerification struct {
+	Entries  int    `json:"entries"`
+	Valid    bool   `json:"valid"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	LastHash string `json:"last_hash,omitempty"`
+}
+
+// errChainBroken stops the walk once the first bad entry is found
+var errChainBroken = errors.New("audit chain broken")
+
+type AuditService struct {
+	repo repository.AuditStore
+}
+
+func NewAuditService(repo repository.AuditStore) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+// Record appends an entry for a mutation. before and after are the entity
+// as it was and as it is now; either may be nil for creates and deletes.
+func (s *AuditService) Record(ctx context.Context, entry data.AuditEntry, before, after interface{}) error {
+	ctx, span := tracing.Start(ctx, "AuditService.Record")
+	defer span.End()
+
+	return appendAudit(ctx, s.repo, entry, before, after)
+}
+
+func appendAudit(ctx context.Context, repo repository.AuditStore, entry data.AuditEntry, before, after interface{}) error {
+	var err error
+	if entry.Before, err = marshalAuditValue(before); err != nil {
+		return err
+	}
+	if entry.After, err = marshalAuditValue(after); err != nil {
+		return err
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	return repo.AppendAuditEntry(ctx, &entry)
+}
+
+type auditRequestKey struct{}
+
+// WithAuditRequest returns a context carrying the request details of audit
+// entries: actor, IP and endpoint. Services that audit a change in the
+// transaction that makes it fill in the rest.
+func WithAuditRequest(ctx context.Context, entry data.AuditEntry) context.Context {
+	return context.WithValue(ctx, auditRequestKey{}, entry)
+}
+
+// RecordAudit appends an entry through tx for a change made in tx, so that
+// the change and its entry are committed together or not at all. Changes
+// not made through the API, whose ctx carries no request details, are not
+// audited.
+func RecordAudit(ctx context.Context, tx repository.Stores, action, entityType, entityID string, before, after interface{}) error {
+	entry, ok := ctx.Value(auditRequestKey{}).(data.AuditEntry)
+	if !ok {
+		return nil
+	}
+	entry.Action, entry.EntityType, entry.EntityID = action, entityType, entityID
+	return appendAudit(ctx, tx.Audit, entry, before, after)
+}
+
+// List returns audit entries matching filter, newest first
+func (s *AuditService) List(ctx context.Context, filter data.AuditFilter) ([]data.AuditEntry, error) {
+	ctx, span := tracing.Start(ctx, "AuditService.List")
+	defer span.End()
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditLimit
+	}
+	if filter.Limit > maxAuditLimit {
+		return nil, ErrInvalidAuditFilter.WithFields(apperr.FieldError{Field: "limit", Message: fmt.Sprintf("must be at most %d", maxAuditLimit)})
+	}
+	if filter.Offset < 0 {
+		return nil, ErrInvalidAuditFilter.WithFields(apperr.FieldError{Field: "offset", Message: "must not be negative"})
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return nil, ErrInvalidAuditFilter.WithFields(apperr.FieldError{Field: "from", Message: "must be before to"})
+	}
+	return s.repo.GetAuditEntries(ctx, filter)
+}
+
+// Verify recomputes every hash in the audit log and checks that each entry
+// links to the one before it. It reports the first entry that fails.
+func (s *AuditService) Verify(ctx context.Context) (*AuditVerification, error) {
+	ctx, span := tracing.Start(ctx, "AuditService.Verify")
+	defer span.End()
+
+	result := &AuditVerification{Valid: true}
+	prevHash := ""
+
+	err := s.repo.WalkAuditLog(ctx, func(entry *data.AuditEntry) error {
+		result.Entries++
+		switch {
+		case entry.PrevHash != prevHash:
+			result.Reason = fmt.Sprintf("prev_hash does not match the hash of the previous entry (expected %q)", prevHash)
+		case entry.ComputeHash() != entry.Hash:
+			result.Reason = "content does not match its hash"
+		default:
+			prevHash = entry.Hash
+			return nil
+		}
+		result.Valid = false
+		result.BrokenAt = entry.ID
+		return errChainBroken
+	})
+	if err != nil && err != errChainBroken {
+		return nil, err
+	}
+
+	result.LastHash = prevHash
+	return result, nil
+}
+
+func marshalAuditValue(value interface{}) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository/memory"
+)
+
+func TestAuditRecordListVerify(t *testing.T) {
+	audit := NewAuditService(memory.New())
+
+	for i, action := range []string{data.AuditActionCreate, data.AuditActionUpdate, data.AuditActionDelete} {
+		entry := data.AuditEntry{Action: action, EntityType: "job", EntityID: "j1", Method: "POST", Endpoint: "/api/jobs"}
+		var after interface{}
+		if action != data.AuditActionDelete {
+			after = map[string]int{"version": i}
+		}
+		if err := audit.Record(ctx, entry, nil, after); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := audit.List(ctx, data.AuditFilter{EntityType: "job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Action != data.AuditActionDelete {
+		t.Fatalf("entries = %+v, want 3 newest first", entries)
+	}
+	if entries[0].PrevHash != entries[1].Hash {
+		t.Error("entries should be chained")
+	}
+
+	updates, err := audit.List(ctx, data.AuditFilter{Action: data.AuditActionUpdate, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || string(updates[0].After) != `{"version":1}` {
+		t.Errorf("updates = %+v", updates)
+	}
+
+	result, err := audit.Verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Entries != 3 || result.LastHash != entries[0].Hash {
+		t.Errorf("Verify = %+v", result)
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository/memory"
+	"github.com/google/uuid"
+)
+
+// ctx is the context of the store and service calls in these tests
+var ctx = context.Background()
+
+func TestRegisterAndLogin(t *testing.T) {
+	store := memory.New()
+	auth := NewAuthService(store, store)
+
+	user, access, err := auth.Register(ctx, "alice", "correct horse", data.RoleManager, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !access.HasPermission(data.PermEmployeePMSWrite) {
+		t.Errorf("a manager should be able to score PMS: %+v", access)
+	}
+	if _, _, err := auth.Register(ctx, "alice", "another", data.RoleAdmin, ""); err != ErrUserExists {
+		t.Errorf("second Register = %v, want ErrUserExists", err)
+	}
+
+	result, err := auth.Login(ctx, "alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User.Id != user.Id {
+		t.Errorf("logged in as %s, want %s", result.User.Id, user.Id)
+	}
+	if _, err := auth.Login(ctx, "alice", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("Login with a wrong password = %v", err)
+	}
+
+	if err := auth.SetUserStatus(ctx, user.Id, user.Id, data.UserStatusDisabled); err != ErrCannotDisableSelf {
+		t.Errorf("disabling oneself = %v", err)
+	}
+	if err := auth.SetUserStatus(ctx, user.Id, uuid.New(), data.UserStatusDisabled); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Login(ctx, "alice", "correct horse"); err != ErrAccountDisabled {
+		t.Errorf("Login to a disabled account = %v", err)
+	}
+}
+
+func TestRevokeRole(t *testing.T) {
+	store := memory.New()
+	auth := NewAuthService(store, store)
+	user, _, err := auth.Register(ctx, "bob", "secret password", data.RoleManager, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.RevokeRole(ctx, user.Id, data.RoleManager); err != ErrLastRole {
+		t.Errorf("revoking the last role = %v, want ErrLastRole", err)
+	}
+	if err := auth.RevokeRole(ctx, user.Id, data.RoleAdmin); err != ErrRoleNotHeld {
+		t.Errorf("revoking a role not held = %v, want ErrRoleNotHeld", err)
+	}
+	if err := auth.GrantRole(ctx, user.Id, data.RoleDistrictManager, "North"); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.RevokeRole(ctx, user.Id, data.RoleManager); err != nil {
+		t.Fatal(err)
+	}
+
+	_, access, err := auth.GetUserByID(ctx, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []data.UserRole{{Role: data.RoleDistrictManager, District: "North"}}
+	if !reflect.DeepEqual(access.Roles, want) {
+		t.Errorf("roles = %+v, want %+v", access.Roles, want)
+	}
+
+	// Revoking both of two roles at once leaves one of them
+	if err := auth.GrantRole(ctx, user.Id, data.RoleManager, ""); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 2)
+	for _, role := range []string{data.RoleManager, data.RoleDistrictManager} {
+		go func(role string) { errs <- auth.RevokeRole(ctx, user.Id, role) }(role)
+	}
+	if err1, err2 := <-errs, <-errs; (err1 == ErrLastRole) == (err2 == ErrLastRole) {
+		t.Errorf("concurrent revokes = %v, %v, want one ErrLastRole", err1, err2)
+	}
+	if _, access, _ := auth.GetUserByID(ctx, user.Id); len(access.Roles) != 1 {
+		t.Errorf("roles after concurrent revokes = %+v", access.Roles)
+	}
+	if err := auth.RevokeRole(ctx, uuid.New(), data.RoleManager); err != ErrUserNotFound {
+		t.Errorf("revoking a role of a missing user = %v", err)
+	}
+}
+
+func TestValidateSession(t *testing.T) {
+	store := memory.New()
+	auth := NewAuthService(store, store)
+	user, _, err := auth.Register(ctx, "bob", "secret password", data.RoleManager, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := auth.Register(ctx, "alice", "secret password", data.RoleAdmin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.ValidateSession(ctx, user.Id, user.TokenVersion); err != nil {
+		t.Fatalf("a fresh session = %v", err)
+	}
+
+	// Editing the account leaves sessions alone, a new district ends them
+	branch := "Bole"
+	if _, _, err := auth.UpdateUser(ctx, user.Id, UpdateUserInput{Branch: &branch}); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.ValidateSession(ctx, user.Id, user.TokenVersion); err != nil {
+		t.Errorf("after editing the branch = %v", err)
+	}
+	if err := auth.GrantRole(ctx, user.Id, data.RoleDistrictManager, "North"); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.ValidateSession(ctx, user.Id, user.TokenVersion); err != ErrSessionRevoked {
+		t.Errorf("after a role change = %v, want ErrSessionRevoked", err)
+	}
+	user, _, _ = auth.GetUserByID(ctx, user.Id)
+	district := "South"
+	if _, _, err := auth.UpdateUser(ctx, user.Id, UpdateUserInput{District: &district}); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.ValidateSession(ctx, user.Id, user.TokenVersion); err != ErrSessionRevoked {
+		t.Errorf("after a district change = %v, want ErrSessionRevoked", err)
+	}
+
+	user, _, _ = auth.GetUserByID(ctx, user.Id)
+	if err := auth.SetUserStatus(ctx, user.Id, admin.Id, data.UserStatusDisabled); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.ValidateSession(ctx, user.Id, user.TokenVersion); err != ErrSessionRevoked {
+		t.Errorf("after disabling = %v, want ErrSessionRevoked", err)
+	}
+	user, _, _ = auth.GetUserByID(ctx, user.Id)
+	if err := auth.ValidateSession(ctx, user.Id, user.TokenVersion); err != ErrAccountDisabled {
+		t.Errorf("a current token of a disabled user = %v, want ErrAccountDisabled", err)
+	}
+}
+
+func TestLoginExternalProvisionsAndSyncsRoles(t *testing.T) {
+	store := memory.New()
+	auth := NewAuthService(store, store)
+	identity := data.ExternalIdentity{Provider: "https://idp.example", Subject: "42", Username: "carol", Email: "carol@example.com", EmailVerified: true}
+
+	result, err := auth.LoginExternal(ctx, identity, data.AuthProviderOIDC, []data.UserRole{{Role: data.RoleManager}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Provisioned || result.User.Name != "carol" {
+		t.Fatalf("first sign-in = %+v", result)
+	}
+
+	result, err = auth.LoginExternal(ctx, identity, data.AuthProviderOIDC, []data.UserRole{{Role: data.RoleAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Provisioned {
+		t.Error("second sign-in should reuse the account")
+	}
+	if names := result.Access.RoleNames(); !reflect.DeepEqual(names, []string{data.RoleAdmin}) {
+		t.Errorf("roles = %v, want the roles of the latest sign-in", names)
+	}
+
+	if _, err := auth.LoginExternal(ctx, identity, data.AuthProviderOIDC, nil); err != ErrNoMappedRole {
+		t.Errorf("sign-in without roles = %v", err)
+	}
+}
+
+func TestLoginExternalDoesNotLinkByEmail(t *testing.T) {
+	store := memory.New()
+	auth := NewAuthService(store, store)
+	local, _, err := auth.Register(ctx, "dana", "secret password", data.RoleAdmin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := "dana@example.com"
+	if _, _, err := auth.UpdateUser(ctx, local.Id, UpdateUserInput{Email: &email}); err != nil {
+		t.Fatal(err)
+	}
+
+	identity := data.ExternalIdentity{Provider: "https://idp.example", Subject: "7", Username: "dana.sso", Email: email, EmailVerified: true}
+	result, err := auth.LoginExternal(ctx, identity, data.AuthProviderOIDC, []data.UserRole{{Role: data.RoleManager}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Provisioned || result.User.Id == local.Id {
+		t.Fatalf("sign-in with the email of a local account = %+v, want a new account", result.User)
+	}
+	if result.User.Email != "" {
+		t.Errorf("new account email = %q, want none while the address is taken", result.User.Email)
+	}
+	linked, err := store.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked == nil || linked.Id != result.User.Id {
+		t.Errorf("identity is linked to %+v, want the new account", linked)
+	}
+}
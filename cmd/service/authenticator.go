@@ -0,0 +1,64 @@
+package service
+
+import (
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/data"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidAuthProvider = apperr.Validation("invalid_auth_provider", "auth_provider", "unknown authentication provider")
+
+// Authenticator checks a name and password against one sign-in backend.
+// user is the local account with that name, or nil if there is none.
+//
+// Backends that own the user's identity (such as LDAP) return it so that
+// the account can be linked, provisioned and given roles from its groups.
+// The local backend returns a nil identity. A wrong name or password is
+// reported as ErrInvalidCredentials.
+type Authenticator interface {
+	Authenticate(name, password string, user *data.User) (*data.ExternalIdentity, error)
+}
+
+// LocalAuthenticator checks passwords against the bcrypt hash in the users table
+type LocalAuthenticator struct{}
+
+func (LocalAuthenticator) Authenticate(name, password string, user *data.User) (*data.ExternalIdentity, error) {
+	if user == nil || user.Password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return nil, nil
+}
+
+// passwordBackend is a registered Authenticator and the group mapping used
+// for the identities it returns
+type passwordBackend struct {
+	auth   Authenticator
+	groups *GroupMapper
+}
+
+// RegisterAuthenticator makes users whose auth_provider is provider sign in
+// through auth. groups maps the groups of the identities it returns to
+// roles; a nil or empty mapper leaves roles to be managed here.
+func (s *AuthService) RegisterAuthenticator(provider string, auth Authenticator, groups *GroupMapper) {
+	s.backends[provider] = passwordBackend{auth: auth, groups: groups}
+}
+
+// SetDefaultAuthProvider chooses the backend used for names that have no
+// local account yet. With a backend other than local, users are provisioned
+// on their first successful sign-in.
+func (s *AuthService) SetDefaultAuthProvider(provider string) error {
+	if _, ok := s.backends[provider]; !ok {
+		return ErrInvalidAuthProvider
+	}
+	s.defaultProvider = provider
+	return nil
+}
+
+// validAuthProvider reports whether users can be assigned to provider
+func (s *AuthService) validAuthProvider(provider string) bool {
+	_, ok := s.backends[provider]
+	return ok || provider == data.AuthProviderOIDC
+}
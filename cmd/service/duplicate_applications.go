@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+)
+
+var (
+	ErrDuplicateApplication   = apperr.Conflict("duplicate_application", "you have already applied for this job")
+	ErrInvalidDuplicatePolicy = apperr.Validation("invalid_duplicate_policy", "duplicate_policy", "must be reject or merge")
+)
+
+// CheckDuplicatePolicy gives a job without a duplicate policy the default
+// one and rejects policies it does not know
+func CheckDuplicatePolicy(job *data.Job) error {
+	switch job.DuplicatePolicy {
+	case "":
+		job.DuplicatePolicy = data.DuplicatePolicyReject
+	case data.DuplicatePolicyReject, data.DuplicatePolicyMerge:
+	default:
+		return ErrInvalidDuplicatePolicy
+	}
+	return nil
+}
+
+// keepsRevisions locks the job an application is for, so that concurrent
+// applications from one person are checked one after the other, and
+// reports whether the job keeps duplicate applications as revisions
+// rather than rejecting them
+func keepsRevisions(ctx context.Context, tx repository.Stores, jobID string) (bool, error) {
+	err := tx.Jobs.LockJob(ctx, jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrJobNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	job, err := tx.Jobs.GetJobById(ctx, jobID)
+	if err != nil {
+		return false, err
+	}
+	return job.DuplicatePolicy == data.DuplicatePolicyMerge, nil
+}
+
+// auditedApplication is what the audit log keeps of an application. The log
+// cannot be purged, so it holds none of the applicant's personal details.
+type auditedApplication struct {
+	JobID  string `json:"job_id"`
+	Resume bool   `json:"resume"`
+	// RevisionOf is the application a revision was stored for
+	RevisionOf string `json:"revision_of,omitempty"`
+}
+
+// applyInternal saves an application, unless the applicant already applied
+// for the job. Then the job's duplicate policy decides whether it is
+// rejected or kept as a revision of the earlier application, which is never
+// changed: the forms need no sign-in, so anyone could send one in the
+// applicant's name. The resume is only stored, in resumes, once the
+// application is accepted. It reports whether the application was kept as a
+// revision, and audits it in tx.
+func applyInternal(ctx context.Context, tx repository.Stores, emp data.InternalEmployee, resumes *resumeFiles) (bool, error) {
+	revise, err := keepsRevisions(ctx, tx, emp.Jobid)
+	if err != nil {
+		return false, err
+	}
+	original, err := tx.Applications.FindInternalDuplicate(ctx, emp)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		original = ""
+	case err != nil:
+		return false, err
+	case !revise:
+		return false, ErrDuplicateApplication
+	}
+
+	if emp.Resumepath != "" {
+		if emp.Resumepath, err = resumes.store(emp.Resumepath); err != nil {
+			return false, err
+		}
+	}
+	audited := auditedApplication{JobID: emp.Jobid, Resume: emp.Resumepath != "", RevisionOf: original}
+	var id string
+	if original == "" {
+		id, err = tx.Applications.ApplyInternal(ctx, emp)
+	} else {
+		id, err = tx.Applications.ReviseInternalApplication(ctx, original, emp)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to save employee record: %w", err)
+	}
+	return original != "", RecordAudit(ctx, tx, data.AuditActionCreate, data.AuditEntityInternalApplication, id, nil, audited)
+}
+
+// applyExternal is applyInternal for external applications
+func applyExternal(ctx context.Context, tx repository.Stores, emp data.ExternalEmployee, resumes *resumeFiles) (bool, error) {
+	revise, err := keepsRevisions(ctx, tx, emp.Jobid)
+	if err != nil {
+		return false, err
+	}
+	original, err := tx.Applications.FindExternalDuplicate(ctx, emp)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		original = ""
+	case err != nil:
+		return false, err
+	case !revise:
+		return false, ErrDuplicateApplication
+	}
+
+	if emp.Resumepath != "" {
+		if emp.Resumepath, err = resumes.store(emp.Resumepath); err != nil {
+			return false, err
+		}
+	}
+	audited := auditedApplication{JobID: emp.Jobid, Resume: emp.Resumepath != "", RevisionOf: original}
+	var id string
+	if original == "" {
+		id, err = tx.Applications.ApplyExternal(ctx, emp)
+	} else {
+		id, err = tx.Applications.ReviseExternalApplication(ctx, original, emp)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to save employee record: %w", err)
+	}
+	return original != "", RecordAudit(ctx, tx, data.AuditActionCreate, data.AuditEntityExternalApplication, id, nil, audited)
+}
+
+// DuplicateApplications reports the applications, across all jobs, that
+// look like they come from the same person: those with the same
+// normalised email, phone or file number. Groups are transitive, so an
+// application that shares an email with one and a phone with another
+// joins both in one group.
+func (s *JobService) DuplicateApplications(ctx context.Context) ([]data.DuplicateGroup, error) {
+	ctx, span := tracing.Start(ctx, "JobService.DuplicateApplications")
+	defer span.End()
+
+	contacts, err := s.applications.GetApplicationContacts(ctx)
+	if err != nil {
+		tracing.Fail(span, err)
+		return nil, err
+	}
+	return groupDuplicates(contacts), nil
+}
+
+// groupDuplicates groups contacts with a union-find over their normalised
+// details. Groups and their applications keep the order of contacts.
+func groupDuplicates(contacts []data.ApplicationContact) []data.DuplicateGroup {
+	parent := make([]int, len(contacts))
+	for i := range parent {
+		parent[i] = i
+	}
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	type detail struct{ match, value string }
+	first := make(map[detail]int)
+	// matches holds, for each application that joined an earlier one, what
+	// they shared
+	matches := make(map[int][]string)
+	for i, c := range contacts {
+		details := []detail{
+			{data.DuplicateMatchEmail, data.NormalizeEmail(c.Email)},
+			{data.DuplicateMatchPhone, data.NormalizePhone(c.Phone)},
+			{data.DuplicateMatchFileNumber, data.NormalizeFileNumber(c.FileNumber)},
+		}
+		for _, d := range details {
+			if d.value == "" {
+				continue
+			}
+			j, ok := first[d]
+			if !ok {
+				first[d] = i
+				continue
+			}
+			parent[find(i)] = find(j)
+			matches[i] = append(matches[i], d.match)
+		}
+	}
+
+	size := make(map[int]int)
+	for i := range contacts {
+		size[find(i)]++
+	}
+	index := make(map[int]int)
+	var groups []data.DuplicateGroup
+	for i, c := range contacts {
+		root := find(i)
+		if size[root] < 2 {
+			continue
+		}
+		g, ok := index[root]
+		if !ok {
+			g = len(groups)
+			index[root] = g
+			groups = append(groups, data.DuplicateGroup{})
+		}
+		group := &groups[g]
+		group.Applications = append(group.Applications, c)
+		for _, match := range matches[i] {
+			if !slices.Contains(group.MatchedOn, match) {
+				group.MatchedOn = append(group.MatchedOn, match)
+			}
+		}
+	}
+	return groups
+}
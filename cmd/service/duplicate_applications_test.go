@@ -0,0 +1,183 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository/memory"
+)
+
+func TestDuplicateApplicationPolicies(t *testing.T) {
+	store := memory.New()
+	jobs := NewJobService(store, store)
+	rejecting := data.Job{Title: "Teller", Description: "Front desk", Department: "Retail"}
+	merging := data.Job{Title: "Auditor", Description: "Internal audit", Department: "Audit", DuplicatePolicy: data.DuplicatePolicyMerge}
+	for _, job := range []*data.Job{&rejecting, &merging} {
+		if err := CheckDuplicatePolicy(job); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rejecting.DuplicatePolicy != data.DuplicatePolicyReject {
+		t.Errorf("default policy = %q", rejecting.DuplicatePolicy)
+	}
+	if err := jobs.CreateJob(ctx, data.Job{Title: "Clerk", Description: "Back office", Department: "Retail", DuplicatePolicy: "ignore"}); err != ErrInvalidDuplicatePolicy {
+		t.Errorf("unknown policy = %v, want ErrInvalidDuplicatePolicy", err)
+	}
+
+	resumes := t.TempDir()
+	external := NewExternalEmployeeService(store, store, resumes)
+	hana := data.ExternalEmployee{FirstName: "Hana", Email: "hana@example.com", Jobid: rejecting.ID}
+	if revision, err := external.SaveExternalEmployee(ctx, hana); revision || err != nil {
+		t.Fatalf("first application = %v, %v", revision, err)
+	}
+	// A rejected application's resume is not stored
+	upload := filepath.Join(resumes, "upload_cv.pdf")
+	if err := os.WriteFile(upload, []byte("%PDF-1.4\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	again := data.ExternalEmployee{FirstName: "Hana", Email: " HANA@example.com", Jobid: rejecting.ID, Resumepath: upload}
+	if _, err := external.SaveExternalEmployee(ctx, again); err != ErrDuplicateApplication {
+		t.Errorf("second application = %v, want ErrDuplicateApplication", err)
+	}
+	if entries, _ := os.ReadDir(resumes); len(entries) != 1 {
+		t.Errorf("resume directory holds %d files after a rejected application, want only the upload", len(entries))
+	}
+	if _, err := external.SaveExternalEmployee(ctx, data.ExternalEmployee{FirstName: "Hana", Jobid: "missing"}); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("application for a missing job = %v, want ErrJobNotFound", err)
+	}
+
+	// A rejected application leaves its link unused
+	links := NewApplicationLinkService(store, store, store)
+	_, link, err := links.GenerateApplicationLinks(ctx, rejecting.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := external.SubmitViaLink(ctx, again, link.Token); err != ErrDuplicateApplication {
+		t.Errorf("duplicate via a link = %v, want ErrDuplicateApplication", err)
+	}
+	if _, err := links.ValidateApplicationLink(ctx, link.Token); err != nil {
+		t.Errorf("link after a rejected application = %v, want it unused", err)
+	}
+
+	// The merging job keeps a revision and leaves the earlier application
+	// as it was
+	internal := NewInternalEmployeeService(store, store, store, t.TempDir())
+	abel := data.InternalEmployee{FirstName: "Abel", LastName: "Girma", FileNumber: "BB-0002", Jobid: merging.ID}
+	if revision, err := internal.Save_Internal_Employee(ctx, abel); revision || err != nil {
+		t.Fatalf("first application = %v, %v", revision, err)
+	}
+	first := abel
+	abel.FileNumber, abel.OtherBankExp = "bb0002", "Branch operations"
+	for i := 0; i < 2; i++ {
+		if revision, err := internal.Save_Internal_Employee(ctx, abel); !revision || err != nil {
+			t.Errorf("application %d = %v, %v, want a revision", i+2, revision, err)
+		}
+	}
+	apps, _ := store.GetInternalApplicationsByJobID(ctx, merging.ID)
+	if len(apps) != 3 || !reflect.DeepEqual(apps[0], first) || !reflect.DeepEqual(apps[2], abel) {
+		t.Errorf("applications after revisions = %+v", apps)
+	}
+
+	// Duplicates across jobs are only reported
+	if _, err := external.SaveExternalEmployee(ctx, data.ExternalEmployee{FirstName: "Hana", Email: "hana@example.com", Jobid: merging.ID}); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := jobs.DuplicateApplications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || len(groups[0].Applications) != 3 || len(groups[1].Applications) != 2 || groups[1].Applications[1].JobTitle != "Auditor" {
+		t.Fatalf("DuplicateApplications = %+v", groups)
+	}
+	// Revisions name the application they revise, even a revision's
+	abel1, abel2, abel3 := groups[0].Applications[0], groups[0].Applications[1], groups[0].Applications[2]
+	if abel1.RevisionOf != "" || abel2.RevisionOf != abel1.ID || abel3.RevisionOf != abel1.ID {
+		t.Errorf("revisions = %+v", groups[0].Applications)
+	}
+}
+
+func TestGroupDuplicates(t *testing.T) {
+	contacts := []data.ApplicationContact{
+		{ID: "1", Email: "hana@example.com"},
+		{ID: "2", Email: "sara@example.com"},
+		{ID: "3", Email: "Hana@Example.com", Phone: "0911 000 111"},
+		{ID: "4", Phone: "0911-000-111"},
+		{ID: "5", FileNumber: "BB-0002"},
+		{ID: "6", FileNumber: "bb 0002"},
+		{ID: "7"},
+		{ID: "8"},
+	}
+	var got [][]string
+	var matchedOn [][]string
+	for _, group := range groupDuplicates(contacts) {
+		var ids []string
+		for _, c := range group.Applications {
+			ids = append(ids, c.ID)
+		}
+		got = append(got, ids)
+		matchedOn = append(matchedOn, group.MatchedOn)
+	}
+	// Applications without details are never duplicates of each other
+	if want := [][]string{{"1", "3", "4"}, {"5", "6"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groups = %v, want %v", got, want)
+	}
+	if want := [][]string{{"email", "phone"}, {"file_number"}}; !reflect.DeepEqual(matchedOn, want) {
+		t.Errorf("matched on = %v, want %v", matchedOn, want)
+	}
+}
+
+func TestRevisionKeepsOriginalResume(t *testing.T) {
+	store := memory.New()
+	job := data.Job{Title: "Auditor", Description: "Internal audit", Department: "Audit", DuplicatePolicy: data.DuplicatePolicyMerge}
+	if err := store.CreateJob(ctx, &job); err != nil {
+		t.Fatal(err)
+	}
+	resumes := t.TempDir()
+	external := NewExternalEmployeeService(store, store, resumes)
+	upload := func(content string) string {
+		path := filepath.Join(resumes, "upload_cv.pdf")
+		if err := os.WriteFile(path, []byte("%PDF-1.4\n"+content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// The revision and another applicant of the same name each get a file
+	// of their own
+	resumesOf := []string{"original", "revision", "namesake"}
+	applications := []data.ExternalEmployee{
+		{FirstName: "Hana", LastName: "Bekele", Email: "hana@example.com", Jobid: job.ID},
+		{FirstName: "Hana", LastName: "Bekele", Email: "hana@example.com", Jobid: job.ID},
+		{FirstName: "Hana", LastName: "Bekele", Email: "other.hana@example.com", Jobid: job.ID},
+	}
+	for i, app := range applications {
+		app.Resumepath = upload(resumesOf[i])
+		if _, err := external.SaveExternalEmployee(ctx, app); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stored, err := store.GetExternalApplicationsByJobID(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 3 {
+		t.Fatalf("applications = %+v, want 3", stored)
+	}
+	for i, want := range resumesOf {
+		content, err := os.ReadFile(stored[i].Resumepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "%PDF-1.4\n"+want {
+			t.Errorf("resume of application %d = %q, want the %s", i+1, content, want)
+		}
+	}
+}
@@ -1,63 +1,76 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/brehan/bank/cmd/apperr"
 	"github.com/brehan/bank/cmd/data"
 	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+)
+
+var (
+    ErrEmployeeNotFound    = apperr.NotFound("employee_not_found", "employee not found")
+    ErrEmployeeOtherDistrict = apperr.Forbidden("other_district", "district managers can only access employees of their own district")
 )
 
 type EmployeeService interface {
     ValidateEmployee(emp data.Employee) error
-    CreateEmployee(emp data.Employee) error
-    GetEmployeeById(id int) (data.Employee, error)
-    GetEmployeeByFileNumber(fileNumber string) (data.Employee, error)
-    GetAllEmployees() ([]data.Employee, error)
-    UpdateEmployeeManagerInputs(id int, individualPMS float64, districtRec float64) error
-    UpdateEmployeePMS(id int, individualPMS float64) error
-    UpdateEmployeeDistrictRec(id int, districtRec float64, managerBranch string) error
-    GetEmployeeForDistrictManager(id int, managerBranch string) (data.Employee, error)
-    GetEmployeesByBranch(branch string) ([]data.Employee, error)
+    CreateEmployee(ctx context.Context, emp data.Employee) error
+    GetEmployeeById(ctx context.Context, id int) (data.Employee, error)
+    GetEmployeeByFileNumber(ctx context.Context, fileNumber string) (data.Employee, error)
+    GetAllEmployees(ctx context.Context) ([]data.Employee, error)
+    UpdateEmployeeManagerInputs(ctx context.Context, id int, individualPMS float64, districtRec float64) error
+    UpdateEmployeePMS(ctx context.Context, id int, individualPMS float64) error
+    UpdateEmployeeDistrictRec(ctx context.Context, id int, districtRec float64, managerDistrict string) error
+    GetEmployeeForDistrictManager(ctx context.Context, id int, managerDistrict string) (data.Employee, error)
+    GetEmployeesByDistrict(ctx context.Context, district string) ([]data.Employee, error)
 }
 
 type DefaultEmployeeService struct {
-    repo *repository.Repository // Add repository instance
+    repo repository.EmployeeStore
 }
 
 // NewEmployeeService creates a new DefaultEmployeeService with a repository
-func NewEmployeeService(repo *repository.Repository) *DefaultEmployeeService {
+func NewEmployeeService(repo repository.EmployeeStore) *DefaultEmployeeService {
     return &DefaultEmployeeService{repo: repo}
 }
 
 
 func (empser *DefaultEmployeeService) ValidateEmployee(emp data.Employee) error {
+    var fields []apperr.FieldError
     if  emp.ID <= 0 {
-        return errors.New("id is required and must be positive")
+        fields = append(fields, apperr.FieldError{Field: "id", Message: "is required and must be positive"})
     }
     if emp.FileNumber == "" {
-        return errors.New("file number is required")
+        fields = append(fields, apperr.FieldError{Field: "file_number", Message: "is required"})
     }
     if emp.FullName == "" {
-        return errors.New("full name is required")
+        fields = append(fields, apperr.FieldError{Field: "full_name", Message: "is required"})
     }
     if emp.Sex == "" {
-        return errors.New("sex is required")
+        fields = append(fields, apperr.FieldError{Field: "sex", Message: "is required"})
+    } else if emp.Sex != "Male" && emp.Sex != "Female" {
+        fields = append(fields, apperr.FieldError{Field: "sex", Message: "must be 'Male' or 'Female'"})
     }
     if emp.EmploymentDate.IsZero() {
-        return errors.New("employment date is required")
+        fields = append(fields, apperr.FieldError{Field: "employment_date", Message: "is required"})
     }
-    if emp.Sex != "Male" && emp.Sex != "Female" {
-        return errors.New("sex must be 'Male' or 'Female'")
+    if len(fields) > 0 {
+        return apperr.New(apperr.KindValidation, "invalid_employee", "employee is not valid").WithFields(fields...)
     }
- 
 
     return nil
 }
 
-func (empser *DefaultEmployeeService) CreateEmployee(emp data.Employee) error {
+func (empser *DefaultEmployeeService) CreateEmployee(ctx context.Context, emp data.Employee) error {
+    ctx, span := tracing.Start(ctx, "EmployeeService.CreateEmployee")
+    defer span.End()
+
     // Validate required fields
     if err := empser.ValidateEmployee(emp); err != nil {
         return err
@@ -76,7 +89,7 @@ func (empser *DefaultEmployeeService) CreateEmployee(emp data.Employee) error {
     emp.Relatedexp = sql.NullInt64{Int64: int64(relatedExp), Valid: true}
 
     // Fetch max values from database
-    maxTotalExp, maxRelatedExp, _, err := empser.repo.GetmaxValuesofexp(emp)
+    maxTotalExp, maxRelatedExp, _, err := empser.repo.GetmaxValuesofexp(ctx, emp)
     if err != nil {
         return err
     }
@@ -134,13 +147,16 @@ func (empser *DefaultEmployeeService) CreateEmployee(emp data.Employee) error {
     emp.Total = sql.NullFloat64{Float64: totalScore, Valid: true}
 
     // Finally, create the employee
-    return empser.repo.CreateEmployee(emp)
+    return empser.repo.CreateEmployee(ctx, emp)
 }
 
 // Add method to update employee with manager inputs
-func (empser *DefaultEmployeeService) UpdateEmployeeManagerInputs(id int, individualPMS float64, districtRec float64) error {
+func (empser *DefaultEmployeeService) UpdateEmployeeManagerInputs(ctx context.Context, id int, individualPMS float64, districtRec float64) error {
+    ctx, span := tracing.Start(ctx, "EmployeeService.UpdateEmployeeManagerInputs")
+    defer span.End()
+
     // Get the employee by ID
-    emp, err := empser.GetEmployeeById(id)
+    emp, err := empser.GetEmployeeById(ctx, id)
     if err != nil {
         return err
     }
@@ -175,13 +191,16 @@ func (empser *DefaultEmployeeService) UpdateEmployeeManagerInputs(id int, indivi
     emp.Total = sql.NullFloat64{Float64: totalScore, Valid: true}
     
     // Update the employee in the database
-    return empser.repo.UpdateEmployee(emp)
+    return empser.repo.UpdateEmployee(ctx, emp)
 }
 
 // Update only Individual PMS (for managers)
-func (empser *DefaultEmployeeService) UpdateEmployeePMS(id int, individualPMS float64) error {
+func (empser *DefaultEmployeeService) UpdateEmployeePMS(ctx context.Context, id int, individualPMS float64) error {
+    ctx, span := tracing.Start(ctx, "EmployeeService.UpdateEmployeePMS")
+    defer span.End()
+
     // Get the employee by ID
-    emp, err := empser.GetEmployeeById(id)
+    emp, err := empser.GetEmployeeById(ctx, id)
     if err != nil {
         return err
     }
@@ -215,20 +234,23 @@ func (empser *DefaultEmployeeService) UpdateEmployeePMS(id int, individualPMS fl
     emp.Total = sql.NullFloat64{Float64: totalScore, Valid: true}
     
     // Update the employee in the database
-    return empser.repo.UpdateEmployee(emp)
+    return empser.repo.UpdateEmployee(ctx, emp)
 }
 
 // Update only District Recommendation (for district managers)
-func (empser *DefaultEmployeeService) UpdateEmployeeDistrictRec(id int, districtRec float64, managerBranch string) error {
+func (empser *DefaultEmployeeService) UpdateEmployeeDistrictRec(ctx context.Context, id int, districtRec float64, managerDistrict string) error {
+    ctx, span := tracing.Start(ctx, "EmployeeService.UpdateEmployeeDistrictRec")
+    defer span.End()
+
     // Get the employee by ID
-    emp, err := empser.GetEmployeeById(id)
+    emp, err := empser.GetEmployeeById(ctx, id)
     if err != nil {
         return err
     }
     
-    // Check if manager is from the same branch
-    if emp.Branch != managerBranch {
-        return errors.New("district manager can only update employees from the same branch")
+    // Check if manager is from the same district
+    if emp.District != managerDistrict {
+        return ErrEmployeeOtherDistrict
     }
     
     // Update only the District Recommendation
@@ -255,21 +277,38 @@ func (empser *DefaultEmployeeService) UpdateEmployeeDistrictRec(id int, district
     emp.Total = sql.NullFloat64{Float64: totalScore, Valid: true}
     
     // Update the employee in the database
-    return empser.repo.UpdateEmployee(emp)
+    return empser.repo.UpdateEmployee(ctx, emp)
 }
 
-func (empser *DefaultEmployeeService) GetEmployeeById(id int) (data.Employee, error) {
-    return empser.repo.GetEmployeesByID(id)
+func (empser *DefaultEmployeeService) GetEmployeeById(ctx context.Context, id int) (data.Employee, error) {
+    ctx, span := tracing.Start(ctx, "EmployeeService.GetEmployeeById")
+    defer span.End()
+
+    emp, err := empser.repo.GetEmployeesByID(ctx, id)
+    if errors.Is(err, sql.ErrNoRows) {
+        return emp, ErrEmployeeNotFound
+    }
+    return emp, err
 }
 
 
-func (empser *DefaultEmployeeService) GetEmployeeByFileNumber(fileNumber string) (data.Employee, error) {
-    return empser.repo.GetEmployeeByFileNumber(fileNumber)
+func (empser *DefaultEmployeeService) GetEmployeeByFileNumber(ctx context.Context, fileNumber string) (data.Employee, error) {
+    ctx, span := tracing.Start(ctx, "EmployeeService.GetEmployeeByFileNumber")
+    defer span.End()
+
+    emp, err := empser.repo.GetEmployeeByFileNumber(ctx, fileNumber)
+    if errors.Is(err, sql.ErrNoRows) {
+        return emp, ErrEmployeeNotFound
+    }
+    return emp, err
 }
-func (empser *DefaultEmployeeService) GetAllEmployees() ([]data.Employee, error) {
-    employees, err := empser.repo.GetAllEmployees()
+func (empser *DefaultEmployeeService) GetAllEmployees(ctx context.Context) ([]data.Employee, error) {
+    ctx, span := tracing.Start(ctx, "EmployeeService.GetAllEmployees")
+    defer span.End()
+
+    employees, err := empser.repo.GetAllEmployees(ctx)
     if err != nil {
-        return nil, fmt.Errorf("failed to get employees from repository: %v", err)
+        return nil, fmt.Errorf("failed to get employees from repository: %w", err)
     }
     
     // Ensure all nullable fields have valid values
@@ -304,16 +343,19 @@ func (empser *DefaultEmployeeService) GetAllEmployees() ([]data.Employee, error)
 }
 
 // Get limited employee data for district managers
-func (empser *DefaultEmployeeService) GetEmployeeForDistrictManager(id int, managerBranch string) (data.Employee, error) {
+func (empser *DefaultEmployeeService) GetEmployeeForDistrictManager(ctx context.Context, id int, managerDistrict string) (data.Employee, error) {
+    ctx, span := tracing.Start(ctx, "EmployeeService.GetEmployeeForDistrictManager")
+    defer span.End()
+
     // Get the employee by ID
-    emp, err := empser.GetEmployeeById(id)
+    emp, err := empser.GetEmployeeById(ctx, id)
     if err != nil {
         return data.Employee{}, err
     }
     
-    // Check if manager is from the same branch
-    if emp.Branch != managerBranch {
-        return data.Employee{}, errors.New("district manager can only view employees from the same branch")
+    // Check if manager is from the same district
+    if emp.District != managerDistrict {
+        return data.Employee{}, ErrEmployeeOtherDistrict
     }
     
     // Return limited information
@@ -321,33 +363,38 @@ func (empser *DefaultEmployeeService) GetEmployeeForDistrictManager(id int, mana
         ID:       emp.ID,
         FullName: emp.FullName,
         Branch:   emp.Branch,
+        District: emp.District,
         // Only include fields needed by district managers
     }
     
     return limitedEmp, nil
 }
 
-// Get all employees for a specific branch (for district managers)
-func (empser *DefaultEmployeeService) GetEmployeesByBranch(branch string) ([]data.Employee, error) {
-    allEmployees, err := empser.repo.GetAllEmployees()
+// Get all employees of a district (for district managers)
+func (empser *DefaultEmployeeService) GetEmployeesByDistrict(ctx context.Context, district string) ([]data.Employee, error) {
+    ctx, span := tracing.Start(ctx, "EmployeeService.GetEmployeesByDistrict")
+    defer span.End()
+
+    allEmployees, err := empser.repo.GetAllEmployees(ctx)
     if err != nil {
         return nil, err
     }
     
-    // Filter employees by branch and return limited information
-    var branchEmployees []data.Employee
+    // Filter employees by district and return limited information
+    var districtEmployees []data.Employee
     for _, emp := range allEmployees {
-        if emp.Branch == branch {
+        if emp.District == district {
             // Only include necessary fields
             limitedEmp := data.Employee{
                 ID:       emp.ID,
                 FullName: emp.FullName,
                 Branch:   emp.Branch,
+                District: emp.District,
                 // Add other fields that district managers need to see
             }
-            branchEmployees = append(branchEmployees, limitedEmp)
+            districtEmployees = append(districtEmployees, limitedEmp)
         }
     }
     
-    return branchEmployees, nil
+    return districtEmployees, nil
 }
\ No newline at end of file
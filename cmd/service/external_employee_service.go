@@ -1,63 +1,84 @@
 package service
 
 import (
-	"errors"
-	"fmt"
-	"io"
-
-	"os"
-	"path/filepath"
+	"context"
 
 	"github.com/brehan/bank/cmd/data"
 	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
 )
 
 type ExternalEmployeeService struct {
-	repo repository.Repository
+	repo repository.ApplicationStore
+	uow  repository.UnitOfWork
+	// resumeDir is where uploads are saved and resumes are kept
+	resumeDir string
 }
 
-func NewExternalEmployeeService(repo repository.Repository) *ExternalEmployeeService {
+func NewExternalEmployeeService(repo repository.ApplicationStore, uow repository.UnitOfWork, resumeDir string) *ExternalEmployeeService {
 	return &ExternalEmployeeService{
-		repo: repo,
+		repo:      repo,
+		uow:       uow,
+		resumeDir: resumeDir,
 	}
 }
 
-func (s *ExternalEmployeeService) SaveExternalEmployee(emp data.ExternalEmployee) error {
-	// First save the resume file if a path is provided
-	if emp.Resumepath != "" {
-		originalPath := emp.Resumepath
-		
-		// Save the resume and get the new path
-		err := s.SaveResume(emp, originalPath)
-		if err != nil {
-			return fmt.Errorf("failed to save resume: %w", err)
-		}
-		
-		// Create a safe filename
-		safeFileName, err := GetSafeFileName(emp.FirstName, emp.LastName, originalPath)
-		if err != nil {
-			return fmt.Errorf("failed to create filename: %w", err)
-		}
-		
-		// Update the path in the employee record
-		emp.Resumepath = filepath.Join("static", safeFileName)
+// SaveExternalEmployee saves an application. If the applicant already
+// applied for the job, it is rejected with ErrDuplicateApplication or, if
+// the job merges duplicates, kept as a revision of the earlier application;
+// revision reports which.
+func (s *ExternalEmployeeService) SaveExternalEmployee(ctx context.Context, emp data.ExternalEmployee) (revision bool, err error) {
+	ctx, span := tracing.Start(ctx, "ExternalEmployeeService.SaveExternalEmployee")
+	defer span.End()
+
+	// Save the employee record, and its resume once it is accepted
+	resumes := newResumeFiles(s.resumeDir)
+	err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		revision, err = applyExternal(ctx, tx, emp, resumes)
+		return err
+	})
+	if err != nil {
+		resumes.discard()
 	}
-	
-	// Save the employee record to the repository
-	err := s.repo.ApplyExternal(emp)
+	return revision, err
+}
+
+// SubmitViaLink saves an application sent through a one-off application
+// link and marks the link used in one transaction. Duplicates are handled
+// as by SaveExternalEmployee; a rejected one leaves the link unused.
+func (s *ExternalEmployeeService) SubmitViaLink(ctx context.Context, emp data.ExternalEmployee, token string) (revision bool, err error) {
+	ctx, span := tracing.Start(ctx, "ExternalEmployeeService.SubmitViaLink")
+	defer span.End()
+
+	resumes := newResumeFiles(s.resumeDir)
+	err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		// Use the link first, so that a second submission on it fails
+		// before anything is saved
+		if err := useLink(ctx, tx.Links, token); err != nil {
+			return err
+		}
+		revision, err = applyExternal(ctx, tx, emp, resumes)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to save employee record: %w", err)
+		resumes.discard()
 	}
-	
-	return nil
+	return revision, err
 }
 
-func (s *ExternalEmployeeService) GetAllExternalApplications() ([]data.ExternalEmployee, error) {
-	return s.repo.GetAllExternalApplications()
+
+func (s *ExternalEmployeeService) GetAllExternalApplications(ctx context.Context) ([]data.ExternalEmployee, error) {
+	ctx, span := tracing.Start(ctx, "ExternalEmployeeService.GetAllExternalApplications")
+	defer span.End()
+
+	return s.repo.GetAllExternalApplications(ctx)
 }
 
-func (s *ExternalEmployeeService) GetApplicationsByJobID(jobID string) ([]data.ExternalEmployee, error) {
-	allApps, err := s.GetAllExternalApplications()
+func (s *ExternalEmployeeService) GetApplicationsByJobID(ctx context.Context, jobID string) ([]data.ExternalEmployee, error) {
+	ctx, span := tracing.Start(ctx, "ExternalEmployeeService.GetApplicationsByJobID")
+	defer span.End()
+
+	allApps, err := s.GetAllExternalApplications(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -71,45 +92,3 @@ func (s *ExternalEmployeeService) GetApplicationsByJobID(jobID string) ([]data.E
 	
 	return jobApps, nil
 }
-
-func (s *ExternalEmployeeService) SaveResume(emp data.ExternalEmployee, originalFilePath string) error {
-	if originalFilePath == "" {
-		return errors.New("file path is empty")
-	}
-
-	// Use ValidateFilePath from helper.go to check file
-	if err := ValidateFilePath(originalFilePath); err != nil {
-		return err
-	}
-	
-	// Create a safe filename
-	safeFileName, err := GetSafeFileName(emp.FirstName, emp.LastName, originalFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to create filename: %w", err)
-	}
-	
-	// Define the destination path (static folder)
-	destPath := filepath.Join("static", safeFileName)
-	
-	// Open file to copy
-	file, err := os.Open(originalFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-	
-	// Create destination file
-	destFile, err := os.Create(destPath)
-	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
-	}
-	defer destFile.Close()
-	
-	// Copy file contents
-	_, err = io.Copy(destFile, file)
-	if err != nil {
-		return fmt.Errorf("failed to copy file: %w", err)
-	}
-	
-	return nil
-} 
\ No newline at end of file
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+	"github.com/google/uuid"
+)
+
+// ErrNoMappedRole is returned when none of the user's groups at the identity
+// provider map to a role here
+var ErrNoMappedRole = apperr.Forbidden("no_mapped_role", "none of your groups grant access to this application")
+
+// LoginResult is the result of Login and LoginExternal. Provisioned is set
+// when the user was created by this sign-in.
+type LoginResult struct {
+	User        *data.User
+	Access      data.UserAccess
+	Provisioned bool
+}
+
+// LoginExternal signs in a user authenticated by an identity provider. The
+// provider is the source of truth for roles: they are replaced on every
+// sign-in with the roles mapped from the user's groups.
+//
+// Users are provisioned the first time they sign in. An existing account is
+// never linked by email, even one the provider says is verified, since that
+// would hand the account to whoever controls the address at the provider.
+// The new account gets no email if the address is taken. A local account is
+// moved to a directory by an admin, and Login then finds it by name.
+func (s *AuthService) LoginExternal(ctx context.Context, identity data.ExternalIdentity, authProvider string, roles []data.UserRole) (*LoginResult, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.LoginExternal")
+	defer span.End()
+
+	if len(roles) == 0 {
+		return nil, ErrNoMappedRole
+	}
+
+	user, err := s.repo.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	provisioned := user == nil
+	if provisioned {
+		user, err = s.provisionExternalUser(ctx, identity, authProvider, roles)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if user.Status == data.UserStatusDisabled {
+			return nil, ErrAccountDisabled
+		}
+		if user, err = s.syncRoles(ctx, user, roles); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.TouchIdentity(ctx, identity.Provider, identity.Subject, time.Now()); err != nil {
+		return nil, err
+	}
+
+	access, err := s.repo.GetUserAccess(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{User: user, Access: access, Provisioned: provisioned}, nil
+}
+
+// syncRoles replaces a user's roles with those from the identity provider.
+// If they changed, the user's other sessions end and the user is returned
+// with the new token version.
+func (s *AuthService) syncRoles(ctx context.Context, user *data.User, roles []data.UserRole) (*data.User, error) {
+	access, err := s.repo.GetUserAccess(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if sameRoles(access.Roles, roles) {
+		return user, nil
+	}
+	err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		if err := tx.Users.SetUserRoles(ctx, user.Id, roles); err != nil {
+			return err
+		}
+		return tx.Users.BumpTokenVersion(ctx, user.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetUserByID(ctx, user.Id)
+}
+
+// sameRoles reports whether a and b hold the same roles and districts, in
+// any order
+func sameRoles(a, b []data.UserRole) bool {
+	held := make(map[data.UserRole]bool, len(a))
+	for _, role := range a {
+		held[role] = true
+	}
+	granted := make(map[data.UserRole]bool, len(b))
+	for _, role := range b {
+		if !held[role] {
+			return false
+		}
+		granted[role] = true
+	}
+	return len(granted) == len(held)
+}
+
+func (s *AuthService) provisionExternalUser(ctx context.Context, identity data.ExternalIdentity, authProvider string, roles []data.UserRole) (*data.User, error) {
+	name := externalUserName(identity)
+	existing, err := s.repo.GetUserByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrUserExists
+	}
+
+	email := ""
+	if identity.EmailVerified {
+		email = identity.Email
+	}
+	if email != "" {
+		// The address may belong to another account, which is not linked
+		taken, err := s.repo.GetUserByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		if taken != nil {
+			email = ""
+		}
+	}
+
+	now := time.Now()
+	user := &data.User{
+		Id:           uuid.New(),
+		Name:         name,
+		Email:        email,
+		Status:       data.UserStatusActive,
+		AuthProvider: authProvider,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		if err := tx.Users.CreateExternalUser(ctx, user, identity, roles); err != nil {
+			return err
+		}
+		return auditUserCreated(ctx, tx, user.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// externalUserName picks the local user name for a provisioned account
+func externalUserName(identity data.ExternalIdentity) string {
+	for _, name := range []string{identity.Username, identity.Email, identity.Subject} {
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+	return identity.Subject
+}
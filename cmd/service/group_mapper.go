@@ -0,0 +1,71 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brehan/bank/cmd/data"
+)
+
+// GroupMapper turns the groups an identity provider reports for a user into
+// roles. It is configured with a comma separated list of group=role or
+// group=role:district entries, for example
+//
+//	bank-admins=admin,bank-managers=manager,district-north=district_manager:North
+//
+// A group may appear more than once to grant several roles.
+type GroupMapper struct {
+	mappings map[string][]data.UserRole
+}
+
+// ParseGroupMapper parses a group mapping. An empty spec maps nothing.
+func ParseGroupMapper(spec string) (*GroupMapper, error) {
+	mapper := &GroupMapper{mappings: make(map[string][]data.UserRole)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		group, target, ok := strings.Cut(entry, "=")
+		group, target = strings.TrimSpace(group), strings.TrimSpace(target)
+		if !ok || group == "" || target == "" {
+			return nil, fmt.Errorf("invalid group mapping %q: expected group=role[:district]", entry)
+		}
+
+		role, district, _ := strings.Cut(target, ":")
+		role, district = strings.TrimSpace(role), strings.TrimSpace(district)
+		if role == data.RoleDistrictManager && district == "" {
+			return nil, fmt.Errorf("invalid group mapping %q: %s needs a district", entry, role)
+		}
+		if role != data.RoleDistrictManager && district != "" {
+			return nil, fmt.Errorf("invalid group mapping %q: only %s takes a district", entry, data.RoleDistrictManager)
+		}
+
+		mapper.mappings[group] = append(mapper.mappings[group], data.UserRole{Role: role, District: district})
+	}
+	return mapper, nil
+}
+
+// Roles returns the roles granted by groups. Each role is granted once; if
+// several groups grant the same role with different districts, the first
+// group in the provider's list wins.
+func (m *GroupMapper) Roles(groups []string) []data.UserRole {
+	var roles []data.UserRole
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		for _, role := range m.mappings[group] {
+			if seen[role.Role] {
+				continue
+			}
+			seen[role.Role] = true
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Empty reports whether no groups are mapped
+func (m *GroupMapper) Empty() bool {
+	return len(m.mappings) == 0
+}
@@ -10,6 +10,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"github.com/brehan/bank/cmd/apperr"
 )
 
 const (
@@ -17,16 +19,28 @@ const (
 	MaxFileSize = 2 * 1024 * 1024 
 )
 
+var (
+	ErrResumeTooLarge = apperr.Validation("resume_too_large", "resume", "file size exceeds the limit of 2MB")
+	ErrResumeNotPDF   = apperr.Validation("resume_not_pdf", "resume", "only PDF files are allowed")
+	ErrResumeNotFound = apperr.Validation("resume_not_found", "resumepath", "resume file not found")
+)
+
+// uploadedTo reports whether path names a file directly in dir, where the
+// handlers save uploads. Any other path came from the client.
+func uploadedTo(dir, path string) bool {
+	return filepath.Dir(filepath.Clean(path)) == filepath.Clean(dir)
+}
+
 func ValidateFile(file *multipart.FileHeader) error {
 	// Check file size
 	if file.Size > MaxFileSize {
-		return fmt.Errorf("file size exceeds the limit of 2MB, current size: %d bytes", file.Size)
+		return ErrResumeTooLarge
 	}
 
 	// Check file extension
 	ext := strings.ToLower(filepath.Ext(file.Filename))
 	if ext != ".pdf" {
-		return errors.New("only PDF files are allowed")
+		return ErrResumeNotPDF
 	}
 
 	// Optional: verify content type (MIME type)
@@ -45,7 +59,7 @@ func ValidateFile(file *multipart.FileHeader) error {
 
 	contentType := http.DetectContentType(buffer)
 	if !strings.Contains(contentType, "application/pdf") && !strings.Contains(contentType, "application/octet-stream") {
-		return fmt.Errorf("invalid file content type: %s, only PDF files are allowed", contentType)
+		return ErrResumeNotPDF.Wrap(fmt.Errorf("content type %s", contentType))
 	}
 
 	return nil
@@ -105,8 +119,8 @@ func GetSafeFileName(firstName, lastName, originalFilename string) (string, erro
 	return fileName, nil
 }
 
-// ProcessResume handles the resume upload process
-func ProcessResume(file *multipart.FileHeader, firstName, lastName string) (string, error) {
+// ProcessResume handles the resume upload process, saving the file in dir
+func ProcessResume(file *multipart.FileHeader, dir, firstName, lastName string) (string, error) {
 	// Validate the file
 	if err := ValidateFile(file); err != nil {
 		return "", err
@@ -119,7 +133,7 @@ func ProcessResume(file *multipart.FileHeader, firstName, lastName string) (stri
 	}
 	
 	// Define destination path
-	destPath := filepath.Join("static", fileName)
+	destPath := filepath.Join(dir, fileName)
 	
 	// Open the uploaded file
 	src, err := file.Open()
@@ -141,18 +155,18 @@ func ValidateFilePath(filePath string) error {
 	// Check if file exists
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
-		return fmt.Errorf("file not found or inaccessible: %w", err)
+		return ErrResumeNotFound.Wrap(err)
 	}
 	
 	// Check file size
 	if fileInfo.Size() > MaxFileSize {
-		return fmt.Errorf("file size exceeds the limit of 2MB, current size: %d bytes", fileInfo.Size())
+		return ErrResumeTooLarge
 	}
 	
 	// Check file extension
 	ext := strings.ToLower(filepath.Ext(filePath))
 	if ext != ".pdf" {
-		return errors.New("only PDF files are allowed")
+		return ErrResumeNotPDF
 	}
 	
 	// Verify content type
@@ -171,7 +185,7 @@ func ValidateFilePath(filePath string) error {
 	
 	contentType := http.DetectContentType(buffer)
 	if !strings.Contains(contentType, "application/pdf") && !strings.Contains(contentType, "application/octet-stream") {
-		return fmt.Errorf("invalid file content type: %s, only PDF files are allowed", contentType)
+		return ErrResumeNotPDF.Wrap(fmt.Errorf("content type %s", contentType))
 	}
 	
 	return nil
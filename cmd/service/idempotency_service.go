@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+)
+
+var (
+	ErrIdempotencyKeyInUse  = apperr.Conflict("idempotency_key_in_use", "a request with this Idempotency-Key is still in progress")
+	ErrIdempotencyKeyReused = apperr.New(apperr.KindUnprocessable, "idempotency_key_reused", "this Idempotency-Key was used for a different request")
+)
+
+const (
+	// idempotencyLease is how long a request in progress holds its key. It
+	// only matters if the server stops before the request completes.
+	idempotencyLease = 5 * time.Minute
+	// idempotencyPruneInterval is how often expired requests are deleted
+	idempotencyPruneInterval = time.Minute
+)
+
+// IdempotencyService runs each request made with an Idempotency-Key once
+// and replays its response to retries until the response expires
+type IdempotencyService struct {
+	store repository.IdempotencyStore
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu        sync.Mutex
+	nextPrune time.Time
+}
+
+// NewIdempotencyService keeps responses for ttl
+func NewIdempotencyService(store repository.IdempotencyStore, ttl time.Duration) *IdempotencyService {
+	return &IdempotencyService{store: store, ttl: ttl, now: time.Now}
+}
+
+// Begin claims key for the request with the given fingerprint. It returns
+// nil if the request should run, and the stored request if it already ran.
+// A key held by a request in progress or by a different request is an
+// error.
+func (s *IdempotencyService) Begin(ctx context.Context, key, fingerprint string) (*data.IdempotentRequest, error) {
+	ctx, span := tracing.Start(ctx, "IdempotencyService.Begin")
+	defer span.End()
+
+	now := s.now()
+	s.prune(ctx, now)
+	held, err := s.store.ReserveIdempotencyKey(ctx, data.IdempotentRequest{
+		Key:         key,
+		Fingerprint: fingerprint,
+		ExpiresAt:   now.Add(idempotencyLease),
+	}, now)
+	switch {
+	case err != nil:
+		tracing.Fail(span, err)
+		return nil, err
+	case held == nil:
+		return nil, nil
+	case held.Fingerprint != fingerprint:
+		return nil, ErrIdempotencyKeyReused
+	case !held.Done():
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return held, nil
+}
+
+// Complete stores the response to the request that holds key
+func (s *IdempotencyService) Complete(ctx context.Context, key string, status int, contentType string, body []byte) error {
+	ctx, span := tracing.Start(ctx, "IdempotencyService.Complete")
+	defer span.End()
+
+	err := s.store.CompleteIdempotencyKey(ctx, data.IdempotentRequest{
+		Key:         key,
+		Status:      status,
+		ContentType: contentType,
+		Body:        body,
+		ExpiresAt:   s.now().Add(s.ttl),
+	})
+	if err != nil {
+		tracing.Fail(span, err)
+	}
+	return err
+}
+
+// Release frees key without storing a response, so that a retry runs again
+func (s *IdempotencyService) Release(ctx context.Context, key string) error {
+	ctx, span := tracing.Start(ctx, "IdempotencyService.Release")
+	defer span.End()
+
+	err := s.store.DeleteIdempotencyKey(ctx, key)
+	if err != nil {
+		tracing.Fail(span, err)
+	}
+	return err
+}
+
+// prune deletes expired requests at most once every
+// idempotencyPruneInterval. Failing to is not the request's problem, so
+// the error only goes on the span.
+func (s *IdempotencyService) prune(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Before(s.nextPrune) {
+		s.mu.Unlock()
+		return
+	}
+	s.nextPrune = now.Add(idempotencyPruneInterval)
+	s.mu.Unlock()
+
+	ctx, span := tracing.Start(ctx, "IdempotencyService.prune")
+	defer span.End()
+	if err := s.store.DeleteExpiredIdempotencyKeys(ctx, now); err != nil {
+		span.RecordError(err)
+	}
+}
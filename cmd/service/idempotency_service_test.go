@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brehan/bank/cmd/repository/memory"
+)
+
+func TestIdempotencyService(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 9, 0, 0, 0, time.UTC)
+	keys := NewIdempotencyService(memory.New().Stores().Idempotency, time.Hour)
+	keys.now = func() time.Time { return now }
+
+	if held, err := keys.Begin(ctx, "create_job k1", "f1"); held != nil || err != nil {
+		t.Fatalf("first request = %+v, %v, want it to run", held, err)
+	}
+	if _, err := keys.Begin(ctx, "create_job k1", "f1"); err != ErrIdempotencyKeyInUse {
+		t.Errorf("retry while in progress = %v, want ErrIdempotencyKeyInUse", err)
+	}
+	if err := keys.Complete(ctx, "create_job k1", 201, "application/json", []byte(`{"job_id":"1"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	held, err := keys.Begin(ctx, "create_job k1", "f1")
+	if err != nil || held == nil || held.Status != 201 || string(held.Body) != `{"job_id":"1"}` {
+		t.Fatalf("retry = %+v, %v, want the stored response", held, err)
+	}
+	if _, err := keys.Begin(ctx, "create_job k1", "f2"); err != ErrIdempotencyKeyReused {
+		t.Errorf("different request with the key = %v, want ErrIdempotencyKeyReused", err)
+	}
+
+	// After the TTL the key is free again
+	now = now.Add(time.Hour)
+	if held, err := keys.Begin(ctx, "create_job k1", "f2"); held != nil || err != nil {
+		t.Errorf("request after the TTL = %+v, %v, want it to run", held, err)
+	}
+
+	// A released key lets a retry run
+	if err := keys.Release(ctx, "create_job k1"); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := keys.Begin(ctx, "create_job k1", "f1"); held != nil || err != nil {
+		t.Errorf("request after release = %+v, %v, want it to run", held, err)
+	}
+
+	// A request in progress holds its key only for the lease
+	now = now.Add(idempotencyLease)
+	if held, err := keys.Begin(ctx, "create_job k1", "f3"); held != nil || err != nil {
+		t.Errorf("request after an abandoned one = %+v, %v, want it to run", held, err)
+	}
+}
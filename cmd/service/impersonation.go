@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrCannotImpersonateSelf = apperr.Validation("cannot_impersonate_self", "", "you cannot impersonate yourself")
+	ErrInvalidImpersonation  = apperr.Validation("invalid_impersonation_ttl", "ttl_minutes", "impersonation duration must be between 1 and 60 minutes")
+	ErrImpersonateDisabled   = apperr.Conflict("user_disabled", "a disabled account cannot be impersonated")
+)
+
+const (
+	DefaultImpersonationTTL = 15 * time.Minute
+	MaxImpersonationTTL     = time.Hour
+)
+
+// Impersonation describes a short-lived session in which an admin sees the
+// application as another user
+type Impersonation struct {
+	Impersonator *data.User
+	User         *data.User
+	Access       data.UserAccess
+	ReadOnly     bool
+	ExpiresAt    time.Time
+}
+
+// Impersonate checks that impersonatorID may act as userID and returns the
+// user's access. A ttl of zero means DefaultImpersonationTTL.
+func (s *AuthService) Impersonate(ctx context.Context, impersonatorID, userID uuid.UUID, readOnly bool, ttl time.Duration) (*Impersonation, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Impersonate")
+	defer span.End()
+
+	if ttl == 0 {
+		ttl = DefaultImpersonationTTL
+	}
+	if ttl < time.Minute || ttl > MaxImpersonationTTL {
+		return nil, ErrInvalidImpersonation
+	}
+	if impersonatorID == userID {
+		return nil, ErrCannotImpersonateSelf
+	}
+
+	impersonator, err := s.repo.GetUserByID(ctx, impersonatorID)
+	if err != nil {
+		return nil, err
+	}
+	if impersonator == nil {
+		return nil, ErrUserNotFound
+	}
+	user, access, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status == data.UserStatusDisabled {
+		return nil, ErrImpersonateDisabled
+	}
+
+	imp := &Impersonation{
+		Impersonator: impersonator,
+		User:         user,
+		Access:       access,
+		ReadOnly:     readOnly,
+		ExpiresAt:    time.Now().Add(ttl),
+	}
+	err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		return RecordAudit(ctx, tx, data.AuditActionCreate, data.AuditEntityImpersonation, userID.String(), nil, map[string]interface{}{
+			"impersonator_id": impersonatorID,
+			"user_id":         userID,
+			"mode":            imp.Mode(),
+			"expires_at":      imp.ExpiresAt.UTC(),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imp, nil
+}
+
+// Mode is the impersonation mode carried by the session token, read-only or
+// read-write
+func (imp *Impersonation) Mode() string {
+	if imp.ReadOnly {
+		return "read-only"
+	}
+	return "read-write"
+}
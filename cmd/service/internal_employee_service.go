@@ -1,128 +1,159 @@
 package service
 
 import (
-	"errors"
-	"fmt"
-	"path/filepath"
-	"os"
-	"io"
+	"context"
+	"database/sql"
 	"github.com/brehan/bank/cmd/data"
 	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
 )
 
 
 // InternalEmployeeService handles operations for internal employees
 type InternalEmployeeService struct {
-	repo repository.Repository
+	applications repository.ApplicationStore
+	employees    repository.EmployeeStore
+	uow          repository.UnitOfWork
+	// resumeDir is where uploads are saved and resumes are kept
+	resumeDir string
 }
 
 // NewInternalEmployeeService creates a new InternalEmployeeService instance
-func NewInternalEmployeeService(repo repository.Repository) *InternalEmployeeService {
+func NewInternalEmployeeService(applications repository.ApplicationStore, employees repository.EmployeeStore, uow repository.UnitOfWork, resumeDir string) *InternalEmployeeService {
 	return &InternalEmployeeService{
-		repo: repo,
+		applications: applications,
+		employees:    employees,
+		uow:          uow,
+		resumeDir:    resumeDir,
 	}
 }
 
-// MatchWithExistingEmployee matches an internal application with an existing employee
-func (s *InternalEmployeeService) MatchWithExistingEmployee(application data.InternalEmployee) (data.Employee, error) {
+// MatchWithExistingEmployee matches an internal application with an existing
+// employee. It returns sql.ErrNoRows if nobody matches.
+func (s *InternalEmployeeService) MatchWithExistingEmployee(ctx context.Context, application data.InternalEmployee) (data.Employee, error) {
+	ctx, span := tracing.Start(ctx, "InternalEmployeeService.MatchWithExistingEmployee")
+	defer span.End()
+
+	var matchedEmployee data.Employee
+	err := s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		var err error
+		matchedEmployee, err = matchEmployee(ctx, tx, application)
+		return err
+	})
+	return matchedEmployee, err
+}
+
+// FindMatchingEmployee returns the employee MatchWithExistingEmployee would
+// match the application with, without linking them or restarting the
+// employee's evaluation. It returns sql.ErrNoRows if nobody matches.
+func (s *InternalEmployeeService) FindMatchingEmployee(ctx context.Context, application data.InternalEmployee) (data.Employee, error) {
+	ctx, span := tracing.Start(ctx, "InternalEmployeeService.FindMatchingEmployee")
+	defer span.End()
+
+	employees, err := s.employees.GetEmployeesByName(ctx, application.FirstName+" "+application.LastName)
+	if err != nil {
+		return data.Employee{}, err
+	}
+	if len(employees) == 0 {
+		return data.Employee{}, sql.ErrNoRows
+	}
+	return employees[0], nil
+}
+
+// matchEmployee links the application to an employee and starts their
+// evaluation, so the reset scores and the new experience score are written
+// together
+func matchEmployee(ctx context.Context, tx repository.Stores, application data.InternalEmployee) (data.Employee, error) {
 	// Use the repository's enhanced auto-matching function
-	matchedEmployee, err := s.repo.AutoMatchInternalApplication(application)
+	matchedEmployee, err := tx.Applications.AutoMatchInternalApplication(ctx, application)
 	if err != nil {
 		return data.Employee{}, err
 	}
-	
+	if matchedEmployee.ID == 0 {
+		return matchedEmployee, sql.ErrNoRows
+	}
+
 	// If we have a match, calculate the experience score automatically
 	// Other scores will need to be filled by managers and district managers
-	err = s.repo.CalculateExperienceScore(matchedEmployee.ID)
+	err = tx.Employees.CalculateExperienceScore(ctx, matchedEmployee.ID)
 	if err != nil {
 		return matchedEmployee, err
 	}
-	
+
 	// Return the matched employee with initialized evaluation
 	return matchedEmployee, nil
 }
 
-// GetApplicationsByJobID retrieves all internal applications for a specific job
-func (s *InternalEmployeeService) GetApplicationsByJobID(jobID string) ([]data.InternalEmployee, error) {
-	return s.repo.GetInternalApplicationsByJobID(jobID)
-}
-
-// GetAllInternalApplications retrieves all internal applications
-func (s *InternalEmployeeService) GetAllInternalApplications() ([]data.InternalEmployee, error) {
-	return s.repo.GetAllInternalApplications()
-}
+// SubmitViaLink saves an application sent through a one-off application
+// link, matches it with an employee and marks the link used in one
+// transaction, so a link is only used up by an application that was saved.
+// The returned employee is zero if the applicant matches nobody.
+//
+// Duplicates are handled as by Save_Internal_Employee. A revision is not
+// matched again, which would restart the employee's evaluation.
+func (s *InternalEmployeeService) SubmitViaLink(ctx context.Context, emp data.InternalEmployee, token string) (matchedEmployee data.Employee, revision bool, err error) {
+	ctx, span := tracing.Start(ctx, "InternalEmployeeService.SubmitViaLink")
+	defer span.End()
 
-// Save_Internal_Employee saves an internal employee application
-func (s *InternalEmployeeService) Save_Internal_Employee(emp data.InternalEmployee) error {
-	// First save the resume file if a path is provided
-	if emp.Resumepath != "" {
-		originalPath := emp.Resumepath
-		
-		// Save the resume and get the new path
-		err := s.SaveResume(emp, originalPath)
-		if err != nil {
-			return fmt.Errorf("failed to save resume: %w", err)
+	resumes := newResumeFiles(s.resumeDir)
+	err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		// Use the link first, so that a second submission on it fails
+		// before anything is saved
+		if err := useLink(ctx, tx.Links, token); err != nil {
+			return err
 		}
-		
-		// Create a safe filename using helper function
-		safeFileName, err := GetSafeFileName(emp.FirstName, emp.LastName, originalPath)
+		revision, err = applyInternal(ctx, tx, emp, resumes)
 		if err != nil {
-			return fmt.Errorf("failed to create filename: %w", err)
+			return err
 		}
-		
-		// Update the path in the employee record
-		emp.Resumepath = filepath.Join("static", safeFileName)
-	}
-	
-	// Save the employee record to the repository
-	err := s.repo.ApplyInternal(emp)
+		if !revision {
+			matched, err := matchEmployee(ctx, tx, emp)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			matchedEmployee = matched
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to save employee record: %w", err)
+		resumes.discard()
+		return data.Employee{}, false, err
 	}
-	
-	return nil
+	return matchedEmployee, revision, nil
 }
 
-// SaveResume saves a resume file
-func (s *InternalEmployeeService) SaveResume(emp data.InternalEmployee, originalFilePath string) error {
-	if originalFilePath == "" {
-		return errors.New("file path is empty")
-	}
+// GetApplicationsByJobID retrieves all internal applications for a specific job
+func (s *InternalEmployeeService) GetApplicationsByJobID(ctx context.Context, jobID string) ([]data.InternalEmployee, error) {
+	ctx, span := tracing.Start(ctx, "InternalEmployeeService.GetApplicationsByJobID")
+	defer span.End()
 
-	// Use helper function to validate file path
-	err := ValidateFilePath(originalFilePath)
-	if err != nil {
+	return s.applications.GetInternalApplicationsByJobID(ctx, jobID)
+}
+
+// GetAllInternalApplications retrieves all internal applications
+func (s *InternalEmployeeService) GetAllInternalApplications(ctx context.Context) ([]data.InternalEmployee, error) {
+	ctx, span := tracing.Start(ctx, "InternalEmployeeService.GetAllInternalApplications")
+	defer span.End()
+
+	return s.applications.GetAllInternalApplications(ctx)
+}
+
+// Save_Internal_Employee saves an internal employee application. If the
+// applicant already applied for the job with the same file number, it is
+// rejected with ErrDuplicateApplication or, if the job merges duplicates,
+// kept as a revision of the earlier application; revision reports which.
+func (s *InternalEmployeeService) Save_Internal_Employee(ctx context.Context, emp data.InternalEmployee) (revision bool, err error) {
+	ctx, span := tracing.Start(ctx, "InternalEmployeeService.Save_Internal_Employee")
+	defer span.End()
+
+	// Save the employee record, and its resume once it is accepted
+	resumes := newResumeFiles(s.resumeDir)
+	err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		revision, err = applyInternal(ctx, tx, emp, resumes)
 		return err
-	}
-	
-	// Create a safe filename
-	safeFileName, err := GetSafeFileName(emp.FirstName, emp.LastName, originalFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to create filename: %w", err)
-	}
-	
-	// Define the destination path (static folder)
-	destPath := filepath.Join("static", safeFileName)
-	
-	// Open file to copy
-	file, err := os.Open(originalFilePath)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		resumes.discard()
 	}
-	defer file.Close()
-	
-	// Create destination file
-	destFile, err := os.Create(destPath)
-	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
-	}
-	defer destFile.Close()
-	
-	// Copy file contents
-	_, err = io.Copy(destFile, file)
-	if err != nil {
-		return fmt.Errorf("failed to copy file: %w", err)
-	}
-	
-	return nil
-} 
\ No newline at end of file
+	return revision, err
+}
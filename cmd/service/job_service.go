@@ -1,37 +1,51 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"time"
 
+	"github.com/brehan/bank/cmd/apperr"
 	"github.com/brehan/bank/cmd/data"
 	"github.com/brehan/bank/cmd/repository"
 	"database/sql"
+	"github.com/brehan/bank/cmd/tracing"
 )
+
+var ErrJobNotFound = apperr.NotFound("job_not_found", "job not found")
+
 type JobService struct {
-	repo *repository.Repository
+	repo         repository.JobStore
+	applications repository.ApplicationStore
 }
 
-func NewJobService(repo *repository.Repository) *JobService {
-	return &JobService{repo: repo}
+func NewJobService(repo repository.JobStore, applications repository.ApplicationStore) *JobService {
+	return &JobService{repo: repo, applications: applications}
 }
 
 // ValidateJob validates job data
 func (s *JobService) ValidateJob(job data.Job) error {
+	var fields []apperr.FieldError
 	if job.Title == "" {
-		return errors.New("job title is required")
+		fields = append(fields, apperr.FieldError{Field: "title", Message: "is required"})
 	}
 	if job.Description == "" {
-		return errors.New("job description is required")
+		fields = append(fields, apperr.FieldError{Field: "description", Message: "is required"})
 	}
 	if job.Department == "" {
-		return errors.New("department is required")
+		fields = append(fields, apperr.FieldError{Field: "department", Message: "is required"})
+	}
+	if len(fields) > 0 {
+		return apperr.New(apperr.KindValidation, "invalid_job", "job is not valid").WithFields(fields...)
 	}
 	return nil
 }
 
 // CreateJob creates a new job posting
-func (s *JobService) CreateJob(job data.Job) error {
+func (s *JobService) CreateJob(ctx context.Context, job data.Job) error {
+	ctx, span := tracing.Start(ctx, "JobService.CreateJob")
+	defer span.End()
+
 	if err := s.ValidateJob(job); err != nil {
 		return err
 	}
@@ -45,53 +59,81 @@ func (s *JobService) CreateJob(job data.Job) error {
 	if !job.Status.Valid {
 		job.Status = sql.NullString{String: "open", Valid: true}
 	}
+	if err := CheckDuplicatePolicy(&job); err != nil {
+		return err
+	}
 	
-	return s.repo.CreateJob(job)
+	return s.repo.CreateJob(ctx, &job)
 }
 
 // GetAllJobs returns all job postings
-func (s *JobService) GetAllJobs() ([]data.Job, error) {
-	return s.repo.GetAllJobs()
+func (s *JobService) GetAllJobs(ctx context.Context) ([]data.Job, error) {
+	ctx, span := tracing.Start(ctx, "JobService.GetAllJobs")
+	defer span.End()
+
+	return s.repo.GetAllJobs(ctx)
 }
 
 // GetJobById returns a specific job by ID
-func (s *JobService) GetJobById(id string) (data.Job, error) {
-	return s.repo.GetJobById(id)
+func (s *JobService) GetJobById(ctx context.Context, id string) (data.Job, error) {
+	ctx, span := tracing.Start(ctx, "JobService.GetJobById")
+	defer span.End()
+
+	job, err := s.repo.GetJobById(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, ErrJobNotFound
+	}
+	return job, err
 }
 
 // UpdateJob updates an existing job
-func (s *JobService) UpdateJob(job data.Job) error {
+func (s *JobService) UpdateJob(ctx context.Context, job data.Job) error {
+	ctx, span := tracing.Start(ctx, "JobService.UpdateJob")
+	defer span.End()
+
 	if err := s.ValidateJob(job); err != nil {
 		return err
 	}
 	
 	// Check if the job exists
-	existingJob, err := s.GetJobById(job.ID)
+	existingJob, err := s.GetJobById(ctx, job.ID)
 	if err != nil {
-		return errors.New("job not found")
+		return err
 	}
 	
 	// Preserve fields that shouldn't be updated
 	job.CreatedAt = existingJob.CreatedAt
+	if job.DuplicatePolicy == "" {
+		job.DuplicatePolicy = existingJob.DuplicatePolicy
+	}
+	if err := CheckDuplicatePolicy(&job); err != nil {
+		return err
+	}
 	
-	return s.repo.UpdateJob(job)
+	return s.repo.UpdateJob(ctx, job)
 }
 
 // DeleteJob deletes a job posting
-func (s *JobService) DeleteJob(id string) error {
+func (s *JobService) DeleteJob(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "JobService.DeleteJob")
+	defer span.End()
+
 	// Check if the job exists
-	_, err := s.GetJobById(id)
+	_, err := s.GetJobById(ctx, id)
 	if err != nil {
-		return errors.New("job not found")
+		return err
 	}
 	
-	return s.repo.DeleteJob(id)
+	return s.repo.DeleteJob(ctx, id)
 }
 
 // Get all applicants for a job
-func (s *JobService) GetJobApplicants(jobID string) ([]data.InternalEmployee, []data.ExternalEmployee, error) {
-	internalApps, err1 := s.repo.GetInternalApplicationsByJobID(jobID)
-	externalApps, err2 := s.repo.GetExternalApplicationsByJobID(jobID)
+func (s *JobService) GetJobApplicants(ctx context.Context, jobID string) ([]data.InternalEmployee, []data.ExternalEmployee, error) {
+	ctx, span := tracing.Start(ctx, "JobService.GetJobApplicants")
+	defer span.End()
+
+	internalApps, err1 := s.applications.GetInternalApplicationsByJobID(ctx, jobID)
+	externalApps, err2 := s.applications.GetExternalApplicationsByJobID(ctx, jobID)
 	
 	if err1 != nil {
 		return nil, nil, err1
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository/memory"
+)
+
+func TestJobLifecycle(t *testing.T) {
+	store := memory.New()
+	jobs := NewJobService(store, store)
+
+	if err := jobs.CreateJob(ctx, data.Job{Title: "Teller"}); err == nil {
+		t.Error("a job without a description should be rejected")
+	}
+	if err := jobs.CreateJob(ctx, data.Job{Title: "Teller", Description: "Front desk", Department: "Retail"}); err != nil {
+		t.Fatal(err)
+	}
+	all, err := jobs.GetAllJobs(ctx)
+	if err != nil || len(all) != 1 {
+		t.Fatalf("GetAllJobs = %+v, %v", all, err)
+	}
+	job := all[0]
+	if job.Status.String != "open" || job.CreatedAt.IsZero() {
+		t.Errorf("new job = %+v, want it open with a creation time", job)
+	}
+
+	job.Title = "Senior Teller"
+	if err := jobs.UpdateJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := jobs.GetJobById(ctx, job.ID); got.Title != "Senior Teller" {
+		t.Errorf("title after update = %q", got.Title)
+	}
+
+	if err := jobs.DeleteJob(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobs.GetJobById(ctx, job.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("GetJobById after delete = %v", err)
+	}
+	if err := jobs.DeleteJob(ctx, job.ID); err == nil {
+		t.Error("deleting a missing job should fail")
+	}
+}
+
+func TestApplicationLinks(t *testing.T) {
+	store := memory.New()
+	links := NewApplicationLinkService(store, store, store)
+
+	if _, _, err := links.GenerateApplicationLinks(ctx, "missing"); err == nil {
+		t.Error("links for a missing job should fail")
+	}
+
+	job := data.Job{Title: "Auditor", Description: "Internal audit", Department: "Audit"}
+	if err := store.CreateJob(ctx, &job); err != nil {
+		t.Fatal(err)
+	}
+	internal, external, err := links.GenerateApplicationLinks(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses, err := links.FormatApplicationLinksForResponse(ctx, internal, external, "https://jobs.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[0].JobTitle != "Auditor" || responses[1].URL != "https://jobs.example/apply/external/"+external.Token {
+		t.Errorf("responses = %+v", responses)
+	}
+
+	if _, err := links.ValidateApplicationLink(ctx, internal.Token); err != nil {
+		t.Fatal(err)
+	}
+	if err := links.MarkLinkAsUsed(ctx, internal.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := links.ValidateApplicationLink(ctx, internal.Token); err == nil {
+		t.Error("a used link should be rejected")
+	}
+}
+
+func TestMatchInternalApplication(t *testing.T) {
+	store := memory.New()
+	job := data.Job{Title: "Branch Manager", Description: "Runs a branch", Department: "Retail"}
+	if err := store.CreateJob(ctx, &job); err != nil {
+		t.Fatal(err)
+	}
+	err := store.CreateEmployee(ctx, data.Employee{
+		FullName: "Eve Tadesse",
+		Totalexp: sql.NullInt64{Int64: 10, Valid: true},
+		Tmdrec20: sql.NullFloat64{Float64: 15, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	internal := NewInternalEmployeeService(store, store, store, t.TempDir())
+	app := data.InternalEmployee{FirstName: "eve", LastName: "tadesse", Jobid: job.ID}
+	if _, err := internal.Save_Internal_Employee(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	matched, err := internal.MatchWithExistingEmployee(ctx, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched.FullName != "Eve Tadesse" {
+		t.Fatalf("matched %+v", matched)
+	}
+
+	// Matching starts a new evaluation with only the experience score
+	emp, err := store.GetEmployeeByID(ctx, matched.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emp.Tmdrec20.Valid || emp.Totalexp20.Float64 != 2 || emp.Total.Float64 != 2 {
+		t.Errorf("scores after matching: tmdrec20 %+v, totalexp20 %+v, total %+v", emp.Tmdrec20, emp.Totalexp20, emp.Total)
+	}
+
+	jobApps, _, err := NewJobService(store, store).GetJobApplicants(ctx, job.ID)
+	if err != nil || len(jobApps) != 1 {
+		t.Errorf("GetJobApplicants = %+v, %v", jobApps, err)
+	}
+}
+
+func TestSubmitViaLink(t *testing.T) {
+	store := memory.New()
+	job := data.Job{Title: "Branch Manager", Description: "Runs a branch", Department: "Retail"}
+	if err := store.CreateJob(ctx, &job); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateEmployee(ctx, data.Employee{FullName: "Eve Tadesse", Totalexp: sql.NullInt64{Int64: 10, Valid: true}}); err != nil {
+		t.Fatal(err)
+	}
+	links := NewApplicationLinkService(store, store, store)
+	internalLink, externalLink, err := links.GenerateApplicationLinks(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	internal := NewInternalEmployeeService(store, store, store, t.TempDir())
+	matched, _, err := internal.SubmitViaLink(ctx, data.InternalEmployee{FirstName: "Eve", LastName: "Tadesse", Jobid: job.ID}, internalLink.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched.FullName != "Eve Tadesse" {
+		t.Errorf("matched %+v", matched)
+	}
+	if emp, _ := store.GetEmployeeByID(ctx, matched.ID); emp.Total.Float64 != 2 {
+		t.Errorf("total after matching = %+v", emp.Total)
+	}
+	if _, err := links.ValidateApplicationLink(ctx, internalLink.Token); err == nil {
+		t.Error("the internal link should be used up")
+	}
+
+	// A link is good for one application only
+	if _, _, err := internal.SubmitViaLink(ctx, data.InternalEmployee{FirstName: "Sam", LastName: "Lee", Jobid: job.ID}, internalLink.Token); err != ErrLinkUsed {
+		t.Errorf("second submission on a link = %v, want ErrLinkUsed", err)
+	}
+	if apps, _ := store.GetAllInternalApplications(ctx); len(apps) != 1 {
+		t.Errorf("%d internal applications after submitting twice on a link, want 1", len(apps))
+	}
+	if _, _, err := internal.SubmitViaLink(ctx, data.InternalEmployee{FirstName: "Sam", LastName: "Lee", Jobid: job.ID}, "missing"); err != ErrInvalidLink {
+		t.Errorf("submission on a missing link = %v, want ErrInvalidLink", err)
+	}
+
+	// Nobody matching is not an error
+	internalLink, _, err = links.GenerateApplicationLinks(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched, _, err = internal.SubmitViaLink(ctx, data.InternalEmployee{FirstName: "Sam", LastName: "Lee", Jobid: job.ID}, internalLink.Token)
+	if err != nil || matched.ID != 0 {
+		t.Errorf("SubmitViaLink without a match = %+v, %v", matched, err)
+	}
+
+	external := NewExternalEmployeeService(store, store, t.TempDir())
+	if _, err := external.SubmitViaLink(ctx, data.ExternalEmployee{FirstName: "Hana", Jobid: job.ID}, externalLink.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := links.ValidateApplicationLink(ctx, externalLink.Token); err == nil {
+		t.Error("the external link should be used up")
+	}
+	if _, err := external.SubmitViaLink(ctx, data.ExternalEmployee{FirstName: "Lulit", Jobid: job.ID}, externalLink.Token); err != ErrLinkUsed {
+		t.Errorf("second submission on a link = %v, want ErrLinkUsed", err)
+	}
+	if apps, _ := store.GetAllExternalApplications(ctx); len(apps) != 1 {
+		t.Errorf("%d external applications after submitting twice on a link, want 1", len(apps))
+	}
+	if apps, _ := store.GetAllInternalApplications(ctx); len(apps) != 2 {
+		t.Errorf("%d internal applications, want 2", len(apps))
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConfig describes how to find and bind users in an LDAP directory or
+// Active Directory. Empty attribute names fall back to the defaults below.
+type LDAPConfig struct {
+	// URL is an ldap:// or ldaps:// URL
+	URL string
+	// StartTLS upgrades an ldap:// connection before any credentials are sent
+	StartTLS  bool
+	TLSConfig *tls.Config
+	// BindDN and BindPassword are the service account used to look users up.
+	// The search is anonymous if BindDN is empty.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds a user by name; %s is replaced with the escaped name.
+	// Use (sAMAccountName=%s) for Active Directory.
+	UserFilter string
+	// SubjectAttr is a stable ID for the user, such as entryUUID or
+	// objectGUID. The entry DN is used if empty.
+	SubjectAttr string
+	NameAttr    string
+	EmailAttr   string
+	// GroupAttr lists the DNs of the user's groups
+	GroupAttr string
+	// GroupBaseDN is the container of the groups that grant roles. Only
+	// groups directly under it are reported, by CN; without it no groups
+	// are reported, so a group of the same name elsewhere in the directory
+	// cannot grant a role.
+	GroupBaseDN string
+	Timeout     time.Duration
+}
+
+const (
+	defaultLDAPUserFilter = "(uid=%s)"
+	defaultLDAPNameAttr   = "displayName"
+	defaultLDAPEmailAttr  = "mail"
+	defaultLDAPGroupAttr  = "memberOf"
+	defaultLDAPTimeout    = 10 * time.Second
+)
+
+// LDAPAuthenticator signs users in with a bind as their own directory entry
+type LDAPAuthenticator struct {
+	cfg       LDAPConfig
+	groupBase *ldap.DN
+}
+
+func NewLDAPAuthenticator(cfg LDAPConfig) (*LDAPAuthenticator, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("ldap: URL and base DN are required")
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = defaultLDAPUserFilter
+	}
+	if strings.Count(cfg.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("ldap: user filter %q must contain %%s exactly once", cfg.UserFilter)
+	}
+	if cfg.NameAttr == "" {
+		cfg.NameAttr = defaultLDAPNameAttr
+	}
+	if cfg.EmailAttr == "" {
+		cfg.EmailAttr = defaultLDAPEmailAttr
+	}
+	if cfg.GroupAttr == "" {
+		cfg.GroupAttr = defaultLDAPGroupAttr
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultLDAPTimeout
+	}
+	a := &LDAPAuthenticator{cfg: cfg}
+	if cfg.GroupBaseDN != "" {
+		base, err := ldap.ParseDN(cfg.GroupBaseDN)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: group base DN %q: %w", cfg.GroupBaseDN, err)
+		}
+		a.groupBase = base
+	}
+	return a, nil
+}
+
+// Authenticate looks the user up with the service account and then binds as
+// the entry that was found. The returned identity carries the directory's
+// email, display name and groups. Groups are reported by the value of their
+// first RDN, usually the CN, since DNs cannot appear in a group mapping;
+// only groups directly under GroupBaseDN are reported.
+func (a *LDAPAuthenticator) Authenticate(name, password string, user *data.User) (*data.ExternalIdentity, error) {
+	// An empty password would be an unauthenticated bind, which most
+	// directories accept for any DN
+	if name == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service account bind: %w", err)
+		}
+	}
+
+	attributes := []string{a.cfg.NameAttr, a.cfg.EmailAttr, a.cfg.GroupAttr}
+	if a.cfg.SubjectAttr != "" {
+		attributes = append(attributes, a.cfg.SubjectAttr)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.cfg.Timeout/time.Second), false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(name)),
+		attributes, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, fmt.Errorf("ldap: user search: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: user bind: %w", err)
+	}
+
+	subject := entry.DN
+	if a.cfg.SubjectAttr != "" {
+		subject = entry.GetAttributeValue(a.cfg.SubjectAttr)
+		if subject == "" {
+			return nil, fmt.Errorf("ldap: %s has no %s", entry.DN, a.cfg.SubjectAttr)
+		}
+	}
+
+	return &data.ExternalIdentity{
+		Provider: data.AuthProviderLDAP,
+		Subject:  subject,
+		Username: name,
+		Name:     entry.GetAttributeValue(a.cfg.NameAttr),
+		Email:    entry.GetAttributeValue(a.cfg.EmailAttr),
+		// The directory is managed by the bank, so its addresses are trusted
+		EmailVerified: true,
+		Groups:        ldapGroups(entry.GetAttributeValues(a.cfg.GroupAttr), a.groupBase),
+	}, nil
+}
+
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	tlsConfig := a.cfg.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	conn, err := ldap.DialURL(a.cfg.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: connect: %w", err)
+	}
+	conn.SetTimeout(a.cfg.Timeout)
+
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: start TLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// ldapGroups returns the first RDN value of each group DN directly under
+// base. Other groups are left out: a CN is only unique within its
+// container, and anyone who can create a group elsewhere could otherwise
+// name it after a group that grants a role.
+func ldapGroups(dns []string, base *ldap.DN) []string {
+	if base == nil {
+		return nil
+	}
+	var groups []string
+	for _, dn := range dns {
+		parsed, err := ldap.ParseDN(dn)
+		if err != nil || len(parsed.RDNs) < 2 || len(parsed.RDNs[0].Attributes) != 1 {
+			continue
+		}
+		if !base.EqualFold(&ldap.DN{RDNs: parsed.RDNs[1:]}) {
+			continue
+		}
+		groups = append(groups, parsed.RDNs[0].Attributes[0].Value)
+	}
+	return groups
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository/memory"
+	"github.com/jimlambrt/gldap/testdirectory"
+)
+
+// testDirectory starts an in-process LDAP server with a service account and
+// three users. eve is in a bank-admins group outside the group base DN.
+// testdirectory gives every user the password "password".
+func testDirectory(t *testing.T) (*testdirectory.Directory, LDAPConfig) {
+	t.Helper()
+	defaults := &testdirectory.Defaults{
+		UserAttr:  "cn",
+		GroupAttr: "cn",
+		UserDN:    "ou=people,dc=example,dc=org",
+		GroupDN:   "ou=groups,dc=example,dc=org",
+	}
+	dir := testdirectory.Start(t, testdirectory.WithNoTLS(t), testdirectory.WithDefaults(t, defaults))
+
+	users := testdirectory.NewUsers(t, []string{"svc"}, testdirectory.WithDefaults(t, defaults))
+	users = append(users, testdirectory.NewUsers(t, []string{"alice"},
+		testdirectory.WithDefaults(t, defaults),
+		testdirectory.WithMembersOf(t, testdirectory.NewMemberOf(t, []string{"bank-admins", "north"}, testdirectory.WithDefaults(t, defaults))...),
+	)...)
+	users = append(users, testdirectory.NewUsers(t, []string{"bob"}, testdirectory.WithDefaults(t, defaults))...)
+	contractors := *defaults
+	contractors.GroupDN = "ou=contractors,dc=example,dc=org"
+	users = append(users, testdirectory.NewUsers(t, []string{"eve"},
+		testdirectory.WithDefaults(t, defaults),
+		testdirectory.WithMembersOf(t, testdirectory.NewMemberOf(t, []string{"bank-admins"}, testdirectory.WithDefaults(t, &contractors))...),
+	)...)
+	dir.SetUsers(users...)
+
+	return dir, LDAPConfig{
+		URL:          fmt.Sprintf("ldap://127.0.0.1:%d", dir.Port()),
+		BindDN:       "cn=svc,ou=people,dc=example,dc=org",
+		BindPassword: "password",
+		BaseDN:       defaults.UserDN,
+		UserFilter:   "(cn=%s)",
+		NameAttr:     "name",
+		EmailAttr:    "email",
+		GroupBaseDN:  defaults.GroupDN,
+	}
+}
+
+func TestLDAPAuthenticatorLogin(t *testing.T) {
+	_, cfg := testDirectory(t)
+	auth, err := NewLDAPAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := auth.Authenticate("alice", "password", nil)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Provider != data.AuthProviderLDAP || identity.Subject != "cn=alice,ou=people,dc=example,dc=org" {
+		t.Errorf("identity = %s/%s", identity.Provider, identity.Subject)
+	}
+	if identity.Username != "alice" || identity.Email != "alice@example.com" || !identity.EmailVerified {
+		t.Errorf("identity = %+v", identity)
+	}
+	wantGroups := []string{"bank-admins", "north"}
+	if !reflect.DeepEqual(identity.Groups, wantGroups) {
+		t.Errorf("groups = %v, want %v", identity.Groups, wantGroups)
+	}
+
+	mapper, err := ParseGroupMapper("bank-admins=admin,north=district_manager:North")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRoles := []data.UserRole{{Role: data.RoleAdmin}, {Role: data.RoleDistrictManager, District: "North"}}
+	if roles := mapper.Roles(identity.Groups); !reflect.DeepEqual(roles, wantRoles) {
+		t.Errorf("roles = %v, want %v", roles, wantRoles)
+	}
+}
+
+func TestLDAPGroupsUnderBaseDN(t *testing.T) {
+	_, cfg := testDirectory(t)
+	auth, err := NewLDAPAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := auth.Authenticate("eve", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identity.Groups) != 0 {
+		t.Errorf("a group outside the group base DN was reported: %v", identity.Groups)
+	}
+
+	cfg.GroupBaseDN = ""
+	auth, err = NewLDAPAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err = auth.Authenticate("alice", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identity.Groups) != 0 {
+		t.Errorf("groups without a group base DN = %v", identity.Groups)
+	}
+}
+
+func TestLDAPLoginDoesNotLinkByEmail(t *testing.T) {
+	_, cfg := testDirectory(t)
+	ldapAuth, err := NewLDAPAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper, err := ParseGroupMapper("bank-admins=admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memory.New()
+	auth := NewAuthService(store, store)
+	auth.RegisterAuthenticator(data.AuthProviderLDAP, ldapAuth, mapper)
+	if err := auth.SetDefaultAuthProvider(data.AuthProviderLDAP); err != nil {
+		t.Fatal(err)
+	}
+
+	local, _, err := auth.Register(ctx, "carol", "secret password", data.RoleManager, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := "alice@example.com"
+	if _, _, err := auth.UpdateUser(ctx, local.Id, UpdateUserInput{Email: &email}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := auth.Login(ctx, "alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Provisioned || result.User.Id == local.Id || result.User.Email != "" {
+		t.Errorf("directory sign-in = %+v, want a new account without the taken email", result.User)
+	}
+	_, access, err := auth.GetUserByID(ctx, local.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := access.RoleNames(); !reflect.DeepEqual(names, []string{data.RoleManager}) {
+		t.Errorf("roles of the local account = %v", names)
+	}
+}
+
+func TestLDAPAuthenticatorRejects(t *testing.T) {
+	_, cfg := testDirectory(t)
+	auth, err := NewLDAPAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, user, password string
+	}{
+		{"wrong password", "alice", "wrong"},
+		{"empty password", "alice", ""},
+		{"unknown user", "mallory", "password"},
+		{"filter injection", "*", "password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := auth.Authenticate(tt.user, tt.password, nil); err != ErrInvalidCredentials {
+				t.Errorf("err = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticatorServiceAccount(t *testing.T) {
+	_, cfg := testDirectory(t)
+	cfg.BindPassword = "wrong"
+	auth, err := NewLDAPAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A broken service account is a configuration error, not a bad login
+	if _, err := auth.Authenticate("alice", "password", nil); err == nil || err == ErrInvalidCredentials {
+		t.Errorf("err = %v, want a bind error", err)
+	}
+}
+
+func TestNewLDAPAuthenticatorConfig(t *testing.T) {
+	for _, cfg := range []LDAPConfig{
+		{BaseDN: "dc=example,dc=org"},
+		{URL: "ldap://localhost"},
+		{URL: "ldap://localhost", BaseDN: "dc=example,dc=org", UserFilter: "(uid=alice)"},
+		{URL: "ldap://localhost", BaseDN: "dc=example,dc=org", GroupBaseDN: "not a DN"},
+	} {
+		if _, err := NewLDAPAuthenticator(cfg); err == nil {
+			t.Errorf("NewLDAPAuthenticator(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrMFANotEnrolled     = apperr.Validation("mfa_not_enrolled", "", "two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled  = apperr.Conflict("mfa_already_enabled", "two-factor authentication is already enabled")
+	ErrMFAInvalidCode     = apperr.Unauthorized("mfa_invalid_code", "invalid two-factor code")
+	ErrMFARequiredForRole = apperr.Forbidden("mfa_required", "two-factor authentication is mandatory for this role")
+	ErrMFALocked          = apperr.New(apperr.KindRateLimited, "mfa_locked", "too many invalid two-factor codes, try again later")
+)
+
+const (
+	recoveryCodeCount = 10
+
+	// mfaMaxAttempts invalid codes in a row lock an enrolment for
+	// mfaLockout. A new mfa_pending token from logging in again does not
+	// lift the lock.
+	mfaMaxAttempts = 5
+	mfaLockout     = 15 * time.Minute
+)
+
+// MFAEnrollment is returned once when a user starts enrolment. The secret and
+// recovery codes are never shown again.
+type MFAEnrollment struct {
+	Secret          string   `json:"secret"`
+	ProvisioningURI string   `json:"provisioning_uri"`
+	RecoveryCodes   []string `json:"recovery_codes"`
+}
+
+type MFAService struct {
+	repo          repository.MFAStore
+	uow           repository.UnitOfWork
+	issuer        string
+	requiredRoles map[string]bool
+}
+
+// NewMFAService creates an MFAService. Users holding one of requiredRoles
+// must enrol before they can get a full session token.
+func NewMFAService(repo repository.MFAStore, uow repository.UnitOfWork, issuer string, requiredRoles []string) *MFAService {
+	required := make(map[string]bool)
+	for _, role := range requiredRoles {
+		role = strings.TrimSpace(role)
+		if role != "" {
+			required[role] = true
+		}
+	}
+	return &MFAService{
+		repo:          repo,
+		uow:           uow,
+		issuer:        issuer,
+		requiredRoles: required,
+	}
+}
+
+// IsRequired reports whether two-factor authentication is mandatory for any
+// of the given roles
+func (s *MFAService) IsRequired(roles ...string) bool {
+	for _, role := range roles {
+		if s.requiredRoles[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEnabled reports whether the user has a confirmed TOTP enrolment
+func (s *MFAService) IsEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	ctx, span := tracing.Start(ctx, "MFAService.IsEnabled")
+	defer span.End()
+
+	mfa, err := s.repo.GetUserMFA(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return mfa != nil && mfa.Enabled, nil
+}
+
+// Enroll generates a new TOTP secret and recovery codes for a user. The
+// enrolment only takes effect after ConfirmEnrollment.
+func (s *MFAService) Enroll(ctx context.Context, user *data.User) (*MFAEnrollment, error) {
+	ctx, span := tracing.Start(ctx, "MFAService.Enroll")
+	defer span.End()
+
+	enabled, err := s.IsEnabled(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	mfa := &data.UserMFA{
+		UserID:    user.Id,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.SaveUserMFA(ctx, mfa, hashes); err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: TOTPProvisioningURI(s.issuer, user.Name, secret),
+		RecoveryCodes:   codes,
+	}, nil
+}
+
+// ConfirmEnrollment enables a pending enrolment once the user proves their
+// authenticator produces valid codes
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, userID uuid.UUID, code string) error {
+	ctx, span := tracing.Start(ctx, "MFAService.ConfirmEnrollment")
+	defer span.End()
+
+	mfa, err := s.repo.GetUserMFA(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if mfa == nil {
+		return ErrMFANotEnrolled
+	}
+	if mfa.Enabled {
+		return ErrMFAAlreadyEnabled
+	}
+	if locked(mfa) {
+		return ErrMFALocked
+	}
+
+	step, ok := ValidateTOTP(mfa.Secret, code, time.Now())
+	if !ok {
+		return s.fail(ctx, userID)
+	}
+	err = s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		if err := tx.MFA.EnableUserMFA(ctx, userID, step); err != nil {
+			return err
+		}
+		return auditMFAEnabled(ctx, tx, userID, true)
+	})
+	if err != nil {
+		return err
+	}
+	return s.succeed(ctx, mfa)
+}
+
+// Verify checks a login code, accepting either a current TOTP code or an
+// unused recovery code. Each code can only be used once.
+func (s *MFAService) Verify(ctx context.Context, userID uuid.UUID, code string) error {
+	ctx, span := tracing.Start(ctx, "MFAService.Verify")
+	defer span.End()
+
+	mfa, err := s.repo.GetUserMFA(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if mfa == nil || !mfa.Enabled {
+		return ErrMFANotEnrolled
+	}
+	if locked(mfa) {
+		return ErrMFALocked
+	}
+
+	if step, ok := ValidateTOTP(mfa.Secret, code, time.Now()); ok {
+		fresh, err := s.repo.AdvanceMFAStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return s.fail(ctx, userID)
+		}
+		return s.succeed(ctx, mfa)
+	}
+
+	used, err := s.repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return s.fail(ctx, userID)
+	}
+	return s.succeed(ctx, mfa)
+}
+
+// locked reports whether an enrolment is locked after too many invalid codes
+func locked(mfa *data.UserMFA) bool {
+	return mfa.LockedUntil != nil && time.Now().Before(*mfa.LockedUntil)
+}
+
+// fail counts an invalid code and returns ErrMFAInvalidCode
+func (s *MFAService) fail(ctx context.Context, userID uuid.UUID) error {
+	if err := s.repo.RecordMFAFailure(ctx, userID, mfaMaxAttempts, time.Now().Add(mfaLockout)); err != nil {
+		return err
+	}
+	return ErrMFAInvalidCode
+}
+
+// succeed clears the count of invalid codes, if there is one
+func (s *MFAService) succeed(ctx context.Context, mfa *data.UserMFA) error {
+	if mfa.FailedAttempts == 0 && mfa.LockedUntil == nil {
+		return nil
+	}
+	return s.repo.ResetMFAFailures(ctx, mfa.UserID)
+}
+
+// Disable removes a user's enrolment after checking a current code. Users
+// holding a role that mandates two-factor authentication cannot disable it.
+func (s *MFAService) Disable(ctx context.Context, userID uuid.UUID, roles []string, code string) error {
+	ctx, span := tracing.Start(ctx, "MFAService.Disable")
+	defer span.End()
+
+	if s.IsRequired(roles...) {
+		return ErrMFARequiredForRole
+	}
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return err
+	}
+	return s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		if err := tx.MFA.DeleteUserMFA(ctx, userID); err != nil {
+			return err
+		}
+		return auditMFAEnabled(ctx, tx, userID, false)
+	})
+}
+
+// auditMFAEnabled records that two-factor was turned on or off for a user
+func auditMFAEnabled(ctx context.Context, tx repository.Stores, userID uuid.UUID, enabled bool) error {
+	return RecordAudit(ctx, tx, data.AuditActionUpdate, data.AuditEntityUserMFA, userID.String(),
+		map[string]bool{"enabled": !enabled}, map[string]bool{"enabled": enabled})
+}
+
+// generateRecoveryCode returns a code like "k3vq7-m2xpa"
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, 7)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(raw))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository/memory"
+)
+
+func TestMFAEnrolAndVerify(t *testing.T) {
+	store := memory.New()
+	user, _, err := NewAuthService(store, store).Register(ctx, "dave", "secret password", data.RoleAdmin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa := NewMFAService(store, store, "Brehan Bank", []string{data.RoleAdmin})
+
+	enrollment, err := mfa.Enroll(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mfa.Verify(ctx, user.Id, enrollment.RecoveryCodes[0]); err != ErrMFANotEnrolled {
+		t.Errorf("Verify before confirming = %v", err)
+	}
+
+	step := time.Now().Unix() / totpPeriod
+	code, _ := TOTPCode(enrollment.Secret, step)
+	if err := mfa.ConfirmEnrollment(ctx, user.Id, code); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfa.Verify(ctx, user.Id, code); err != ErrMFAInvalidCode {
+		t.Errorf("replaying the confirmation code = %v", err)
+	}
+	next, _ := TOTPCode(enrollment.Secret, step+1)
+	if err := mfa.Verify(ctx, user.Id, next); err != nil {
+		t.Errorf("Verify with the next code = %v", err)
+	}
+
+	recovery := enrollment.RecoveryCodes[1]
+	if err := mfa.Verify(ctx, user.Id, recovery); err != nil {
+		t.Errorf("Verify with a recovery code = %v", err)
+	}
+	if err := mfa.Verify(ctx, user.Id, recovery); err != ErrMFAInvalidCode {
+		t.Errorf("reusing a recovery code = %v", err)
+	}
+
+	if err := mfa.Disable(ctx, user.Id, []string{data.RoleAdmin}, enrollment.RecoveryCodes[2]); err != ErrMFARequiredForRole {
+		t.Errorf("an admin disabling MFA = %v", err)
+	}
+}
+
+func TestMFALockout(t *testing.T) {
+	store := memory.New()
+	user, _, err := NewAuthService(store, store).Register(ctx, "erin", "secret password", data.RoleAdmin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa := NewMFAService(store, store, "Brehan Bank", nil)
+	enrollment, err := mfa.Enroll(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := time.Now().Unix() / totpPeriod
+	code, _ := TOTPCode(enrollment.Secret, step)
+	if err := mfa.ConfirmEnrollment(ctx, user.Id, code); err != nil {
+		t.Fatal(err)
+	}
+
+	// A valid code clears the count of invalid ones
+	for i := 0; i < mfaMaxAttempts-1; i++ {
+		if err := mfa.Verify(ctx, user.Id, "000000"); err != ErrMFAInvalidCode {
+			t.Fatalf("invalid code %d = %v", i+1, err)
+		}
+	}
+	if err := mfa.Verify(ctx, user.Id, enrollment.RecoveryCodes[0]); err != nil {
+		t.Fatalf("Verify with a recovery code = %v", err)
+	}
+
+	for i := 0; i < mfaMaxAttempts; i++ {
+		if err := mfa.Verify(ctx, user.Id, "000000"); err != ErrMFAInvalidCode {
+			t.Fatalf("invalid code %d = %v", i+1, err)
+		}
+	}
+	if err := mfa.Verify(ctx, user.Id, enrollment.RecoveryCodes[1]); err != ErrMFALocked {
+		t.Errorf("a valid code while locked = %v, want ErrMFALocked", err)
+	}
+	if used, _ := store.UseRecoveryCode(ctx, user.Id, hashRecoveryCode(enrollment.RecoveryCodes[1])); !used {
+		t.Error("a code tried while locked was used up")
+	}
+}
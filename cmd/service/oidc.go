@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/data"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrOIDCNonceMismatch = apperr.Unauthorized("oidc_nonce_mismatch", "OIDC nonce does not match")
+
+// OIDCConfig configures sign-in through an OpenID Connect provider
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// GroupsClaim is the ID token claim listing the user's groups
+	GroupsClaim string
+}
+
+// OIDCProvider runs the authorization code flow (with PKCE) against an
+// OpenID Connect provider and turns the verified ID token into an
+// ExternalIdentity
+type OIDCProvider struct {
+	issuer      string
+	groupsClaim string
+	oauth       oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider fetches the provider's discovery document. ctx is used for
+// discovery and for fetching signing keys later on.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery for %s: %w", cfg.IssuerURL, err)
+	}
+
+	groupsClaim := cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+
+	return &OIDCProvider{
+		issuer:      cfg.IssuerURL,
+		groupsClaim: groupsClaim,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "groups"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// OIDCLoginState is generated per login attempt and must be kept by the
+// client (in a cookie) until the provider redirects back
+type OIDCLoginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// NewOIDCLoginState returns fresh random state, nonce and PKCE verifier
+func NewOIDCLoginState() (*OIDCLoginState, error) {
+	values := make([]string, 3)
+	for i := range values {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return &OIDCLoginState{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// AuthCodeURL is where the user is sent to sign in
+func (p *OIDCProvider) AuthCodeURL(login *OIDCLoginState) string {
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	return p.oauth.AuthCodeURL(login.State,
+		oidc.Nonce(login.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+}
+
+// Exchange redeems the authorization code and verifies the returned ID
+// token's signature, issuer, audience, expiry and nonce
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, login *OIDCLoginState) (*data.ExternalIdentity, error) {
+	token, err := p.oauth.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", login.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("OIDC code exchange: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("OIDC token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC ID token: %w", err)
+	}
+	if idToken.Nonce != login.Nonce {
+		return nil, ErrOIDCNonceMismatch
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	identity := &data.ExternalIdentity{
+		Provider: p.issuer,
+		Subject:  idToken.Subject,
+		Username: stringClaim(claims, "preferred_username"),
+		Name:     stringClaim(claims, "name"),
+		Email:    stringClaim(claims, "email"),
+		Groups:   stringsClaim(claims, p.groupsClaim),
+	}
+	// Some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	return identity, nil
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// stringsClaim reads a claim that is either a list of strings or a single
+// string
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+)
+
+const mockClientID = "brehan-bank"
+
+// mockIdP is a minimal OpenID Connect provider: discovery, an authorize
+// endpoint that signs the user in immediately, a token endpoint that checks
+// PKCE, and a JWKS endpoint
+type mockIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	// signWith, if set, signs ID tokens instead of the published key
+	signWith *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	nonce     string
+	challenge string
+}
+
+func newMockIdP(t *testing.T, claims map[string]interface{}) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{key: key, claims: claims, codes: make(map[string]mockAuthorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/keys", idp.keys)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                idp.URL,
+		"authorization_endpoint":                idp.URL + "/authorize",
+		"token_endpoint":                        idp.URL + "/token",
+		"jwks_uri":                              idp.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (idp *mockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != mockClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	code := "code-" + q.Get("state")
+	idp.mu.Lock()
+	idp.codes[code] = mockAuthorization{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	idp.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   idp.URL,
+		"aud":   mockClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": auth.nonce,
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idp.sign(claims),
+	})
+}
+
+func (idp *mockIdP) keys(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *mockIdP) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	key := idp.key
+	if idp.signWith != nil {
+		key = idp.signWith
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// signIn follows the authorize redirect and returns the code and state the
+// provider sent back
+func signIn(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func newTestOIDCProvider(t *testing.T, idp *mockIdP) *OIDCProvider {
+	t.Helper()
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		IssuerURL:   idp.URL,
+		ClientID:    mockClientID,
+		RedirectURL: "http://localhost:8080/api/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestOIDCProviderLogin(t *testing.T) {
+	idp := newMockIdP(t, map[string]interface{}{
+		"sub":                "u-123",
+		"preferred_username": "abebe",
+		"name":               "Abebe Kebede",
+		"email":              "abebe@brehanbank.example",
+		"email_verified":     true,
+		"groups":             []string{"bank-managers", "district-north"},
+	})
+	provider := newTestOIDCProvider(t, idp)
+
+	login, err := NewOIDCLoginState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := signIn(t, provider.AuthCodeURL(login))
+	if state != login.State {
+		t.Fatalf("state = %q, want %q", state, login.State)
+	}
+
+	identity, err := provider.Exchange(context.Background(), code, login)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := &data.ExternalIdentity{
+		Provider:      idp.URL,
+		Subject:       "u-123",
+		Username:      "abebe",
+		Name:          "Abebe Kebede",
+		Email:         "abebe@brehanbank.example",
+		EmailVerified: true,
+		Groups:        []string{"bank-managers", "district-north"},
+	}
+	if !reflect.DeepEqual(identity, want) {
+		t.Errorf("identity = %+v, want %+v", identity, want)
+	}
+}
+
+func TestOIDCProviderRejectsWrongVerifier(t *testing.T) {
+	idp := newMockIdP(t, map[string]interface{}{"sub": "u-123"})
+	provider := newTestOIDCProvider(t, idp)
+
+	login, _ := NewOIDCLoginState()
+	code, _ := signIn(t, provider.AuthCodeURL(login))
+
+	stolen := *login
+	stolen.Verifier = "not-the-verifier"
+	if _, err := provider.Exchange(context.Background(), code, &stolen); err == nil {
+		t.Fatal("Exchange succeeded with the wrong PKCE verifier")
+	}
+}
+
+func TestOIDCProviderRejectsWrongNonce(t *testing.T) {
+	idp := newMockIdP(t, map[string]interface{}{"sub": "u-123"})
+	provider := newTestOIDCProvider(t, idp)
+
+	login, _ := NewOIDCLoginState()
+	code, _ := signIn(t, provider.AuthCodeURL(login))
+
+	replayed := *login
+	replayed.Nonce = "other-nonce"
+	if _, err := provider.Exchange(context.Background(), code, &replayed); err != ErrOIDCNonceMismatch {
+		t.Fatalf("Exchange error = %v, want ErrOIDCNonceMismatch", err)
+	}
+}
+
+func TestOIDCProviderRejectsForeignSignature(t *testing.T) {
+	idp := newMockIdP(t, map[string]interface{}{"sub": "u-123"})
+	provider := newTestOIDCProvider(t, idp)
+
+	// Tokens signed with a key the provider does not publish must fail
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.signWith = other
+
+	login, _ := NewOIDCLoginState()
+	code, _ := signIn(t, provider.AuthCodeURL(login))
+	if _, err := provider.Exchange(context.Background(), code, login); err == nil || !strings.Contains(err.Error(), "ID token") {
+		t.Fatalf("Exchange error = %v, want an ID token verification error", err)
+	}
+}
+
+func TestGroupMapper(t *testing.T) {
+	mapper, err := ParseGroupMapper("bank-admins=admin, bank-managers=manager,district-north=district_manager:North,district-south=district_manager:South")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		groups []string
+		want   []data.UserRole
+	}{
+		{nil, nil},
+		{[]string{"unrelated"}, nil},
+		{[]string{"bank-admins"}, []data.UserRole{{Role: "admin"}}},
+		{
+			[]string{"bank-managers", "district-north"},
+			[]data.UserRole{{Role: "manager"}, {Role: "district_manager", District: "North"}},
+		},
+		{
+			[]string{"district-south", "district-north"},
+			[]data.UserRole{{Role: "district_manager", District: "South"}},
+		},
+	}
+	for _, tt := range tests {
+		if got := mapper.Roles(tt.groups); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Roles(%v) = %v, want %v", tt.groups, got, tt.want)
+		}
+	}
+}
+
+func TestParseGroupMapperErrors(t *testing.T) {
+	for _, spec := range []string{
+		"bank-admins",
+		"=admin",
+		"north=district_manager",
+		"bank-admins=admin:North",
+	} {
+		if _, err := ParseGroupMapper(spec); err == nil {
+			t.Errorf("ParseGroupMapper(%q) succeeded, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/brehan/bank/cmd/apperr"
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/brehan/bank/cmd/tracing"
+)
+
+var ErrRateLimited = apperr.New(apperr.KindRateLimited, "rate_limited", "too many requests, try again later")
+
+const (
+	// rateLimitAttempts bounds how often Take retries while concurrent
+	// requests keep changing the same bucket
+	rateLimitAttempts = 5
+	// rateLimitPruneInterval is how often full buckets are deleted
+	rateLimitPruneInterval = time.Minute
+)
+
+// RateLimiter keeps a token bucket per client and route. The buckets live
+// in a store so that several nodes can share them.
+type RateLimiter struct {
+	store repository.RateLimitStore
+	now   func() time.Time
+
+	mu        sync.Mutex
+	nextPrune time.Time
+}
+
+func NewRateLimiter(store repository.RateLimitStore) *RateLimiter {
+	return &RateLimiter{store: store, now: time.Now}
+}
+
+// Take takes a token from the bucket, which names the route and client.
+// When the bucket is empty it returns ErrRateLimited and how long until
+// the next token.
+//
+// A bucket is stored as the time at which it is full again. It is one token
+// short for every refill interval before then, so taking a token moves that
+// time one interval on.
+func (l *RateLimiter) Take(ctx context.Context, bucket string, limit data.RateLimit) (time.Duration, error) {
+	ctx, span := tracing.Start(ctx, "RateLimiter.Take")
+	defer span.End()
+
+	interval := limit.Per / time.Duration(limit.Requests)
+	capacity := interval * time.Duration(limit.Requests)
+	now := l.now().UTC()
+	l.prune(ctx, now)
+
+	for i := 0; i < rateLimitAttempts; i++ {
+		prev, err := l.store.GetTokenBucket(ctx, bucket)
+		if err != nil {
+			tracing.Fail(span, err)
+			return 0, err
+		}
+		fullAt := prev
+		if fullAt.Before(now) {
+			fullAt = now
+		}
+		fullAt = fullAt.Add(interval)
+		if wait := fullAt.Sub(now) - capacity; wait > 0 {
+			return wait, ErrRateLimited
+		}
+
+		ok, err := l.store.SetTokenBucket(ctx, bucket, prev, fullAt)
+		if err != nil {
+			tracing.Fail(span, err)
+			return 0, err
+		}
+		if ok {
+			return 0, nil
+		}
+	}
+	// Other requests for the same bucket won every time
+	return interval, ErrRateLimited
+}
+
+// prune deletes the buckets that are full by now, at most once every
+// rateLimitPruneInterval. Failing to is not the request's problem, so the
+// error only goes on the span.
+func (l *RateLimiter) prune(ctx context.Context, now time.Time) {
+	l.mu.Lock()
+	if now.Before(l.nextPrune) {
+		l.mu.Unlock()
+		return
+	}
+	l.nextPrune = now.Add(rateLimitPruneInterval)
+	l.mu.Unlock()
+
+	ctx, span := tracing.Start(ctx, "RateLimiter.prune")
+	defer span.End()
+	if err := l.store.DeleteTokenBuckets(ctx, now); err != nil {
+		span.RecordError(err)
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository/memory"
+)
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 9, 0, 0, 0, time.UTC)
+	store := memory.NewRateLimits()
+	limiter := NewRateLimiter(store)
+	limiter.now = func() time.Time { return now }
+	limit := data.RateLimit{Requests: 3, Per: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Take(ctx, "login 192.0.2.1", limit); err != nil {
+			t.Fatalf("request %d of the burst = %v", i+1, err)
+		}
+	}
+	wait, err := limiter.Take(ctx, "login 192.0.2.1", limit)
+	if err != ErrRateLimited || wait != 20*time.Second {
+		t.Fatalf("request after the burst = %v, %v, want ErrRateLimited after 20s", wait, err)
+	}
+	if _, err := limiter.Take(ctx, "login 192.0.2.2", limit); err != nil {
+		t.Errorf("another client = %v, want its own bucket", err)
+	}
+
+	// One token comes back every 20 seconds
+	now = now.Add(15 * time.Second)
+	if wait, err := limiter.Take(ctx, "login 192.0.2.1", limit); err != ErrRateLimited || wait != 5*time.Second {
+		t.Errorf("after 15s = %v, %v, want ErrRateLimited after 5s", wait, err)
+	}
+	now = now.Add(5 * time.Second)
+	if _, err := limiter.Take(ctx, "login 192.0.2.1", limit); err != nil {
+		t.Errorf("after 20s = %v", err)
+	}
+	if _, err := limiter.Take(ctx, "login 192.0.2.1", limit); err != ErrRateLimited {
+		t.Errorf("second request after 20s = %v, want ErrRateLimited", err)
+	}
+
+	// Idle buckets fill up to the burst, not beyond, and are then pruned
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Take(ctx, "login 192.0.2.1", limit); err != nil {
+			t.Fatalf("request %d after an hour = %v", i+1, err)
+		}
+	}
+	if _, err := limiter.Take(ctx, "login 192.0.2.1", limit); err != ErrRateLimited {
+		t.Errorf("fourth request after an hour = %v, want ErrRateLimited", err)
+	}
+	if fullAt, _ := store.GetTokenBucket(ctx, "login 192.0.2.2"); !fullAt.IsZero() {
+		t.Errorf("full bucket kept until %v", fullAt)
+	}
+}
+
+func TestRateLimiterConcurrent(t *testing.T) {
+	limiter := NewRateLimiter(memory.NewRateLimits())
+	limit := data.RateLimit{Requests: 10, Per: time.Hour}
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := limiter.Take(ctx, "apply 192.0.2.1", limit); err == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// Requests that lose the race too often are refused, never let through
+	if allowed == 0 || allowed > 10 {
+		t.Errorf("%d of 50 concurrent requests allowed, want at most 10", allowed)
+	}
+}
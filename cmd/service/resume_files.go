@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// resumeFiles stores the resumes of the applications saved in one
+// transaction. Each resume gets a file of its own under a random name, so a
+// revision never overwrites the resume of the application it revises, nor
+// an applicant that of another with the same name. If the transaction
+// fails, discard removes the files again.
+type resumeFiles struct {
+	dir    string
+	stored []string
+}
+
+func newResumeFiles(dir string) *resumeFiles {
+	return &resumeFiles{dir: dir}
+}
+
+// store copies an upload in the resume directory to a new file and returns
+// the copy's path
+func (r *resumeFiles) store(upload string) (string, error) {
+	if !uploadedTo(r.dir, upload) {
+		return "", ErrResumeNotFound
+	}
+	if err := ValidateFilePath(upload); err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(upload)
+	if err != nil {
+		return "", fmt.Errorf("failed to open resume: %w", err)
+	}
+	defer src.Close()
+
+	path := filepath.Join(r.dir, uuid.NewString()+".pdf")
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return "", fmt.Errorf("failed to save resume: %w", err)
+	}
+	r.stored = append(r.stored, path)
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", fmt.Errorf("failed to save resume: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return "", fmt.Errorf("failed to save resume: %w", err)
+	}
+	return path, nil
+}
+
+// discard removes the resumes stored so far
+func (r *resumeFiles) discard() {
+	for _, path := range r.stored {
+		os.Remove(path)
+	}
+	r.stored = nil
+}
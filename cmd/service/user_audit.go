@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/brehan/bank/cmd/data"
+	"github.com/brehan/bank/cmd/repository"
+	"github.com/google/uuid"
+)
+
+// UserRecord renders a user the way the admin API does, leaving out the
+// password hash. Audit entries for users hold the same shape.
+func UserRecord(user *data.User, access data.UserAccess) map[string]interface{} {
+	record := map[string]interface{}{
+		"id":            user.Id.String(),
+		"name":          user.Name,
+		"email":         user.Email,
+		"branch":        user.Branch,
+		"status":        user.Status,
+		"auth_provider": user.AuthProvider,
+		"last_login":    user.LastLogin,
+		"created_at":    user.CreatedAt,
+		"updated_at":    user.UpdatedAt,
+		"role":          access.PrimaryRole().Role,
+		"roles":         access.RoleNames(),
+		"district":      nil,
+	}
+	if district := access.District(); district != "" {
+		record["district"] = district
+	}
+	return record
+}
+
+// readUserRecord reads the user and their roles through users
+func readUserRecord(ctx context.Context, users repository.UserStore, id uuid.UUID) (map[string]interface{}, error) {
+	user, err := users.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	access, err := users.GetUserAccess(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return UserRecord(user, access), nil
+}
+
+// changeUser locks the user and calls change in one transaction, auditing the
+// user as they were before and after it
+func (s *AuthService) changeUser(ctx context.Context, id uuid.UUID, change func(tx repository.Stores) error) error {
+	return s.uow.WithTx(ctx, func(tx repository.Stores) error {
+		if err := tx.Users.LockUser(ctx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		before, err := readUserRecord(ctx, tx.Users, id)
+		if err != nil {
+			return err
+		}
+		if err := change(tx); err != nil {
+			return err
+		}
+		after, err := readUserRecord(ctx, tx.Users, id)
+		if err != nil {
+			return err
+		}
+		return RecordAudit(ctx, tx, data.AuditActionUpdate, data.AuditEntityUser, id.String(), before, after)
+	})
+}
+
+// auditUserCreated records a user created in tx
+func auditUserCreated(ctx context.Context, tx repository.Stores, id uuid.UUID) error {
+	after, err := readUserRecord(ctx, tx.Users, id)
+	if err != nil {
+		return err
+	}
+	return RecordAudit(ctx, tx, data.AuditActionCreate, data.AuditEntityUser, id.String(), nil, after)
+}
@@ -0,0 +1,126 @@
+// Package tracing sets up OpenTelemetry tracing for the API. Spans are
+// started for every request, service call and SQL query, and carry the
+// request ID and the role of the signed-in user. They are exported over
+// OTLP/HTTP, written to stdout, or dropped, depending on configuration.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/brehan/bank/cmd/config"
+	"github.com/brehan/bank/cmd/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans started by this module
+const instrumentationName = "github.com/brehan/bank/cmd"
+
+// Attribute keys added to every span started while serving a request
+const (
+	RequestIDKey = attribute.Key("request.id")
+	UserRoleKey  = attribute.Key("user.role")
+)
+
+// Setup installs the global tracer provider described by cfg and returns
+// the function that flushes and stops it. With the none exporter nothing
+// is installed and spans cost next to nothing.
+func Setup(ctx context.Context, cfg config.TracingConfig, stdout io.Writer) (shutdown func(context.Context) error, err error) {
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case config.TracingNone:
+		return func(context.Context) error { return nil }, nil
+	case config.TracingStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(stdout))
+	case config.TracingOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tp := NewProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	)
+	Install(tp)
+	return tp.Shutdown, nil
+}
+
+// NewProvider returns a tracer provider that adds the request attributes
+// to every span. Tests pass sdktrace.WithSyncer with an in-memory exporter.
+func NewProvider(opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	opts = append([]sdktrace.TracerProviderOption{sdktrace.WithSpanProcessor(requestAttributes{})}, opts...)
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+// Install makes tp the global tracer provider and propagates W3C trace
+// context, so that traces started by a caller continue here
+func Install(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Tracer returns the tracer of the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts a span named name as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Fail marks span as failed with err
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+type userRoleKey struct{}
+
+// WithUserRole returns a copy of ctx carrying the role of the signed-in
+// user, which is added to the spans started with it
+func WithUserRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, userRoleKey{}, role)
+}
+
+// UserRole returns the role carried by ctx, or ""
+func UserRole(ctx context.Context) string {
+	role, _ := ctx.Value(userRoleKey{}).(string)
+	return role
+}
+
+// requestAttributes copies the request ID and user role from the context a
+// span is started with onto the span
+type requestAttributes struct{}
+
+func (requestAttributes) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	if id := logging.RequestID(parent); id != "" {
+		s.SetAttributes(RequestIDKey.String(id))
+	}
+	if role := UserRole(parent); role != "" {
+		s.SetAttributes(UserRoleKey.String(role))
+	}
+}
+
+func (requestAttributes) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (requestAttributes) Shutdown(context.Context) error   { return nil }
+func (requestAttributes) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/brehan/bank/cmd/config"
+	"github.com/brehan/bank/cmd/logging"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetupStdout(t *testing.T) {
+	t.Cleanup(func() { Install(noop.NewTracerProvider()) })
+
+	var out bytes.Buffer
+	cfg := config.TracingConfig{Exporter: config.TracingStdout, ServiceName: "bank-test", SampleRatio: 1}
+	shutdown, err := Setup(context.Background(), cfg, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := logging.WithRequestID(WithUserRole(context.Background(), "manager"), "req-1")
+	_, span := Start(ctx, "JobService.GetAllJobs")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`"Name":"JobService.GetAllJobs"`, `"req-1"`, `"manager"`, `"bank-test"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("exported span is missing %s:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestSetupSampleRatio(t *testing.T) {
+	t.Cleanup(func() { Install(noop.NewTracerProvider()) })
+
+	var out bytes.Buffer
+	cfg := config.TracingConfig{Exporter: config.TracingStdout, ServiceName: "bank-test", SampleRatio: 0}
+	shutdown, err := Setup(context.Background(), cfg, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := Start(context.Background(), "JobService.GetAllJobs")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("span exported with sample ratio 0:\n%s", out.String())
+	}
+}
+
+func TestSetupNone(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: config.TracingNone}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
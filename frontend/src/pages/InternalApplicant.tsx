@@ -20,6 +20,8 @@ const InternalApplicant: React.FC = () => {
     reason: '',
   });
   const [resumeFile, setResumeFile] = useState<File | null>(null);
+  // One key per application, reused when a failed submission is retried
+  const [idempotencyKey] = useState<string>(() => crypto.randomUUID());
   
   // Get job ID from URL query parameter
   const location = useLocation();
@@ -81,7 +83,7 @@ const InternalApplicant: React.FC = () => {
       };
       
       // Call API to submit application
-      await applicationAPI.submitInternal(applicationData, resumeFile || undefined);
+      await applicationAPI.submitInternal(applicationData, resumeFile || undefined, idempotencyKey);
       
       setMessage({ text: 'Your application has been submitted successfully!', type: 'success' });
       setSubmitted(true);
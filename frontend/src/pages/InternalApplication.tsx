@@ -63,7 +63,7 @@ const InternalApplication: React.FC = () => {
         reason: '',
       });
     } catch (err: any) {
-      setError(err.response?.data?.error || 'Failed to submit application. Please try again later.');
+      setError(err.response?.data?.detail || 'Failed to submit application. Please try again later.');
     } finally {
       setIsSubmitting(false);
     }
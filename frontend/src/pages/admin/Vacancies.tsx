@@ -16,6 +16,7 @@ interface Job {
   job_type: string;
   application_deadline: string;
   status: string;
+  duplicate_policy: string;
 }
 
 const Vacancies: React.FC = () => {
@@ -37,6 +38,7 @@ const Vacancies: React.FC = () => {
     job_type: 'internal',
     application_deadline: '',
     status: 'open',
+    duplicate_policy: 'reject',
   });
 
   // Fetch jobs when component mounts
@@ -87,6 +89,7 @@ const Vacancies: React.FC = () => {
       job_type: 'internal',
       application_deadline: '',
       status: 'open',
+      duplicate_policy: 'reject',
     });
     setEditingJob(null);
   };
@@ -111,6 +114,7 @@ const Vacancies: React.FC = () => {
       job_type: job.job_type,
       application_deadline: job.application_deadline,
       status: job.status,
+      duplicate_policy: job.duplicate_policy || 'reject',
     });
     setShowForm(true);
   };
@@ -360,6 +364,21 @@ const Vacancies: React.FC = () => {
                   </div>
                 </div>
 
+                <div className="form-row">
+                  <div className="form-group">
+                    <label htmlFor="duplicate_policy">Repeat Applications</label>
+                    <select
+                      id="duplicate_policy"
+                      name="duplicate_policy"
+                      value={formData.duplicate_policy || 'reject'}
+                      onChange={handleInputChange}
+                    >
+                      <option value="reject">Reject a second application from the same applicant</option>
+                      <option value="merge">Keep it as a revision of the applicant's earlier application</option>
+                    </select>
+                  </div>
+                </div>
+
                 <div className="form-group full-width">
                   <label htmlFor="description">Job Description</label>
                   <textarea
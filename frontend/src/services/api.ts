@@ -631,7 +631,9 @@ export const userAPI = {
 
 // Application API
 export const applicationAPI = {
-  submitInternal: (applicationData: any, resume?: File) => {
+  // idempotencyKey should stay the same while the same application is
+  // retried, so that the server records it once
+  submitInternal: (applicationData: any, resume?: File, idempotencyKey?: string) => {
     const formData = new FormData();
     if (resume) {
       formData.append('resume', resume);
@@ -641,11 +643,14 @@ export const applicationAPI = {
     return apiRequest('/api/public/apply/internal', {
       method: 'POST',
       body: formData,
-      headers: {}, // Let browser set content-type for multipart/form-data
+      // Let browser set content-type for multipart/form-data
+      headers: idempotencyKey ? { 'Idempotency-Key': idempotencyKey } : {},
     });
   },
   
-  submitExternal: (applicationData: any, resume?: File) => {
+  // idempotencyKey should stay the same while the same application is
+  // retried, so that the server records it once
+  submitExternal: (applicationData: any, resume?: File, idempotencyKey?: string) => {
     const formData = new FormData();
     if (resume) {
       formData.append('resume', resume);
@@ -655,7 +660,8 @@ export const applicationAPI = {
     return apiRequest('/api/public/apply/external', {
       method: 'POST',
       body: formData,
-      headers: {}, // Let browser set content-type for multipart/form-data
+      // Let browser set content-type for multipart/form-data
+      headers: idempotencyKey ? { 'Idempotency-Key': idempotencyKey } : {},
     });
   },
   
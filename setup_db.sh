@@ -12,106 +12,23 @@ echo "Creating database..."
 PGPASSWORD=$DB_PASSWORD psql -U $DB_USER -h $DB_HOST -p $DB_PORT -c "DROP DATABASE IF EXISTS $DB_NAME;"
 PGPASSWORD=$DB_PASSWORD psql -U $DB_USER -h $DB_HOST -p $DB_PORT -c "CREATE DATABASE $DB_NAME;"
 
-# Step 2: Enable uuid-ossp and create schema
-echo "Setting up schema..."
-PGPASSWORD=$DB_PASSWORD psql -U $DB_USER -h $DB_HOST -p $DB_PORT -d $DB_NAME <<EOF
-CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
-
-CREATE TABLE Employee (
-    id SERIAL PRIMARY KEY,
-    file_number TEXT,
-    full_name TEXT,
-    sex TEXT,
-    employment_date DATE,
-    individual_pms FLOAT,
-    last_dop DATE,
-    job_grade TEXT,
-    new_salary FLOAT,
-    job_category TEXT,
-    new_position TEXT,
-    branch TEXT,
-    department TEXT,
-    district TEXT,
-    twin_branch TEXT,
-    region TEXT,
-    field_of_study TEXT,
-    educational_level TEXT,
-    cluster TEXT,
-    indpms25 FLOAT,
-    totalexp20 FLOAT,
-    totalexp INT,
-    relatedexp INT,
-    expafterpromo FLOAT,
-    tmdrec20 FLOAT,
-    disrec20 FLOAT,
-    total FLOAT
-);
-
-CREATE TABLE Job (
-    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    job_name TEXT,
-    job_desc TEXT,
-    job_type TEXT,
-    job_progress TEXT,
-    rmeark TEXT,
-    closetime TIMESTAMP,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE InternalEmployee (
-    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    first_name TEXT,
-    last_name TEXT,
-    jobid UUID REFERENCES Job(id),
-    other_bank_exp TEXT,
-    employee_id INT REFERENCES Employee(id) ON DELETE CASCADE,
-    resume_path TEXT
-);
-
-CREATE TABLE ExternalEmployee (
-    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    first_name TEXT,
-    last_name TEXT,
-    email TEXT,
-    phone TEXT,
-    jobid UUID REFERENCES Job(id),
-    other_job_exp TEXT,
-    other_job_exp_year INT,
-    resume_path TEXT
-);
-
-CREATE TABLE Users (
-    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    name TEXT,
-    password TEXT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE Admin (
-    user_id UUID PRIMARY KEY REFERENCES Users(id) ON DELETE CASCADE
-);
-
-CREATE TABLE Manager (
-    user_id UUID PRIMARY KEY REFERENCES Users(id) ON DELETE CASCADE
-);
-
-CREATE TABLE DistrictManager (
-    user_id UUID PRIMARY KEY REFERENCES Users(id) ON DELETE CASCADE,
-    district TEXT
-);
+# Step 2: Create the schema with the migrations built into the API
+echo "Applying migrations..."
+(cd "$(dirname "$0")" && DATABASE_URL="host=$DB_HOST port=$DB_PORT user=$DB_USER password=$DB_PASSWORD dbname=$DB_NAME sslmode=disable" go run ./cmd/api migrate up) || exit 1
 
+# Step 3: Add test data
+echo "Adding test data..."
+PGPASSWORD=$DB_PASSWORD psql -U $DB_USER -h $DB_HOST -p $DB_PORT -d $DB_NAME <<EOF
 -- Add a test admin user
 INSERT INTO Users (id, name, password) 
 VALUES (uuid_generate_v4(), 'testadmin', 'testpassword');
 
 -- Make the user an admin
-INSERT INTO Admin (user_id)
-SELECT id FROM Users WHERE name = 'testadmin';
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM Users u JOIN roles r ON r.name = 'admin' WHERE u.name = 'testadmin';
 
 -- Add some test job postings
-INSERT INTO Job (job_name, job_desc, job_type, job_progress)
+INSERT INTO jobs (title, description, job_type, status)
 VALUES 
 ('Senior Loan Officer', 'Responsible for evaluating loan applications', 'Full-time', 'Open'),
 ('Bank Teller', 'Handle customer transactions', 'Full-time', 'Open'),
@@ -124,7 +41,7 @@ INSERT INTO Employee (
     branch, department, district, region, 
     field_of_study, educational_level, cluster, 
     indpms25, totalexp20, totalexp, relatedexp, 
-    expafterpromo, tmdrec20, disrec20, total
+    expafterpromo, tmdrec20, disrec15, total
 ) VALUES
 ('EMP001', 'John Smith', 'Male', '2018-03-15', 4.2, 
  '2022-05-01', 'Grade 5', 75000, 'Finance', 'Senior Accountant', 